@@ -0,0 +1,462 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+
+	"github.com/EduardDranca/GoAgent/internal/agent"
+	"github.com/EduardDranca/GoAgent/internal/agent/instructions"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/config"
+	"github.com/EduardDranca/GoAgent/internal/logging"
+	"github.com/EduardDranca/GoAgent/internal/repl"
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+// replSession holds the state shared by the commands typed at the prompt.
+type replSession struct {
+	directory    string
+	agent        agent.AgentInterface[models.AgentRequest]
+	instructions *instructions.Instructions
+	gitUtil      utils.GitUtil
+	commands     *repl.Registry
+}
+
+// newREPLSession creates the session of the prompt and registers its commands.
+func newREPLSession(directory string, programmingAgent agent.AgentInterface[models.AgentRequest], projectInstructions *instructions.Instructions, gitUtil utils.GitUtil) *replSession {
+	s := &replSession{
+		directory:    directory,
+		agent:        programmingAgent,
+		instructions: projectInstructions,
+		gitUtil:      gitUtil,
+		commands:     repl.NewRegistry(commandName(CommandImplement)),
+	}
+	s.commands.MustRegister(
+		repl.Command{
+			Name: commandName(CommandImplement),
+			Args: []repl.Arg{{Name: "change request", Text: true}},
+			Help: "Implements the change request and offers to commit it.",
+			Run:  s.runImplement,
+		},
+		repl.Command{
+			Name: commandName(CommandAsk),
+			Args: []repl.Arg{{Name: "query", Text: true}},
+			Help: fmt.Sprintf("Answers a question about the code, following up on the previous ones. %s starts a new thread.", askNewThreadFlag),
+			Run:  s.runAsk,
+		},
+		repl.Command{
+			Name: commandName(CommandClear),
+			Help: "Forgets the previous questions.",
+			Run:  s.runClear,
+		},
+		repl.Command{
+			Name: commandName(CommandResume),
+			Args: []repl.Arg{{Name: "run id", Optional: true}},
+			Help: "Resumes the interrupted change request of a run, or of the most recent one.",
+			Run:  s.runResume,
+		},
+		repl.Command{
+			Name: commandName(CommandInstructions),
+			Help: "Shows the project and directory instructions given to the agent.",
+			Run:  s.runInstructions,
+		},
+		repl.Command{
+			Name: commandName(CommandProfile),
+			Args: []repl.Arg{{Name: "name", Optional: true, Values: s.profileNames}},
+			Help: "Switches to a profile of the configuration, or lists the profiles.",
+			Run:  s.runProfile,
+		},
+		repl.Command{
+			Name:    commandName(CommandHelp),
+			Aliases: []string{"h", "?"},
+			Args:    []repl.Arg{{Name: "command", Optional: true, Values: s.commandNames}},
+			Help:    "Lists the commands, or describes one.",
+			Run: func(out io.Writer, argument string) error {
+				return s.commands.WriteHelp(out, argument)
+			},
+		},
+		repl.Command{
+			Name: commandName(CommandStatus),
+			Help: "Shows the repository, its branch and its pending changes.",
+			Run:  s.runStatus,
+		},
+		repl.Command{
+			Name: commandName(CommandModel),
+			Args: []repl.Arg{{Name: "model", Optional: true, Values: s.modelNames}},
+			Help: "Shows the models in use, or switches the models of the programming service.",
+			Run:  s.runModel,
+		},
+		repl.Command{
+			Name: commandName(CommandConfig),
+			Args: []repl.Arg{{Name: "key", Optional: true, Values: s.settingKeys}},
+			Help: "Shows the configuration and where every setting came from, or the settings starting with key.",
+			Run:  s.runConfig,
+		},
+		repl.Command{
+			Name: commandName(CommandDiff),
+			Help: "Shows the pending changes to the tracked files.",
+			Run:  s.runDiff,
+		},
+		repl.Command{
+			Name: commandName(CommandCommit),
+			Args: []repl.Arg{{Name: "message", Text: true}},
+			Help: "Commits every pending change with the message.",
+			Run:  s.runCommit,
+		},
+		repl.Command{
+			Name:    commandName(CommandQuit),
+			Aliases: []string{"exit", "q"},
+			Help:    "Ends the session.",
+			Run: func(_ io.Writer, _ string) error {
+				return repl.ErrQuit
+			},
+		},
+	)
+	return s
+}
+
+// commandName returns the name of a command without its prefix.
+func commandName(command string) string {
+	return strings.TrimPrefix(command, repl.Prefix)
+}
+
+// execute runs the command of a line typed at the prompt, writing its output to out. Empty lines are ignored.
+// It returns repl.ErrQuit if the session ends.
+func (s *replSession) execute(line string, out io.Writer) error {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	return s.commands.Execute(line, out)
+}
+
+// switchable returns the agent of the session if its configuration can be switched.
+func (s *replSession) switchable() (*switchableAgent, error) {
+	switchable, ok := s.agent.(*switchableAgent)
+	if !ok {
+		return nil, errors.New("the configuration cannot be changed in this session")
+	}
+	return switchable, nil
+}
+
+// commandNames returns the names of the commands, completed by /help.
+func (s *replSession) commandNames() []string {
+	var names []string
+	for _, command := range s.commands.Commands() {
+		names = append(names, command.Name)
+	}
+	return names
+}
+
+// profileNames returns the names of the configured profiles, completed by /profile.
+func (s *replSession) profileNames() []string {
+	switchable, err := s.switchable()
+	if err != nil {
+		return nil
+	}
+	return switchable.layers.ProfileNames()
+}
+
+// modelNames returns the models known for the programming service, completed by /model: its default models and the
+// models configured for it and its roles.
+func (s *replSession) modelNames() []string {
+	switchable, err := s.switchable()
+	if err != nil {
+		return nil
+	}
+	cfg := switchable.cfg
+	instructionsModel, generateCodeModel, analysisModel := config.DefaultModelNames(cfg.ProgrammingService)
+	models := []string{instructionsModel, generateCodeModel, analysisModel, cfg.InstructionsModelName, cfg.GenerateCodeModelName, cfg.AnalysisModelName}
+	for _, roleConfig := range cfg.Roles {
+		if roleConfig.Service == "" || roleConfig.Service == cfg.ProgrammingService {
+			models = append(models, roleConfig.Model)
+		}
+	}
+	models = slices.DeleteFunc(models, func(model string) bool { return model == "" })
+	slices.Sort(models)
+	return slices.Compact(models)
+}
+
+// settingKeys returns the keys of the settings, completed by /config.
+func (s *replSession) settingKeys() []string {
+	switchable, err := s.switchable()
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	for _, setting := range switchable.layers.Settings() {
+		keys = append(keys, setting.Key)
+	}
+	return keys
+}
+
+// runImplement runs /implement, the command of lines that do not start with one, which implements the change request
+// given as argument and offers to commit it.
+func (s *replSession) runImplement(out io.Writer, changeRequest string) error {
+	logging.Logger.Debugf("Handling %s command with request: %s", CommandImplement, changeRequest)
+	err := s.agent.Implement(models.AgentRequest{
+		Query:     changeRequest,
+		Directory: s.directory,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to implement change request: %w", err)
+	}
+	fmt.Fprintln(out, "Change request processed successfully.")
+	return nil
+}
+
+// runAsk runs /ask, which answers the question given as argument, following up on the previous ones unless it
+// starts with askNewThreadFlag.
+func (s *replSession) runAsk(out io.Writer, query string) error {
+	logging.Logger.Debugf("Handling %s command with query: %s", CommandAsk, query)
+	if rest, found := strings.CutPrefix(query, askNewThreadFlag); found && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+		if err := s.agent.ResetAskThread(); err != nil {
+			return fmt.Errorf("failed to start a new ask thread: %w", err)
+		}
+		query = strings.TrimSpace(rest)
+		if query == "" {
+			fmt.Fprintln(out, "Started a new ask thread.")
+			return nil
+		}
+	}
+	result, err := s.agent.Ask(models.AgentRequest{
+		Query:     query,
+		Directory: s.directory,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ask agent: %w", err)
+	}
+
+	rendered, err := utils.RenderWithGlamour(result)
+	if err != nil {
+		// Fall back to the raw result if glamour rendering fails
+		rendered = color.HiWhiteString(result) + "\n"
+	}
+	fmt.Fprint(out, rendered)
+	return nil
+}
+
+// runClear runs /clear, which forgets the previous /ask questions.
+func (s *replSession) runClear(out io.Writer, _ string) error {
+	logging.Logger.Debugf("Handling %s command", CommandClear)
+	if err := s.agent.ResetAskThread(); err != nil {
+		return fmt.Errorf("failed to clear the ask thread: %w", err)
+	}
+	fmt.Fprintln(out, "Cleared the ask thread.")
+	return nil
+}
+
+// runResume runs /resume, which resumes the interrupted change request of the run given as argument, or of the most
+// recent run if there is none.
+func (s *replSession) runResume(out io.Writer, runID string) error {
+	logging.Logger.Debugf("Handling %s command with run: %s", CommandResume, runID)
+	err := s.agent.Implement(models.AgentRequest{
+		Query:     runID,
+		Directory: s.directory,
+		Resume:    true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resume change request: %w", err)
+	}
+	fmt.Fprintln(out, "Change request processed successfully.")
+	return nil
+}
+
+// runInstructions runs /instructions, which shows the project and directory instructions given to the agent.
+func (s *replSession) runInstructions(out io.Writer, _ string) error {
+	logging.Logger.Debugf("Handling %s command", CommandInstructions)
+	if project := s.instructions.Project(); project != nil {
+		fmt.Fprintf(out, "Project instructions, added to every prompt: %s\n", describeInstructions(project))
+		fmt.Fprintln(out, project.Content)
+	} else {
+		fmt.Fprintf(out, "No project instructions, create %s to add them.\n", instructions.ProjectFile)
+	}
+
+	directoryInstructions, err := s.instructions.Directories()
+	if err != nil {
+		return err
+	}
+	if len(directoryInstructions) == 0 {
+		fmt.Fprintf(out, "No directory instructions, create %s files to add instructions for the files under their directory.\n", instructions.DirectoryFile)
+		return nil
+	}
+	fmt.Fprintln(out, "Directory instructions, added when files under their directory are read or edited:")
+	for _, file := range directoryInstructions {
+		fmt.Fprintf(out, "  - %s, for the files in %s\n", describeInstructions(file), file.Dir)
+	}
+	return nil
+}
+
+// describeInstructions returns the path and size of an instruction file.
+func describeInstructions(file *instructions.File) string {
+	description := fmt.Sprintf("%s (%d bytes", file.Path, file.Size)
+	if file.Truncated {
+		description += fmt.Sprintf(", truncated to %d", len(file.Content))
+	}
+	return description + ")"
+}
+
+// runProfile runs /profile, which switches to the profile given as argument, or lists the profiles if there is none.
+func (s *replSession) runProfile(out io.Writer, name string) error {
+	switchable, err := s.switchable()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		names := switchable.layers.ProfileNames()
+		if len(names) == 0 {
+			fmt.Fprintf(out, "No profiles are configured, add them to profiles in %s.\n", config.ProjectConfigFile)
+			return nil
+		}
+		current := switchable.cfg.Profile
+		if current == "" {
+			current = "none"
+		}
+		fmt.Fprintf(out, "Current profile: %s. Available profiles: %s\n", current, strings.Join(names, ", "))
+		return nil
+	}
+
+	if err := switchable.switchProfile(name); err != nil {
+		return fmt.Errorf("failed to switch to the profile %s: %w", name, err)
+	}
+	fmt.Fprintf(out, "Switched to the profile %s, using the %s service.\n", name, switchable.cfg.ProgrammingService)
+	return nil
+}
+
+// runStatus runs /status, which shows the repository, its branch and its pending changes.
+func (s *replSession) runStatus(out io.Writer, _ string) error {
+	fmt.Fprintf(out, "Repository: %s\n", s.directory)
+	if isGitRepo, _ := utils.IsGitRepository(s.directory); !isGitRepo {
+		fmt.Fprintln(out, "Not a git repository, changes are not tracked.")
+		return nil
+	}
+	status, err := utils.GetGitStatus(s.directory)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status.Head == "":
+		fmt.Fprintln(out, "Branch: no commits yet")
+	case status.Branch == "":
+		fmt.Fprintf(out, "Branch: detached at %s\n", status.Head)
+	default:
+		fmt.Fprintf(out, "Branch: %s at %s\n", status.Branch, status.Head)
+	}
+	if len(status.Changes) == 0 {
+		fmt.Fprintln(out, "No pending changes.")
+		return nil
+	}
+	fmt.Fprintf(out, "Pending changes (%d):\n", len(status.Changes))
+	for _, change := range status.Changes {
+		fmt.Fprintf(out, "  %s\n", change)
+	}
+	return nil
+}
+
+// runModel runs /model, which shows the models in use, or switches the models of the programming service to the
+// model given as argument.
+func (s *replSession) runModel(out io.Writer, model string) error {
+	switchable, err := s.switchable()
+	if err != nil {
+		return err
+	}
+	if model != "" {
+		if err := switchable.switchModel(model); err != nil {
+			return fmt.Errorf("failed to switch to the model %s: %w", model, err)
+		}
+		fmt.Fprintf(out, "Switched the %s models to %s.\n", switchable.cfg.ProgrammingService, model)
+	}
+
+	cfg := switchable.cfg
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "Service:\t%s\n", cfg.ProgrammingService)
+	fmt.Fprintf(writer, "Instructions model:\t%s\n", cfg.InstructionsModelName)
+	fmt.Fprintf(writer, "Code generation model:\t%s\n", cfg.GenerateCodeModelName)
+	fmt.Fprintf(writer, "Analysis model:\t%s\n", cfg.AnalysisModelName)
+	roles := make([]string, 0, len(cfg.Roles))
+	for role := range cfg.Roles {
+		roles = append(roles, role)
+	}
+	slices.Sort(roles)
+	for _, role := range roles {
+		roleConfig := cfg.Roles[role]
+		if roleConfig.Service == "" && roleConfig.Model == "" {
+			continue
+		}
+		llmService, model := roleConfig.Service, roleConfig.Model
+		if llmService == "" {
+			llmService = cfg.ProgrammingService
+		}
+		if model == "" {
+			model = "default"
+		}
+		fmt.Fprintf(writer, "Role %s:\t%s/%s\n", role, llmService, model)
+	}
+	return writer.Flush()
+}
+
+// runConfig runs /config, which shows the effective configuration and where every setting came from, or only the
+// settings whose key starts with the argument.
+func (s *replSession) runConfig(out io.Writer, key string) error {
+	switchable, err := s.switchable()
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	found := false
+	for _, setting := range switchable.layers.Settings() {
+		if strings.HasPrefix(setting.Key, key) {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", setting.Key, setting.Value, setting.Origin)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no setting starts with %s", key)
+	}
+	return writer.Flush()
+}
+
+// runDiff runs /diff, which shows the pending changes to the tracked files.
+func (s *replSession) runDiff(out io.Writer, _ string) error {
+	if isGitRepo, _ := utils.IsGitRepository(s.directory); !isGitRepo {
+		return fmt.Errorf("%s is not a git repository", s.directory)
+	}
+	diff, err := utils.GitDiff(s.directory)
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		fmt.Fprintln(out, "No pending changes to the tracked files.")
+		return nil
+	}
+	fmt.Fprint(out, diff)
+	return nil
+}
+
+// runCommit runs /commit, which commits every pending change with the message given as argument.
+func (s *replSession) runCommit(out io.Writer, message string) error {
+	if isGitRepo, _ := utils.IsGitRepository(s.directory); !isGitRepo {
+		return fmt.Errorf("%s is not a git repository", s.directory)
+	}
+	status, err := utils.GetGitStatus(s.directory)
+	if err != nil {
+		return err
+	}
+	if len(status.Changes) == 0 {
+		fmt.Fprintln(out, "No changes to commit.")
+		return nil
+	}
+	if err := s.gitUtil.Add(s.directory); err != nil {
+		return err
+	}
+	if err := s.gitUtil.Commit(s.directory, message); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Committed %d changes.\n", len(status.Changes))
+	return nil
+}
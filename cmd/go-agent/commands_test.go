@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/initialize"
+	"github.com/EduardDranca/GoAgent/internal/agent/instructions"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/agent/service"
+	"github.com/EduardDranca/GoAgent/internal/config"
+	"github.com/EduardDranca/GoAgent/internal/input/completer"
+	"github.com/EduardDranca/GoAgent/internal/repl"
+)
+
+// newTestSession creates a session in a repository holding a README and the project config projectConfig, with the
+// fake service answering from testdata/e2e/ask.json.
+func newTestSession(t *testing.T, projectConfig string, args ...string) (*replSession, *switchableAgent) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GEMINI_API_KEY", "gemini-key")
+	t.Setenv("GROQ_API_KEY", "")
+	script, err := filepath.Abs(filepath.Join("testdata", "e2e", "ask.json"))
+	require.NoError(t, err)
+	repo := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "README.md"), []byte("# Demo\n"), 0644))
+	configPath := filepath.Join(repo, config.ProjectConfigFile)
+	require.NoError(t, os.MkdirAll(filepath.Dir(configPath), 0755))
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf("service: fake\nfake_script: %s\n%s", script, projectConfig)), 0644))
+
+	layers, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), append([]string{"-directory", repo}, args...))
+	require.NoError(t, err)
+	cfg, err := layers.Config()
+	require.NoError(t, err)
+	projectInstructions, err := instructions.Load(repo, instructions.Limits{})
+	require.NoError(t, err)
+	programmingService, err := initialize.InitProgrammingService(context.Background(), cfg, initialize.WithInstructions(projectInstructions))
+	require.NoError(t, err)
+	gitUtil := initGitUtil(repo)
+	programmingAgent := newSwitchableAgent(context.Background(), layers, cfg, programmingService, projectInstructions, nil, gitUtil)
+	return newREPLSession(repo, programmingAgent, projectInstructions, gitUtil), programmingAgent
+}
+
+func TestREPLSession_Profile(t *testing.T) {
+	session, programmingAgent := newTestSession(t, `
+profiles:
+  cheap: {max_process_loops: 5}
+  best: {max_process_loops: 30}
+  groq: {service: groq}
+`, "-profile", "cheap")
+	programmingService := programmingAgent.programmingService
+
+	_, err := programmingAgent.Ask(models.AgentRequest{Query: "What does this repository contain?", Directory: session.directory})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, session.execute("/profile", &out))
+	assert.Equal(t, "Current profile: cheap. Available profiles: best, cheap, groq\n", out.String())
+
+	require.NoError(t, session.execute("/profile best", &out))
+	assert.Equal(t, "best", programmingAgent.cfg.Profile)
+	assert.Equal(t, 30, programmingAgent.cfg.MaxProcessLoops)
+	assert.NotSame(t, programmingService, programmingAgent.programmingService, "the programming service is rebuilt")
+	histories := programmingAgent.programmingService.(*service.LLMProgrammingService).Histories()
+	assert.Len(t, histories.AskThread.Turns, 1, "the ask thread is continued, the provider is unchanged")
+
+	// A profile whose configuration is invalid keeps the current one
+	assert.ErrorContains(t, session.execute("/profile groq", &out), "no API key set for the groq programming service")
+	assert.ErrorContains(t, session.execute("/profile fast", &out), `unknown profile "fast"`)
+	assert.Equal(t, "best", programmingAgent.cfg.Profile)
+
+	currentWordCompleter, err := completer.NewCurrentWordCompleter(nil, completer.WithCommands(session.commands))
+	require.NoError(t, err)
+	completion := currentWordCompleter.Complete([]rune("/profile b"), len("/profile b"))
+	assert.Equal(t, []string{"best"}, completion.Candidates)
+}
+
+func TestREPLSession_Model(t *testing.T) {
+	session, programmingAgent := newTestSession(t, "roles: {generate_code: {model: gemini-2.5-pro}}", "-service", "gemini")
+
+	var out bytes.Buffer
+	require.NoError(t, session.execute("/model gemini-2.0-flash", &out))
+	assert.Equal(t, "gemini-2.0-flash", programmingAgent.cfg.AnalysisModelName)
+	assert.Equal(t, "gemini-2.0-flash", programmingAgent.cfg.InstructionsModelName)
+	assert.Regexp(t, `(?m)^Code generation model: +gemini-2\.0-flash$`, out.String())
+	assert.Regexp(t, `(?m)^Role generate_code: +gemini/gemini-2\.5-pro$`, out.String())
+
+	values, ok := session.commands.CompleteCommand("/model ")
+	assert.True(t, ok)
+	assert.Contains(t, values, "gemini-2.0-flash")
+	assert.Contains(t, values, "gemini-2.5-pro", "the models of the roles are completed")
+
+	out.Reset()
+	require.NoError(t, session.execute("/config gemini.analysis", &out))
+	assert.Regexp(t, `^gemini\.analysis_model +gemini-2\.0-flash +selected\n$`, out.String())
+	assert.ErrorContains(t, session.execute("/config unknown", &out), "no setting starts with unknown")
+}
+
+func TestREPLSession_HelpAndQuit(t *testing.T) {
+	session, _ := newTestSession(t, "")
+
+	var out bytes.Buffer
+	require.NoError(t, session.execute("/help", &out))
+	for _, command := range []string{CommandHelp, CommandStatus, CommandModel, CommandConfig, CommandClear, CommandDiff, CommandCommit, CommandQuit} {
+		assert.Contains(t, out.String(), command)
+	}
+	assert.Contains(t, out.String(), "Lines that do not start with / run /implement.")
+
+	out.Reset()
+	require.NoError(t, session.execute("/? quit", &out))
+	assert.Equal(t, "/quit\n  Ends the session.\n  Aliases: /exit, /q\n", out.String())
+
+	assert.ErrorIs(t, session.execute("/exit", &out), repl.ErrQuit)
+	assert.ErrorContains(t, session.execute("/hepl", &out), "unknown command /hepl, run /help to list the commands")
+	assert.ErrorIs(t, session.execute("/commit", &out), repl.ErrUsage)
+	assert.NoError(t, session.execute("  ", &out), "empty lines are ignored")
+}
+
+func TestREPLSession_AgentCommands(t *testing.T) {
+	session, _ := newTestSession(t, "")
+
+	var out bytes.Buffer
+	require.NoError(t, session.execute("/ask What does this repository contain?", &out))
+	assert.Contains(t, out.String(), "README.")
+
+	out.Reset()
+	require.NoError(t, session.execute("/clear", &out))
+	assert.Equal(t, "Cleared the ask thread.\n", out.String())
+
+	out.Reset()
+	require.NoError(t, session.execute("/ask --new", &out))
+	assert.Equal(t, "Started a new ask thread.\n", out.String())
+
+	out.Reset()
+	require.NoError(t, session.execute("/instructions", &out))
+	assert.Contains(t, out.String(), "No project instructions, create .go-agent/instructions.md to add them.\n")
+
+	assert.ErrorContains(t, session.execute("/resume", &out), "failed to resume change request")
+}
+
+func TestREPLSession_GitCommands(t *testing.T) {
+	session, _ := newTestSession(t, "")
+
+	var out bytes.Buffer
+	require.NoError(t, session.execute("/status", &out))
+	assert.Contains(t, out.String(), "Not a git repository")
+	assert.ErrorContains(t, session.execute("/diff", &out), "is not a git repository")
+
+	repo, err := git.PlainInit(session.directory, false)
+	require.NoError(t, err)
+	session.gitUtil = initGitUtil(session.directory)
+	repoConfig, err := repo.Config()
+	require.NoError(t, err)
+	repoConfig.User.Name = "GoAgent"
+	repoConfig.User.Email = "goagent@example.com"
+	require.NoError(t, repo.SetConfig(repoConfig))
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = worktree.Add("README.md")
+	require.NoError(t, err)
+	_, err = worktree.Commit("Initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "GoAgent", Email: "goagent@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(session.directory, "README.md"), []byte("# Demo\n\nUpdated.\n"), 0644))
+
+	out.Reset()
+	require.NoError(t, session.execute("/status", &out))
+	assert.Regexp(t, `Branch: master at [0-9a-f]{7}\n`, out.String())
+	assert.Contains(t, out.String(), " M README.md")
+
+	out.Reset()
+	require.NoError(t, session.execute("/diff", &out))
+	assert.Contains(t, out.String(), "+Updated.")
+
+	out.Reset()
+	require.NoError(t, session.execute("/commit Update the README", &out))
+	head, err := repo.Head()
+	require.NoError(t, err)
+	commit, err := repo.CommitObject(head.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, "Update the README", commit.Message)
+
+	out.Reset()
+	require.NoError(t, session.execute("/commit Nothing", &out))
+	assert.Equal(t, "No changes to commit.\n", out.String())
+}
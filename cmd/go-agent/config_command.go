@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/EduardDranca/GoAgent/internal/config"
+)
+
+const (
+	// SubcommandConfig is the first argument that inspects the configuration instead of running the agent.
+	SubcommandConfig = "config"
+	// configShow prints the effective configuration, e.g. go-agent config show --origin
+	configShow = "show"
+)
+
+// runConfig runs the config subcommand with the given arguments, writing to stdout, and returns the exit code:
+// 0 if the configuration is valid, 1 if it is not and 2 for usage errors.
+func runConfig(args []string, stdout io.Writer) int {
+	if len(args) == 0 || args[0] != configShow {
+		fmt.Fprintf(os.Stderr, "usage: go-agent %s %s [--origin] [flags]\n", SubcommandConfig, configShow)
+		return 2
+	}
+	flags := flag.NewFlagSet(SubcommandConfig+" "+configShow, flag.ContinueOnError)
+	originFlag := flags.Bool("origin", false, "Prints where the value of every setting came from.")
+	layers, err := config.LoadLayers(flags, args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	for _, setting := range layers.Settings() {
+		if *originFlag {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", setting.Key, setting.Value, setting.Origin)
+		} else {
+			fmt.Fprintf(writer, "%s\t%s\n", setting.Key, setting.Value)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if _, err := layers.Config(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/config"
+)
+
+func TestRunConfigShow(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GEMINI_API_KEY", "")
+	t.Setenv("GOAGENT_LOG_LEVEL", "debug")
+	repo := t.TempDir()
+	projectConfig := filepath.Join(repo, config.ProjectConfigFile)
+	require.NoError(t, os.MkdirAll(filepath.Dir(projectConfig), 0755))
+	require.NoError(t, os.WriteFile(projectConfig, []byte("max_process_loops: 10\n"), 0644))
+
+	var stdout bytes.Buffer
+	code := runConfig([]string{configShow, "--origin", "-directory", repo, "-service", "groq", "-groq-api-key", "secret-key"}, &stdout)
+	assert.Equal(t, 0, code)
+	output := stdout.String()
+	assert.Regexp(t, `(?m)^service +groq +flag -service$`, output)
+	assert.Regexp(t, `(?m)^groq\.api_key +<redacted> +flag -groq-api-key$`, output)
+	assert.Regexp(t, `(?m)^log_level +debug +env GOAGENT_LOG_LEVEL$`, output)
+	assert.Regexp(t, `(?m)^max_process_loops +10 +project config `, output)
+	assert.Regexp(t, `(?m)^max_history_length +100 +default$`, output)
+	assert.NotContains(t, output, "secret-key")
+
+	stdout.Reset()
+	assert.Equal(t, 1, runConfig([]string{configShow, "-directory", repo}, &stdout), "the gemini API key is missing")
+	assert.Regexp(t, `(?m)^service +gemini$`, stdout.String())
+
+	assert.Equal(t, 2, runConfig([]string{"edit"}, &stdout))
+}
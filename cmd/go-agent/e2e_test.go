@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/agent"
+	"github.com/EduardDranca/GoAgent/internal/agent/initialize"
+	"github.com/EduardDranca/GoAgent/internal/agent/instructions"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/config"
+	"github.com/EduardDranca/GoAgent/internal/input"
+	"github.com/EduardDranca/GoAgent/internal/llm"
+)
+
+// e2eHarness runs requests through the same initialize, agent, service, context and git code as the CLI,
+// against a temporary git repository, with the LLM replaced by a fake service script from testdata/e2e.
+type e2eHarness struct {
+	t       *testing.T
+	dir     string
+	repo    *git.Repository
+	agent   agent.AgentInterface[models.AgentRequest]
+	session *replSession
+	answers []string // Answers given to the prompts of the agent, in order
+}
+
+// newE2EHarness creates a git repository holding files in a single commit and an agent driven by script.
+func newE2EHarness(t *testing.T, script string, files map[string]string) *e2eHarness {
+	t.Helper()
+	dir := t.TempDir()
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	repoConfig, err := repo.Config()
+	require.NoError(t, err)
+	repoConfig.User.Name = "GoAgent E2E"
+	repoConfig.User.Email = "e2e@example.com"
+	require.NoError(t, repo.SetConfig(repoConfig))
+
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	for path, content := range files {
+		fullPath := filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), 0644))
+		_, err = worktree.Add(path)
+		require.NoError(t, err)
+	}
+	_, err = worktree.Commit("Initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "GoAgent E2E", Email: "e2e@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	scriptPath := filepath.Join("testdata", "e2e", script)
+	fakeScript, err := llm.LoadFakeScript(scriptPath)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.Empty(t, fakeScript.Unused(), "not every scripted response was served")
+	})
+
+	cfg := &config.Config{
+		Directory:              dir,
+		ProgrammingService:     config.FakeService,
+		FakeScript:             scriptPath,
+		MaxHistoryLength:       100,
+		MaxProcessLoops:        10,
+		MaxFileSize:            512 * 1024,
+		FilePreviewLines:       50,
+		MaxParallelGenerations: 1,
+	}
+	projectInstructions, err := instructions.Load(dir, instructions.Limits{})
+	require.NoError(t, err)
+	programmingService, err := initialize.InitProgrammingService(context.Background(), cfg,
+		initialize.WithInstructions(projectInstructions),
+		initialize.WithFakeScript(fakeScript),
+	)
+	require.NoError(t, err)
+
+	h := &e2eHarness{t: t, dir: dir, repo: repo}
+	h.agent = newProgrammingAgent(programmingService, cfg, initGitUtil(dir))
+	h.session = newREPLSession(dir, h.agent, projectInstructions, initGitUtil(dir))
+
+	originalGetter := input.UserInputGetter
+	input.UserInputGetter = func(prompt string) (string, error) {
+		require.NotEmpty(t, h.answers, "unexpected prompt: %s", prompt)
+		answer := h.answers[0]
+		h.answers = h.answers[1:]
+		return answer, nil
+	}
+	t.Cleanup(func() { input.UserInputGetter = originalGetter })
+	return h
+}
+
+// run processes a line typed at the prompt, answering the prompts of the agent with answers.
+func (h *e2eHarness) run(line string, answers ...string) {
+	h.answers = answers
+	require.NoError(h.t, h.session.execute(line, io.Discard))
+	assert.Empty(h.t, h.answers, "not every answer was used")
+}
+
+func (h *e2eHarness) assertFile(path string, expected string) {
+	content, err := os.ReadFile(filepath.Join(h.dir, path))
+	require.NoError(h.t, err)
+	assert.Equal(h.t, expected, string(content))
+}
+
+// commitMessages returns the messages of the commits on HEAD, newest first.
+func (h *e2eHarness) commitMessages() []string {
+	head, err := h.repo.Head()
+	require.NoError(h.t, err)
+	commits, err := h.repo.Log(&git.LogOptions{From: head.Hash()})
+	require.NoError(h.t, err)
+
+	var messages []string
+	require.NoError(h.t, commits.ForEach(func(commit *object.Commit) error {
+		messages = append(messages, commit.Message)
+		return nil
+	}))
+	return messages
+}
+
+func (h *e2eHarness) assertClean() {
+	worktree, err := h.repo.Worktree()
+	require.NoError(h.t, err)
+	status, err := worktree.Status()
+	require.NoError(h.t, err)
+	assert.True(h.t, status.IsClean(), "worktree is not clean:\n%s", status)
+}
+
+func TestE2E_ImplementCreatesAndCommitsFile(t *testing.T) {
+	h := newE2EHarness(t, "implement_new_file.yaml", map[string]string{"README.md": "# Demo\n"})
+
+	h.run("Add a hello.txt file greeting the world", "Y")
+
+	h.assertFile("hello.txt", "Hello, world!\n")
+	h.assertFile("README.md", "# Demo\n")
+	assert.Equal(t, []string{"Add hello.txt", "Initial commit"}, h.commitMessages())
+	h.assertClean()
+}
+
+func TestE2E_ImplementReadsAndUpdatesFile(t *testing.T) {
+	h := newE2EHarness(t, "implement_read_and_update.yaml", map[string]string{"README.md": "# Demo\n"})
+
+	h.run("/implement Document the build command in the README", "Y")
+
+	h.assertFile("README.md", "# Demo\n\n## Build\n\nRun `go build ./...`.\n")
+	assert.Equal(t, []string{"Document the build in the README", "Initial commit"}, h.commitMessages())
+	h.assertClean()
+}
+
+func TestE2E_ImplementNotCommittedWhenDeclined(t *testing.T) {
+	h := newE2EHarness(t, "implement_new_file.yaml", map[string]string{"README.md": "# Demo\n"})
+
+	h.run("Add a hello.txt file greeting the world", "N")
+
+	h.assertFile("hello.txt", "Hello, world!\n")
+	assert.Equal(t, []string{"Initial commit"}, h.commitMessages())
+}
+
+func TestE2E_AskAnswersWithoutChangingFiles(t *testing.T) {
+	h := newE2EHarness(t, "ask.json", map[string]string{"README.md": "# Demo\n"})
+
+	answer, err := h.agent.Ask(models.AgentRequest{Query: "What does this repository contain?", Directory: h.dir})
+	require.NoError(t, err)
+	assert.Equal(t, "The repository only contains a README.", answer)
+
+	h.run("/ask What does this repository contain?")
+	h.run("/clear")
+	h.run("/ask --new What does this repository contain?")
+	assert.Equal(t, []string{"Initial commit"}, h.commitMessages())
+	h.assertClean()
+}
+
+func TestE2E_ImplementFollowsDirectoryInstructions(t *testing.T) {
+	h := newE2EHarness(t, "implement_directory_instructions.yaml", map[string]string{
+		"docs/AGENTS.md": "End every document with a license notice.",
+		"docs/guide.md":  "# Guide\n",
+	})
+
+	h.run("Document the install command in the guide", "Y")
+	h.run("/instructions")
+
+	h.assertFile("docs/guide.md", "# Guide\n\nRun `go install ./...`.\n\nLicensed under MIT.\n")
+	assert.Equal(t, []string{"Document the install command", "Initial commit"}, h.commitMessages())
+}
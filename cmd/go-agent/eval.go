@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/EduardDranca/GoAgent/internal/eval"
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+// SubcommandEval is the first argument that runs an eval suite instead of the interactive agent.
+const SubcommandEval = "eval"
+
+// runEval runs the eval subcommand with the given arguments and returns the exit code:
+// 0 if every task that ran passed, 1 if a task failed and 2 for usage errors.
+func runEval(args []string) int {
+	flags := flag.NewFlagSet(SubcommandEval, flag.ContinueOnError)
+	suiteFlag := flags.String("suite", "", "Sets the YAML file defining the targets and tasks to evaluate. Required.")
+	targetsFlag := flags.String("targets", "", "Comma-separated names of the targets to run. Defaults to all targets of the suite.")
+	jsonFlag := flags.String("json", "", "Writes the detailed results as JSON to the given file.")
+	logLevelFlag := flags.String("log-level", "warning", "Sets the logging level of the agent while tasks run.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *suiteFlag == "" {
+		fmt.Fprintln(os.Stderr, "the -suite flag is required")
+		flags.Usage()
+		return 2
+	}
+
+	if err := logging.InitializeLogging(*logLevelFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize logging: %v\n", err)
+		return 2
+	}
+	defer logging.CloseLogger()
+
+	suite, err := eval.LoadSuite(*suiteFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var targets []string
+	if *targetsFlag != "" {
+		targets = strings.Split(*targetsFlag, ",")
+	}
+
+	results := eval.Run(context.Background(), suite, targets)
+	if err := eval.WriteTable(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *jsonFlag != "" {
+		file, err := os.Create(*jsonFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", *jsonFlag, err)
+			return 2
+		}
+		defer file.Close()
+		if err := eval.WriteJSON(file, results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	for _, result := range results {
+		if !result.Skipped && !result.Passed {
+			return 1
+		}
+	}
+	return 0
+}
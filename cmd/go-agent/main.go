@@ -3,34 +3,66 @@ package main
 import (
 	"context"
 	"errors"
-	"strings"
+	"flag"
+	"fmt"
+	"os"
 
-	"github.com/fatih/color"
 	"github.com/reeflective/readline" // Use the new library
 
 	"github.com/EduardDranca/GoAgent/internal/agent"
+	context2 "github.com/EduardDranca/GoAgent/internal/agent/context"
 	"github.com/EduardDranca/GoAgent/internal/agent/initialize"
+	"github.com/EduardDranca/GoAgent/internal/agent/instructions"
 	"github.com/EduardDranca/GoAgent/internal/agent/models"
 	"github.com/EduardDranca/GoAgent/internal/agent/service"
 	"github.com/EduardDranca/GoAgent/internal/config"
 	"github.com/EduardDranca/GoAgent/internal/input"
 	"github.com/EduardDranca/GoAgent/internal/input/completer"
+	"github.com/EduardDranca/GoAgent/internal/llm"
 	"github.com/EduardDranca/GoAgent/internal/logging"
+	"github.com/EduardDranca/GoAgent/internal/repl"
 	"github.com/EduardDranca/GoAgent/internal/utils"
 )
 
+// Commands typed at the prompt, see newREPLSession.
 const (
-	CommandAsk       = "/ask"
-	CommandImplement = "/implement"
+	CommandAsk          = "/ask"
+	CommandImplement    = "/implement"
+	CommandClear        = "/clear"
+	CommandResume       = "/resume"
+	CommandInstructions = "/instructions"
+	CommandProfile      = "/profile"
+	CommandHelp         = "/help"
+	CommandStatus       = "/status"
+	CommandModel        = "/model"
+	CommandConfig       = "/config"
+	CommandDiff         = "/diff"
+	CommandCommit       = "/commit"
+	CommandQuit         = "/quit"
+
+	// askNewThreadFlag starts a new ask thread instead of following up on the previous questions, e.g. /ask --new <query>
+	askNewThreadFlag = "--new"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == SubcommandEval {
+		os.Exit(runEval(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == SubcommandConfig {
+		os.Exit(runConfig(os.Args[2:], os.Stdout))
+	}
+
 	logging.Logger.Info("Starting GoAgent...")
-	// Load configuration
-	cfg, err := config.LoadConfig()
+	// Load configuration, keeping its layers to switch profiles
+	layers, err := config.LoadLayers(flag.CommandLine, os.Args[1:])
 	if err != nil {
 		logging.Logger.Fatalf("Failed to load configuration: %v", err)
 	}
+	cfg, err := layers.Config()
+	if err != nil {
+		logging.Logger.Fatalf("Failed to load configuration: %v", err)
+	}
+	logging.Logger.Infof("Service being used: %s", cfg.ProgrammingService)
 
 	// Initialize logging with log level from config
 	err = logging.InitializeLogging(cfg.LogLevel)
@@ -48,47 +80,100 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Load the project instructions, shared by the prompts and the /instructions command
+	projectInstructions, err := instructions.Load(cfg.Directory, instructions.Limits{
+		MaxFileSize:  cfg.Instructions.MaxFileSize,
+		MaxTotalSize: cfg.Instructions.MaxTotalSize,
+	})
+	if err != nil {
+		logging.Logger.Fatalf("Failed to load project instructions: %v", err)
+	}
+
+	// Exit once the agent returned, so that the transcript it records is closed
+	if err := runAgent(ctx, layers, cfg, projectInstructions); err != nil {
+		logging.Logger.Fatalf("Error: %v", err)
+	}
+}
+
+// runAgent runs the agent, recording its LLM sessions to one transcript closed on return if enabled.
+func runAgent(ctx context.Context, layers *config.Layers, cfg *config.Config, projectInstructions *instructions.Instructions) error {
+	var recorder *llm.SessionRecorder
+	if cfg.RecordSessions {
+		var err error
+		recorder, err = llm.NewSessionRecorder(cfg.SessionsDir, llm.NewSessionID())
+		if err != nil {
+			return fmt.Errorf("failed to create the session recorder: %w", err)
+		}
+		defer recorder.Close()
+		logging.Logger.Infof("Recording LLM sessions to %s", recorder.Path())
+	}
+
 	// Initialize services
-	programmingService, err := initialize.InitProgrammingService(ctx, cfg)
+	programmingService, err := initialize.InitProgrammingService(ctx, cfg, serviceOptions(projectInstructions, recorder)...)
 	if err != nil {
-		logging.Logger.Fatalf("Failed to initialize services: %v", err)
+		return fmt.Errorf("failed to initialize services: %w", err)
 	}
 	logging.Logger.Infof("Programming service initialized successfully.")
 
 	// Run the application based on the specified mode
-	runService(programmingService, cfg.Directory)
+	return runService(ctx, layers, programmingService, cfg, projectInstructions, recorder)
+}
+
+// serviceOptions returns the options of the programming services of the session, recording their LLM sessions to
+// recorder unless it is nil.
+func serviceOptions(projectInstructions *instructions.Instructions, recorder *llm.SessionRecorder) []initialize.Option {
+	options := []initialize.Option{initialize.WithInstructions(projectInstructions)}
+	if recorder != nil {
+		options = append(options, initialize.WithSessionRecorder(recorder))
+	}
+	return options
 }
 
 // runService runs the application in local mode
-func runService(programmingService service.ProgrammingService, directory string) {
+func runService(ctx context.Context, layers *config.Layers, programmingService service.ProgrammingService, cfg *config.Config, projectInstructions *instructions.Instructions, recorder *llm.SessionRecorder) error {
+	directory := cfg.Directory
 	logging.Logger.Infof("Starting runService in directory: %s", directory)
 	if directory == "" {
-		logging.Logger.Fatalf("Error: -d option missing; directory must be a git repository")
+		return errors.New("-d option missing; directory must be a git repository")
 	}
 
 	isDir, err := utils.IsDirectory(directory)
 	if err != nil {
-		logging.Logger.Fatalf("Error: failed to access directory %s with error %v", directory, err)
+		return fmt.Errorf("failed to access directory %s with error %w", directory, err)
 	}
 
 	if !isDir {
-		logging.Logger.Fatalf("Error: value provided for -d argument must be a valid directory")
+		return errors.New("value provided for -d argument must be a valid directory")
 	}
 
 	// Initialize gitUtil based on whether the directory is a git repository
 	gitUtil := initGitUtil(directory)
 
-	currentWordCompleter, err := completer.InitCompleter(directory, gitUtil)
+	programmingAgent := newSwitchableAgent(ctx, layers, cfg, programmingService, projectInstructions, recorder, gitUtil)
+	session := newREPLSession(directory, programmingAgent, projectInstructions, gitUtil)
+
+	currentWordCompleter, err := completer.InitCompleter(directory, gitUtil, completer.WithCommands(session.commands))
 	if err != nil {
 		logging.Logger.Errorf("Failed to initialize current word completer: %v. File name completion won't be available", err)
 	}
 
-	programmingAgent := agent.NewLocalProgrammingAgent(programmingService, gitUtil)
+	if cfg.ResumeRun != "" {
+		if err := session.runResume(os.Stdout, cfg.ResumeRun); err != nil {
+			logging.Logger.Errorf("Error: %v", err)
+		}
+	}
+
+	runChangeRequestLoop(session, currentWordCompleter)
+	return nil
+}
 
-	runChangeRequestLoop(directory, programmingAgent, currentWordCompleter, gitUtil)
+// newProgrammingAgent creates the agent that handles the change requests of the session.
+func newProgrammingAgent(programmingService service.ProgrammingService, cfg *config.Config, gitUtil utils.GitUtil) agent.AgentInterface[models.AgentRequest] {
+	fileLimits := context2.FileLimits{MaxFileSize: cfg.MaxFileSize, PreviewLines: cfg.FilePreviewLines}
+	return agent.NewLocalProgrammingAgent(programmingService, gitUtil, context2.WithFileLimits(fileLimits))
 }
 
-func runChangeRequestLoop(directory string, programmingAgent agent.AgentInterface[models.AgentRequest], currentWordCompleter *completer.CurrentWordCompleter, gitUtil utils.GitUtil) {
+func runChangeRequestLoop(session *replSession, currentWordCompleter *completer.CurrentWordCompleter) {
 	for {
 		logging.Logger.Infof("Waiting for change request...")
 		changeRequest, err := input.GetLocalChangeRequest(currentWordCompleter)
@@ -102,13 +187,19 @@ func runChangeRequestLoop(directory string, programmingAgent agent.AgentInterfac
 		}
 		logging.Logger.Debugf("Received change request: %s", changeRequest)
 
-		processLocalChangeRequest(directory, changeRequest, programmingAgent)
+		if err := session.execute(changeRequest, os.Stdout); errors.Is(err, repl.ErrQuit) {
+			logging.Logger.Infof("Session ended.")
+			break
+		} else if err != nil {
+			logging.Logger.Errorf("Error: %v", err)
+		}
 
-		// Re-initialize completer in case files changed
-		currentWordCompleter, err = completer.InitCompleter(directory, gitUtil)
-		if err != nil {
-			logging.Logger.Errorf("Failed to initialize current word completer: %v", err)
-			// Continue even if completer fails, just without completion
+		// Update the completer with the files changed by the request
+		if currentWordCompleter != nil {
+			currentWordCompleter.Remember(changeRequest)
+			if err := currentWordCompleter.Refresh(); err != nil {
+				logging.Logger.Errorf("Failed to update the completions: %v", err)
+			}
 		}
 	}
 }
@@ -130,78 +221,3 @@ func initGitUtil(directory string) utils.GitUtil {
 	}
 	return gitUtil
 }
-
-// parseCommand extracts the command and argument from the input string.
-// If the string starts with '/', it's treated as a command. Otherwise, it's
-// treated as an implicit '/implement' command.
-func parseCommand(input string) (command string, argument string) {
-	input = strings.TrimSpace(input)
-	if strings.HasPrefix(input, "/") {
-		parts := strings.FieldsFunc(input, func(r rune) bool {
-			return r == ' ' || r == '\t'
-		})
-		if len(parts) > 0 {
-			command = parts[0]
-			argument = strings.TrimSpace(strings.TrimPrefix(input, command))
-		} else {
-			// Should not happen with TrimSpace, but handle defensively
-			command = input
-			argument = ""
-		}
-	} else {
-		command = CommandImplement // Default command
-		argument = input
-	}
-	return command, argument
-}
-
-// processLocalChangeRequest parses the change request and dispatches to the appropriate handler.
-func processLocalChangeRequest(directory string, changeRequest string, programmingAgent agent.AgentInterface[models.AgentRequest]) {
-	logging.Logger.Debugf("Processing change request: %s", changeRequest)
-
-	command, argument := parseCommand(changeRequest)
-
-	switch command {
-	case CommandAsk:
-		handleAskCommand(directory, argument, programmingAgent)
-	case CommandImplement:
-		handleImplementCommand(directory, argument, programmingAgent)
-	default:
-		logging.Logger.Errorf("Error: unknown command '%s'. Supported commands: %s, %s", command, CommandAsk, CommandImplement)
-	}
-}
-
-// handleAskCommand processes the /ask command.
-func handleAskCommand(directory string, query string, programmingAgent agent.AgentInterface[models.AgentRequest]) {
-	logging.Logger.Debugf("Handling %s command with query: %s", CommandAsk, query)
-	result, err := programmingAgent.Ask(models.AgentRequest{
-		Query:     query,
-		Directory: directory,
-	})
-	if err != nil {
-		logging.Logger.Errorf("Error: failed to ask agent: %v", err)
-		return
-	}
-
-	out, err := utils.RenderWithGlamour(result)
-	if err != nil {
-		// Fallback to printing raw result if glamour rendering fails
-		logging.Logger.Infof(color.HiWhiteString(result))
-	} else {
-		logging.Logger.Infof(out) // Print glamour-rendered output to stdout
-	}
-}
-
-// handleImplementCommand processes the /implement command (default).
-func handleImplementCommand(directory string, changeRequest string, programmingAgent agent.AgentInterface[models.AgentRequest]) {
-	logging.Logger.Debugf("Handling %s command with request: %s", CommandImplement, changeRequest)
-	err := programmingAgent.Implement(models.AgentRequest{
-		Query:     changeRequest,
-		Directory: directory,
-	})
-	if err != nil {
-		logging.Logger.Errorf("Error: failed to implement change request: %v", err)
-		return
-	}
-	logging.Logger.Infof("Change request processed successfully.")
-}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EduardDranca/GoAgent/internal/agent"
+	"github.com/EduardDranca/GoAgent/internal/agent/initialize"
+	"github.com/EduardDranca/GoAgent/internal/agent/instructions"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/agent/service"
+	"github.com/EduardDranca/GoAgent/internal/config"
+	"github.com/EduardDranca/GoAgent/internal/llm"
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+// switchableAgent is the agent of the session, rebuilt with the programming service of another configuration, e.g.
+// another profile or model, without restarting the session.
+type switchableAgent struct {
+	agent.AgentInterface[models.AgentRequest]
+	ctx                 context.Context
+	layers              *config.Layers
+	cfg                 *config.Config
+	programmingService  service.ProgrammingService
+	projectInstructions *instructions.Instructions
+	recorder            *llm.SessionRecorder
+	gitUtil             utils.GitUtil
+}
+
+// newSwitchableAgent creates the agent of the session, using programmingService, created with the configuration
+// merged by layers. The rebuilt services keep recording to recorder, unless it is nil.
+func newSwitchableAgent(ctx context.Context, layers *config.Layers, cfg *config.Config, programmingService service.ProgrammingService, projectInstructions *instructions.Instructions, recorder *llm.SessionRecorder, gitUtil utils.GitUtil) *switchableAgent {
+	return &switchableAgent{
+		AgentInterface:      newProgrammingAgent(programmingService, cfg, gitUtil),
+		ctx:                 ctx,
+		layers:              layers,
+		cfg:                 cfg,
+		programmingService:  programmingService,
+		projectInstructions: projectInstructions,
+		recorder:            recorder,
+		gitUtil:             gitUtil,
+	}
+}
+
+// switchProfile selects the profile name and rebuilds the programming service with the resulting configuration.
+func (a *switchableAgent) switchProfile(name string) error {
+	layers, err := a.layers.WithProfile(name)
+	if err != nil {
+		return err
+	}
+	return a.reconfigure(layers)
+}
+
+// switchModel sets the instructions, code generation and analysis models of the programming service to model and
+// rebuilds the programming service. The models configured for the roles are kept.
+func (a *switchableAgent) switchModel(model string) error {
+	llmService := a.cfg.ProgrammingService
+	if llmService == config.FakeService {
+		return fmt.Errorf("the %s service has no models", llmService)
+	}
+	layers := a.layers
+	for _, task := range []string{"instructions_model", "generate_code_model", "analysis_model"} {
+		var err error
+		if layers, err = layers.WithSetting(fmt.Sprintf("%s.%s", llmService, task), model); err != nil {
+			return err
+		}
+	}
+	return a.reconfigure(layers)
+}
+
+// reconfigure rebuilds the programming service with the configuration merged by layers. The conversations of the
+// roles whose provider is unchanged are continued. If the configuration or the service is invalid, the current
+// configuration is kept.
+func (a *switchableAgent) reconfigure(layers *config.Layers) error {
+	cfg, err := layers.Config()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	options := append(serviceOptions(a.projectInstructions, a.recorder), initialize.WithPreviousService(a.programmingService, a.cfg))
+	programmingService, err := initialize.InitProgrammingService(a.ctx, cfg, options...)
+	if err != nil {
+		return err
+	}
+
+	a.AgentInterface = newProgrammingAgent(programmingService, cfg, a.gitUtil)
+	a.layers, a.cfg, a.programmingService = layers, cfg, programmingService
+	utils.SetGlamourStylePath(string(cfg.GlamourStylePath))
+	return nil
+}
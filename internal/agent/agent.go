@@ -5,4 +5,6 @@ package agent
 type AgentInterface[T any] interface {
 	Implement(request T) error
 	Ask(request T) (string, error)
+	// ResetAskThread starts a new conversation for the following questions asked with Ask.
+	ResetAskThread() error
 }
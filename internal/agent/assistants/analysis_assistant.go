@@ -2,11 +2,14 @@ package assistants
 
 import (
 	context2 "context"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
 	"github.com/EduardDranca/GoAgent/internal/llm"
 )
 
 type AnalysisAssistant interface {
 	Execute(ctx context2.Context, message string) (string, error)
+	GetHistory() []models.Message
+	SetHistory(history []models.Message)
 }
 
 type defaultAnalysisAssistant struct {
@@ -26,3 +29,13 @@ func (a *defaultAnalysisAssistant) Execute(ctx context2.Context, message string)
 	}
 	return response, nil
 }
+
+// GetHistory returns the history of the analysis session.
+func (a *defaultAnalysisAssistant) GetHistory() []models.Message {
+	return a.session.GetHistory()
+}
+
+// SetHistory replaces the history of the analysis session, e.g. to continue an earlier conversation.
+func (a *defaultAnalysisAssistant) SetHistory(history []models.Message) {
+	a.session.SetHistory(history)
+}
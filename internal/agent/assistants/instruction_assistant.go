@@ -16,6 +16,8 @@ import (
 type InstructionAssistant interface {
 	Instruct(ctx context2.Context, message string) (commands.Command, error)
 	ClearHistory()
+	GetHistory() []models.Message
+	SetHistory(history []models.Message)
 }
 
 type defaultInstructionAssistant struct {
@@ -33,13 +35,23 @@ func (a *defaultInstructionAssistant) ClearHistory() {
 	a.session.SetHistory([]models.Message{})
 }
 
+// GetHistory returns the history of the instruction agent.
+func (a *defaultInstructionAssistant) GetHistory() []models.Message {
+	return a.session.GetHistory()
+}
+
+// SetHistory replaces the history of the instruction agent, e.g. to resume an interrupted change request.
+func (a *defaultInstructionAssistant) SetHistory(history []models.Message) {
+	a.session.SetHistory(history)
+}
+
 func (a *defaultInstructionAssistant) Instruct(ctx context2.Context, message string) (commands.Command, error) {
 	logging.Logger.Debugf("Starting Instruct function with message: %s", message)
 
 	var commandMap map[string]interface{}
 	var response string
 
-	// Use retry-go to handle potential JSON unmarshalling failures
+	// Use retry-go to handle potential JSON unmarshalling failures; provider errors are already retried by the session
 	err := retry.Do(
 		func() error {
 			logging.Logger.Debug("Attempting to get and unmarshal LLM response")
@@ -49,8 +61,7 @@ func (a *defaultInstructionAssistant) Instruct(ctx context2.Context, message str
 			response, sendErr = a.session.SendMessage(ctx, message, llm.WithJSON())
 			if sendErr != nil {
 				logging.Logger.Errorf("SendMessage failed: %v", sendErr)
-				// Return the error to retry the SendMessage call
-				return sendErr
+				return retry.Unrecoverable(sendErr)
 			}
 			logging.Logger.Debugf("Raw response from SendMessage: %s", response)
 
@@ -69,6 +80,7 @@ func (a *defaultInstructionAssistant) Instruct(ctx context2.Context, message str
 		retry.DelayType(retry.BackOffDelay),
 		retry.Delay(100*time.Millisecond),
 		retry.MaxDelay(5*time.Second), // Maximum delay between retries is 5 seconds
+		retry.LastErrorOnly(true),
 		retry.OnRetry(func(n uint, err error) {
 			logging.Logger.Warnf("Retry attempt %d failed: %v", n+1, err)
 		}),
@@ -83,7 +95,7 @@ func (a *defaultInstructionAssistant) Instruct(ctx context2.Context, message str
 			return nil, fmt.Errorf("failed to unmarshal command response into JSON after multiple retries. Raw response: %s. %w", response, err)
 		}
 		// Handle other types of errors (e.g., from SendMessage)
-		return nil, fmt.Errorf("failed to get valid command response from LLM after multiple retries. %w", err)
+		return nil, fmt.Errorf("failed to get valid command response from LLM: %w", err)
 	}
 
 	// Proceed with creating the command now that we have a valid commandMap
@@ -2,14 +2,21 @@ package commands
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/config"
+	"github.com/EduardDranca/GoAgent/internal/input"
 	"github.com/EduardDranca/GoAgent/internal/logging"
 	"github.com/EduardDranca/GoAgent/internal/utils"
 	"github.com/fatih/color"
+	"strconv"
 	"strings"
 )
 
+// ErrNoUserToAsk is returned by ask_user commands when no user is available and the policy is to fail the request.
+var ErrNoUserToAsk = errors.New("the agent asked for clarification but no user is available to answer")
+
 // Command interface
 type Command interface {
 	Process(agentContext context.ProgrammingAgentContext) (string, error)
@@ -81,6 +88,23 @@ func (c *UpdateFileCommand) Process(_ context.ProgrammingAgentContext) (string,
 
 }
 
+// UpdateFilesCommand struct represents a command to update several independent files at once.
+type UpdateFilesCommand struct {
+	Updates []UpdateFileCommand `json:"updates"`
+}
+
+// Process for UpdateFilesCommand returns a message indicating the files were updated.
+func (c *UpdateFilesCommand) Process(agentContext context.ProgrammingAgentContext) (string, error) {
+	filePaths := make([]string, 0, len(c.Updates))
+	for i := range c.Updates {
+		if _, err := c.Updates[i].Process(agentContext); err != nil {
+			return "", err
+		}
+		filePaths = append(filePaths, c.Updates[i].FilePath)
+	}
+	return fmt.Sprintf("The files %s were updated, please carry on with the change request.", strings.Join(filePaths, ", ")), nil
+}
+
 // MoveFileCommand struct represents a command to move a file.
 type MoveFileCommand struct {
 	OldPath string `json:"old_path"`
@@ -135,6 +159,59 @@ func (c *RespondCommand) Process(_ context.ProgrammingAgentContext) (string, err
 	return c.Message, nil
 }
 
+// AskUserCommand struct represents a command to ask the user for clarification, optionally offering answers to choose from.
+type AskUserCommand struct {
+	Question string               `json:"question"`
+	Options  []string             `json:"options"`
+	Policy   config.AskUserPolicy `json:"-"` // Set by the service running the command, empty when the user is asked
+}
+
+// Process for AskUserCommand asks the user the question and returns the answer.
+func (c *AskUserCommand) Process(_ context.ProgrammingAgentContext) (string, error) {
+	logging.Logger.Infof("Executing command: Ask user: %s", c.Question)
+	if c.Policy != "" {
+		return c.answerWithoutUser()
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "The agent needs clarification: %s\n", c.Question)
+	for i, option := range c.Options {
+		fmt.Fprintf(&sb, "  %d. %s\n", i+1, option)
+	}
+	if len(c.Options) > 0 {
+		sb.WriteString("Type the number of an answer or your own answer: ")
+	} else {
+		sb.WriteString("Your answer: ")
+	}
+	answer, err := input.UserInputGetter(sb.String())
+	if err != nil {
+		return "", fmt.Errorf("error getting the answer of the user: %w", err)
+	}
+
+	answer = strings.TrimSpace(answer)
+	if index, err := strconv.Atoi(answer); err == nil && index >= 1 && index <= len(c.Options) {
+		answer = c.Options[index-1]
+	}
+	if answer == "" {
+		return "The user did not answer. Proceed with your best judgement and state the assumptions you made.", nil
+	}
+	return fmt.Sprintf("The user answered: %s", answer), nil
+}
+
+// answerWithoutUser answers the question according to the policy of the command.
+func (c *AskUserCommand) answerWithoutUser() (string, error) {
+	logging.Logger.Warnf("No user is available to answer, following the %s policy", c.Policy)
+	switch c.Policy {
+	case config.FailPolicy:
+		return "", fmt.Errorf("%w: %s", ErrNoUserToAsk, c.Question)
+	case config.FirstOptionPolicy:
+		if len(c.Options) > 0 {
+			return fmt.Sprintf("The user is not available, use the first answer: %s", c.Options[0]), nil
+		}
+	}
+	return "The user is not available to answer. Proceed with your best judgement and state the assumptions you made.", nil
+}
+
 // formatFileContent formats the content of a file for output.
 func formatFileContent(file string, content string) string {
 	return fmt.Sprintf("Content of %s:\n%s\n\n", file, content)
@@ -177,41 +254,30 @@ func NewCommand(commandMap map[string]interface{}) (Command, error) {
 		return &SearchCommand{Query: query}, nil
 
 	case "update_file":
-		filePathRaw, ok := commandMap["file_path"]
-		if !ok {
-			return nil, fmt.Errorf("missing 'file_path' parameter for update_file command")
-		}
-		filePath, ok := filePathRaw.(string)
-		if !ok {
-			return nil, fmt.Errorf("invalid 'file_path' parameter type for update_file command")
-		}
+		return newUpdateFileCommand(commandMap)
 
-		implementationPlanRaw, ok := commandMap["implementation_plan"]
+	case "update_files":
+		updatesRaw, ok := commandMap["updates"]
 		if !ok {
-			return nil, fmt.Errorf("missing 'implementation_plan' parameter for update_file command")
+			return nil, fmt.Errorf("missing 'updates' parameter for update_files command")
 		}
-		implementationPlan, ok := implementationPlanRaw.(string)
-		if !ok {
-			return nil, fmt.Errorf("invalid 'implementation_plan' parameter type for update_file command")
+		updates, ok := updatesRaw.([]interface{})
+		if !ok || len(updates) == 0 {
+			return nil, fmt.Errorf("invalid 'updates' parameter for update_files command, expected a non-empty array")
 		}
-
-		var contextFiles []string
-		contextFilesRaw, ok := commandMap["context_files"]
-		if !ok {
-			contextFiles = []string{}
-		} else {
-			contextFilesSlice, ok := contextFilesRaw.([]interface{})
+		command := &UpdateFilesCommand{}
+		for _, updateRaw := range updates {
+			updateMap, ok := updateRaw.(map[string]interface{})
 			if !ok {
-				return nil, fmt.Errorf("invalid 'context_files' parameter type for update_file command")
+				return nil, fmt.Errorf("invalid element type in 'updates' parameter for update_files command")
+			}
+			update, err := newUpdateFileCommand(updateMap)
+			if err != nil {
+				return nil, err
 			}
-			contextFiles = convertToStringArray(contextFilesSlice)
+			command.Updates = append(command.Updates, *update)
 		}
-
-		return &UpdateFileCommand{
-			FilePath:           filePath,
-			ImplementationPlan: implementationPlan,
-			ContextFiles:       contextFiles,
-		}, nil
+		return command, nil
 
 	case "move_file":
 		oldPathRaw, ok := commandMap["old_path"]
@@ -266,11 +332,75 @@ func NewCommand(commandMap map[string]interface{}) (Command, error) {
 		}
 		return &RespondCommand{Message: message}, nil
 
+	case "ask_user":
+		questionRaw, ok := commandMap["question"]
+		if !ok {
+			return nil, fmt.Errorf("missing 'question' parameter for ask_user command")
+		}
+		question, ok := questionRaw.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid 'question' parameter type for ask_user command")
+		}
+		var options []string
+		if optionsRaw, ok := commandMap["options"]; ok && optionsRaw != nil {
+			optionsSlice, ok := optionsRaw.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid 'options' parameter type for ask_user command")
+			}
+			for _, o := range optionsSlice {
+				option, ok := o.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid option type in 'options' parameter for ask_user command")
+				}
+				options = append(options, option)
+			}
+		}
+		return &AskUserCommand{Question: question, Options: options}, nil
+
 	default:
 		return nil, fmt.Errorf("unknown command: %s", commandMap["command"])
 	}
 }
 
+// newUpdateFileCommand constructs an UpdateFileCommand from the parameters of an update_file command.
+func newUpdateFileCommand(commandMap map[string]interface{}) (*UpdateFileCommand, error) {
+	filePathRaw, ok := commandMap["file_path"]
+	if !ok {
+		return nil, fmt.Errorf("missing 'file_path' parameter for update_file command")
+	}
+	filePath, ok := filePathRaw.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid 'file_path' parameter type for update_file command")
+	}
+
+	implementationPlanRaw, ok := commandMap["implementation_plan"]
+	if !ok {
+		return nil, fmt.Errorf("missing 'implementation_plan' parameter for update_file command")
+	}
+	implementationPlan, ok := implementationPlanRaw.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid 'implementation_plan' parameter type for update_file command")
+	}
+
+	var contextFiles []string
+	contextFilesRaw, ok := commandMap["context_files"]
+	if !ok {
+		contextFiles = []string{}
+	} else {
+		contextFilesSlice, ok := contextFilesRaw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid 'context_files' parameter type for update_file command")
+		}
+		contextFiles = convertToStringArray(contextFilesSlice)
+	}
+
+	return &UpdateFileCommand{
+		FilePath:           filePath,
+		ImplementationPlan: implementationPlan,
+		ContextFiles:       contextFiles,
+	}, nil
+}
+
 // convertToStringArray converts an interface{} to a []string, handling type assertions and errors.
 func convertToStringArray(input interface{}) []string {
 	if input == nil {
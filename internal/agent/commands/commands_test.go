@@ -5,12 +5,80 @@ import (
 	"errors"
 	"fmt"
 	"github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/config"
+	"github.com/EduardDranca/GoAgent/internal/input"
 	"reflect"
+	"strings"
 	"testing"
 
 	"go.uber.org/mock/gomock"
 )
 
+func TestAskUserCommand_Process(t *testing.T) {
+	var prompt string
+	originalGetter := input.UserInputGetter
+	defer func() { input.UserInputGetter = originalGetter }()
+
+	tests := []struct {
+		name           string
+		answer         string
+		expectedOutput string
+	}{
+		{"option number", "2", "The user answered: yaml"},
+		{"free text", " toml ", "The user answered: toml"},
+		{"no answer", "", "The user did not answer. Proceed with your best judgement and state the assumptions you made."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input.UserInputGetter = func(p string) (string, error) {
+				prompt = p
+				return test.answer, nil
+			}
+			command := &AskUserCommand{Question: "Which format?", Options: []string{"json", "yaml"}}
+
+			output, err := command.Process(nil)
+			if err != nil {
+				t.Fatalf("AskUserCommand.Process failed: %v", err)
+			}
+			if output != test.expectedOutput {
+				t.Errorf("AskUserCommand.Process: Unexpected output:\nGot:  %q\nWant: %q", output, test.expectedOutput)
+			}
+			if !strings.Contains(prompt, "Which format?\n  1. json\n  2. yaml\n") {
+				t.Errorf("AskUserCommand.Process: Unexpected prompt: %q", prompt)
+			}
+		})
+	}
+}
+
+func TestAskUserCommand_Process_WithoutUser(t *testing.T) {
+	originalGetter := input.UserInputGetter
+	input.UserInputGetter = func(p string) (string, error) {
+		t.Fatalf("The user was asked: %s", p)
+		return "", nil
+	}
+	defer func() { input.UserInputGetter = originalGetter }()
+
+	command := &AskUserCommand{Question: "Which format?", Options: []string{"json", "yaml"}}
+
+	command.Policy = config.FirstOptionPolicy
+	output, err := command.Process(nil)
+	if err != nil || output != "The user is not available, use the first answer: json" {
+		t.Errorf("AskUserCommand.Process with first_option policy = %q, %v", output, err)
+	}
+
+	command.Policy = config.BestJudgementPolicy
+	output, err = command.Process(nil)
+	if err != nil || !strings.Contains(output, "best judgement") {
+		t.Errorf("AskUserCommand.Process with best_judgement policy = %q, %v", output, err)
+	}
+
+	command.Policy = config.FailPolicy
+	_, err = command.Process(nil)
+	if !errors.Is(err, ErrNoUserToAsk) {
+		t.Errorf("AskUserCommand.Process with fail policy: got error %v, want %v", err, ErrNoUserToAsk)
+	}
+}
+
 func TestReadCommand_Process(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -559,6 +627,69 @@ func TestNewCommand(t *testing.T) {
 			expectedCommand: nil,
 			expectedError:   errors.New("invalid 'message' parameter type for respond command"),
 		},
+		{
+			name: "UpdateFilesCommand with valid parameters",
+			commandMap: map[string]interface{}{
+				"command": "update_files",
+				"updates": []interface{}{
+					map[string]interface{}{"file_path": "a.go", "implementation_plan": "Plan A", "context_files": []interface{}{"c.go"}},
+					map[string]interface{}{"file_path": "b.go", "implementation_plan": "Plan B"},
+				},
+			},
+			expectedCommand: &UpdateFilesCommand{Updates: []UpdateFileCommand{
+				{FilePath: "a.go", ImplementationPlan: "Plan A", ContextFiles: []string{"c.go"}},
+				{FilePath: "b.go", ImplementationPlan: "Plan B", ContextFiles: []string{}},
+			}},
+			expectedError: nil,
+		},
+		{
+			name: "UpdateFilesCommand with empty updates",
+			commandMap: map[string]interface{}{
+				"command": "update_files",
+				"updates": []interface{}{},
+			},
+			expectedCommand: nil,
+			expectedError:   errors.New("invalid 'updates' parameter for update_files command, expected a non-empty array"),
+		},
+		{
+			name: "UpdateFilesCommand with invalid update",
+			commandMap: map[string]interface{}{
+				"command": "update_files",
+				"updates": []interface{}{
+					map[string]interface{}{"file_path": "a.go"},
+				},
+			},
+			expectedCommand: nil,
+			expectedError:   errors.New("missing 'implementation_plan' parameter for update_file command"),
+		},
+		{
+			name: "Valid AskUserCommand",
+			commandMap: map[string]interface{}{
+				"command":  "ask_user",
+				"question": "Which format?",
+				"options":  []interface{}{"json", "yaml"},
+			},
+			expectedCommand: &AskUserCommand{Question: "Which format?", Options: []string{"json", "yaml"}},
+			expectedError:   nil,
+		},
+		{
+			name: "AskUserCommand missing question",
+			commandMap: map[string]interface{}{
+				"command": "ask_user",
+			},
+			expectedCommand: nil,
+			expectedError:   errors.New("missing 'question' parameter for ask_user command"),
+		},
+		{
+			name: "AskUserCommand invalid options type",
+			commandMap: map[string]interface{}{
+				"command":  "ask_user",
+				"question": "Which format?",
+				"options":  "json",
+			},
+			expectedCommand: nil,
+			expectedError:   errors.New("invalid 'options' parameter type for ask_user command"),
+		},
 		{
 			name: "Unknown command",
 			commandMap: map[string]interface{}{
@@ -0,0 +1,289 @@
+package context
+
+import (
+	"bytes"
+	"crypto/sha256"
+	errors2 "errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+// ErrFileConflict is returned by FlushChanges when a file changed on disk after the agent read it
+// and the conflict has not been resolved.
+var ErrFileConflict = errors2.New("file was modified on disk after it was read")
+
+// ConflictResolution selects how a FileConflict is resolved.
+type ConflictResolution int
+
+const (
+	ConflictOverwrite ConflictResolution = iota // Write the agent's version, discarding the changes on disk
+	ConflictSkip                                // Keep the version on disk and drop the agent's change
+	ConflictMerge                               // Write the three-way merge, including conflict markers if any
+)
+
+// FileConflict describes a pending write to a file that changed on disk after the agent read it.
+type FileConflict struct {
+	FilePath  string
+	Base      string // Content when the agent read the file
+	Ours      string // Content produced by the agent
+	Theirs    string // Content currently on disk
+	Merged    string // Three-way merge of Ours and Theirs against Base
+	Conflicts int    // Number of regions in Merged marked as conflicting
+	Mergeable bool   // False if the file on disk can no longer be merged as text
+}
+
+// DetectConflicts returns the pending writes whose target changed on disk since the agent read it,
+// or which now exist on disk although the agent never saw them.
+func (c *LocalProgrammingAgentContext) DetectConflicts() ([]FileConflict, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.detectConflicts()
+}
+
+// detectConflicts implements DetectConflicts; the caller must hold c.mu.
+func (c *LocalProgrammingAgentContext) detectConflicts() ([]FileConflict, error) {
+	var conflicts []FileConflict
+	seen := make(map[string]bool)
+	for _, filePath := range append(append([]string{}, c.updatedFiles...), c.newFiles...) {
+		if seen[filePath] || c.isDeleted(filePath) {
+			continue
+		}
+		seen[filePath] = true
+
+		conflict, err := c.checkConflict(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+		}
+	}
+	return conflicts, nil
+}
+
+// ResolveConflict applies the chosen resolution to a conflicting file and records the current
+// disk state as its new baseline, so that FlushChanges accepts the write.
+func (c *LocalProgrammingAgentContext) ResolveConflict(filePath string, resolution ConflictResolution) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conflict, err := c.checkConflict(filePath)
+	if err != nil {
+		return err
+	}
+	if conflict == nil {
+		return nil
+	}
+
+	switch resolution {
+	case ConflictOverwrite:
+	case ConflictSkip:
+		c.dropPendingWrite(filePath)
+	case ConflictMerge:
+		if !conflict.Mergeable {
+			return fmt.Errorf("error merging %s: the file on disk is not a text file", filePath)
+		}
+		c.currentFileContents[filePath] = conflict.Merged
+	default:
+		return fmt.Errorf("unknown conflict resolution %d", resolution)
+	}
+	return c.rebaseline(filePath, resolution == ConflictSkip)
+}
+
+// checkConflict compares the file on disk with the snapshot taken when it was read.
+// It returns nil if the pending write can be applied safely.
+func (c *LocalProgrammingAgentContext) checkConflict(filePath string) (*FileConflict, error) {
+	_, fullPath, err := c.paths.resolve(c.resolveAlias(filePath), false)
+	if err != nil {
+		return nil, fmt.Errorf("error checking %s for external modifications: %w", filePath, err)
+	}
+	meta, wasRead := c.fileMetadata[filePath]
+
+	info, err := os.Stat(fullPath)
+	if errors2.Is(err, os.ErrNotExist) {
+		if !wasRead {
+			return nil, nil
+		}
+		// The file was deleted externally
+		return c.newConflict(filePath, "", true), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error checking %s for external modifications: %w", filePath, err)
+	}
+
+	if wasRead && info.ModTime().Equal(meta.modTime) && int(info.Size()) == meta.size {
+		return nil, nil
+	}
+
+	decoded, current, err := readSnapshot(filePath, fullPath, c.fileLimits)
+	if err != nil {
+		return nil, fmt.Errorf("error checking %s for external modifications: %w", filePath, err)
+	}
+	if wasRead && current.hash == meta.hash {
+		// Only the modification time changed, e.g. the file was touched
+		meta.modTime = current.modTime
+		c.fileMetadata[filePath] = meta
+		return nil, nil
+	}
+	return c.newConflict(filePath, decoded, current.kind == textFile), nil
+}
+
+// newConflict builds a FileConflict from the cached base and agent content and the content on disk.
+func (c *LocalProgrammingAgentContext) newConflict(filePath string, theirs string, mergeable bool) *FileConflict {
+	conflict := &FileConflict{
+		FilePath:  filePath,
+		Base:      c.baseContents[filePath],
+		Ours:      c.currentFileContents[filePath],
+		Theirs:    theirs,
+		Mergeable: mergeable,
+	}
+	if mergeable {
+		conflict.Merged, conflict.Conflicts = utils.ThreeWayMerge(conflict.Base, conflict.Ours, conflict.Theirs)
+	}
+	return conflict
+}
+
+// rebaseline records the current disk state of a file as the state the agent's content is based on.
+// If keepDisk is true, the cached content is replaced with the content on disk as well.
+func (c *LocalProgrammingAgentContext) rebaseline(filePath string, keepDisk bool) error {
+	_, fullPath, err := c.paths.resolve(c.resolveAlias(filePath), false)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", filePath, err)
+	}
+	decoded, meta, err := readSnapshot(filePath, fullPath, c.fileLimits)
+	if errors2.Is(err, os.ErrNotExist) {
+		delete(c.fileMetadata, filePath)
+		delete(c.baseContents, filePath)
+		if keepDisk {
+			delete(c.currentFileContents, filePath)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", filePath, err)
+	}
+
+	c.fileMetadata[filePath] = meta
+	if meta.kind == textFile {
+		c.baseContents[filePath] = decoded
+	} else {
+		delete(c.baseContents, filePath)
+	}
+	if keepDisk {
+		if meta.kind == textFile {
+			c.currentFileContents[filePath] = decoded
+		} else {
+			delete(c.currentFileContents, filePath)
+		}
+	}
+	return nil
+}
+
+// dropPendingWrite removes a file from the list of files to be written.
+func (c *LocalProgrammingAgentContext) dropPendingWrite(filePath string) {
+	remove := func(paths []string) []string {
+		kept := paths[:0]
+		for _, path := range paths {
+			if path != filePath {
+				kept = append(kept, path)
+			}
+		}
+		return kept
+	}
+	c.updatedFiles = remove(c.updatedFiles)
+	c.newFiles = remove(c.newFiles)
+}
+
+// isDeleted reports whether a file is marked for deletion.
+func (c *LocalProgrammingAgentContext) isDeleted(filePath string) bool {
+	for _, deletedFile := range c.deletedFiles {
+		if deletedFile == filePath {
+			return true
+		}
+	}
+	return false
+}
+
+// readSnapshot reads and decodes a file, recording its modification time and content hash.
+// The file is stat'ed before it is read, so a concurrent write is detected later rather than missed.
+func readSnapshot(filePath string, fullPath string, limits FileLimits) (string, fileMetadata, error) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", fileMetadata{}, err
+	}
+	if limits.MaxFileSize > 0 && info.Size() > int64(limits.MaxFileSize) {
+		decoded, meta, err := readLargeSnapshot(filePath, fullPath, limits)
+		meta.modTime = info.ModTime()
+		return decoded, meta, err
+	}
+	raw, err := os.ReadFile(fullPath)
+	if err != nil {
+		return "", fileMetadata{}, err
+	}
+	decoded, meta := decodeFileContent(filePath, raw, limits)
+	meta.modTime = info.ModTime()
+	meta.hash = sha256.Sum256(raw)
+	return decoded, meta, nil
+}
+
+// readLargeSnapshot reads a file over the size limit without loading it whole: the file is streamed to compute its
+// hash and count its lines, and only its head and tail are kept for its preview.
+func readLargeSnapshot(filePath string, fullPath string, limits FileLimits) (string, fileMetadata, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "", fileMetadata{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	counter := &lineCounter{}
+	size, err := io.Copy(io.MultiWriter(hash, counter), file)
+	if err != nil {
+		return "", fileMetadata{}, err
+	}
+	head := make([]byte, min(size, int64(limits.MaxFileSize)))
+	if _, err := file.ReadAt(head, 0); err != nil {
+		return "", fileMetadata{}, err
+	}
+	tail := make([]byte, len(head))
+	if _, err := file.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return "", fileMetadata{}, err
+	}
+
+	decoded, meta := decodeLargeFile(filePath, head, tail, int(size), counter.newlines, counter.crlfs, limits)
+	copy(meta.hash[:], hash.Sum(nil))
+	return decoded, meta, nil
+}
+
+// lineCounter counts the line feeds written to it, and those ending CRLF line endings.
+type lineCounter struct {
+	newlines int
+	crlfs    int
+	lastCR   bool // The last byte written was a carriage return
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.newlines += bytes.Count(p, []byte("\n"))
+	c.crlfs += bytes.Count(p, []byte("\r\n"))
+	if c.lastCR && p[0] == '\n' {
+		c.crlfs++
+	}
+	c.lastCR = p[len(p)-1] == '\r'
+	return len(p), nil
+}
+
+// conflictPaths returns the paths of the given conflicts.
+func conflictPaths(conflicts []FileConflict) string {
+	paths := make([]string, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		paths = append(paths, conflict.FilePath)
+	}
+	return strings.Join(paths, ", ")
+}
@@ -0,0 +1,124 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// writeExternally simulates an edit made outside the agent, making sure the modification time changes.
+func writeExternally(t *testing.T, path string, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+}
+
+func newConflictTestContext(t *testing.T, content string) (*LocalProgrammingAgentContext, string) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "file.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0644))
+
+	ctx, err := NewLocalProgrammingAgentContext(tempDir, "change request", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	_, exists := ctx.GetFileContent("file.txt")
+	require.True(t, exists)
+	return ctx, filePath
+}
+
+func TestLocalAgentContext_DetectConflicts(t *testing.T) {
+	ctx, filePath := newConflictTestContext(t, "one\ntwo\nthree\n")
+	require.NoError(t, ctx.UpdateFileContent("file.txt", "one\ntwo\nthree\nfour\n"))
+
+	// Touching the file without changing it is not a conflict.
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(filePath, future, future))
+	conflicts, err := ctx.DetectConflicts()
+	require.NoError(t, err)
+	require.Empty(t, conflicts)
+
+	writeExternally(t, filePath, "zero\none\ntwo\nthree\n")
+	conflicts, err = ctx.DetectConflicts()
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, FileConflict{
+		FilePath:  "file.txt",
+		Base:      "one\ntwo\nthree\n",
+		Ours:      "one\ntwo\nthree\nfour\n",
+		Theirs:    "zero\none\ntwo\nthree\n",
+		Merged:    "zero\none\ntwo\nthree\nfour\n",
+		Mergeable: true,
+	}, conflicts[0])
+
+	// Unresolved conflicts are never flushed.
+	require.ErrorIs(t, ctx.FlushChanges(), ErrFileConflict)
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "zero\none\ntwo\nthree\n", string(content))
+}
+
+func TestLocalAgentContext_DetectConflicts_NewFileCreatedOnDisk(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx, err := NewLocalProgrammingAgentContext(tempDir, "change request", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+
+	require.NoError(t, ctx.UpdateFileContent("new.txt", "agent\n"))
+	writeExternally(t, filepath.Join(tempDir, "new.txt"), "disk\n")
+
+	conflicts, err := ctx.DetectConflicts()
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "new.txt", conflicts[0].FilePath)
+	require.Equal(t, 1, conflicts[0].Conflicts)
+}
+
+func TestLocalAgentContext_ResolveConflict(t *testing.T) {
+	tests := []struct {
+		name            string
+		resolution      ConflictResolution
+		expectedContent string
+	}{
+		{name: "overwrite", resolution: ConflictOverwrite, expectedContent: "one\nagent\nthree\n"},
+		{name: "skip", resolution: ConflictSkip, expectedContent: "one\ndisk\nthree\n"},
+		{
+			name:            "merge",
+			resolution:      ConflictMerge,
+			expectedContent: "one\n<<<<<<< agent\nagent\n||||||| base\ntwo\n=======\ndisk\n>>>>>>> disk\nthree\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, filePath := newConflictTestContext(t, "one\ntwo\nthree\n")
+			require.NoError(t, ctx.UpdateFileContent("file.txt", "one\nagent\nthree\n"))
+			writeExternally(t, filePath, "one\ndisk\nthree\n")
+
+			require.NoError(t, ctx.ResolveConflict("file.txt", test.resolution))
+			conflicts, err := ctx.DetectConflicts()
+			require.NoError(t, err)
+			require.Empty(t, conflicts)
+
+			require.NoError(t, ctx.FlushChanges())
+			content, err := os.ReadFile(filePath)
+			require.NoError(t, err)
+			require.Equal(t, test.expectedContent, string(content))
+		})
+	}
+}
+
+func TestLocalAgentContext_FlushChanges_UpdatesBaseline(t *testing.T) {
+	ctx, filePath := newConflictTestContext(t, "one\n")
+
+	// The agent's own writes do not count as external modifications.
+	require.NoError(t, ctx.UpdateFileContent("file.txt", "two\n"))
+	require.NoError(t, ctx.FlushChanges())
+	require.NoError(t, ctx.UpdateFileContent("file.txt", "three\n"))
+	require.NoError(t, ctx.FlushChanges())
+
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "three\n", string(content))
+}
@@ -0,0 +1,210 @@
+package context
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxFileSize is the largest file, in bytes, whose full content is given to the LLM.
+	DefaultMaxFileSize = 512 * 1024
+	// DefaultPreviewLines is the number of lines shown from the head and from the tail of files over the size limit.
+	DefaultPreviewLines = 50
+
+	// binarySniffLength is how many leading bytes are inspected for NUL bytes, mirroring git's heuristic.
+	binarySniffLength = 8000
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+const (
+	// contentNotShown ends the placeholder shown instead of the content of binary files.
+	contentNotShown = "its content is not shown."
+	// cannotBeModified ends the header of the previews of large files and of files with invalid bytes.
+	cannotBeModified = "the file cannot be modified.]"
+)
+
+// isPlaceholder reports whether content, as returned by decodeFileContent, is not the exact text of the file: a
+// preview, a placeholder or a text whose invalid bytes were replaced.
+func isPlaceholder(content string) bool {
+	firstLine, _, _ := strings.Cut(content, "\n")
+	return strings.HasPrefix(firstLine, "The file ") && strings.HasSuffix(firstLine, contentNotShown) ||
+		strings.HasPrefix(firstLine, "[The file ") && strings.HasSuffix(firstLine, cannotBeModified)
+}
+
+// fileKind classifies a file read from disk.
+type fileKind int
+
+const (
+	textFile    fileKind = iota // Valid UTF-8 within the size limit, editable by the agent
+	binaryFile                  // Contains NUL bytes, content is not shown
+	largeFile                   // Exceeds the size limit, only a head/tail preview is shown
+	nonUTF8File                 // Not valid UTF-8, shown with invalid bytes replaced
+)
+
+// fileMetadata records how a file was encoded on disk so it can be restored when written back,
+// and a snapshot of its state used to detect external modifications.
+type fileMetadata struct {
+	kind            fileKind
+	size            int
+	bom             bool
+	crlf            bool
+	trailingNewline bool
+	modTime         time.Time
+	hash            [sha256.Size]byte
+}
+
+// readOnlyReason explains why the agent may not rewrite the file, or returns an empty string if it may.
+func (m fileMetadata) readOnlyReason() string {
+	switch m.kind {
+	case binaryFile:
+		return "it is a binary file"
+	case largeFile:
+		return fmt.Sprintf("it is %d bytes, which exceeds the configured size limit, and only a preview was shown", m.size)
+	case nonUTF8File:
+		return "it is not valid UTF-8 and rewriting it would lose data"
+	default:
+		return ""
+	}
+}
+
+// FileLimits configures how much of a file is given to the LLM.
+type FileLimits struct {
+	// MaxFileSize is the largest file, in bytes, whose full content is shown. Zero or less disables the limit.
+	MaxFileSize int
+	// PreviewLines is the number of head and tail lines shown for files over MaxFileSize.
+	PreviewLines int
+}
+
+// DefaultFileLimits returns the limits used when none are configured.
+func DefaultFileLimits() FileLimits {
+	return FileLimits{MaxFileSize: DefaultMaxFileSize, PreviewLines: DefaultPreviewLines}
+}
+
+// decodeFileContent converts raw file bytes into the text shown to the LLM and the metadata needed
+// to write it back. For text files the BOM is stripped and CRLF line endings are normalised to LF.
+func decodeFileContent(filePath string, raw []byte, limits FileLimits) (string, fileMetadata) {
+	meta := fileMetadata{size: len(raw)}
+
+	sniff := raw
+	if len(sniff) > binarySniffLength {
+		sniff = sniff[:binarySniffLength]
+	}
+	if bytes.IndexByte(sniff, 0) != -1 {
+		meta.kind = binaryFile
+		return fmt.Sprintf("The file %s appears to be binary (%d bytes); "+contentNotShown, filePath, len(raw)), meta
+	}
+
+	if bytes.HasPrefix(raw, utf8BOM) {
+		meta.bom = true
+		raw = raw[len(utf8BOM):]
+	}
+	content := string(raw)
+	crlfCount := strings.Count(content, "\r\n")
+	meta.crlf = crlfCount > 0 && crlfCount*2 >= strings.Count(content, "\n")
+	meta.trailingNewline = strings.HasSuffix(content, "\n")
+	if meta.crlf {
+		content = strings.ReplaceAll(content, "\r\n", "\n")
+	}
+
+	if limits.MaxFileSize > 0 && meta.size > limits.MaxFileSize {
+		meta.kind = largeFile
+		return previewContent(filePath, strings.ToValidUTF8(content, "�"), meta.size, limits), meta
+	}
+
+	if !utf8.ValidString(content) {
+		meta.kind = nonUTF8File
+		return fmt.Sprintf("[The file %s is not valid UTF-8; invalid bytes are shown as � and "+cannotBeModified+"\n%s",
+			filePath, strings.ToValidUTF8(content, "�")), meta
+	}
+
+	meta.kind = textFile
+	return content, meta
+}
+
+// encodeFileContent restores the BOM, line endings and trailing newline recorded in meta.
+func encodeFileContent(content string, meta fileMetadata) []byte {
+	if meta.trailingNewline && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	} else if !meta.trailingNewline && meta.size > 0 {
+		content = strings.TrimSuffix(content, "\n")
+	}
+	if meta.crlf {
+		content = strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\n", "\r\n")
+	}
+
+	var buf bytes.Buffer
+	if meta.bom {
+		buf.Write(utf8BOM)
+	}
+	buf.WriteString(content)
+	return buf.Bytes()
+}
+
+// decodeLargeFile is decodeFileContent for a file of size bytes over the size limit, of which only the first and the
+// last limits.MaxFileSize bytes, head and tail, were read. The whole file has newlines line feeds, crlfs of which end
+// CRLF line endings.
+func decodeLargeFile(filePath string, head []byte, tail []byte, size int, newlines int, crlfs int, limits FileLimits) (string, fileMetadata) {
+	meta := fileMetadata{size: size, kind: largeFile}
+	if bytes.IndexByte(head[:min(len(head), binarySniffLength)], 0) != -1 {
+		meta.kind = binaryFile
+		return fmt.Sprintf("The file %s appears to be binary (%d bytes); "+contentNotShown, filePath, size), meta
+	}
+
+	if bytes.HasPrefix(head, utf8BOM) {
+		meta.bom = true
+		head = head[len(utf8BOM):]
+	}
+	meta.crlf = crlfs > 0 && crlfs*2 >= newlines
+	meta.trailingNewline = bytes.HasSuffix(tail, []byte("\n"))
+	decode := func(raw []byte) []string {
+		content := string(raw)
+		if meta.crlf {
+			content = strings.ReplaceAll(content, "\r\n", "\n")
+		}
+		return strings.Split(strings.ToValidUTF8(content, "�"), "\n")
+	}
+	return previewLines(filePath, decode(head), decode(tail), newlines+1, size, limits), meta
+}
+
+// previewContent returns the first and last lines of a file that exceeds the size limit.
+func previewContent(filePath string, content string, size int, limits FileLimits) string {
+	lines := strings.Split(content, "\n")
+	return previewLines(filePath, lines, lines, len(lines), size, limits)
+}
+
+// previewLines returns the preview of a file of lineCount lines, given lines from its start, head, and lines up to
+// its end, tail. Each half of the preview is also capped at half the size limit so that files with very long lines
+// stay small; that is why head and tail only need to hold half the size limit.
+func previewLines(filePath string, headLines []string, tailLines []string, lineCount int, size int, limits FileLimits) string {
+	header := fmt.Sprintf("[The file %s is %d bytes, which exceeds the %d byte limit. Showing a preview; "+cannotBeModified,
+		filePath, size, limits.MaxFileSize)
+	if limits.PreviewLines <= 0 {
+		return header
+	}
+
+	headCount := min(limits.PreviewLines, lineCount)
+	tailStart := max(headCount, lineCount-limits.PreviewLines)
+	budget := limits.MaxFileSize / 2
+
+	head := strings.Join(headLines[:min(headCount, len(headLines))], "\n")
+	if len(head) > budget {
+		head = strings.ToValidUTF8(head[:budget], "") + " [...]"
+	}
+	tail := strings.Join(tailLines[max(0, len(tailLines)-(lineCount-tailStart)):], "\n")
+	if len(tail) > budget {
+		tail = "[...] " + strings.ToValidUTF8(tail[len(tail)-budget:], "")
+	}
+
+	if tailStart == headCount {
+		if tail == "" {
+			return fmt.Sprintf("%s\n%s", header, head)
+		}
+		return fmt.Sprintf("%s\n%s\n%s", header, head, tail)
+	}
+	return fmt.Sprintf("%s\n%s\n[... %d lines omitted ...]\n%s", header, head, tailStart-headCount, tail)
+}
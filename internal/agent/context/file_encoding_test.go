@@ -0,0 +1,179 @@
+package context
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEncodeFileContent_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{name: "LF with trailing newline", raw: "line1\nline2\n", expected: "line1\nline2\n"},
+		{name: "LF without trailing newline", raw: "line1\nline2", expected: "line1\nline2"},
+		{name: "CRLF", raw: "line1\r\nline2\r\n", expected: "line1\nline2\n"},
+		{name: "BOM and CRLF", raw: "\xEF\xBB\xBFline1\r\nline2", expected: "line1\nline2"},
+		{name: "empty", raw: "", expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, meta := decodeFileContent("file.txt", []byte(test.raw), DefaultFileLimits())
+			require.Equal(t, textFile, meta.kind)
+			require.Equal(t, test.expected, decoded, "the LLM should see normalised content")
+			require.Equal(t, test.raw, string(encodeFileContent(decoded, meta)), "unchanged content should be written back byte for byte")
+		})
+	}
+}
+
+func TestEncodeFileContent_PreservesFormattingOfEditedContent(t *testing.T) {
+	_, meta := decodeFileContent("file.txt", []byte("\xEF\xBB\xBFold\r\ncontent"), DefaultFileLimits())
+
+	// Generated code usually ends with a newline and uses LF line endings.
+	encoded := encodeFileContent("new\ncontent\nhere\n", meta)
+
+	require.Equal(t, "\xEF\xBB\xBFnew\r\ncontent\r\nhere", string(encoded))
+}
+
+func TestDecodeFileContent_Binary(t *testing.T) {
+	content, meta := decodeFileContent("image.png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), DefaultFileLimits())
+
+	require.Equal(t, binaryFile, meta.kind)
+	require.NotEmpty(t, meta.readOnlyReason())
+	require.Contains(t, content, "appears to be binary")
+	require.NotContains(t, content, "IHDR")
+}
+
+func TestDecodeFileContent_NonUTF8(t *testing.T) {
+	content, meta := decodeFileContent("latin1.txt", []byte("caf\xe9\n"), DefaultFileLimits())
+
+	require.Equal(t, nonUTF8File, meta.kind)
+	require.NotEmpty(t, meta.readOnlyReason())
+	require.Contains(t, content, "caf�")
+}
+
+func TestDecodeFileContent_LargeFilePreview(t *testing.T) {
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, strings.Repeat("x", 10))
+	}
+	lines[0] = "first line"
+	lines[len(lines)-1] = "last line"
+	raw := strings.Join(lines, "\n")
+
+	content, meta := decodeFileContent("fixture.txt", []byte(raw), FileLimits{MaxFileSize: 1024, PreviewLines: 5})
+
+	require.Equal(t, largeFile, meta.kind)
+	require.NotEmpty(t, meta.readOnlyReason())
+	require.Contains(t, content, "exceeds the 1024 byte limit")
+	require.Contains(t, content, "first line")
+	require.Contains(t, content, "last line")
+	require.Contains(t, content, "[... 990 lines omitted ...]")
+}
+
+func TestDecodeFileContent_LargeSingleLineIsCapped(t *testing.T) {
+	raw := strings.Repeat("y", 100000)
+
+	content, meta := decodeFileContent("minified.js", []byte(raw), FileLimits{MaxFileSize: 1000, PreviewLines: 5})
+
+	require.Equal(t, largeFile, meta.kind)
+	require.Less(t, len(content), 2000, "preview should stay close to the size limit")
+}
+
+func TestReadSnapshot_LargeFileMatchesDecodedContent(t *testing.T) {
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, strings.Repeat("x", i%50))
+	}
+	tests := map[string]string{
+		"CRLF with BOM":  "\xEF\xBB\xBF" + strings.Join(lines, "\r\n") + "\r\n",
+		"LF":             strings.Join(lines, "\n"),
+		"long lines":     strings.Repeat("y", 5000) + "\n" + strings.Repeat("z", 5000),
+		"few long lines": "first\n" + strings.Repeat("w", 3000) + "\nlast\n",
+		"binary":         "\x00" + strings.Repeat("b", 5000),
+	}
+	limits := FileLimits{MaxFileSize: 1024, PreviewLines: 5}
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "fixture.txt")
+			require.NoError(t, os.WriteFile(path, []byte(raw), 0644))
+
+			content, meta, err := readSnapshot("fixture.txt", path, limits)
+			require.NoError(t, err)
+			expectedContent, expectedMeta := decodeFileContent("fixture.txt", []byte(raw), limits)
+			require.Equal(t, expectedContent, content)
+			require.Equal(t, sha256.Sum256([]byte(raw)), meta.hash)
+			meta.modTime, meta.hash = expectedMeta.modTime, expectedMeta.hash
+			require.Equal(t, expectedMeta, meta)
+		})
+	}
+}
+
+func TestLocalAgentContext_FlushChanges_PreservesEncoding(t *testing.T) {
+	// Create a temporary directory with a CRLF file that has a BOM and no trailing newline.
+	tempDir, err := os.MkdirTemp("", "test-encoding")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	filePath := filepath.Join(tempDir, "windows.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte("\xEF\xBB\xBFfirst\r\nsecond"), 0644))
+
+	ctx, err := NewLocalProgrammingAgentContext(tempDir, "change request", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+
+	content, exists := ctx.GetFileContent("windows.txt")
+	require.True(t, exists)
+	require.Equal(t, "first\nsecond", content)
+
+	require.NoError(t, ctx.UpdateFileContent("windows.txt", content+"\nthird\n"))
+	require.NoError(t, ctx.FlushChanges())
+
+	written, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "\xEF\xBB\xBFfirst\r\nsecond\r\nthird", string(written))
+}
+
+func TestLocalAgentContext_BinaryAndLargeFilesAreReadOnly(t *testing.T) {
+	// Create a temporary directory with a binary file and a file over the size limit.
+	tempDir, err := os.MkdirTemp("", "test-readonly-files")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	binaryContent := []byte{0x00, 0x01, 0x02, 0x03}
+	largeContent := strings.Repeat("large line\n", 100)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "data.bin"), binaryContent, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "large.txt"), []byte(largeContent), 0644))
+
+	ctx, err := NewLocalProgrammingAgentContext(tempDir, "change request", &utils.NoOpGitUtil{},
+		WithFileLimits(FileLimits{MaxFileSize: 100, PreviewLines: 2}))
+	require.NoError(t, err)
+
+	_, exists := ctx.GetFileContent("data.bin")
+	require.True(t, exists)
+	preview, exists := ctx.GetFileContent("large.txt")
+	require.True(t, exists)
+	require.Contains(t, preview, "Showing a preview")
+
+	require.ErrorContains(t, ctx.CanModify("data.bin"), "it is a binary file")
+	require.Error(t, ctx.UpdateFileContent("data.bin", "text"))
+	require.Error(t, ctx.UpdateFileContent("large.txt", preview))
+	require.Empty(t, ctx.SearchCode("large line"), "files over the size limit should not be searched through their preview")
+	require.NoError(t, ctx.CanModify("new.txt"), "a file that does not exist can be created")
+
+	require.NoError(t, ctx.FlushChanges())
+
+	written, err := os.ReadFile(filepath.Join(tempDir, "data.bin"))
+	require.NoError(t, err)
+	require.Equal(t, binaryContent, written)
+	written, err = os.ReadFile(filepath.Join(tempDir, "large.txt"))
+	require.NoError(t, err)
+	require.Equal(t, largeContent, string(written))
+}
@@ -8,14 +8,23 @@ import (
 	"github.com/EduardDranca/GoAgent/internal/utils"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 )
 
 // LocalProgrammingAgentContext retrieves files from a local directory.
+// It is safe for concurrent use.
 type LocalProgrammingAgentContext struct {
+	// mu guards the file caches, the pending changes and the change request.
+	mu sync.Mutex
+
 	rootDir              string
-	currentFileContents  map[string]string // In-memory storage of file contents
+	paths                *pathResolver           // Confines LLM-supplied paths to rootDir
+	currentFileContents  map[string]string       // In-memory storage of file contents
+	baseContents         map[string]string       // File contents as last read from or written to disk
+	fileMetadata         map[string]fileMetadata // On-disk encoding of every file read, used when writing back
+	fileLimits           FileLimits
 	CurrentRepoStructure []string
 	changeRequest        string   // You might want to handle this differently for local context
 	updatedFiles         []string // Keep track of updated files for flushing
@@ -32,11 +41,30 @@ type LocalProgrammingAgentContext struct {
 	gitUtil     utils.GitUtil // GitUtil interface for Git operations
 }
 
+// Option is a functional option type for configuring a LocalProgrammingAgentContext.
+type Option func(c *LocalProgrammingAgentContext)
+
+// WithFileLimits sets the size limit and preview length used when reading files.
+func WithFileLimits(limits FileLimits) Option {
+	return func(c *LocalProgrammingAgentContext) {
+		c.fileLimits = limits
+	}
+}
+
 // NewLocalProgrammingAgentContext creates a new LocalProgrammingAgentContext.
-func NewLocalProgrammingAgentContext(rootDir string, changeRequest string, gitUtil utils.GitUtil) (*LocalProgrammingAgentContext, error) {
+func NewLocalProgrammingAgentContext(rootDir string, changeRequest string, gitUtil utils.GitUtil, options ...Option) (*LocalProgrammingAgentContext, error) {
+	paths, err := newPathResolver(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving project root: %w", err)
+	}
+
 	ctx := &LocalProgrammingAgentContext{
 		rootDir:              rootDir,
+		paths:                paths,
 		currentFileContents:  make(map[string]string),
+		baseContents:         make(map[string]string),
+		fileMetadata:         make(map[string]fileMetadata),
+		fileLimits:           DefaultFileLimits(),
 		changeRequest:        changeRequest,
 		CurrentRepoStructure: []string{},
 		updatedFiles:         []string{},
@@ -46,6 +74,9 @@ func NewLocalProgrammingAgentContext(rootDir string, changeRequest string, gitUt
 		fileAliases:          make(map[string]string), // Initialize fileAliases
 		gitUtil:              gitUtil,
 	}
+	for _, option := range options {
+		option(ctx)
+	}
 
 	// Build the repository structure
 	if err := ctx.buildRepoStructure(); err != nil {
@@ -67,8 +98,19 @@ func (c *LocalProgrammingAgentContext) buildRepoStructure() error {
 
 // GetFileContent retrieves the content of a file from the local file system or from the cache.
 func (c *LocalProgrammingAgentContext) GetFileContent(filePath string) (string, bool) {
-	// Resolve alias before proceeding
-	filePath = c.resolveAlias(filePath)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getFileContent(filePath)
+}
+
+// getFileContent implements GetFileContent; the caller must hold c.mu.
+func (c *LocalProgrammingAgentContext) getFileContent(filePath string) (string, bool) {
+	// Validate the path and resolve alias before proceeding
+	filePath, fullPath, err := c.resolveFilePath(filePath, false)
+	if err != nil {
+		logging.Logger.Warnf("Rejected read of %s: %v", filePath, err)
+		return fmt.Sprintf("Access denied: %v", err), false
+	}
 	// Check if the file was deleted
 	for _, deletedFile := range c.deletedFiles {
 		if deletedFile == filePath {
@@ -81,20 +123,29 @@ func (c *LocalProgrammingAgentContext) GetFileContent(filePath string) (string,
 	}
 
 	// Read the file content from the file system
-	fullPath := filepath.Join(c.rootDir, filePath)
-	content, err := os.ReadFile(fullPath)
+	decoded, meta, err := readSnapshot(filePath, fullPath, c.fileLimits)
 	if err != nil {
 		return "The file does not exist or could not be read.", false
 	}
 
-	// Cache the file content
-	c.currentFileContents[filePath] = string(content)
-	return strings.ToValidUTF8(string(content), " "), true
+	// Only cache editable text, so that previews and placeholders are never written back
+	c.fileMetadata[filePath] = meta
+	if meta.kind == textFile {
+		c.currentFileContents[filePath] = decoded
+		c.baseContents[filePath] = decoded
+	}
+	return decoded, true
 }
 
 // UpdateFileContent updates the content of a file in the cache.
-func (c *LocalProgrammingAgentContext) UpdateFileContent(filePath string, newContents string) {
-	filePath = c.resolveAlias(filePath)
+func (c *LocalProgrammingAgentContext) UpdateFileContent(filePath string, newContents string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filePath, err := c.checkModifiable(filePath)
+	if err != nil {
+		return err
+	}
 	// If the file is not in the cache, add it to the new files
 	if _, exists := c.currentFileContents[filePath]; !exists {
 		c.newFiles = append(c.newFiles, filePath)
@@ -105,17 +156,48 @@ func (c *LocalProgrammingAgentContext) UpdateFileContent(filePath string, newCon
 	}
 	// Update the file content in the cache
 	c.currentFileContents[filePath] = newContents
+	return nil
+}
+
+// CanModify returns the error UpdateFileContent would return for filePath, e.g. because it is a binary file, so that
+// no content is generated for a file that cannot be written. The file is read if it was not read yet.
+func (c *LocalProgrammingAgentContext) CanModify(filePath string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.getFileContent(filePath)
+	_, err := c.checkModifiable(filePath)
+	return err
+}
+
+// checkModifiable resolves filePath for writing and checks that the file read from it, if any, may be rewritten.
+// The caller must hold c.mu.
+func (c *LocalProgrammingAgentContext) checkModifiable(filePath string) (string, error) {
+	filePath, _, err := c.resolveFilePath(filePath, true)
+	if err != nil {
+		return filePath, fmt.Errorf("error updating file: %w", err)
+	}
+	if meta, ok := c.fileMetadata[filePath]; ok && meta.readOnlyReason() != "" {
+		return filePath, fmt.Errorf("error updating file: %s cannot be modified because %s", filePath, meta.readOnlyReason())
+	}
+	return filePath, nil
 }
 
 // SearchCode searches for a given query in the repository.
 func (c *LocalProgrammingAgentContext) SearchCode(query string) map[string][]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	searchResults := make(map[string][]int)
-	for _, file := range c.CurrentRepoStructure {
+	for _, file := range slices.Clone(c.CurrentRepoStructure) {
 		file = c.resolveAlias(file)
-		content, exists := c.GetFileContent(file)
+		content, exists := c.getFileContent(file)
 		if !exists {
 			continue // Skip if file does not exist or cannot be read
 		}
+		if kind := c.fileMetadata[file].kind; kind == binaryFile || kind == largeFile {
+			continue // Skip files whose full content is not available
+		}
 
 		scanner := bufio.NewScanner(strings.NewReader(content))
 		lineNumber := 1
@@ -137,16 +219,27 @@ func (c *LocalProgrammingAgentContext) SearchCode(query string) map[string][]int
 
 // GetRepoStructure returns the current repository structure.
 func (c *LocalProgrammingAgentContext) GetRepoStructure() []string {
-	return c.CurrentRepoStructure
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.CurrentRepoStructure)
 }
 
 // GetChangeRequest returns the current change request.
 func (c *LocalProgrammingAgentContext) GetChangeRequest() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.changeRequest
 }
 
 // Delete marks a file for deletion during FlushChanges.
 func (c *LocalProgrammingAgentContext) Delete(filePath string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filePath, _, err := c.paths.resolve(filePath, true)
+	if err != nil {
+		return err
+	}
 	c.deletedFiles = append(c.deletedFiles, filePath)
 	// Remove from CurrentRepoStructure and currentFileContents
 	c.removeFileFromContext(filePath)
@@ -155,12 +248,26 @@ func (c *LocalProgrammingAgentContext) Delete(filePath string) error {
 
 // FlushChanges writes the updated files to the local file system, deletes marked files, and moves marked files.
 func (c *LocalProgrammingAgentContext) FlushChanges() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Refuse to overwrite files that changed on disk since the agent read them
+	conflicts, err := c.detectConflicts()
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: %s", ErrFileConflict, conflictPaths(conflicts))
+	}
 
 	// Delete files marked for deletion
 	for _, filePath := range c.deletedFiles {
-		// Resolve alias before proceeding
-		fullPath := filepath.Join(c.rootDir, filePath)
-		err := os.Remove(fullPath)
+		// Re-validate in case the file system changed since the file was marked
+		_, fullPath, err := c.paths.resolve(filePath, true)
+		if err != nil {
+			return fmt.Errorf("error deleting file %s: %w", filePath, err)
+		}
+		err = os.Remove(fullPath)
 		if err != nil {
 			if errors2.Is(err, os.ErrNotExist) {
 				logging.Logger.Infof("File %s does not exist, skipping deletion: %v", filePath, err)
@@ -185,14 +292,20 @@ func (c *LocalProgrammingAgentContext) FlushChanges() error {
 		if skipFile {
 			continue // Skip to the next file
 		}
-		oldFullPath := filepath.Join(c.rootDir, oldPath)
-		newFullPath := filepath.Join(c.rootDir, newPath)
+		_, oldFullPath, err := c.paths.resolve(oldPath, true)
+		if err != nil {
+			return fmt.Errorf("error moving file from %s to %s: %w", oldPath, newPath, err)
+		}
+		_, newFullPath, err := c.paths.resolve(newPath, true)
+		if err != nil {
+			return fmt.Errorf("error moving file from %s to %s: %w", oldPath, newPath, err)
+		}
 		logging.Logger.Infof("Moving file from %s to %s", oldFullPath, newFullPath)
 
 		if err := os.MkdirAll(filepath.Dir(newFullPath), 0755); err != nil {
 			return fmt.Errorf("error creating directory for new file: %w", err)
 		}
-		err := os.Rename(oldFullPath, newFullPath)
+		err = os.Rename(oldFullPath, newFullPath)
 		if err != nil {
 			return fmt.Errorf("error moving file from %s to %s: %w", oldPath, newPath, err)
 		}
@@ -201,23 +314,40 @@ func (c *LocalProgrammingAgentContext) FlushChanges() error {
 		c.updateFilePathsInContext(oldPath, newPath)
 	}
 
+	// Clear file aliases once the files have been moved, so new paths refer to their new location on disk
+	c.fileAliasesMutex.Lock()
+	c.fileAliases = make(map[string]string)
+	c.fileAliasesMutex.Unlock()
+
 	// Create the new files
 	for _, path := range c.newFiles {
-		fullPath := filepath.Join(c.rootDir, path)
+		_, fullPath, err := c.paths.resolve(path, true)
+		if err != nil {
+			return fmt.Errorf("error creating file %s: %w", path, err)
+		}
 		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
 			return fmt.Errorf("error creating directory for %s: %w", path, err)
 		}
-		if err := os.WriteFile(fullPath, []byte(c.currentFileContents[path]), 0644); err != nil {
+		if err := os.WriteFile(fullPath, c.encodedContent(path), 0644); err != nil {
 			return fmt.Errorf("error creating file %s: %w", path, err)
 		}
+		if err := c.rebaseline(path, false); err != nil {
+			return err
+		}
 	}
 
 	// Write the updated files
 	for _, path := range c.updatedFiles {
-		fullPath := filepath.Join(c.rootDir, path)
-		if err := os.WriteFile(fullPath, []byte(c.currentFileContents[path]), 0644); err != nil {
+		_, fullPath, err := c.paths.resolve(path, true)
+		if err != nil {
 			return fmt.Errorf("error updating file %s: %w", path, err)
 		}
+		if err := os.WriteFile(fullPath, c.encodedContent(path), 0644); err != nil {
+			return fmt.Errorf("error updating file %s: %w", path, err)
+		}
+		if err := c.rebaseline(path, false); err != nil {
+			return err
+		}
 	}
 
 	// Clear the updated and new files
@@ -226,24 +356,31 @@ func (c *LocalProgrammingAgentContext) FlushChanges() error {
 	c.deletedFiles = []string{}
 	c.movedFiles = make(map[string]string)
 
-	// Clear file aliases after flushing
-	c.fileAliasesMutex.Lock()
-	c.fileAliases = make(map[string]string) // Clear aliases after flushing
-	c.fileAliasesMutex.Unlock()
-
 	return nil
 }
 
 // SetChangeRequest sets the change request.
 func (c *LocalProgrammingAgentContext) SetChangeRequest(changeRequest string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.changeRequest = changeRequest
 }
 
 // MoveFile marks a file for moving during FlushChanges.
 func (c *LocalProgrammingAgentContext) MoveFile(oldPath string, newPath string) error {
-	// Check if the old file exists
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	oldPath, oldFilePath, err := c.paths.resolve(oldPath, true)
+	if err != nil {
+		return err
+	}
+	newPath, _, err = c.paths.resolve(newPath, true)
+	if err != nil {
+		return err
+	}
 
-	oldFilePath := filepath.Join(c.rootDir, oldPath)
+	// Check if the old file exists
 	if _, err := os.Stat(oldFilePath); errors2.Is(err, os.ErrNotExist) {
 		return errors2.New(fmt.Sprintf("file %s does not exist", oldPath))
 	}
@@ -260,6 +397,31 @@ func (c *LocalProgrammingAgentContext) MoveFile(oldPath string, newPath string)
 	return nil
 }
 
+// encodedContent returns the cached content of a file, restoring the BOM, line endings and
+// trailing newline it had on disk when it was read.
+func (c *LocalProgrammingAgentContext) encodedContent(filePath string) []byte {
+	content := c.currentFileContents[filePath]
+	meta, ok := c.fileMetadata[filePath]
+	if !ok {
+		return []byte(content)
+	}
+	return encodeFileContent(content, meta)
+}
+
+// resolveFilePath validates filePath against the project root and follows move aliases.
+// It returns the normalised relative path and its absolute location on disk.
+func (c *LocalProgrammingAgentContext) resolveFilePath(filePath string, write bool) (string, string, error) {
+	relPath, _, err := c.paths.resolve(filePath, write)
+	if err != nil {
+		return filePath, "", err
+	}
+	relPath, fullPath, err := c.paths.resolve(c.resolveAlias(relPath), write)
+	if err != nil {
+		return filePath, "", err
+	}
+	return relPath, fullPath, nil
+}
+
 // resolveAlias resolves a file path alias if it exists.
 func (c *LocalProgrammingAgentContext) resolveAlias(filePath string) string {
 	c.fileAliasesMutex.RLock()
@@ -282,6 +444,8 @@ func (c *LocalProgrammingAgentContext) removeFileFromContext(filePath string) {
 	}
 	// Remove from currentFileContents
 	delete(c.currentFileContents, filePath)
+	delete(c.baseContents, filePath)
+	delete(c.fileMetadata, filePath)
 }
 
 // updateFilePathsInContext updates file paths in CurrentRepoStructure, currentFileContents, updatedFiles, and newFiles after a move.
@@ -299,6 +463,14 @@ func (c *LocalProgrammingAgentContext) updateFilePathsInContext(oldPath string,
 		c.currentFileContents[newPath] = content
 		delete(c.currentFileContents, oldPath)
 	}
+	if content, exists := c.baseContents[oldPath]; exists {
+		c.baseContents[newPath] = content
+		delete(c.baseContents, oldPath)
+	}
+	if meta, exists := c.fileMetadata[oldPath]; exists {
+		c.fileMetadata[newPath] = meta
+		delete(c.fileMetadata, oldPath)
+	}
 
 	// Update updatedFiles
 	for i, path := range c.updatedFiles {
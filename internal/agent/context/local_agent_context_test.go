@@ -1,10 +1,12 @@
 package context
 
 import (
+	"fmt"
 	"github.com/EduardDranca/GoAgent/internal/utils"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -464,3 +466,42 @@ func TestLocalAgentContext_GetRepoStructure_UpdatedInMemory(t *testing.T) {
 	sort.Strings(expectedStructure)
 
 }
+
+func TestLocalAgentContext_ConcurrentAccess(t *testing.T) {
+	tempDir := t.TempDir()
+	var files []string
+	for i := 0; i < 8; i++ {
+		file := fmt.Sprintf("file%d.txt", i)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, file), []byte("content"), 0644))
+		files = append(files, file)
+	}
+
+	ctx, err := NewLocalProgrammingAgentContext(tempDir, "change request", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	ctx.CurrentRepoStructure = append([]string{}, files...)
+
+	// Read, update, search and create files from several goroutines; run with -race to detect data races.
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content, exists := ctx.GetFileContent(file)
+			require.True(t, exists)
+			require.NoError(t, ctx.UpdateFileContent(file, content+" updated"))
+			require.NoError(t, ctx.UpdateFileContent(fmt.Sprintf("new/file%d.txt", i), "new"))
+			ctx.SearchCode("updated")
+			ctx.GetRepoStructure()
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, ctx.FlushChanges())
+	for i, file := range files {
+		content, err := os.ReadFile(filepath.Join(tempDir, file))
+		require.NoError(t, err)
+		require.Equal(t, "content updated", string(content))
+		_, err = os.Stat(filepath.Join(tempDir, "new", fmt.Sprintf("file%d.txt", i)))
+		require.NoError(t, err)
+	}
+}
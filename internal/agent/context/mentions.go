@@ -0,0 +1,132 @@
+package context
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+const (
+	// MaxMentionedFiles bounds the files attached to a request by its mentions, e.g. when mentioning a large directory.
+	MaxMentionedFiles = 20
+	// MaxMentionedBytes bounds the total size of the contents attached to a prompt by the mentions of its request.
+	MaxMentionedBytes = 256 * 1024
+)
+
+// mentionPattern matches the mentions of a request: an @ at the start of a word followed by a path.
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([^\s@]+)`)
+
+// rangePattern matches the line range ending a mentioned path, e.g. :10-80 or :10.
+var rangePattern = regexp.MustCompile(`:(\d+)(?:-(\d+))?$`)
+
+// Mention is a file of the repository mentioned in a request, e.g. @internal/config/config.go:10-80.
+type Mention struct {
+	Path      string
+	StartLine int // First mentioned line, 0 when the whole file is mentioned
+	EndLine   int // Last mentioned line, 0 when the whole file is mentioned
+}
+
+// String returns the path of the mention followed by its line range, if any.
+func (m Mention) String() string {
+	if m.StartLine == 0 {
+		return m.Path
+	}
+	return fmt.Sprintf("%s:%d-%d", m.Path, m.StartLine, m.EndLine)
+}
+
+// Excerpt returns the mentioned lines of content, the content of the file as returned by GetFileContent. It returns an
+// error if a line range is mentioned and content is only a preview or a placeholder of the file, whose lines are not
+// those of the file.
+func (m Mention) Excerpt(content string) (string, error) {
+	if m.StartLine == 0 {
+		return content, nil
+	}
+	if isPlaceholder(content) {
+		return "", fmt.Errorf("lines %d-%d of %s cannot be shown, only a preview of the file is available", m.StartLine, m.EndLine, m.Path)
+	}
+	lines := strings.Split(content, "\n")
+	if m.StartLine > len(lines) {
+		return "", nil
+	}
+	return strings.Join(lines[m.StartLine-1:min(m.EndLine, len(lines))], "\n"), nil
+}
+
+// ParseMentions returns the files mentioned in request and found in structure, the files of the repository.
+// @path mentions a file, @dir/ every file below the directory and @path:10-80 lines 10 to 80 of a file. Mentions
+// of other paths, e.g. @someone, are left as text. At most MaxMentionedFiles are returned, in the order of the request.
+func ParseMentions(request string, structure []string) []Mention {
+	var mentions []Mention
+	seen := make(map[Mention]bool)
+	add := func(mention Mention) {
+		if !seen[mention] {
+			seen[mention] = true
+			mentions = append(mentions, mention)
+		}
+	}
+	for _, match := range mentionPattern.FindAllStringSubmatch(request, -1) {
+		for _, mention := range resolveMention(match[1], structure) {
+			add(mention)
+		}
+	}
+	if len(mentions) > MaxMentionedFiles {
+		logging.Logger.Warnf("The request mentions %d files, only the first %d are attached", len(mentions), MaxMentionedFiles)
+		mentions = mentions[:MaxMentionedFiles]
+	}
+	return mentions
+}
+
+// resolveMention returns the files of structure mentioned by text, the text following an @. Punctuation ending a
+// sentence, e.g. in "look at @main.go.", is not part of the path.
+func resolveMention(text string, structure []string) []Mention {
+	if mentions := resolveMentionPath(text, structure); len(mentions) > 0 {
+		return mentions
+	}
+	trimmed := strings.TrimRight(text, ".,;:!?)'\"")
+	if trimmed == text || trimmed == "" {
+		return nil
+	}
+	return resolveMentionPath(trimmed, structure)
+}
+
+// resolveMentionPath returns the file, the line range of a file or the files of the directory mentioned by text.
+func resolveMentionPath(text string, structure []string) []Mention {
+	mention := Mention{Path: text}
+	if match := rangePattern.FindStringSubmatchIndex(text); match != nil {
+		mention.Path = text[:match[0]]
+		mention.StartLine, _ = strconv.Atoi(text[match[2]:match[3]])
+		mention.EndLine = mention.StartLine
+		if match[4] >= 0 {
+			mention.EndLine, _ = strconv.Atoi(text[match[4]:match[5]])
+		}
+		if mention.StartLine == 0 || mention.EndLine < mention.StartLine {
+			return nil
+		}
+	}
+	isDir := strings.HasSuffix(mention.Path, "/")
+	mention.Path = path.Clean(mention.Path)
+	if mention.Path == "." || strings.HasPrefix(mention.Path, "../") || path.IsAbs(mention.Path) {
+		return nil
+	}
+
+	if !isDir {
+		for _, file := range structure {
+			if file == mention.Path {
+				return []Mention{mention}
+			}
+		}
+	}
+	if mention.StartLine != 0 {
+		return nil
+	}
+	var mentions []Mention
+	for _, file := range structure {
+		if strings.HasPrefix(file, mention.Path+"/") {
+			mentions = append(mentions, Mention{Path: file})
+		}
+	}
+	return mentions
+}
@@ -0,0 +1,67 @@
+package context
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMentions(t *testing.T) {
+	structure := []string{"main.go", "go.mod", "internal/config/config.go", "internal/config/layers.go", "internal/logging/logging.go"}
+
+	tests := []struct {
+		name     string
+		request  string
+		expected []Mention
+	}{
+		{"file", "Explain @main.go", []Mention{{Path: "main.go"}}},
+		{"range", "Refactor @internal/config/layers.go:10-80 please", []Mention{{Path: "internal/config/layers.go", StartLine: 10, EndLine: 80}}},
+		{"single line", "What does @main.go:3 do?", []Mention{{Path: "main.go", StartLine: 3, EndLine: 3}}},
+		{"directory", "Document @internal/config/", []Mention{{Path: "internal/config/config.go"}, {Path: "internal/config/layers.go"}}},
+		{"directory without slash", "Document @internal/logging", []Mention{{Path: "internal/logging/logging.go"}}},
+		{"trailing punctuation", "Compare @main.go, @go.mod.", []Mention{{Path: "main.go"}, {Path: "go.mod"}}},
+		{"repeated", "@main.go and @./main.go", []Mention{{Path: "main.go"}}},
+		{"unknown path and email", "Ask @someone or me@example.com about @missing.go", nil},
+		{"invalid range", "@main.go:0 @main.go:9-3", nil},
+		{"outside the repository", "@../secret.txt @/etc/passwd", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ParseMentions(tt.request, structure))
+		})
+	}
+}
+
+func TestParseMentions_Limit(t *testing.T) {
+	var structure []string
+	for i := 0; i < MaxMentionedFiles+5; i++ {
+		structure = append(structure, "pkg/"+string(rune('a'+i))+".go")
+	}
+	assert.Len(t, ParseMentions("@pkg/", structure), MaxMentionedFiles)
+}
+
+func TestMention_Excerpt(t *testing.T) {
+	content := "line 1\nline 2\nline 3\nline 4"
+	excerpt := func(mention Mention, content string) string {
+		text, err := mention.Excerpt(content)
+		require.NoError(t, err)
+		return text
+	}
+
+	assert.Equal(t, content, excerpt(Mention{Path: "a.go"}, content))
+	assert.Equal(t, "line 2\nline 3", excerpt(Mention{Path: "a.go", StartLine: 2, EndLine: 3}, content))
+	assert.Equal(t, "line 3\nline 4", excerpt(Mention{Path: "a.go", StartLine: 3, EndLine: 80}, content))
+	assert.Equal(t, "", excerpt(Mention{Path: "a.go", StartLine: 10, EndLine: 80}, content))
+	assert.Equal(t, "a.go:3-80", Mention{Path: "a.go", StartLine: 3, EndLine: 80}.String())
+
+	// The lines of a preview are not those of the file
+	preview := previewContent("a.go", strings.Repeat("line\n", 100), 500, FileLimits{MaxFileSize: 100, PreviewLines: 2})
+	assert.Equal(t, preview, excerpt(Mention{Path: "a.go"}, preview))
+	_, err := Mention{Path: "a.go", StartLine: 10, EndLine: 80}.Excerpt(preview)
+	assert.ErrorContains(t, err, "lines 10-80 of a.go cannot be shown")
+	binary, _ := decodeFileContent("a.bin", []byte{0, 1, 2}, DefaultFileLimits())
+	_, err = Mention{Path: "a.bin", StartLine: 1, EndLine: 2}.Excerpt(binary)
+	assert.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package context
+
+import (
+	errors2 "errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalidPath is returned for paths that cannot refer to a file in the project.
+	ErrInvalidPath = errors2.New("invalid path")
+	// ErrPathOutsideRoot is returned when a path escapes the project root, directly or through a symlink.
+	ErrPathOutsideRoot = errors2.New("path resolves outside the project root")
+	// ErrProtectedPath is returned when a write targets a location the agent must never modify.
+	ErrProtectedPath = errors2.New("path is protected and cannot be modified")
+)
+
+// protectedDirs lists the top-level directories that can be read but never written.
+var protectedDirs = []string{".git"}
+
+// pathResolver confines file paths supplied by the LLM to the project root.
+type pathResolver struct {
+	rootDir  string // Absolute, cleaned project root
+	realRoot string // Project root with symlinks evaluated
+}
+
+// newPathResolver creates a pathResolver for the given project root.
+func newPathResolver(rootDir string) (*pathResolver, error) {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving absolute path of %s: %w", rootDir, err)
+	}
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving symlinks of %s: %w", rootDir, err)
+	}
+	return &pathResolver{rootDir: absRoot, realRoot: realRoot}, nil
+}
+
+// resolve normalises path and returns it relative to the project root (using forward slashes)
+// together with its absolute location on disk. Paths that escape the root, either lexically or
+// through symlinks, are rejected. When write is true, paths inside protected directories are rejected too.
+func (r *pathResolver) resolve(path string, write bool) (string, string, error) {
+	trimmed := strings.TrimSpace(path)
+	if trimmed == "" {
+		return "", "", fmt.Errorf("%w: path is empty", ErrInvalidPath)
+	}
+	if strings.ContainsRune(trimmed, 0) {
+		return "", "", fmt.Errorf("%w: %q contains a NUL byte", ErrInvalidPath, path)
+	}
+
+	relPath := filepath.Clean(filepath.FromSlash(trimmed))
+	if filepath.IsAbs(relPath) {
+		rel, ok := relativeTo(r.rootDir, relPath)
+		if !ok {
+			rel, ok = relativeTo(r.realRoot, relPath)
+		}
+		if !ok {
+			return "", "", fmt.Errorf("%w: %s", ErrPathOutsideRoot, path)
+		}
+		relPath = rel
+	}
+	if relPath == "." {
+		return "", "", fmt.Errorf("%w: %q refers to the project root", ErrInvalidPath, path)
+	}
+	if escapesRoot(relPath) {
+		return "", "", fmt.Errorf("%w: %s", ErrPathOutsideRoot, path)
+	}
+
+	fullPath := filepath.Join(r.rootDir, relPath)
+	realPath, err := r.evalExistingPrefix(fullPath)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s: %v", ErrPathOutsideRoot, path, err)
+	}
+	realRel, ok := relativeTo(r.realRoot, realPath)
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s is a symlink to a location outside the project", ErrPathOutsideRoot, path)
+	}
+
+	if write && (isProtected(relPath) || isProtected(realRel)) {
+		return "", "", fmt.Errorf("%w: %s", ErrProtectedPath, path)
+	}
+
+	return filepath.ToSlash(relPath), fullPath, nil
+}
+
+// evalExistingPrefix evaluates symlinks on the longest existing prefix of fullPath and
+// re-appends the components that do not exist yet, so that new files can be validated too.
+func (r *pathResolver) evalExistingPrefix(fullPath string) (string, error) {
+	existing := fullPath
+	var missing []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = parent
+	}
+
+	realPath, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{realPath}, missing...)...), nil
+}
+
+// relativeTo returns target relative to base if target lies within base.
+func relativeTo(base string, target string) (string, bool) {
+	rel, err := filepath.Rel(base, target)
+	if err != nil || escapesRoot(rel) {
+		return "", false
+	}
+	return rel, true
+}
+
+// escapesRoot reports whether a cleaned relative path points above its base directory.
+func escapesRoot(relPath string) bool {
+	return relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// isProtected reports whether a cleaned relative path lies inside a protected directory.
+func isProtected(relPath string) bool {
+	first := strings.SplitN(filepath.ToSlash(relPath), "/", 2)[0]
+	for _, dir := range protectedDirs {
+		if strings.EqualFold(first, dir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathResolver_Resolve(t *testing.T) {
+	// Create a project root and a sibling directory that must stay unreachable.
+	baseDir, err := os.MkdirTemp("", "test-path-resolver")
+	require.NoError(t, err)
+	defer os.RemoveAll(baseDir)
+
+	rootDir := filepath.Join(baseDir, "project")
+	outsideDir := filepath.Join(baseDir, "outside")
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, "src"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, ".git"), 0755))
+	require.NoError(t, os.MkdirAll(outsideDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(outsideDir, "secret.txt"), []byte("secret"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "src", "main.go"), []byte("package main"), 0644))
+
+	// Symlinks escaping the root, pointing inside it, and pointing into .git.
+	require.NoError(t, os.Symlink(filepath.Join(outsideDir, "secret.txt"), filepath.Join(rootDir, "secret_link.txt")))
+	require.NoError(t, os.Symlink(outsideDir, filepath.Join(rootDir, "outside_link")))
+	require.NoError(t, os.Symlink(filepath.Join(rootDir, "src"), filepath.Join(rootDir, "src_link")))
+	require.NoError(t, os.Symlink(filepath.Join(rootDir, ".git"), filepath.Join(rootDir, "git_link")))
+
+	resolver, err := newPathResolver(rootDir)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		path        string
+		write       bool
+		expectedRel string
+		expectedErr error
+	}{
+		{name: "plain relative path", path: "src/main.go", expectedRel: "src/main.go"},
+		{name: "dot segments inside root", path: "./src/../src/main.go", expectedRel: "src/main.go"},
+		{name: "new file in new directory", path: "pkg/new/file.go", write: true, expectedRel: "pkg/new/file.go"},
+		{name: "absolute path inside root", path: filepath.Join(rootDir, "src", "main.go"), expectedRel: "src/main.go"},
+		{name: "symlink inside root", path: "src_link/main.go", write: true, expectedRel: "src_link/main.go"},
+		{name: "reading .git is allowed", path: ".git/config", expectedRel: ".git/config"},
+		{name: "parent traversal", path: "../../etc/passwd", expectedErr: ErrPathOutsideRoot},
+		{name: "traversal hidden behind subdirectory", path: "src/../../outside/secret.txt", expectedErr: ErrPathOutsideRoot},
+		{name: "absolute path outside root", path: "/etc/passwd", expectedErr: ErrPathOutsideRoot},
+		{name: "absolute path to sibling", path: filepath.Join(outsideDir, "secret.txt"), expectedErr: ErrPathOutsideRoot},
+		{name: "file symlink escaping root", path: "secret_link.txt", expectedErr: ErrPathOutsideRoot},
+		{name: "directory symlink escaping root", path: "outside_link/secret.txt", expectedErr: ErrPathOutsideRoot},
+		{name: "new file below escaping symlink", path: "outside_link/new/file.txt", write: true, expectedErr: ErrPathOutsideRoot},
+		{name: "write into .git", path: ".git/config", write: true, expectedErr: ErrProtectedPath},
+		{name: "write into .git with different case", path: ".GIT/hooks/pre-commit", write: true, expectedErr: ErrProtectedPath},
+		{name: "write into .git through dot segments", path: "src/../.git/HEAD", write: true, expectedErr: ErrProtectedPath},
+		{name: "write into .git through symlink", path: "git_link/config", write: true, expectedErr: ErrProtectedPath},
+		{name: "write .git itself", path: ".git", write: true, expectedErr: ErrProtectedPath},
+		{name: "empty path", path: "  ", expectedErr: ErrInvalidPath},
+		{name: "project root", path: ".", expectedErr: ErrInvalidPath},
+		{name: "NUL byte", path: "src/main.go\x00.txt", expectedErr: ErrInvalidPath},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			relPath, fullPath, err := resolver.resolve(test.path, test.write)
+			if test.expectedErr != nil {
+				require.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expectedRel, relPath)
+			require.Equal(t, filepath.Join(resolver.rootDir, filepath.FromSlash(test.expectedRel)), fullPath)
+		})
+	}
+}
+
+func TestLocalAgentContext_RejectsPathsOutsideRoot(t *testing.T) {
+	// Create a project root next to a file that must never be read or written.
+	baseDir, err := os.MkdirTemp("", "test-sandbox")
+	require.NoError(t, err)
+	defer os.RemoveAll(baseDir)
+
+	rootDir := filepath.Join(baseDir, "project")
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, ".git"), 0755))
+	secretPath := filepath.Join(baseDir, "secret.txt")
+	require.NoError(t, os.WriteFile(secretPath, []byte("secret"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "file.txt"), []byte("content"), 0644))
+
+	ctx, err := NewLocalProgrammingAgentContext(rootDir, "change request", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+
+	// Reads outside the root report the violation in the content returned to the LLM.
+	content, exists := ctx.GetFileContent("../secret.txt")
+	require.False(t, exists)
+	require.Contains(t, content, "Access denied")
+	require.NotContains(t, content, "secret\n")
+
+	// Writes, moves and deletions outside the root or into .git are rejected.
+	require.ErrorIs(t, ctx.UpdateFileContent("../secret.txt", "overwritten"), ErrPathOutsideRoot)
+	require.ErrorIs(t, ctx.UpdateFileContent(".git/config", "overwritten"), ErrProtectedPath)
+	require.ErrorIs(t, ctx.MoveFile("file.txt", "../moved.txt"), ErrPathOutsideRoot)
+	require.ErrorIs(t, ctx.MoveFile(secretPath, "stolen.txt"), ErrPathOutsideRoot)
+	require.ErrorIs(t, ctx.Delete("../secret.txt"), ErrPathOutsideRoot)
+	require.ErrorIs(t, ctx.Delete(".git/HEAD"), ErrProtectedPath)
+
+	require.NoError(t, ctx.FlushChanges())
+
+	// Nothing outside the project was touched.
+	secret, err := os.ReadFile(secretPath)
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(secret))
+	_, err = os.Stat(filepath.Join(baseDir, "moved.txt"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(rootDir, ".git", "config"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestLocalAgentContext_FlushChanges_RevalidatesPaths(t *testing.T) {
+	// Create a project root and a directory outside of it.
+	baseDir, err := os.MkdirTemp("", "test-sandbox-flush")
+	require.NoError(t, err)
+	defer os.RemoveAll(baseDir)
+
+	rootDir := filepath.Join(baseDir, "project")
+	outsideDir := filepath.Join(baseDir, "outside")
+	require.NoError(t, os.MkdirAll(rootDir, 0755))
+	require.NoError(t, os.MkdirAll(outsideDir, 0755))
+
+	ctx, err := NewLocalProgrammingAgentContext(rootDir, "change request", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+
+	// The path is valid when the update is recorded...
+	require.NoError(t, ctx.UpdateFileContent("generated/file.txt", "content"))
+
+	// ...but a symlink swapped in before flushing must not redirect the write.
+	require.NoError(t, os.Symlink(outsideDir, filepath.Join(rootDir, "generated")))
+
+	err = ctx.FlushChanges()
+	require.ErrorIs(t, err, ErrPathOutsideRoot)
+	_, err = os.Stat(filepath.Join(outsideDir, "file.txt"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -3,7 +3,7 @@ package context
 // ProgrammingAgentContext defines the interface for interacting with the project's context.
 type ProgrammingAgentContext interface {
 	GetFileContent(filePath string) (string, bool)
-	UpdateFileContent(filePath string, newContents string)
+	UpdateFileContent(filePath string, newContents string) error
 	SearchCode(query string) map[string][]int
 	GetRepoStructure() []string
 	GetChangeRequest() string
@@ -11,3 +11,9 @@ type ProgrammingAgentContext interface {
 	FlushChanges() error
 	MoveFile(oldPath string, newPath string) error
 }
+
+// ModifiabilityChecker is implemented by contexts that can tell whether a file may be modified before its new
+// content is generated.
+type ModifiabilityChecker interface {
+	CanModify(filePath string) error
+}
@@ -144,9 +144,11 @@ func (mr *MockProgrammingAgentContextMockRecorder) SearchCode(arg0 interface{})
 }
 
 // UpdateFileContent mocks base method.
-func (m *MockProgrammingAgentContext) UpdateFileContent(arg0, arg1 string) {
+func (m *MockProgrammingAgentContext) UpdateFileContent(arg0, arg1 string) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "UpdateFileContent", arg0, arg1)
+	ret := m.ctrl.Call(m, "UpdateFileContent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // UpdateFileContent indicates an expected call of UpdateFileContent.
@@ -0,0 +1,139 @@
+package context
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// Snapshot holds the changes of a LocalProgrammingAgentContext that are not flushed to disk yet,
+// so that an interrupted change request can be resumed from them.
+type Snapshot struct {
+	ChangeRequest string            `json:"change_request"`
+	Files         map[string]string `json:"files"`         // Pending content of the updated and new files
+	BaseContents  map[string]string `json:"base_contents"` // Content the updated files had on disk when they were read
+	UpdatedFiles  []string          `json:"updated_files"`
+	NewFiles      []string          `json:"new_files"`
+	DeletedFiles  []string          `json:"deleted_files"`
+	MovedFiles    map[string]string `json:"moved_files"` // oldPath -> newPath
+}
+
+// Snapshotter is implemented by contexts whose pending changes can be saved and restored.
+type Snapshotter interface {
+	Snapshot() Snapshot
+	Restore(snapshot Snapshot) error
+}
+
+// Snapshot returns the change request and the pending changes of the context.
+func (c *LocalProgrammingAgentContext) Snapshot() Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot := Snapshot{
+		ChangeRequest: c.changeRequest,
+		Files:         make(map[string]string),
+		BaseContents:  make(map[string]string),
+		UpdatedFiles:  slices.Clone(c.updatedFiles),
+		NewFiles:      slices.Clone(c.newFiles),
+		DeletedFiles:  slices.Clone(c.deletedFiles),
+		MovedFiles:    maps.Clone(c.movedFiles),
+	}
+	for _, filePath := range append(slices.Clone(c.updatedFiles), c.newFiles...) {
+		snapshot.Files[filePath] = c.currentFileContents[filePath]
+		if base, ok := c.baseContents[filePath]; ok {
+			snapshot.BaseContents[filePath] = base
+		}
+	}
+	return snapshot
+}
+
+// Restore applies the changes of a snapshot to a context created for the same directory. Updated files whose
+// content on disk no longer matches the content they were read with are reported as conflicts before flushing.
+func (c *LocalProgrammingAgentContext) Restore(snapshot Snapshot) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.changeRequest = snapshot.ChangeRequest
+	for oldPath, newPath := range snapshot.MovedFiles {
+		if c.existsOnDisk(newPath) && !c.existsOnDisk(oldPath) {
+			// The move was already flushed
+			continue
+		}
+		c.movedFiles[oldPath] = newPath
+		c.updateFilePathsInContext(oldPath, newPath)
+		c.fileAliasesMutex.Lock()
+		c.fileAliases[newPath] = oldPath
+		c.fileAliasesMutex.Unlock()
+	}
+	for _, filePath := range snapshot.DeletedFiles {
+		c.deletedFiles = append(c.deletedFiles, filePath)
+		c.removeFileFromContext(filePath)
+	}
+
+	for _, filePath := range snapshot.UpdatedFiles {
+		decoded, meta, err := c.readForRestore(filePath)
+		if err != nil {
+			return err
+		}
+		base := snapshot.BaseContents[filePath]
+		switch {
+		case meta != nil && decoded == snapshot.Files[filePath]:
+			// The pending content was already written, e.g. by a run stopped at the loop limit
+			base = decoded
+		case meta == nil:
+			// The file was removed from disk since it was read, so that the removal is detected
+			meta = &fileMetadata{kind: textFile, size: -1}
+		case decoded != base:
+			// The file changed on disk since it was read, invalidate its snapshot so that the change is detected.
+			// Its encoding is kept, so that overwriting it keeps its line endings and byte order mark.
+			meta.modTime, meta.hash = time.Time{}, [sha256.Size]byte{}
+		}
+		c.fileMetadata[filePath] = *meta
+		c.baseContents[filePath] = base
+		c.currentFileContents[filePath] = snapshot.Files[filePath]
+		c.updatedFiles = append(c.updatedFiles, filePath)
+	}
+	for _, filePath := range snapshot.NewFiles {
+		decoded, meta, err := c.readForRestore(filePath)
+		if err != nil {
+			return err
+		}
+		if meta != nil && decoded == snapshot.Files[filePath] {
+			c.fileMetadata[filePath] = *meta
+			c.baseContents[filePath] = decoded
+		}
+		c.currentFileContents[filePath] = snapshot.Files[filePath]
+		c.newFiles = append(c.newFiles, filePath)
+		if !slices.Contains(c.CurrentRepoStructure, filePath) {
+			c.CurrentRepoStructure = append(c.CurrentRepoStructure, filePath)
+		}
+	}
+	return nil
+}
+
+// readForRestore reads a file of a snapshot from disk. It returns nil metadata if the file does not exist
+// or cannot be read; the caller must hold c.mu.
+func (c *LocalProgrammingAgentContext) readForRestore(filePath string) (string, *fileMetadata, error) {
+	_, fullPath, err := c.resolveFilePath(filePath, true)
+	if err != nil {
+		return "", nil, fmt.Errorf("error restoring %s: %w", filePath, err)
+	}
+	decoded, meta, err := readSnapshot(filePath, fullPath, c.fileLimits)
+	if err != nil {
+		return "", nil, nil
+	}
+	return decoded, &meta, nil
+}
+
+// existsOnDisk reports whether filePath exists in the project directory.
+func (c *LocalProgrammingAgentContext) existsOnDisk(filePath string) bool {
+	_, fullPath, err := c.paths.resolve(filePath, false)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(fullPath)
+	return err == nil
+}
@@ -0,0 +1,121 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+// newSnapshotFixture creates a directory holding files and a context with pending changes to all of them.
+func newSnapshotFixture(t *testing.T) (string, *LocalProgrammingAgentContext) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{"updated.txt": "old\n", "deleted.txt": "deleted\n", "moved.txt": "moved\n"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	ctx, err := NewLocalProgrammingAgentContext(dir, "change request", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	_, exists := ctx.GetFileContent("updated.txt")
+	require.True(t, exists)
+	require.NoError(t, ctx.UpdateFileContent("updated.txt", "new\n"))
+	require.NoError(t, ctx.UpdateFileContent("created.txt", "created\n"))
+	require.NoError(t, ctx.Delete("deleted.txt"))
+	require.NoError(t, ctx.MoveFile("moved.txt", "renamed.txt"))
+	return dir, ctx
+}
+
+func TestLocalAgentContext_SnapshotRestore(t *testing.T) {
+	dir, ctx := newSnapshotFixture(t)
+
+	restored, err := NewLocalProgrammingAgentContext(dir, "", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(ctx.Snapshot()))
+
+	assert.Equal(t, "change request", restored.GetChangeRequest())
+	content, _ := restored.GetFileContent("updated.txt")
+	assert.Equal(t, "new\n", content)
+	content, _ = restored.GetFileContent("renamed.txt")
+	assert.Equal(t, "moved\n", content)
+	_, exists := restored.GetFileContent("deleted.txt")
+	assert.False(t, exists)
+	assert.ElementsMatch(t, []string{"updated.txt", "created.txt", "renamed.txt"}, restored.GetRepoStructure())
+
+	require.NoError(t, restored.FlushChanges())
+	for name, expected := range map[string]string{"updated.txt": "new\n", "created.txt": "created\n", "renamed.txt": "moved\n"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "deleted.txt"))
+	assert.NoFileExists(t, filepath.Join(dir, "moved.txt"))
+}
+
+func TestLocalAgentContext_RestoreDetectsFilesModifiedOnDisk(t *testing.T) {
+	dir, ctx := newSnapshotFixture(t)
+	snapshot := ctx.Snapshot()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "updated.txt"), []byte("edited\n"), 0644))
+
+	restored, err := NewLocalProgrammingAgentContext(dir, "", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(snapshot))
+
+	conflicts, err := restored.DetectConflicts()
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "updated.txt", conflicts[0].FilePath)
+	assert.Equal(t, "old\n", conflicts[0].Base)
+	assert.Equal(t, "new\n", conflicts[0].Ours)
+	assert.Equal(t, "edited\n", conflicts[0].Theirs)
+}
+
+func TestLocalAgentContext_RestoreAlreadyFlushedChanges(t *testing.T) {
+	dir, ctx := newSnapshotFixture(t)
+	snapshot := ctx.Snapshot()
+	require.NoError(t, ctx.FlushChanges())
+
+	restored, err := NewLocalProgrammingAgentContext(dir, "", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(snapshot))
+
+	conflicts, err := restored.DetectConflicts()
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+	require.NoError(t, restored.FlushChanges())
+	content, err := os.ReadFile(filepath.Join(dir, "renamed.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "moved\n", string(content))
+}
+
+func TestLocalAgentContext_RestoreKeepsEncodingOfFilesModifiedOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "crlf.txt"), []byte("\ufeffold\r\n"), 0644))
+	ctx, err := NewLocalProgrammingAgentContext(dir, "change request", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	_, exists := ctx.GetFileContent("crlf.txt")
+	require.True(t, exists)
+	require.NoError(t, ctx.UpdateFileContent("crlf.txt", "new\n"))
+	snapshot := ctx.Snapshot()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "crlf.txt"), []byte("\ufeffedited\r\n"), 0644))
+
+	restored, err := NewLocalProgrammingAgentContext(dir, "", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(snapshot))
+	meta := restored.fileMetadata["crlf.txt"]
+	assert.True(t, meta.bom)
+	assert.True(t, meta.crlf)
+
+	conflicts, err := restored.DetectConflicts()
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1, "the change on disk is still detected")
+	require.NoError(t, restored.ResolveConflict("crlf.txt", ConflictOverwrite))
+	require.NoError(t, restored.FlushChanges())
+	content, err := os.ReadFile(filepath.Join(dir, "crlf.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "\ufeffnew\r\n", string(content))
+}
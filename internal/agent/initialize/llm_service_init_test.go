@@ -2,8 +2,15 @@ package initialize
 
 import (
 	"context"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/agent/service"
 	"github.com/EduardDranca/GoAgent/internal/config"
+	"github.com/EduardDranca/GoAgent/internal/llm"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestInitLLMService(t *testing.T) {
@@ -45,3 +52,264 @@ func TestInitLLMServiceEmptyAPIKey(t *testing.T) {
 		t.Errorf("InitLLMService did not return an error for empty API key")
 	}
 }
+
+func TestInitLLMServiceReplayWithoutAPIKey(t *testing.T) {
+	ctx := context.Background()
+	transcriptPath := filepath.Join(t.TempDir(), "run.jsonl")
+	if err := os.WriteFile(transcriptPath, []byte(`{"seq":1,"role":"code_analysis","type":"message","request":"q","response":"a"}`+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write transcript: %v", err)
+	}
+
+	cfg := &config.Config{
+		ProgrammingService: config.GeminiService,
+		ReplaySession:      transcriptPath,
+	}
+
+	_, err := InitProgrammingService(ctx, cfg)
+	if err != nil {
+		t.Errorf("InitLLMService returned an error when replaying: %v", err)
+	}
+}
+
+func TestInitLLMServiceFake(t *testing.T) {
+	ctx := context.Background()
+	scriptPath := filepath.Join(t.TempDir(), "script.yaml")
+	if err := os.WriteFile(scriptPath, []byte("roles:\n  code_analysis:\n    - response: done\n"), 0644); err != nil {
+		t.Fatalf("failed to write fake script: %v", err)
+	}
+
+	cfg := &config.Config{
+		ProgrammingService: config.FakeService,
+		FakeScript:         scriptPath,
+	}
+	if _, err := InitProgrammingService(ctx, cfg); err != nil {
+		t.Errorf("InitLLMService returned an error for the fake service: %v", err)
+	}
+
+	cfg.FakeScript = filepath.Join(t.TempDir(), "missing.yaml")
+	if _, err := InitProgrammingService(ctx, cfg); err == nil {
+		t.Errorf("InitLLMService did not return an error for a missing fake script")
+	}
+
+	// The script is only loaded when the fake service is used
+	cfg.ProgrammingService = config.GeminiService
+	cfg.GeminiApiKey = "test-api-key"
+	if _, err := InitProgrammingService(ctx, cfg); err != nil {
+		t.Errorf("InitLLMService loaded the fake script of an unused fake service: %v", err)
+	}
+}
+
+func TestInitLLMServiceFallbacks(t *testing.T) {
+	ctx := context.Background()
+	scriptPath := filepath.Join(t.TempDir(), "script.yaml")
+	if err := os.WriteFile(scriptPath, []byte("roles:\n  code_analysis:\n    - response: done\n"), 0644); err != nil {
+		t.Fatalf("failed to write fake script: %v", err)
+	}
+
+	cfg := &config.Config{
+		ProgrammingService: config.FakeService,
+		FakeScript:         scriptPath,
+		Fallbacks:          map[string][]config.ProviderConfig{RoleCodeAnalysis: {{Service: config.FakeService}}},
+		CircuitBreaker:     config.CircuitBreakerConfig{FailureThreshold: 3, Cooldown: time.Minute},
+	}
+	if _, err := InitProgrammingService(ctx, cfg); err != nil {
+		t.Errorf("InitLLMService returned an error for a fallback chain: %v", err)
+	}
+
+	cfg.Fallbacks = map[string][]config.ProviderConfig{"reviewer": {{Service: config.FakeService}}}
+	if _, err := InitProgrammingService(ctx, cfg); err == nil || !strings.Contains(err.Error(), `unknown assistant role "reviewer"`) {
+		t.Errorf("InitLLMService did not reject an unknown fallback role: %v", err)
+	}
+}
+
+func TestInitLLMServicePromptOverrides(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	promptsDir := filepath.Join(dir, PromptsDir)
+	if err := os.MkdirAll(promptsDir, 0755); err != nil {
+		t.Fatalf("failed to create prompts directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(promptsDir, "implement.tmpl"), []byte("Implement {{.ChangeRequest}}\n"), 0644); err != nil {
+		t.Fatalf("failed to write prompt override: %v", err)
+	}
+
+	cfg := &config.Config{
+		Directory:          dir,
+		ProgrammingService: config.GeminiService,
+		GeminiApiKey:       "valid-api-key",
+	}
+	if _, err := InitProgrammingService(ctx, cfg); err != nil {
+		t.Errorf("InitLLMService returned an error for a valid prompt override: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(promptsDir, "implement.tmpl"), []byte("Implement {{.Request}}\n"), 0644); err != nil {
+		t.Fatalf("failed to write prompt override: %v", err)
+	}
+	if _, err := InitProgrammingService(ctx, cfg); err == nil || !strings.Contains(err.Error(), "unknown variable .Request") {
+		t.Errorf("InitLLMService did not reject a prompt override with an unknown variable: %v", err)
+	}
+}
+
+func TestInitLLMServiceRoles(t *testing.T) {
+	ctx := context.Background()
+	temperature := float32(0.1)
+	cfg := &config.Config{
+		ProgrammingService: config.GeminiService,
+		GeminiApiKey:       "valid-api-key",
+		GroqApiKey:         "valid-api-key",
+		MaxHistoryLength:   100,
+		Roles: map[string]config.RoleConfig{
+			RoleCodeInstruction: {Service: config.GroqService, Temperature: &temperature},
+			RoleGenerateCode:    {Model: "gemini-2.5-pro", MaxHistoryLength: 20},
+		},
+		ProviderRateLimits: map[config.LLMServiceType]config.RateLimitConfig{config.GroqService: {RequestsPerMinute: 30}},
+	}
+	if _, err := InitProgrammingService(ctx, cfg); err != nil {
+		t.Errorf("InitLLMService returned an error for per-role providers: %v", err)
+	}
+
+	cfg.Roles = map[string]config.RoleConfig{"reviewer": {Service: config.GroqService}}
+	if _, err := InitProgrammingService(ctx, cfg); err == nil || !strings.Contains(err.Error(), `unknown assistant role "reviewer" in roles`) {
+		t.Errorf("InitLLMService did not reject an unknown role: %v", err)
+	}
+}
+
+func TestSessionFactoryRoleProvider(t *testing.T) {
+	cfg := &config.Config{
+		ProgrammingService: config.GeminiService,
+		MaxHistoryLength:   100,
+		Roles: map[string]config.RoleConfig{
+			RoleCodeInstruction: {Service: config.GroqService},
+			RoleCodeAnalysis:    {Service: config.OpenAIService, Model: "gpt-4.1"},
+			RoleGenerateCode:    {Model: "gemini-2.5-pro", MaxHistoryLength: 20},
+		},
+	}
+	factory, err := newSessionFactory(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("newSessionFactory returned an error: %v", err)
+	}
+
+	groqModel, _, _ := config.DefaultModelNames(config.GroqService)
+	tests := []struct {
+		role        string
+		wantService config.LLMServiceType
+		wantModel   string
+	}{
+		{RoleCodeInstruction, config.GroqService, groqModel},
+		{RoleCodeAnalysis, config.OpenAIService, "gpt-4.1"},
+		{RoleGenerateCode, config.GeminiService, "gemini-2.5-pro"},
+		{RolePatchApply, config.GeminiService, "configured-model"},
+	}
+	for _, tt := range tests {
+		llmService, modelName := factory.roleProvider(tt.role, "configured-model")
+		if llmService != tt.wantService || modelName != tt.wantModel {
+			t.Errorf("roleProvider(%s) = %s/%s, want %s/%s", tt.role, llmService, modelName, tt.wantService, tt.wantModel)
+		}
+	}
+
+	if length := factory.roleMaxHistoryLength(RoleGenerateCode); length != 20 {
+		t.Errorf("roleMaxHistoryLength(%s) = %d, want 20", RoleGenerateCode, length)
+	}
+	if length := factory.roleMaxHistoryLength(RoleCodeAnalysis); length != 100 {
+		t.Errorf("roleMaxHistoryLength(%s) = %d, want 100", RoleCodeAnalysis, length)
+	}
+
+	// Every provider has its own rate limiter, shared by its sessions
+	if factory.rateLimiter(config.GroqService) != factory.rateLimiter(config.GroqService) {
+		t.Errorf("the sessions of a provider do not share its rate limiter")
+	}
+	if factory.rateLimiter(config.GroqService) == factory.rateLimiter(config.GeminiService) {
+		t.Errorf("two providers share a rate limiter")
+	}
+}
+
+func TestSessionFactoryChainRetryPolicy(t *testing.T) {
+	cfg := &config.Config{ProgrammingService: config.GeminiService, Retry: config.RetryConfig{MaxAttempts: 5}}
+	factory, err := newSessionFactory(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("newSessionFactory returned an error: %v", err)
+	}
+
+	tests := []struct {
+		index, count int
+		wantAttempts int
+	}{
+		{0, 1, 5}, // Without fallbacks the provider retries
+		{0, 3, 1}, // A provider followed by a fallback fails over at once
+		{1, 3, 1},
+		{2, 3, 5}, // The last provider of the chain retries
+	}
+	for _, tt := range tests {
+		if attempts := factory.chainRetryPolicy(tt.index, tt.count).MaxAttempts; attempts != tt.wantAttempts {
+			t.Errorf("chainRetryPolicy(%d, %d).MaxAttempts = %d, want %d", tt.index, tt.count, attempts, tt.wantAttempts)
+		}
+	}
+}
+
+// historyServiceStub is a programming service holding the given histories.
+type historyServiceStub struct {
+	service.ProgrammingService
+	histories service.Histories
+}
+
+func (s *historyServiceStub) Histories() service.Histories {
+	return s.histories
+}
+
+func TestSessionFactoryContinuedHistories(t *testing.T) {
+	previousCfg := &config.Config{ProgrammingService: config.GeminiService}
+	previous := &historyServiceStub{histories: service.Histories{
+		CodeAnalysis: []models.Message{{Role: "user", Content: "Add a README"}},
+		AskThread:    &service.AskThread{Turns: []service.AskTurn{{Question: "What does main.go do?", Answer: "It starts the agent."}}},
+	}}
+
+	tests := []struct {
+		name             string
+		cfg              *config.Config
+		wantCodeAnalysis bool
+		wantAskThread    bool
+	}{
+		{"same provider", &config.Config{ProgrammingService: config.GeminiService, AnalysisModelName: "gemini-2.5-pro"}, true, true},
+		{"other provider", &config.Config{ProgrammingService: config.GroqService}, false, false},
+		{"other provider of a role", &config.Config{
+			ProgrammingService: config.GeminiService,
+			Roles:              map[string]config.RoleConfig{RoleAskAnalysis: {Service: config.OpenAIService}},
+		}, true, false},
+	}
+	for _, tt := range tests {
+		factory := &sessionFactory{cfg: tt.cfg, previous: previous, previousCfg: previousCfg}
+		histories := factory.continuedHistories()
+		if got := histories.CodeAnalysis != nil; got != tt.wantCodeAnalysis {
+			t.Errorf("%s: code analysis history continued = %v, want %v", tt.name, got, tt.wantCodeAnalysis)
+		}
+		if got := len(histories.AskThread.Turns) > 0; got != tt.wantAskThread {
+			t.Errorf("%s: ask thread continued = %v, want %v", tt.name, got, tt.wantAskThread)
+		}
+	}
+}
+
+func TestRoleOptions(t *testing.T) {
+	temperature, seed := float32(0.7), 7
+	roleConfig := config.RoleConfig{
+		Temperature:     &temperature,
+		MaxOutputTokens: 32768,
+		Stop:            []string{"<END>"},
+		Seed:            &seed,
+		ReasoningEffort: config.HighReasoningEffort,
+	}
+
+	// The configured sampling overrides the defaults of the role, which come first
+	options := &llm.Options{}
+	for _, option := range append([]llm.Option{llm.WithTemperature(0.3), llm.WithTopK(10)}, roleOptions(roleConfig)...) {
+		option(options)
+	}
+	if *options.Temperature != 0.7 || *options.TopK != 10 {
+		t.Errorf("roleOptions did not override the default sampling: temperature %v, top_k %v", *options.Temperature, *options.TopK)
+	}
+	if *options.MaxOutputTokens != 32768 || options.StopSequences[0] != "<END>" || *options.Seed != 7 || *options.ReasoningEffort != "high" {
+		t.Errorf("roleOptions did not set the configured options: %+v", options)
+	}
+	if len(roleOptions(config.RoleConfig{})) != 0 {
+		t.Errorf("roleOptions returned options for an empty role configuration")
+	}
+}
@@ -0,0 +1,231 @@
+package instructions
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+const (
+	// DirectoryFile is the name of the files holding the instructions for the files under their directory.
+	DirectoryFile = "AGENTS.md"
+
+	// DefaultMaxFileSize is the number of bytes of an instruction file used when no limit is configured.
+	DefaultMaxFileSize = 8 * 1024
+	// DefaultMaxTotalSize is the number of bytes of directory instructions added to a prompt when no limit is configured.
+	DefaultMaxTotalSize = 32 * 1024
+)
+
+// ProjectFile is the path, relative to the repository, of the instructions that apply to the whole project.
+var ProjectFile = filepath.Join(".go-agent", "instructions.md")
+
+// Limits bounds the size of the instructions given to the LLM.
+type Limits struct {
+	MaxFileSize  int // Bytes of an instruction file that are used, the rest is truncated
+	MaxTotalSize int // Bytes of directory instructions added to a single prompt
+}
+
+// File is a loaded instruction file.
+type File struct {
+	Path      string // Path of the file, relative to the repository
+	Dir       string // Directory whose files the instructions apply to, "." for the whole repository
+	Content   string
+	Size      int  // Size of the file on disk, in bytes
+	Truncated bool // Whether the content was cut to the size limit
+}
+
+// Instructions holds the project instructions of a repository and loads its directory instructions on demand.
+// It is safe for concurrent use.
+type Instructions struct {
+	root    string
+	limits  Limits
+	project *File // Nil when the project has no instructions
+
+	mu      sync.Mutex
+	dirs    map[string]*File // Loaded directory instructions, nil for directories without any
+	leftOut map[*File]bool   // Instructions that were left out for exceeding the total size limit, to warn once
+}
+
+// Load reads the project instructions of the repository at root. Directory instructions are read when
+// files under their directory are first used.
+func Load(root string, limits Limits) (*Instructions, error) {
+	if limits.MaxFileSize <= 0 {
+		limits.MaxFileSize = DefaultMaxFileSize
+	}
+	if limits.MaxTotalSize <= 0 {
+		limits.MaxTotalSize = DefaultMaxTotalSize
+	}
+	instructions := &Instructions{root: root, limits: limits, dirs: make(map[string]*File), leftOut: make(map[*File]bool)}
+
+	project, err := instructions.readFile(ProjectFile, ".")
+	if err != nil {
+		return nil, err
+	}
+	if project != nil {
+		logging.Logger.Infof("Loaded project instructions from %s", ProjectFile)
+	}
+	instructions.project = project
+	return instructions, nil
+}
+
+// Project returns the instructions that apply to the whole project, or nil if there are none.
+func (i *Instructions) Project() *File {
+	return i.project
+}
+
+// ForFiles returns the directory instructions that apply to paths, relative to the repository, from the outermost
+// directory to the innermost. Paths outside the repository, e.g. ../x/y.go, have none. When the instructions exceed the
+// total size limit, the outermost ones are left out.
+func (i *Instructions) ForFiles(paths ...string) []*File {
+	var applicable []*File
+	for _, path := range paths {
+		if !filepath.IsLocal(path) {
+			continue
+		}
+		for _, dir := range ancestors(path) {
+			file := i.directory(dir)
+			if file != nil && !slices.Contains(applicable, file) {
+				applicable = append(applicable, file)
+			}
+		}
+	}
+	// Deeper directories have more specific instructions, so they are kept first
+	slices.SortStableFunc(applicable, func(a, b *File) int {
+		return depth(b.Dir) - depth(a.Dir)
+	})
+
+	var kept []*File
+	total := 0
+	for _, file := range applicable {
+		if total+len(file.Content) > i.limits.MaxTotalSize {
+			i.warnLeftOut(file)
+			continue
+		}
+		total += len(file.Content)
+		kept = append(kept, file)
+	}
+	slices.Reverse(kept)
+	return kept
+}
+
+// warnLeftOut warns that file was left out for exceeding the total size limit, the first time it is.
+func (i *Instructions) warnLeftOut(file *File) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.leftOut[file] {
+		return
+	}
+	i.leftOut[file] = true
+	logging.Logger.Warnf("Leaving out the instructions of %s, the instructions exceed %d bytes", file.Path, i.limits.MaxTotalSize)
+}
+
+// Directories lists every directory instruction file of the repository, for display.
+func (i *Instructions) Directories() ([]*File, error) {
+	var files []*File
+	err := filepath.WalkDir(i.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != i.root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Name() != DirectoryFile {
+			return nil
+		}
+		relPath, err := filepath.Rel(i.root, path)
+		if err != nil {
+			return err
+		}
+		if file := i.directory(filepath.Dir(relPath)); file != nil {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory instructions: %w", err)
+	}
+	return files, nil
+}
+
+// Format renders directory instructions to be added to a prompt.
+func Format(files []*File) string {
+	var sb strings.Builder
+	for _, file := range files {
+		fmt.Fprintf(&sb, "Instructions for the files in %s (from %s), which your changes to these files must follow:\n%s\n\n", file.Dir, file.Path, file.Content)
+	}
+	return sb.String()
+}
+
+// directory returns the instructions of dir, reading them on first use.
+func (i *Instructions) directory(dir string) *File {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if file, ok := i.dirs[dir]; ok {
+		return file
+	}
+	file, err := i.readFile(filepath.Join(dir, DirectoryFile), dir)
+	if err != nil {
+		logging.Logger.Warnf("Ignoring directory instructions: %v", err)
+	}
+	i.dirs[dir] = file
+	return file
+}
+
+// readFile reads the instruction file at path, relative to the root, truncating it to the size limit.
+// It returns nil if the file does not exist.
+func (i *Instructions) readFile(path string, dir string) (*File, error) {
+	data, err := os.ReadFile(filepath.Join(i.root, path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instructions %s: %w", path, err)
+	}
+
+	file := &File{Path: path, Dir: dir, Content: strings.TrimSpace(string(data)), Size: len(data)}
+	if len(file.Content) > i.limits.MaxFileSize {
+		logging.Logger.Warnf("The instructions in %s exceed %d bytes and were truncated", path, i.limits.MaxFileSize)
+		cut := i.limits.MaxFileSize
+		// Do not cut a multi-byte character in half
+		for cut > 0 && !utf8.RuneStart(file.Content[cut]) {
+			cut--
+		}
+		file.Content = file.Content[:cut]
+		file.Truncated = true
+	}
+	if file.Content == "" {
+		return nil, nil
+	}
+	return file, nil
+}
+
+// ancestors returns the directories containing path, from the repository root to its own directory.
+func ancestors(path string) []string {
+	dir := filepath.Dir(filepath.Clean(path))
+	dirs := []string{dir}
+	for dir != "." && dir != string(filepath.Separator) {
+		dir = filepath.Dir(dir)
+		dirs = append(dirs, dir)
+	}
+	slices.Reverse(dirs)
+	return dirs
+}
+
+// depth returns the number of directories in dir.
+func depth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(dir, string(filepath.Separator)) + 1
+}
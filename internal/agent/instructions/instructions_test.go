@@ -0,0 +1,113 @@
+package instructions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles creates files, given by path relative to dir, with their content.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		fullPath := filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), 0644))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{ProjectFile: "Wrap errors with %w.\n"})
+
+	instructions, err := Load(dir, Limits{})
+	require.NoError(t, err)
+	project := instructions.Project()
+	require.NotNil(t, project)
+	assert.Equal(t, "Wrap errors with %w.", project.Content)
+	assert.False(t, project.Truncated)
+
+	instructions, err = Load(t.TempDir(), Limits{})
+	require.NoError(t, err)
+	assert.Nil(t, instructions.Project())
+}
+
+func TestLoad_TruncatesLargeFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{ProjectFile: "Use testify. Never edit généré files."})
+
+	instructions, err := Load(dir, Limits{MaxFileSize: 29})
+	require.NoError(t, err)
+	project := instructions.Project()
+	require.NotNil(t, project)
+	// The limit falls inside "é", which is not cut in half
+	assert.Equal(t, "Use testify. Never edit gén", project.Content)
+	assert.True(t, project.Truncated)
+	assert.Equal(t, 40, project.Size)
+}
+
+func TestInstructions_ForFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		DirectoryFile:                              "Root instructions.",
+		filepath.Join("internal", DirectoryFile):   "Internal instructions.",
+		filepath.Join("internal", "api", "api.go"): "package api",
+		filepath.Join("cmd", "main.go"):            "package main",
+	})
+	instructions, err := Load(dir, Limits{})
+	require.NoError(t, err)
+
+	files := instructions.ForFiles(filepath.Join("internal", "api", "api.go"), filepath.Join("cmd", "main.go"))
+	require.Len(t, files, 2)
+	assert.Equal(t, ".", files[0].Dir)
+	assert.Equal(t, "Root instructions.", files[0].Content)
+	assert.Equal(t, "internal", files[1].Dir)
+	assert.Equal(t, filepath.Join("internal", DirectoryFile), files[1].Path)
+
+	assert.Len(t, instructions.ForFiles("README.md"), 1)
+
+	// Instructions added later are found for directories not used yet
+	writeFiles(t, dir, map[string]string{filepath.Join("tools", DirectoryFile): "Tools instructions."})
+	files = instructions.ForFiles(filepath.Join("tools", "gen.go"))
+	require.Len(t, files, 2)
+	assert.Equal(t, "Tools instructions.", files[1].Content)
+
+	directories, err := instructions.Directories()
+	require.NoError(t, err)
+	assert.Len(t, directories, 3)
+}
+
+func TestInstructions_ForFiles_OutsideRepository(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "repo")
+	writeFiles(t, parent, map[string]string{
+		DirectoryFile:                      "Instructions outside the repository.",
+		filepath.Join("x", DirectoryFile):  "Instructions of a sibling directory.",
+		filepath.Join("repo", "README.md"): "readme",
+	})
+	instructions, err := Load(dir, Limits{})
+	require.NoError(t, err)
+
+	assert.Empty(t, instructions.ForFiles(filepath.Join("..", "x", "y.go")))
+	assert.Empty(t, instructions.ForFiles(filepath.Join(parent, "x", "y.go")))
+	assert.Empty(t, instructions.ForFiles(filepath.Join("a", "..", "..", "x", "y.go")))
+}
+
+func TestInstructions_ForFiles_TotalSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		DirectoryFile:                       "Root instructions.",
+		filepath.Join("pkg", DirectoryFile): "Package instructions.",
+	})
+	instructions, err := Load(dir, Limits{MaxTotalSize: 30})
+	require.NoError(t, err)
+
+	// The more specific instructions of the deeper directory are kept
+	files := instructions.ForFiles(filepath.Join("pkg", "file.go"))
+	require.Len(t, files, 1)
+	assert.Equal(t, "pkg", files[0].Dir)
+	assert.Equal(t, "Instructions for the files in pkg (from pkg/AGENTS.md), which your changes to these files must follow:\nPackage instructions.\n\n", Format(files))
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
 	"github.com/EduardDranca/GoAgent/internal/agent/context"
 	"github.com/EduardDranca/GoAgent/internal/agent/models"
@@ -11,19 +12,32 @@ import (
 	"strings"
 )
 
+// maxConflictRounds is the number of times conflicts with files modified on disk can be handed back to the agent.
+const maxConflictRounds = 3
+
+// resolveConflictsRequest is the change request given to the agent when the user hands a conflict back to it.
+const resolveConflictsRequest = `The following files were modified on disk while you were working: %s.
+Their content now contains a three-way merge of your changes and the changes on disk. Regions that could not be
+merged are delimited by "<<<<<<< agent", "||||||| base", "=======" and ">>>>>>> disk" markers.
+Resolve every conflict by keeping the changes on disk and re-applying your changes on top of them, and remove all markers.
+
+The original change request was:
+%s`
+
 // LocalProgrammingAgent implements the Agent interface for local file changes.
 type LocalProgrammingAgent struct {
 	programmingService service.ProgrammingService
 	gitUtil            utils.GitUtil // Inject GitUtil interface
 	autoCommit         bool
+	contextOptions     []context.Option // Options applied to every programming context created by the agent
 }
 
 // NewLocalProgrammingAgent creates a new LocalProgrammingAgent.
-func NewLocalProgrammingAgent(programmingService service.ProgrammingService, gitUtil utils.GitUtil) AgentInterface[models.AgentRequest] {
+func NewLocalProgrammingAgent(programmingService service.ProgrammingService, gitUtil utils.GitUtil, contextOptions ...context.Option) AgentInterface[models.AgentRequest] {
 	if gitUtil == nil {
 		gitUtil = &utils.RealGitUtil{} // Default to RealGitUtil if nil is provided
 	}
-	return &LocalProgrammingAgent{programmingService: programmingService, gitUtil: gitUtil, autoCommit: false}
+	return &LocalProgrammingAgent{programmingService: programmingService, gitUtil: gitUtil, autoCommit: false, contextOptions: contextOptions}
 }
 
 // SetAutoCommit sets the autoCommit field of the LocalProgrammingAgent.
@@ -76,13 +90,17 @@ func (a *LocalProgrammingAgent) resetAndWrapError(dir string, baseError error, m
 // Implement implements the Agent interface for LocalProgrammingAgent.
 func (a *LocalProgrammingAgent) Implement(request models.AgentRequest) error {
 	// Skip empty change requests
-	if strings.TrimSpace(request.Query) == "" {
+	if !request.Resume && strings.TrimSpace(request.Query) == "" {
 		logging.Logger.Infof("Skipping empty change request.")
 		return nil
 	}
 
-	// Create a programming context
-	programmingAgentContext, err := a.createContext(request.Directory, request.Query, a.gitUtil)
+	// Create a programming context; a resumed change request is restored into it from its checkpoint
+	changeRequest := request.Query
+	if request.Resume {
+		changeRequest = ""
+	}
+	programmingAgentContext, err := a.createContext(request.Directory, changeRequest, a.gitUtil)
 	if err != nil {
 		// Use errors.New for consistent error wrapping if desired, or fmt.Errorf
 		return fmt.Errorf("error initializing programming context: %w", err)
@@ -92,7 +110,15 @@ func (a *LocalProgrammingAgent) Implement(request models.AgentRequest) error {
 	logging.Logger.Infof("Working on request...")
 
 	// Implement the plan with context
-	commitMessage, err := a.programmingService.ImplementWithContext(programmingAgentContext)
+	var commitMessage string
+	if request.Resume {
+		commitMessage, err = a.programmingService.ResumeWithContext(request.Query, programmingAgentContext)
+		if errors.Is(err, service.ErrResumeFailed) {
+			return err
+		}
+	} else {
+		commitMessage, err = a.programmingService.ImplementWithContext(programmingAgentContext)
+	}
 	if err != nil {
 		resetErr := a.resetAndWrapError(request.Directory, err, "error implementing plan with context", false)
 		if resetErr != nil {
@@ -100,6 +126,15 @@ func (a *LocalProgrammingAgent) Implement(request models.AgentRequest) error {
 		}
 	}
 
+	// Resolve files that were modified on disk while the agent was working
+	commitMessage, err = a.resolveConflicts(programmingAgentContext, programmingAgentContext.GetChangeRequest(), commitMessage)
+	if err != nil {
+		resetErr := a.resetAndWrapError(request.Directory, err, "error resolving conflicts with files modified on disk", false)
+		if resetErr != nil {
+			return resetErr
+		}
+	}
+
 	// Flush changes to the file system
 	err = programmingAgentContext.FlushChanges()
 	if err != nil {
@@ -123,9 +158,98 @@ func (a *LocalProgrammingAgent) Implement(request models.AgentRequest) error {
 	return nil
 }
 
+// resolveConflicts asks the user how to handle every pending write whose file changed on disk since the agent read it.
+// Conflicts handed back to the agent are merged with conflict markers and given to the programming service to resolve,
+// for at most maxConflictRounds rounds. It returns commitMessage, the message of the change request, which describes
+// the change better than the messages of the rounds resolving conflicts; those are only used if it is empty.
+func (a *LocalProgrammingAgent) resolveConflicts(programmingAgentContext *context.LocalProgrammingAgentContext, query string, commitMessage string) (string, error) {
+	for round := 0; ; round++ {
+		conflicts, err := programmingAgentContext.DetectConflicts()
+		if err != nil {
+			return commitMessage, err
+		}
+		if len(conflicts) == 0 {
+			return commitMessage, nil
+		}
+
+		var handedBack []string
+		for _, conflict := range conflicts {
+			canHandBack := round < maxConflictRounds && conflict.Mergeable
+			resolution, handBack := a.promptForConflict(conflict, canHandBack)
+			if err := programmingAgentContext.ResolveConflict(conflict.FilePath, resolution); err != nil {
+				return commitMessage, err
+			}
+			if handBack {
+				handedBack = append(handedBack, conflict.FilePath)
+			}
+		}
+		if len(handedBack) == 0 {
+			return commitMessage, nil
+		}
+
+		logging.Logger.Infof("Handing conflicts in %s back to the agent...", strings.Join(handedBack, ", "))
+		programmingAgentContext.SetChangeRequest(fmt.Sprintf(resolveConflictsRequest, strings.Join(handedBack, ", "), query))
+		resolvedMessage, err := a.programmingService.ImplementWithContext(programmingAgentContext)
+		if err != nil {
+			return commitMessage, fmt.Errorf("error resolving conflicts: %w", err)
+		}
+		if commitMessage == "" {
+			commitMessage = resolvedMessage
+		}
+	}
+}
+
+// promptForConflict asks the user how to resolve a conflict. It returns the resolution to apply
+// and whether the merged result should be handed back to the agent.
+func (a *LocalProgrammingAgent) promptForConflict(conflict context.FileConflict, canHandBack bool) (context.ConflictResolution, bool) {
+	var summary string
+	var options []string
+	switch {
+	case !conflict.Mergeable:
+		summary = "it can no longer be merged as text"
+	case conflict.Conflicts == 0:
+		summary = "the changes merge cleanly"
+		options = append(options, "[M]erge")
+	default:
+		summary = fmt.Sprintf("merging leaves %d conflicting region(s)", conflict.Conflicts)
+	}
+	options = append(options, "[O]verwrite", "[S]kip")
+	if canHandBack {
+		options = append(options, "[A]gent")
+	}
+
+	choice, err := input.UserInputGetter(fmt.Sprintf("The file %s was modified on disk while the agent was working and %s. %s ",
+		conflict.FilePath, summary, strings.Join(options, "/")))
+	if err != nil {
+		logging.Logger.Errorf("Error reading conflict resolution choice, keeping the file on disk: %v", err)
+		return context.ConflictSkip, false
+	}
+
+	switch strings.ToUpper(strings.TrimSpace(choice)) {
+	case "M":
+		if conflict.Mergeable && conflict.Conflicts == 0 {
+			logging.Logger.Infof("User chose to merge %s.", conflict.FilePath)
+			return context.ConflictMerge, false
+		}
+	case "O":
+		logging.Logger.Infof("User chose to overwrite %s with the agent's changes.", conflict.FilePath)
+		return context.ConflictOverwrite, false
+	case "S":
+		logging.Logger.Infof("User chose to keep %s as it is on disk.", conflict.FilePath)
+		return context.ConflictSkip, false
+	case "A":
+		if canHandBack {
+			logging.Logger.Infof("User chose to hand the conflict in %s back to the agent.", conflict.FilePath)
+			return context.ConflictMerge, true
+		}
+	}
+	logging.Logger.Warnf("Invalid choice '%s', keeping %s as it is on disk.", choice, conflict.FilePath)
+	return context.ConflictSkip, false
+}
+
 // createContext initializes the programming context.
 func (a *LocalProgrammingAgent) createContext(dir string, request string, gitUtil utils.GitUtil) (*context.LocalProgrammingAgentContext, error) {
-	programmingAgentContext, err := context.NewLocalProgrammingAgentContext(dir, request, gitUtil)
+	programmingAgentContext, err := context.NewLocalProgrammingAgentContext(dir, request, gitUtil, a.contextOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing programming context: %w", err)
 	}
@@ -137,7 +261,7 @@ func (a *LocalProgrammingAgent) Ask(req models.AgentRequest) (string, error) {
 	logging.Logger.Infof("Starting Ask function with request: %s", req.Query)
 
 	// Create local agent context
-	agentContext, err := context.NewLocalProgrammingAgentContext(req.Directory, req.Query, a.gitUtil)
+	agentContext, err := context.NewLocalProgrammingAgentContext(req.Directory, req.Query, a.gitUtil, a.contextOptions...)
 	if err != nil {
 		logging.Logger.Errorf("Error creating agent context: %v", err)
 		return "", fmt.Errorf("error creating agent context: %w", err)
@@ -156,6 +280,14 @@ func (a *LocalProgrammingAgent) Ask(req models.AgentRequest) (string, error) {
 	return answer, nil
 }
 
+// ResetAskThread implements the ResetAskThread method for LocalProgrammingAgent.
+func (a *LocalProgrammingAgent) ResetAskThread() error {
+	if err := a.programmingService.ResetAskThread(); err != nil {
+		return fmt.Errorf("error resetting ask thread: %w", err)
+	}
+	return nil
+}
+
 // handleCommit encapsulates all commit related logic including prompting, reading input, and committing changes.
 func (a *LocalProgrammingAgent) handleCommit(directory string, commitMessage string) error {
 	if a.autoCommit {
@@ -1,12 +1,15 @@
 package agent
 
 import (
+	"github.com/EduardDranca/GoAgent/internal/agent/context"
 	"github.com/EduardDranca/GoAgent/internal/agent/models"
 	"github.com/EduardDranca/GoAgent/internal/agent/service"
 	"github.com/EduardDranca/GoAgent/internal/input"
 	"github.com/EduardDranca/GoAgent/internal/utils"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -165,3 +168,57 @@ func TestLocalProgrammingAgent_Implement_CommitChoice_A(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, agent.(*LocalProgrammingAgent).autoCommit, "autoCommit should be set to true") // Assert autoCommit is true
 }
+
+func TestLocalProgrammingAgent_Implement_ConflictHandedBackToAgent(t *testing.T) {
+	// Create a temporary directory with a file the agent will modify.
+	tempDir, err := os.MkdirTemp("", "test-local-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	filePath := filepath.Join(tempDir, "file.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte("one\ntwo\n"), 0644))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := service.NewMockService(ctrl)
+	mockGitUtil := utils.NewMockGitUtil(ctrl)
+	agent := NewLocalProgrammingAgentWithAutoCommit(mockService, mockGitUtil, true)
+
+	// Hand the conflict back to the agent
+	input.UserInputGetter = testInputGetter([]string{"A"})
+
+	request := models.AgentRequest{
+		Directory: tempDir,
+		Query:     "test change request",
+	}
+
+	mockGitUtil.EXPECT().LsTree(tempDir).Return([]string{"file.txt"}, nil).Times(1)
+	gomock.InOrder(
+		// The agent modifies the file while the user edits the same line on disk.
+		mockService.EXPECT().ImplementWithContext(gomock.Any()).DoAndReturn(func(agentContext context.ProgrammingAgentContext) (string, error) {
+			_, exists := agentContext.GetFileContent("file.txt")
+			require.True(t, exists)
+			require.NoError(t, agentContext.UpdateFileContent("file.txt", "one\nagent\n"))
+			require.NoError(t, os.WriteFile(filePath, []byte("one\ndisk two\n"), 0644))
+			return "commit message", nil
+		}).Times(1),
+		// The agent receives the merged file with conflict markers and resolves it.
+		mockService.EXPECT().ImplementWithContext(gomock.Any()).DoAndReturn(func(agentContext context.ProgrammingAgentContext) (string, error) {
+			require.Contains(t, agentContext.GetChangeRequest(), "file.txt")
+			require.Contains(t, agentContext.GetChangeRequest(), "test change request")
+			content, _ := agentContext.GetFileContent("file.txt")
+			require.True(t, strings.Contains(content, "<<<<<<< agent") && strings.Contains(content, ">>>>>>> disk"))
+			require.NoError(t, agentContext.UpdateFileContent("file.txt", "one\ndisk agent\n"))
+			return "resolved commit message", nil
+		}).Times(1),
+	)
+	mockGitUtil.EXPECT().Add(tempDir).Return(nil).Times(1)
+	mockGitUtil.EXPECT().Commit(tempDir, "commit message").Return(nil).Times(1)
+
+	err = agent.Implement(request)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "one\ndisk agent\n", string(content))
+}
@@ -3,4 +3,7 @@ package models
 type AgentRequest struct {
 	Query     string `json:"query"`
 	Directory string `json:"directory"`
+	// Resume continues an interrupted change request instead of starting a new one. Query then holds
+	// the id of the run to resume, or is empty to resume the most recent run.
+	Resume bool `json:"resume"`
 }
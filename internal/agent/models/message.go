@@ -1,6 +1,6 @@
 package models
 
 type Message struct {
-	Content string
-	Role    string
+	Content string `json:"content"`
+	Role    string `json:"role"`
 }
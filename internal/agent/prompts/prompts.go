@@ -0,0 +1,238 @@
+package prompts
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateExt is the extension of template files, whose name without it is the name of the prompt.
+const templateExt = ".tmpl"
+
+// Prompt names. A prompt is overridden by a file named <name>.tmpl in the override directory.
+const (
+	SystemCodeAnalysis        = "system_code_analysis"
+	SystemAskAnalysis         = "system_ask_analysis"
+	SystemCodeInstruction     = "system_code_instruction"
+	SystemAskInstruction      = "system_ask_instruction"
+	SystemGenerateCode        = "system_generate_code"
+	SystemPatchApply          = "system_patch_apply"
+	SystemHistorySummary      = "system_history_summary"
+	SystemProjectInstructions = "system_project_instructions"
+	Implement                 = "implement"
+	Ask                       = "ask"
+	AskFollowUp               = "ask_follow_up"
+	ContextFiles              = "context_files"
+	ContextFilesBatch         = "context_files_batch"
+	UpdateFileCommand         = "update_file_command"
+	UpdateFilesCommand        = "update_files_command"
+	GenerateExistingFile      = "generate_existing_file"
+	GenerateNewFile           = "generate_new_file"
+	ApplyPatch                = "apply_patch"
+	StallCorrection           = "stall_correction"
+)
+
+// variables lists the variables every prompt is rendered with.
+var variables = map[string][]string{
+	SystemCodeAnalysis:        nil,
+	SystemAskAnalysis:         nil,
+	SystemCodeInstruction:     nil,
+	SystemAskInstruction:      nil,
+	SystemGenerateCode:        nil,
+	SystemPatchApply:          nil,
+	SystemHistorySummary:      nil,
+	SystemProjectInstructions: {"Path", "Content"},
+	Implement:                 {"Structure", "ChangeRequest", "MentionedFiles"},
+	Ask:                       {"Structure", "Question", "MentionedFiles"},
+	AskFollowUp:               {"FilesRead", "Question", "MentionedFiles"},
+	ContextFiles:              {"FilePath"},
+	ContextFilesBatch:         {"FilePaths"},
+	UpdateFileCommand:         {"FilePath", "ImplementationPlan", "ContextFilesAnswer"},
+	UpdateFilesCommand:        {"Updates", "ContextFilesAnswer"},
+	GenerateExistingFile:      {"FileContent", "ImplementationPlan", "ChangeRequest", "ContextFiles"},
+	GenerateNewFile:           {"FilePath", "ImplementationPlan", "ChangeRequest", "ContextFiles"},
+	ApplyPatch:                {"FileContent", "Patch"},
+	StallCorrection:           {"Reason"},
+}
+
+//go:embed defaults/*.tmpl
+var defaults embed.FS
+
+// Vars holds the values of the variables a prompt is rendered with.
+type Vars map[string]any
+
+// Set holds the template of every prompt.
+type Set struct {
+	templates  map[string]*template.Template
+	overridden []string // Names of the prompts overridden by the project
+}
+
+// Default returns the built-in prompts.
+func Default() *Set {
+	set := &Set{templates: make(map[string]*template.Template)}
+	for name := range variables {
+		data, err := defaults.ReadFile("defaults/" + name + templateExt)
+		if err != nil {
+			panic(fmt.Sprintf("missing default prompt %s: %v", name, err))
+		}
+		tmpl, err := parseTemplate(name, string(data))
+		if err != nil {
+			panic(err)
+		}
+		set.templates[name] = tmpl
+	}
+	return set
+}
+
+// Load returns the built-in prompts, overridden by the <name>.tmpl files in dir. A missing dir uses the built-in
+// prompts only. It fails if an override does not match a prompt, does not parse or references unknown variables.
+func Load(dir string) (*Set, error) {
+	set := Default()
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return set, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt overrides: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != templateExt {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), templateExt)
+		if _, ok := variables[name]; !ok {
+			return nil, fmt.Errorf("unknown prompt override %s, expected one of %s", entry.Name(), strings.Join(Names(), ", "))
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt override %s: %w", entry.Name(), err)
+		}
+		tmpl, err := parseTemplate(name, string(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid prompt override %s: %w", entry.Name(), err)
+		}
+		set.templates[name] = tmpl
+		set.overridden = append(set.overridden, name)
+	}
+	return set, nil
+}
+
+// Names returns the names of all prompts, sorted.
+func Names() []string {
+	return slices.Sorted(maps.Keys(variables))
+}
+
+// Overridden returns the names of the prompts overridden by the project.
+func (s *Set) Overridden() []string {
+	return s.overridden
+}
+
+// Render renders the prompt name with vars.
+func (s *Set) Render(name string, vars Vars) (string, error) {
+	tmpl, ok := s.templates[name]
+	if !ok {
+		return "", fmt.Errorf("unknown prompt %s", name)
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, vars); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s: %w", name, err)
+	}
+	return sb.String(), nil
+}
+
+// parseTemplate parses the template of prompt name and checks that it only references its variables.
+// A single trailing newline is dropped, so that template files can end with one.
+func parseTemplate(name string, text string) (*template.Template, error) {
+	text = strings.TrimSuffix(text, "\n")
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := checkVariables(t.Tree.Root, variables[name], true); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// checkVariables returns an error if node references a variable that is not in known. Fields are only checked
+// where dot holds the variables, i.e. outside of range and with blocks; $.Name is checked everywhere.
+func checkVariables(node parse.Node, known []string, dotIsVars bool) error {
+	check := func(name string) error {
+		if !slices.Contains(known, name) {
+			if len(known) == 0 {
+				return fmt.Errorf("unknown variable .%s, the prompt has no variables", name)
+			}
+			return fmt.Errorf("unknown variable .%s, expected one of .%s", name, strings.Join(known, ", ."))
+		}
+		return nil
+	}
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkVariables(child, known, dotIsVars); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkVariables(n.Pipe, known, dotIsVars)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				if err := checkVariables(arg, known, dotIsVars); err != nil {
+					return err
+				}
+			}
+		}
+	case *parse.FieldNode:
+		if dotIsVars {
+			return check(n.Ident[0])
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			return check(n.Ident[1])
+		}
+	case *parse.ChainNode:
+		return checkVariables(n.Node, known, dotIsVars)
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode, known, dotIsVars, dotIsVars)
+	case *parse.RangeNode:
+		return checkBranch(&n.BranchNode, known, dotIsVars, false)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode, known, dotIsVars, false)
+	case *parse.TemplateNode:
+		return checkVariables(n.Pipe, known, dotIsVars)
+	}
+	return nil
+}
+
+// checkBranch checks the pipeline of an if, range or with block, its body, where dot may change, and its else branch.
+func checkBranch(branch *parse.BranchNode, known []string, dotIsVars bool, bodyDotIsVars bool) error {
+	if err := checkVariables(branch.Pipe, known, dotIsVars); err != nil {
+		return err
+	}
+	if err := checkVariables(branch.List, known, bodyDotIsVars); err != nil {
+		return err
+	}
+	return checkVariables(branch.ElseList, known, dotIsVars)
+}
@@ -0,0 +1,80 @@
+package prompts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefault_RendersEveryPrompt(t *testing.T) {
+	set := Default()
+	for _, name := range Names() {
+		vars := Vars{}
+		for _, variable := range variables[name] {
+			vars[variable] = "<" + variable + ">"
+		}
+		prompt, err := set.Render(name, vars)
+		require.NoError(t, err, name)
+		assert.NotEmpty(t, prompt, name)
+		for _, variable := range variables[name] {
+			assert.Contains(t, prompt, "<"+variable+">", "%s does not use %s", name, variable)
+		}
+	}
+
+	prompt, err := set.Render(Implement, Vars{"Structure": []string{"main.go", "go.mod"}, "ChangeRequest": "Add a flag", "MentionedFiles": ""})
+	require.NoError(t, err)
+	assert.Equal(t, "The current project structure is as follows:\n[main.go go.mod]\n You are tasked with implementing the following: \nAdd a flag", prompt)
+
+	_, err = set.Render(Implement, Vars{"Structure": []string{}})
+	assert.ErrorContains(t, err, "failed to render prompt implement")
+}
+
+func TestLoad_Overrides(t *testing.T) {
+	dir := t.TempDir()
+	override := "{{range .Structure}}- {{.}}\n{{end}}Implement: {{$.ChangeRequest}}\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, Implement+templateExt), []byte(override), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644))
+
+	set, err := Load(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{Implement}, set.Overridden())
+	prompt, err := set.Render(Implement, Vars{"Structure": []string{"main.go", "go.mod"}, "ChangeRequest": "Add a flag"})
+	require.NoError(t, err)
+	assert.Equal(t, "- main.go\n- go.mod\nImplement: Add a flag", prompt)
+
+	// Prompts that are not overridden keep their default
+	prompt, err = set.Render(StallCorrection, Vars{"Reason": "read a.go was issued 3 times"})
+	require.NoError(t, err)
+	assert.Contains(t, prompt, "you are not making progress: read a.go was issued 3 times")
+
+	set, err = Load(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, set.Overridden())
+}
+
+func TestLoad_InvalidOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		template string
+		wantErr  string
+	}{
+		{"unknown prompt", "review.tmpl", "Review", "unknown prompt override review.tmpl"},
+		{"unknown variable", "ask.tmpl", "{{.Query}}", "invalid prompt override ask.tmpl: unknown variable .Query, expected one of .Structure, .Question"},
+		{"unknown variable in condition", "ask.tmpl", "{{if .Files}}{{.Question}}{{end}}", "unknown variable .Files"},
+		{"unknown root variable in range", "implement.tmpl", "{{range .Structure}}{{$.Plan}}{{end}}", "unknown variable .Plan"},
+		{"variable of a system prompt", "system_patch_apply.tmpl", "Apply {{.Patch}}", "unknown variable .Patch, the prompt has no variables"},
+		{"syntax error", "ask.tmpl", "{{.Question", "invalid prompt override ask.tmpl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.template), 0644))
+			_, err := Load(dir)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
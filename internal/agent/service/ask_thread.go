@@ -0,0 +1,81 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+)
+
+// AskTurn is a question asked with /ask and the answer it received.
+type AskTurn struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// AskThread is the conversation that follow-up /ask questions continue: the questions and answers so far,
+// the files read to answer them and the history of the ask analysis session.
+type AskThread struct {
+	Turns     []AskTurn        `json:"turns"`
+	FilesRead []string         `json:"files_read"`
+	History   []models.Message `json:"history"`
+}
+
+// LoadAskThread reads the thread saved at path. A missing file is an empty thread.
+func LoadAskThread(path string) (*AskThread, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &AskThread{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ask thread: %w", err)
+	}
+	var thread AskThread
+	if err := json.Unmarshal(data, &thread); err != nil {
+		return nil, fmt.Errorf("failed to parse ask thread %s: %w", path, err)
+	}
+	return &thread, nil
+}
+
+// Save writes the thread to path, creating its directory if needed.
+func (t *AskThread) Save(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal ask thread: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create ask thread directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write ask thread: %w", err)
+	}
+	return nil
+}
+
+// addFilesRead records files read while answering a question, keeping the order in which they were first read.
+func (t *AskThread) addFilesRead(files []string) {
+	for _, file := range files {
+		if !slices.Contains(t.FilesRead, file) {
+			t.FilesRead = append(t.FilesRead, file)
+		}
+	}
+}
+
+// readTrackingContext decorates a ProgrammingAgentContext and records the files whose content was read.
+type readTrackingContext struct {
+	context.ProgrammingAgentContext
+	filesRead []string
+}
+
+func (c *readTrackingContext) GetFileContent(filePath string) (string, bool) {
+	content, exists := c.ProgrammingAgentContext.GetFileContent(filePath)
+	if exists && !slices.Contains(c.filesRead, filePath) {
+		c.filesRead = append(c.filesRead, filePath)
+	}
+	return content, exists
+}
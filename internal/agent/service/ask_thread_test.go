@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/commands"
+	context2 "github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+)
+
+// historyAnalysisAssistant is an AnalysisAssistant keeping a history like an LLM session, answering every message with "noted".
+type historyAnalysisAssistant struct {
+	history []models.Message
+}
+
+func (a *historyAnalysisAssistant) Execute(_ context.Context, message string) (string, error) {
+	a.history = append(a.history, models.Message{Role: "user", Content: message}, models.Message{Role: "assistant", Content: "noted"})
+	return "noted", nil
+}
+
+func (a *historyAnalysisAssistant) GetHistory() []models.Message {
+	return a.history
+}
+
+func (a *historyAnalysisAssistant) SetHistory(history []models.Message) {
+	a.history = history
+}
+
+// askContext returns a context asking question, in a project holding main.go.
+func askContext(ctrl *gomock.Controller, question string) *context2.MockProgrammingAgentContext {
+	agentContext := context2.NewMockProgrammingAgentContext(ctrl)
+	agentContext.EXPECT().GetRepoStructure().Return([]string{"main.go"}).AnyTimes()
+	agentContext.EXPECT().GetChangeRequest().Return(question).AnyTimes()
+	agentContext.EXPECT().GetFileContent("main.go").Return("package main", true).AnyTimes()
+	return agentContext
+}
+
+func TestLLMProgrammingService_AskWithContext_ContinuesThread(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	path := filepath.Join(t.TempDir(), ".go-agent", "ask_thread.json")
+
+	askAnalysisAssistant := &historyAnalysisAssistant{}
+	askInstructionAssistant := NewMockInstructionAssistant(ctrl)
+	askInstructionAssistant.EXPECT().ClearHistory().AnyTimes()
+	gomock.InOrder(
+		askInstructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.ReadCommand{Files: []string{"main.go"}}, nil),
+		askInstructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.RespondCommand{Message: "It starts the agent."}, nil),
+		askInstructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.RespondCommand{Message: "There are none."}, nil),
+		askInstructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.RespondCommand{Message: "A Go project."}, nil),
+	)
+	service := NewLLMProgrammingService(nil, askAnalysisAssistant, nil, askInstructionAssistant, nil, nil, 10, WithAskThreadFile(path))
+
+	answer, err := service.AskWithContext(askContext(ctrl, "What does main.go do?"))
+	require.NoError(t, err)
+	assert.Equal(t, "It starts the agent.", answer)
+	assert.Contains(t, askAnalysisAssistant.history[0].Content, "The current project structure is as follows")
+
+	// The follow-up continues the analysis history and lists the files read so far
+	answer, err = service.AskWithContext(askContext(ctrl, "And its tests?"))
+	require.NoError(t, err)
+	assert.Equal(t, "There are none.", answer)
+	require.Len(t, askAnalysisAssistant.history, 6)
+	assert.Contains(t, askAnalysisAssistant.history[4].Content, "The files read so far are: main.go")
+	assert.Contains(t, askAnalysisAssistant.history[4].Content, "And its tests?")
+
+	thread, err := LoadAskThread(path)
+	require.NoError(t, err)
+	assert.Equal(t, []AskTurn{
+		{Question: "What does main.go do?", Answer: "It starts the agent."},
+		{Question: "And its tests?", Answer: "There are none."},
+	}, thread.Turns)
+	assert.Equal(t, []string{"main.go"}, thread.FilesRead)
+	assert.Equal(t, askAnalysisAssistant.history, thread.History)
+
+	// A service started later resumes the persisted thread
+	restoredAssistant := &historyAnalysisAssistant{}
+	NewLLMProgrammingService(nil, restoredAssistant, nil, askInstructionAssistant, nil, nil, 10, WithAskThreadFile(path))
+	assert.Equal(t, thread.History, restoredAssistant.history)
+
+	// After a reset, the next question starts a new thread
+	require.NoError(t, service.ResetAskThread())
+	assert.NoFileExists(t, path)
+	answer, err = service.AskWithContext(askContext(ctrl, "What is this project?"))
+	require.NoError(t, err)
+	assert.Equal(t, "A Go project.", answer)
+	require.Len(t, askAnalysisAssistant.history, 2)
+	assert.Contains(t, askAnalysisAssistant.history[0].Content, "The current project structure is as follows")
+}
+
+func TestLoadAskThread(t *testing.T) {
+	dir := t.TempDir()
+
+	thread, err := LoadAskThread(filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	assert.Empty(t, thread.Turns)
+
+	invalidPath := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalidPath, []byte("{"), 0644))
+	_, err = LoadAskThread(invalidPath)
+	assert.ErrorContains(t, err, "failed to parse ask thread")
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+)
+
+// checkpointFile is the name of the file holding the checkpoint in the directory of a run.
+const checkpointFile = "checkpoint.json"
+
+var (
+	// ErrNoRuns is returned when there is no interrupted run to resume.
+	ErrNoRuns = errors.New("no interrupted run to resume")
+	// ErrResumeFailed is returned when a run cannot be resumed, before any of its changes were restored.
+	ErrResumeFailed = errors.New("cannot resume run")
+)
+
+// RunCheckpoint is the state of a change request after its last processed command, from which it can be resumed.
+type RunCheckpoint struct {
+	ID                 string           `json:"id"`
+	SavedAt            time.Time        `json:"saved_at"`
+	Context            context.Snapshot `json:"context"`
+	AnalysisHistory    []models.Message `json:"analysis_history"`
+	InstructionHistory []models.Message `json:"instruction_history"`
+	PendingMessage     string           `json:"pending_message"` // Result of the last command, to be sent to the analysis session next
+	Loops              int              `json:"loops"`           // Process loops run since the loop limit was last confirmed
+}
+
+// CheckpointStore saves the checkpoints of runs to <dir>/<id>/checkpoint.json.
+type CheckpointStore struct {
+	dir string
+}
+
+// NewCheckpointStore creates a CheckpointStore saving runs to dir.
+func NewCheckpointStore(dir string) *CheckpointStore {
+	return &CheckpointStore{dir: dir}
+}
+
+// Dir returns the directory of the run id.
+func (s *CheckpointStore) Dir(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+// Save writes checkpoint, replacing the previous checkpoint of its run.
+func (s *CheckpointStore) Save(checkpoint *RunCheckpoint) error {
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+	dir := s.Dir(checkpoint.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create run directory: %w", err)
+	}
+	// Write to a temporary file first, so that a crash never leaves a truncated checkpoint behind
+	tmpPath := filepath.Join(dir, checkpointFile+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, checkpointFile)); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Load reads the checkpoint of run id. An empty id loads the most recent run.
+func (s *CheckpointStore) Load(id string) (*RunCheckpoint, error) {
+	if id == "" {
+		latest, err := s.Latest()
+		if err != nil {
+			return nil, err
+		}
+		id = latest
+	}
+	if !isRunID(id) {
+		return nil, fmt.Errorf("invalid run id %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir(id), checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("run %s not found in %s", id, s.dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint of run %s: %w", id, err)
+	}
+	var checkpoint RunCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint of run %s: %w", id, err)
+	}
+	return &checkpoint, nil
+}
+
+// Latest returns the id of the most recent run that has a checkpoint.
+func (s *CheckpointStore) Latest() (string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNoRuns
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to list runs: %w", err)
+	}
+	// Run ids start with their creation time, so they sort chronologically
+	for _, entry := range slices.Backward(entries) {
+		if _, err := os.Stat(filepath.Join(s.dir, entry.Name(), checkpointFile)); entry.IsDir() && err == nil {
+			return entry.Name(), nil
+		}
+	}
+	return "", ErrNoRuns
+}
+
+// isRunID reports whether id names a run directory of the store, and no other path, e.g. ../config.
+func isRunID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+// Remove deletes the directory of run id, once it completed.
+func (s *CheckpointStore) Remove(id string) error {
+	if !isRunID(id) {
+		return fmt.Errorf("invalid run id %q", id)
+	}
+	if err := os.RemoveAll(s.Dir(id)); err != nil {
+		return fmt.Errorf("failed to remove run %s: %w", id, err)
+	}
+	return nil
+}
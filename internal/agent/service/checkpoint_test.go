@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/commands"
+	context2 "github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/input"
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+func TestLLMProgrammingService_ResumeWithContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "obsolete.txt"), []byte("obsolete\n"), 0644))
+	store := NewCheckpointStore(filepath.Join(t.TempDir(), "runs"))
+
+	// The run is interrupted by an error after deleting a file
+	analysisAssistant := &historyAnalysisAssistant{}
+	instructionAssistant := NewMockInstructionAssistant(ctrl)
+	instructionAssistant.EXPECT().ClearHistory().AnyTimes()
+	instructionAssistant.EXPECT().GetHistory().Return([]models.Message{{Role: "user", Content: "instruction"}}).AnyTimes()
+	gomock.InOrder(
+		instructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.DeleteFileCommand{FilePath: "obsolete.txt"}, nil),
+		instructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(nil, errors.New("connection reset")),
+	)
+	service := NewLLMProgrammingService(analysisAssistant, nil, instructionAssistant, nil, nil, nil, 10, WithCheckpoints(store))
+
+	agentContext, err := context2.NewLocalProgrammingAgentContext(dir, "Remove obsolete.txt", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	_, err = service.ImplementWithContext(agentContext)
+	require.ErrorContains(t, err, "connection reset")
+
+	runID, err := store.Latest()
+	require.NoError(t, err)
+	checkpoint, err := store.Load(runID)
+	require.NoError(t, err)
+	assert.Equal(t, "Remove obsolete.txt", checkpoint.Context.ChangeRequest)
+	assert.Equal(t, []string{"obsolete.txt"}, checkpoint.Context.DeletedFiles)
+	assert.Equal(t, analysisAssistant.history[:2], checkpoint.AnalysisHistory)
+	assert.Equal(t, 1, checkpoint.Loops)
+
+	// A new service resumes the run from its checkpoint
+	resumedAnalysisAssistant := &historyAnalysisAssistant{}
+	resumedInstructionAssistant := NewMockInstructionAssistant(ctrl)
+	resumedInstructionAssistant.EXPECT().ClearHistory().AnyTimes()
+	resumedInstructionAssistant.EXPECT().SetHistory(checkpoint.InstructionHistory)
+	resumedInstructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.CommitCommand{Message: "Remove obsolete.txt"}, nil)
+	resumedService := NewLLMProgrammingService(resumedAnalysisAssistant, nil, resumedInstructionAssistant, nil, nil, nil, 10, WithCheckpoints(store))
+
+	resumedContext, err := context2.NewLocalProgrammingAgentContext(dir, "", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	response, err := resumedService.ResumeWithContext("", resumedContext)
+	require.NoError(t, err)
+	assert.Equal(t, "Remove obsolete.txt", response)
+	assert.Equal(t, "Remove obsolete.txt", resumedContext.GetChangeRequest())
+	require.Len(t, resumedAnalysisAssistant.history, 4)
+	assert.Equal(t, checkpoint.AnalysisHistory, resumedAnalysisAssistant.history[:2])
+	assert.Equal(t, checkpoint.PendingMessage, resumedAnalysisAssistant.history[2].Content)
+
+	// The completed run is removed, and its pending deletion is flushed with the context
+	_, err = store.Latest()
+	assert.ErrorIs(t, err, ErrNoRuns)
+	require.NoError(t, resumedContext.FlushChanges())
+	assert.NoFileExists(t, filepath.Join(dir, "obsolete.txt"))
+}
+
+func TestLLMProgrammingService_ResumeWithContext_NoRuns(t *testing.T) {
+	service := NewLLMProgrammingService(nil, nil, nil, nil, nil, nil, 10, WithCheckpoints(NewCheckpointStore(t.TempDir())))
+	agentContext, err := context2.NewLocalProgrammingAgentContext(t.TempDir(), "", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+
+	_, err = service.ResumeWithContext("", agentContext)
+	assert.ErrorIs(t, err, ErrResumeFailed)
+	assert.ErrorIs(t, err, ErrNoRuns)
+}
+
+func TestLLMProgrammingService_ResumeWithContext_AfterLoopLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	dir := t.TempDir()
+	store := NewCheckpointStore(filepath.Join(t.TempDir(), "runs"))
+	originalGetter := input.UserInputGetter
+	defer func() { input.UserInputGetter = originalGetter }()
+
+	// The run is stopped at the loop limit of 1 loop
+	input.UserInputGetter = func(string) (string, error) { return "n", nil }
+	instructionAssistant := NewMockInstructionAssistant(ctrl)
+	instructionAssistant.EXPECT().ClearHistory().AnyTimes()
+	instructionAssistant.EXPECT().GetHistory().Return(nil).AnyTimes()
+	instructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.CheckStructureCommand{}, nil).Times(2)
+	service := NewLLMProgrammingService(&historyAnalysisAssistant{}, nil, instructionAssistant, nil, nil, nil, 1, WithCheckpoints(store))
+	agentContext, err := context2.NewLocalProgrammingAgentContext(dir, "List the files", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	response, err := service.ImplementWithContext(agentContext)
+	require.NoError(t, err)
+	require.Equal(t, "Process stopped by user after loop limit.", response)
+
+	// Resuming it runs the next loop without asking again
+	input.UserInputGetter = func(prompt string) (string, error) {
+		t.Errorf("unexpected prompt %q", prompt)
+		return "n", nil
+	}
+	resumedInstructionAssistant := NewMockInstructionAssistant(ctrl)
+	resumedInstructionAssistant.EXPECT().ClearHistory().AnyTimes()
+	resumedInstructionAssistant.EXPECT().SetHistory(gomock.Any())
+	resumedInstructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.CommitCommand{Message: "Listed the files"}, nil)
+	resumedService := NewLLMProgrammingService(&historyAnalysisAssistant{}, nil, resumedInstructionAssistant, nil, nil, nil, 1, WithCheckpoints(store))
+	resumedContext, err := context2.NewLocalProgrammingAgentContext(dir, "", &utils.NoOpGitUtil{})
+	require.NoError(t, err)
+	response, err = resumedService.ResumeWithContext("", resumedContext)
+	require.NoError(t, err)
+	assert.Equal(t, "Listed the files", response)
+}
+
+func TestCheckpointStore_InvalidRunID(t *testing.T) {
+	store := NewCheckpointStore(filepath.Join(t.TempDir(), "runs"))
+	for _, id := range []string{"..", "../config", "a/../../b", "."} {
+		_, err := store.Load(id)
+		assert.ErrorContains(t, err, "invalid run id", id)
+		assert.ErrorContains(t, store.Remove(id), "invalid run id", id)
+	}
+}
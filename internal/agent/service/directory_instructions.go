@@ -0,0 +1,45 @@
+package service
+
+import (
+	"github.com/EduardDranca/GoAgent/internal/agent/commands"
+	"github.com/EduardDranca/GoAgent/internal/agent/instructions"
+)
+
+// directoryInstructions returns the directory instructions of the files read or edited by command that were not
+// sent yet in the current request, formatted to be appended to its result, and marks them as sent.
+func (s *LLMProgrammingService) directoryInstructions(command commands.Command, sent map[*instructions.File]bool) string {
+	if s.instructions == nil {
+		return ""
+	}
+	var files []*instructions.File
+	for _, file := range s.instructions.ForFiles(commandPaths(command)...) {
+		if !sent[file] {
+			sent[file] = true
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return ""
+	}
+	return "\n\n" + instructions.Format(files)
+}
+
+// commandPaths returns the paths of the files read or edited by command.
+func commandPaths(command commands.Command) []string {
+	switch c := command.(type) {
+	case *commands.ReadCommand:
+		return c.Files
+	case *commands.UpdateFileCommand:
+		return []string{c.FilePath}
+	case *commands.UpdateFilesCommand:
+		paths := make([]string, 0, len(c.Updates))
+		for _, update := range c.Updates {
+			paths = append(paths, update.FilePath)
+		}
+		return paths
+	case *commands.MoveFileCommand:
+		return []string{c.NewPath}
+	default:
+		return nil
+	}
+}
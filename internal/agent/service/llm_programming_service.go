@@ -2,47 +2,112 @@ package service
 
 import (
 	context2 "context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/EduardDranca/GoAgent/internal/agent/assistants"
 	"github.com/EduardDranca/GoAgent/internal/agent/commands"
 	"github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/agent/instructions"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/agent/prompts"
+	"github.com/EduardDranca/GoAgent/internal/config"
 	"github.com/EduardDranca/GoAgent/internal/input"
+	"github.com/EduardDranca/GoAgent/internal/llm"
 	"github.com/EduardDranca/GoAgent/internal/logging"
+	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const (
-	promptAnalysis = `Which context files, if any, (besides '%s' itself) should be provided to the instruction session to correctly generate the code for this update?
-Keep in mind that the llm that generates the code does not have access to the analysis history and will need the content of the files to generate the code even if the file to be generated doesn't reference them directly, the llm will still need to know their content for generation.`
-	promptInstruction = `Please construct the final update_file command JSON. Use the following details:
-File Path: %s
-Implementation Plan: %s
-Context file analysis session response: %s
-Respond only with the complete JSON object for the command.`
-	promptGenerateFileContentExisting = `Make the following changes to this file:
-%s
-
-based on this implementation plan:
-%s
-
-in the context of this change request:
-%s
-
-Using the following file contents as context:
-%s`
-	promptGenerateFileContentNew = `Create the following file: %s
-
-with the following implementation plan:
-%s
-
-in the context of this change request:
-%s
-
-Using the following file contents as context:
-%s`
-	initialPromptImplementContext = "The current project structure is as follows:\n%s\n You are tasked with implementing the following: \n%s"
-	initialPromptAskContext       = "The current project structure is as follows:\n%s\n User Query: \n%s"
-)
+// GenerationWorker holds the assistants used to generate the content of a single file.
+// Their sessions are stateful, so a worker generates one file at a time.
+type GenerationWorker struct {
+	GenerateCode assistants.GenerateCodeAssistant
+	ApplyPatch   assistants.GenerateCodeAssistant
+}
+
+// Option is a functional option type for configuring an LLMProgrammingService.
+type Option func(s *LLMProgrammingService)
+
+// WithGenerationWorkers sets the workers used to generate file contents. Independent files of an
+// update_files command are generated in parallel, one per worker, so the number of workers bounds
+// the number of concurrent code generation requests.
+func WithGenerationWorkers(workers ...GenerationWorker) Option {
+	return func(s *LLMProgrammingService) {
+		if len(workers) > 0 {
+			s.generationWorkers = workers
+		}
+	}
+}
+
+// WithAskThreadFile persists the ask thread to path, so that follow-up /ask questions can continue it after a
+// restart. A thread saved earlier is loaded; if it cannot be read, a new thread is started.
+func WithAskThreadFile(path string) Option {
+	return func(s *LLMProgrammingService) {
+		s.askThreadPath = path
+		thread, err := LoadAskThread(path)
+		if err != nil {
+			logging.Logger.Warnf("Starting a new ask thread: %v", err)
+			return
+		}
+		s.askThread = thread
+	}
+}
+
+// WithCheckpoints saves a checkpoint of every change request to store after each processed command,
+// so that interrupted change requests can be resumed with ResumeWithContext.
+func WithCheckpoints(store *CheckpointStore) Option {
+	return func(s *LLMProgrammingService) {
+		s.checkpoints = store
+	}
+}
+
+// WithInstructions adds the directory instructions of the files the agent reads or edits to the results
+// of its commands and to the prompts generating their content.
+func WithInstructions(projectInstructions *instructions.Instructions) Option {
+	return func(s *LLMProgrammingService) {
+		s.instructions = projectInstructions
+	}
+}
+
+// WithPrompts sets the templates of the prompts sent to the LLM, e.g. to use the overrides of the project.
+func WithPrompts(set *prompts.Set) Option {
+	return func(s *LLMProgrammingService) {
+		s.prompts = set
+	}
+}
+
+// WithAskUserPolicy answers the questions of the agent according to policy instead of asking the user, e.g. when
+// running without a user. The empty policy asks the user.
+func WithAskUserPolicy(policy config.AskUserPolicy) Option {
+	return func(s *LLMProgrammingService) {
+		s.askUserPolicy = policy
+	}
+}
+
+// WithHistories continues the conversations of another service, e.g. the service replaced when switching profiles.
+// Nil histories are not continued.
+func WithHistories(histories Histories) Option {
+	return func(s *LLMProgrammingService) {
+		if histories.CodeAnalysis != nil {
+			s.codeAnalysisAssistant.SetHistory(slices.Clone(histories.CodeAnalysis))
+		}
+		if histories.AskThread != nil {
+			s.askThread = histories.AskThread
+		}
+	}
+}
+
+// Histories holds the conversations of the service that outlive a change request: the history of the code analysis
+// session and the ask thread.
+type Histories struct {
+	CodeAnalysis []models.Message
+	AskThread    *AskThread
+}
 
 // LLMProgrammingService uses the LLMSession interface for interacting with LLMs.
 type LLMProgrammingService struct {
@@ -53,6 +118,17 @@ type LLMProgrammingService struct {
 	codeGenerateCodeAssistant  assistants.GenerateCodeAssistant
 	patchGenerateCodeAssistant assistants.GenerateCodeAssistant
 	maxLoops                   int
+	generationWorkers          []GenerationWorker
+	idleWorkers                chan GenerationWorker      // Pool of workers that are not generating a file
+	loopsUsed                  atomic.Int64               // Process loops run since the service was created
+	askThread                  *AskThread                 // Conversation continued by follow-up questions
+	askThreadPath              string                     // File the ask thread is saved to, empty when it is not persisted
+	checkpoints                *CheckpointStore           // Nil when change requests are not checkpointed
+	runID                      string                     // Id of the checkpointed change request being implemented
+	mentions                   []context.Mention          // Files mentioned in the change request being implemented
+	instructions               *instructions.Instructions // Nil when directory instructions are not used
+	prompts                    *prompts.Set
+	askUserPolicy              config.AskUserPolicy // Empty when the questions of the agent are asked to the user
 }
 
 // NewLLMProgrammingService creates a new instance of LLMProgrammingService.
@@ -64,9 +140,10 @@ func NewLLMProgrammingService(
 	codeGenerateCodeAssistant assistants.GenerateCodeAssistant,
 	patchGenerateCodeAssistant assistants.GenerateCodeAssistant,
 	maxLoops int,
+	options ...Option,
 ) *LLMProgrammingService {
 	logging.Logger.Infof("Creating new LLMProgrammingService")
-	return &LLMProgrammingService{
+	s := &LLMProgrammingService{
 		codeAnalysisAssistant:      codeAnalysisAssistant,
 		askAnalysisAssistant:       askAnalysisAssistant,
 		codeInstructionAssistant:   codeInstructionAssistant,
@@ -74,7 +151,22 @@ func NewLLMProgrammingService(
 		codeGenerateCodeAssistant:  codeGenerateCodeAssistant,
 		patchGenerateCodeAssistant: patchGenerateCodeAssistant,
 		maxLoops:                   maxLoops,
+		generationWorkers:          []GenerationWorker{{GenerateCode: codeGenerateCodeAssistant, ApplyPatch: patchGenerateCodeAssistant}},
+		askThread:                  &AskThread{},
+		prompts:                    prompts.Default(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if len(s.askThread.Turns) > 0 {
+		s.askAnalysisAssistant.SetHistory(s.askThread.History)
 	}
+
+	s.idleWorkers = make(chan GenerationWorker, len(s.generationWorkers))
+	for _, worker := range s.generationWorkers {
+		s.idleWorkers <- worker
+	}
+	return s
 }
 
 // ImplementWithContext performs implementation using provided context and LLM sessions.
@@ -82,9 +174,21 @@ func (s *LLMProgrammingService) ImplementWithContext(agentContext context.Progra
 	logging.Logger.Infof("Starting ImplementWithContext")
 
 	defer s.codeInstructionAssistant.ClearHistory()
-
-	// Create the initial prompt
-	initialPrompt := fmt.Sprintf(initialPromptImplementContext, agentContext.GetRepoStructure(), agentContext.GetChangeRequest())
+	s.startRun(llm.NewSessionID())
+	defer s.endRun()
+
+	// Create the initial prompt, with the contents of the files mentioned in the change request
+	changeRequest := agentContext.GetChangeRequest()
+	s.mentions = mentionedFiles(agentContext, changeRequest)
+	defer func() { s.mentions = nil }()
+	initialPrompt, err := s.prompts.Render(prompts.Implement, prompts.Vars{
+		"Structure":      agentContext.GetRepoStructure(),
+		"ChangeRequest":  changeRequest,
+		"MentionedFiles": formatMentions(agentContext, s.mentions),
+	})
+	if err != nil {
+		return "", err
+	}
 
 	// Process the initial prompt
 	response, err := s.processRequest(initialPrompt, agentContext, true)
@@ -94,23 +198,193 @@ func (s *LLMProgrammingService) ImplementWithContext(agentContext context.Progra
 	return response, nil
 }
 
+// ResumeWithContext resumes the change request of run runID, or of the most recent run if runID is empty,
+// from its last checkpoint. The pending changes of the run are restored into agentContext.
+func (s *LLMProgrammingService) ResumeWithContext(runID string, agentContext context.ProgrammingAgentContext) (string, error) {
+	logging.Logger.Infof("Starting ResumeWithContext")
+	if s.checkpoints == nil {
+		return "", fmt.Errorf("%w: change requests are not checkpointed", ErrResumeFailed)
+	}
+	snapshotter, ok := agentContext.(context.Snapshotter)
+	if !ok {
+		return "", fmt.Errorf("%w: cannot restore a checkpoint into a context of type %T", ErrResumeFailed, agentContext)
+	}
+	checkpoint, err := s.checkpoints.Load(runID)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrResumeFailed, err)
+	}
+	if err := snapshotter.Restore(checkpoint.Context); err != nil {
+		return "", fmt.Errorf("%w: error restoring checkpoint of run %s: %w", ErrResumeFailed, checkpoint.ID, err)
+	}
+	logging.Logger.Infof("Resuming run %s, checkpointed at %s", checkpoint.ID, checkpoint.SavedAt.Format(time.DateTime))
+
+	defer s.codeInstructionAssistant.ClearHistory()
+	s.codeAnalysisAssistant.SetHistory(checkpoint.AnalysisHistory)
+	s.codeInstructionAssistant.SetHistory(checkpoint.InstructionHistory)
+	s.startRun(checkpoint.ID)
+	defer s.endRun()
+	s.mentions = mentionedFiles(agentContext, agentContext.GetChangeRequest())
+	defer func() { s.mentions = nil }()
+
+	resp, err := s.sendMessage(checkpoint.PendingMessage, true)
+	if err != nil {
+		return "", fmt.Errorf("error sending checkpointed message in ResumeWithContext: %w", err)
+	}
+	// Resuming a run stopped at the loop limit answers the loop limit prompt
+	loops := checkpoint.Loops
+	if loops >= s.maxLoops {
+		loops = 0
+	}
+	response, err := s.processLoop(resp, agentContext, true, loops)
+	if err != nil {
+		return "", fmt.Errorf("error processing request in ResumeWithContext: %w", err)
+	}
+	return response, nil
+}
+
+// startRun starts checkpointing the change request being implemented as run id.
+func (s *LLMProgrammingService) startRun(id string) {
+	if s.checkpoints == nil {
+		return
+	}
+	s.runID = id
+	logging.Logger.Debugf("Checkpointing run %s to %s", id, s.checkpoints.Dir(id))
+}
+
+// endRun stops checkpointing the current run. Runs that did not complete keep their checkpoint.
+func (s *LLMProgrammingService) endRun() {
+	if s.runID != "" {
+		if _, err := os.Stat(s.checkpoints.Dir(s.runID)); err == nil {
+			logging.Logger.Infof("The change request can be resumed from its last checkpoint with /resume %s", s.runID)
+		}
+	}
+	s.runID = ""
+}
+
+// saveCheckpoint saves the state of the current run, with pendingMessage to be sent next. Failing to
+// save a checkpoint does not stop the run, so errors are logged.
+func (s *LLMProgrammingService) saveCheckpoint(pendingMessage string, loops int, agentContext context.ProgrammingAgentContext) {
+	snapshotter, ok := agentContext.(context.Snapshotter)
+	if s.runID == "" || !ok {
+		return
+	}
+	checkpoint := &RunCheckpoint{
+		ID:                 s.runID,
+		SavedAt:            time.Now(),
+		Context:            snapshotter.Snapshot(),
+		AnalysisHistory:    s.codeAnalysisAssistant.GetHistory(),
+		InstructionHistory: s.codeInstructionAssistant.GetHistory(),
+		PendingMessage:     pendingMessage,
+		Loops:              loops,
+	}
+	if err := s.checkpoints.Save(checkpoint); err != nil {
+		logging.Logger.Warnf("Failed to checkpoint run %s: %v", s.runID, err)
+	}
+}
+
+// completeRun removes the checkpoint of the current run once its final command was received.
+func (s *LLMProgrammingService) completeRun() {
+	if s.runID == "" {
+		return
+	}
+	if err := s.checkpoints.Remove(s.runID); err != nil {
+		logging.Logger.Warnf("Failed to remove the checkpoint of run %s: %v", s.runID, err)
+	}
+}
+
 // AskWithContext performs asking using provided context and LLM sessions, without file modifications.
+// Questions continue the ask thread of the previous ones until it is reset with ResetAskThread.
 func (s *LLMProgrammingService) AskWithContext(agentContext context.ProgrammingAgentContext) (string, error) {
 	logging.Logger.Infof("Starting AskWithContext")
 
 	defer s.askInstructionAssistant.ClearHistory()
 
-	// Create the initial prompt
-	initialPrompt := fmt.Sprintf(initialPromptAskContext, agentContext.GetRepoStructure(), agentContext.GetChangeRequest())
+	// Create the initial prompt, or the follow-up prompt of an ongoing thread. The mentioned files are read through
+	// the tracking context, so that they count as read by the thread.
+	question := agentContext.GetChangeRequest()
+	trackingContext := &readTrackingContext{ProgrammingAgentContext: agentContext}
+	mentions := formatMentions(trackingContext, mentionedFiles(agentContext, question))
+	var prompt string
+	var err error
+	if len(s.askThread.Turns) == 0 {
+		s.askAnalysisAssistant.SetHistory([]models.Message{})
+		prompt, err = s.prompts.Render(prompts.Ask, prompts.Vars{
+			"Structure":      agentContext.GetRepoStructure(),
+			"Question":       question,
+			"MentionedFiles": mentions,
+		})
+	} else {
+		filesRead := "none"
+		if len(s.askThread.FilesRead) > 0 {
+			filesRead = strings.Join(s.askThread.FilesRead, ", ")
+		}
+		prompt, err = s.prompts.Render(prompts.AskFollowUp, prompts.Vars{
+			"FilesRead":      filesRead,
+			"Question":       question,
+			"MentionedFiles": mentions,
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	history := slices.Clone(s.askAnalysisAssistant.GetHistory())
 
-	// Process the initial prompt
-	response, err := s.processRequest(initialPrompt, agentContext, false)
+	// Process the prompt
+	response, err := s.processRequest(prompt, trackingContext, false)
 	if err != nil {
+		// Failed questions are left out of the thread
+		s.askAnalysisAssistant.SetHistory(history)
 		return "", fmt.Errorf("error processing request in AskWithContext: %w", err)
 	}
+
+	s.askThread.Turns = append(s.askThread.Turns, AskTurn{Question: question, Answer: response})
+	s.askThread.addFilesRead(trackingContext.filesRead)
+	s.askThread.History = s.askAnalysisAssistant.GetHistory()
+	s.saveAskThread()
 	return response, nil
 }
 
+// ResetAskThread starts a new ask thread, so that the next question is answered without the previous ones.
+func (s *LLMProgrammingService) ResetAskThread() error {
+	logging.Logger.Infof("Starting a new ask thread")
+	s.askThread = &AskThread{}
+	s.askAnalysisAssistant.SetHistory([]models.Message{})
+	if s.askThreadPath == "" {
+		return nil
+	}
+	if err := os.Remove(s.askThreadPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove ask thread: %w", err)
+	}
+	return nil
+}
+
+// saveAskThread persists the ask thread if it is configured to. Failures only cost the thread on restart, so they are logged.
+func (s *LLMProgrammingService) saveAskThread() {
+	if s.askThreadPath == "" {
+		return
+	}
+	if err := s.askThread.Save(s.askThreadPath); err != nil {
+		logging.Logger.Warnf("Failed to save the ask thread: %v", err)
+	}
+}
+
+// Histories returns the conversations of the service, to be continued by another one with WithHistories.
+func (s *LLMProgrammingService) Histories() Histories {
+	return Histories{
+		CodeAnalysis: slices.Clone(s.codeAnalysisAssistant.GetHistory()),
+		AskThread: &AskThread{
+			Turns:     slices.Clone(s.askThread.Turns),
+			FilesRead: slices.Clone(s.askThread.FilesRead),
+			History:   slices.Clone(s.askThread.History),
+		},
+	}
+}
+
+// LoopsUsed returns the number of process loops run since the service was created, e.g. to benchmark models.
+func (s *LLMProgrammingService) LoopsUsed() int {
+	return int(s.loopsUsed.Load())
+}
+
 // processRequest encapsulates the shared logic for AskWithContext and ImplementWithContext.
 func (s *LLMProgrammingService) processRequest(initialPrompt string, agentContext context.ProgrammingAgentContext, useImplementSessions bool) (string, error) {
 	logging.Logger.Debugf("Starting processRequest")
@@ -119,8 +393,14 @@ func (s *LLMProgrammingService) processRequest(initialPrompt string, agentContex
 	if err != nil {
 		return "", fmt.Errorf("error sending initial message in processRequest: %w", err)
 	}
+	return s.processLoop(resp, agentContext, useImplementSessions, 0)
+}
 
-	loopCounter := 0
+// processLoop processes the commands of the LLM until it sends a final command, starting from resp and
+// loopCounter loops already run since the loop limit was last confirmed.
+func (s *LLMProgrammingService) processLoop(resp commands.Command, agentContext context.ProgrammingAgentContext, useImplementSessions bool, loopCounter int) (string, error) {
+	stalls := newStallDetector()
+	sentInstructions := make(map[*instructions.File]bool)
 	for {
 		loopCounter++
 		if loopCounter > s.maxLoops {
@@ -142,15 +422,33 @@ func (s *LLMProgrammingService) processRequest(initialPrompt string, agentContex
 			}
 		}
 
+		s.loopsUsed.Add(1)
 		processedResponse, isCommand, isFinalCommand, err := s.processCommand(resp, agentContext)
+		if errors.Is(err, commands.ErrNoUserToAsk) {
+			return "", err
+		}
 		if err != nil {
 			logging.Logger.Errorf("Error processing command in processRequest: %v", err)
 		}
 
 		if isFinalCommand {
 			logging.Logger.Infof("Received final command, task complete.")
+			if useImplementSessions {
+				s.completeRun()
+			}
 			return processedResponse, nil
 		}
+		processedResponse += s.directoryInstructions(resp, sentInstructions)
+		if reason := stalls.observe(resp, agentContext.GetFileContent); reason != "" {
+			var stop bool
+			processedResponse, stop = s.handleStall(stalls, reason, processedResponse)
+			if stop {
+				return "Process stopped by user after the agent stalled.", nil
+			}
+		}
+		if useImplementSessions {
+			s.saveCheckpoint(processedResponse, loopCounter, agentContext)
+		}
 
 		if isCommand {
 			resp, err = s.sendMessage(processedResponse, useImplementSessions)
@@ -168,6 +466,39 @@ func (s *LLMProgrammingService) processRequest(initialPrompt string, agentContex
 	}
 }
 
+// handleStall asks a stalled agent to change its approach by appending a corrective message to the result of its
+// last command. If the agent already stalled after being corrected, it asks the user instead, who can let the agent
+// continue, stop the process or give the agent guidance. It returns the message to send next and whether to stop.
+func (s *LLMProgrammingService) handleStall(stalls *stallDetector, reason string, processedResponse string) (string, bool) {
+	if stalls.corrections < maxStallCorrections {
+		stalls.corrections++
+		logging.Logger.Warnf("The agent is not making progress (%s), asking it to change its approach.", reason)
+		correction, err := s.prompts.Render(prompts.StallCorrection, prompts.Vars{"Reason": reason})
+		if err != nil {
+			logging.Logger.Errorf("Error rendering the stall correction: %v", err)
+			return processedResponse, false
+		}
+		return processedResponse + correction, false
+	}
+
+	stalls.corrections = 0
+	userInput, err := input.UserInputGetter(fmt.Sprintf("The agent is not making progress: %s. It has tried:\n%sContinue? [Y]es/[N]o, or type guidance for the agent: ", reason, stalls.summary()))
+	if err != nil {
+		logging.Logger.Errorf("Error getting user input: %v. Stopping process.", err)
+		return processedResponse, true
+	}
+	userInput = strings.TrimSpace(userInput)
+	switch strings.ToLower(userInput) {
+	case "yes", "y":
+		return processedResponse, false
+	case "no", "n", "":
+		return processedResponse, true
+	default:
+		logging.Logger.Infof("Forwarding the guidance of the user to the agent.")
+		return processedResponse + "\n\nGuidance from the user: " + userInput, false
+	}
+}
+
 // sendMessage sends messages to the appropriate sessions based on sessionType ('ask' or 'implement').
 func (s *LLMProgrammingService) sendMessage(processedResponse string, useImplementSessions bool) (commands.Command, error) {
 	var analysisAssistant assistants.AnalysisAssistant
@@ -229,14 +560,26 @@ func (s *LLMProgrammingService) executeCommand(command commands.Command, agentCo
 	logging.Logger.Debugf("Starting executeCommand for command type: %s", commandType)
 
 	updateCommand, isUpdate := command.(*commands.UpdateFileCommand)
+	updateFilesCommand, isUpdateFiles := command.(*commands.UpdateFilesCommand)
 
 	if isUpdate {
 		var err error
 		command, err = s.handleFileUpdate(updateCommand, agentContext) // Pass commandMap to handleFileUpdate
 		if err != nil {
-			return "File update failed, please retry.", fmt.Errorf("error handling file update in executeCommand: %w", err)
+			wrappedErr := fmt.Errorf("error handling file update in executeCommand: %w", err)
+			return fmt.Sprintf("File update failed, please retry. %v", wrappedErr), wrappedErr
+		}
+	} else if isUpdateFiles {
+		var err error
+		command, err = s.handleFilesUpdate(updateFilesCommand, agentContext)
+		if err != nil {
+			wrappedErr := fmt.Errorf("error handling files update in executeCommand: %w", err)
+			return fmt.Sprintf("File update failed, please retry the files that failed. %v", wrappedErr), wrappedErr
 		}
 	}
+	if askUser, ok := command.(*commands.AskUserCommand); ok {
+		askUser.Policy = s.askUserPolicy
+	}
 	processedResponse, err := command.Process(agentContext)
 	if err != nil {
 		// Replace errors.New with fmt.Errorf
@@ -273,14 +616,24 @@ func (s *LLMProgrammingService) handleFileUpdate(updateCommand *commands.UpdateF
 	implementationPlan := updateCommand.ImplementationPlan // Extract implementation_plan from commandMap
 	logging.Logger.Debugf("Starting handleFileUpdate for file: %s", filePath)
 
-	analysisPrompt := fmt.Sprintf(promptAnalysis, filePath)
+	analysisPrompt, err := s.prompts.Render(prompts.ContextFiles, prompts.Vars{"FilePath": filePath})
+	if err != nil {
+		return nil, err
+	}
 	analysisResponse, err := s.codeAnalysisAssistant.Execute(context2.Background(), analysisPrompt)
 
 	if err != nil {
 		return nil, fmt.Errorf("error prompting analysis LLM for context files in handleFileUpdate: %w", err)
 	}
 
-	instructionPrompt := fmt.Sprintf(promptInstruction, filePath, implementationPlan, analysisResponse)
+	instructionPrompt, err := s.prompts.Render(prompts.UpdateFileCommand, prompts.Vars{
+		"FilePath":           filePath,
+		"ImplementationPlan": implementationPlan,
+		"ContextFilesAnswer": analysisResponse,
+	})
+	if err != nil {
+		return nil, err
+	}
 	instructionResponse, err := s.codeInstructionAssistant.Instruct(context2.Background(), instructionPrompt)
 
 	if err != nil {
@@ -292,49 +645,193 @@ func (s *LLMProgrammingService) handleFileUpdate(updateCommand *commands.UpdateF
 		return nil, fmt.Errorf("error creating final update_file command in handleFileUpdate: %w", err)
 	}
 
-	err = s.generateFileContent(finalUpdateCmd.ImplementationPlan, finalUpdateCmd.FilePath, finalUpdateCmd.ContextFiles, agentContext)
+	worker := <-s.idleWorkers
+	defer func() { s.idleWorkers <- worker }()
+
+	err = s.generateFileContent(worker, finalUpdateCmd.ImplementationPlan, finalUpdateCmd.FilePath, finalUpdateCmd.ContextFiles, agentContext)
 	if err != nil {
 		return nil, fmt.Errorf("error generating file content in handleFileUpdate: %w", err) // Return error from generateFileContent
 	}
 	return instructionResponse, nil
 }
 
+// handleFilesUpdate handles the update_files command. Context files are chosen for all files in a single
+// analysis round, after which the files are generated in parallel by the generation workers.
+func (s *LLMProgrammingService) handleFilesUpdate(updateFilesCommand *commands.UpdateFilesCommand, agentContext context.ProgrammingAgentContext) (commands.Command, error) {
+	filePaths := make([]string, 0, len(updateFilesCommand.Updates))
+	for _, update := range updateFilesCommand.Updates {
+		filePaths = append(filePaths, update.FilePath)
+	}
+	logging.Logger.Debugf("Starting handleFilesUpdate for files: %v", filePaths)
+
+	analysisPrompt, err := s.prompts.Render(prompts.ContextFilesBatch, prompts.Vars{"FilePaths": strings.Join(filePaths, ", ")})
+	if err != nil {
+		return nil, err
+	}
+	analysisResponse, err := s.codeAnalysisAssistant.Execute(context2.Background(), analysisPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("error prompting analysis LLM for context files in handleFilesUpdate: %w", err)
+	}
+
+	updatesJSON, err := json.Marshal(updateFilesCommand.Updates)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding updates in handleFilesUpdate: %w", err)
+	}
+	instructionPrompt, err := s.prompts.Render(prompts.UpdateFilesCommand, prompts.Vars{
+		"Updates":            string(updatesJSON),
+		"ContextFilesAnswer": analysisResponse,
+	})
+	if err != nil {
+		return nil, err
+	}
+	instructionResponse, err := s.codeInstructionAssistant.Instruct(context2.Background(), instructionPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("error prompting instruction LLM to construct final update_files command in handleFilesUpdate: %w", err)
+	}
+
+	finalUpdateCmd, ok := instructionResponse.(*commands.UpdateFilesCommand)
+	if !ok {
+		logging.Logger.Warnf("Instruction LLM did not return an update_files command, using the original command instead.")
+		finalUpdateCmd = updateFilesCommand
+	}
+
+	if err := s.generateFilesContent(finalUpdateCmd.Updates, agentContext); err != nil {
+		return nil, err
+	}
+	return finalUpdateCmd, nil
+}
+
+// generateFilesContent generates the content of several files, in parallel when they are independent.
+// Files are independent when none of them is a context file of another or mentioned, and no file is updated twice.
+func (s *LLMProgrammingService) generateFilesContent(updates []commands.UpdateFileCommand, agentContext context.ProgrammingAgentContext) error {
+	mentionedFiles := make([]string, 0, len(s.mentions))
+	for _, mention := range s.mentions {
+		mentionedFiles = append(mentionedFiles, mention.Path)
+	}
+	parallel := independentUpdates(updates, mentionedFiles)
+	if !parallel {
+		logging.Logger.Infof("The updated files depend on each other, generating them one at a time.")
+	}
+
+	errs := make([]error, len(updates))
+	var wg sync.WaitGroup
+	for i, update := range updates {
+		generate := func() {
+			worker := <-s.idleWorkers
+			defer func() { s.idleWorkers <- worker }()
+			errs[i] = s.generateFileContent(worker, update.ImplementationPlan, update.FilePath, update.ContextFiles, agentContext)
+		}
+		if !parallel {
+			generate()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			generate()
+		}()
+	}
+	wg.Wait()
+
+	var failures []string
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, updates[i].FilePath)
+			failed = append(failed, fmt.Errorf("%s: %w", updates[i].FilePath, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error generating content of %s: %w", strings.Join(failures, ", "), errors.Join(failed...))
+	}
+	return nil
+}
+
+// independentUpdates reports whether the given updates can be generated in parallel: no file is updated twice, and
+// no updated file is read to generate another one, as a context file or as one of mentionedFiles.
+func independentUpdates(updates []commands.UpdateFileCommand, mentionedFiles []string) bool {
+	targets := make(map[string]bool, len(updates))
+	for _, update := range updates {
+		target := normalizePaths([]string{update.FilePath})[0]
+		if targets[target] {
+			return false
+		}
+		targets[target] = true
+	}
+	for _, update := range updates {
+		target := normalizePaths([]string{update.FilePath})[0]
+		for _, contextFile := range normalizePaths(update.ContextFiles) {
+			if contextFile != target && targets[contextFile] {
+				return false
+			}
+		}
+	}
+	// The mentioned files are read to generate every file but themselves
+	if len(updates) > 1 {
+		for _, mentionedFile := range normalizePaths(mentionedFiles) {
+			if targets[mentionedFile] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // generateFileContent generates the content of each file based on the implementation plan.
-func (s *LLMProgrammingService) generateFileContent(implementationPlan, file string, contextFiles []string, agentContext context.ProgrammingAgentContext) error {
+func (s *LLMProgrammingService) generateFileContent(worker GenerationWorker, implementationPlan, file string, contextFiles []string, agentContext context.ProgrammingAgentContext) error {
 	logging.Logger.Infof("Starting generateFileContent for file: %s", file)
 
-	contextFilePromptComponent := s.buildContextFilePromptComponent(agentContext, contextFiles, file)
+	// Generating the content of a file that cannot be written would waste a request
+	if checker, ok := agentContext.(context.ModifiabilityChecker); ok {
+		if err := checker.CanModify(file); err != nil {
+			return err
+		}
+	}
+
+	changeRequest := agentContext.GetChangeRequest()
+	contextFilePromptComponent := s.buildContextFilePromptComponent(agentContext, contextFiles, file) +
+		buildMentionPromptComponent(agentContext, s.mentions, contextFiles, file)
 
 	existingFileContent, exists := agentContext.GetFileContent(file)
 
 	var prompt string
+	var err error
 
 	if exists {
-		prompt = fmt.Sprintf(
-			promptGenerateFileContentExisting,
-			existingFileContent,
-			implementationPlan,
-			agentContext.GetChangeRequest(),
-			contextFilePromptComponent)
+		prompt, err = s.prompts.Render(prompts.GenerateExistingFile, prompts.Vars{
+			"FileContent":        existingFileContent,
+			"ImplementationPlan": implementationPlan,
+			"ChangeRequest":      changeRequest,
+			"ContextFiles":       contextFilePromptComponent,
+		})
 	} else {
-		prompt = fmt.Sprintf(
-			promptGenerateFileContentNew,
-			file,
-			implementationPlan,
-			agentContext.GetChangeRequest(),
-			contextFilePromptComponent)
+		prompt, err = s.prompts.Render(prompts.GenerateNewFile, prompts.Vars{
+			"FilePath":           file,
+			"ImplementationPlan": implementationPlan,
+			"ChangeRequest":      changeRequest,
+			"ContextFiles":       contextFilePromptComponent,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.instructions != nil {
+		if directoryInstructions := s.instructions.ForFiles(file); len(directoryInstructions) > 0 {
+			prompt += "\n\n" + instructions.Format(directoryInstructions)
+		}
 	}
 
 	logging.Logger.Debugf("Generating content for file: %s", file)
 
-	codeGenerated, err := s.codeGenerateCodeAssistant.GenerateCode(context2.Background(), prompt)
+	codeGenerated, err := worker.GenerateCode.GenerateCode(context2.Background(), prompt)
 
 	if err != nil {
 		logging.Logger.Errorf("Error from generateCodeAgent.Execute: %v", err)
 		return err
 	} else {
 
-		appliedPatch, patchErr := s.applyPatch(existingFileContent, codeGenerated, file, agentContext)
+		appliedPatch, patchErr := s.applyPatch(worker, existingFileContent, codeGenerated, file, agentContext)
 		if patchErr != nil {
 			logging.Logger.Errorf("Error applying patch for file %s: %v", file, patchErr)
 		}
@@ -343,13 +840,15 @@ func (s *LLMProgrammingService) generateFileContent(implementationPlan, file str
 		}
 
 		logging.Logger.Debugf("Generated content for file: %s", file)
-		agentContext.UpdateFileContent(file, codeGenerated)
+		if err := agentContext.UpdateFileContent(file, codeGenerated); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (s *LLMProgrammingService) applyPatch(existingFileContent string, extractedContent string, file string, agentContext context.ProgrammingAgentContext) (bool, error) {
+func (s *LLMProgrammingService) applyPatch(worker GenerationWorker, existingFileContent string, extractedContent string, file string, agentContext context.ProgrammingAgentContext) (bool, error) {
 	isPatch := strings.HasPrefix(extractedContent, "--- a/")
 	if !isPatch {
 		return false, nil
@@ -361,18 +860,23 @@ func (s *LLMProgrammingService) applyPatch(existingFileContent string, extracted
 		return false, nil
 	}
 
-	logging.Logger.Debugf("Calling patchGenerateCodeAssistant.GenerateCode for file: %s", file)
+	logging.Logger.Debugf("Calling the patch assistant for file: %s", file)
 	// The patch assistant is specifically designed to take existing content and a patch and return the new content.
 	// The prompt format here is crucial for the patch assistant to understand the input.
-	patchPrompt := fmt.Sprintf("Apply the following git patch to the file content:\n\nFile Content:\n```\n%s\n```\n\nGit Patch:\n```diff\n%s\n```\n\nProvide only the resulting file content.", existingFileContent, extractedContent)
-	patchedContent, patchErr := s.patchGenerateCodeAssistant.GenerateCode(context2.Background(), patchPrompt)
+	patchPrompt, err := s.prompts.Render(prompts.ApplyPatch, prompts.Vars{"FileContent": existingFileContent, "Patch": extractedContent})
+	if err != nil {
+		return false, err
+	}
+	patchedContent, patchErr := worker.ApplyPatch.GenerateCode(context2.Background(), patchPrompt)
 	if patchErr != nil {
 		logging.Logger.Errorf("Error applying patch using patchGenerateCodeAssistant.GenerateCode for file %s: %v.", file, patchErr)
 		return false, patchErr
 	}
 
 	logging.Logger.Infof("Successfully applied patch using patchGenerateCodeAssistant.GenerateCode for file %s.", file)
-	agentContext.UpdateFileContent(file, patchedContent)
+	if err := agentContext.UpdateFileContent(file, patchedContent); err != nil {
+		return false, err
+	}
 
 	return true, nil
 }
@@ -2,13 +2,21 @@ package service
 
 import (
 	"context"
+	"errors"
 	"github.com/EduardDranca/GoAgent/internal/agent/commands"
 	context2 "github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/config"
+	"github.com/EduardDranca/GoAgent/internal/utils"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/EduardDranca/GoAgent/internal/agent/assistants"
 	"github.com/EduardDranca/GoAgent/internal/llm"
+	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
 )
 
@@ -62,6 +70,32 @@ func (mr *MockAnalysisAssistantMockRecorder) ClearHistory() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearHistory", reflect.TypeOf((*MockAnalysisAssistant)(nil).ClearHistory))
 }
 
+// GetHistory mocks base method.
+func (m *MockAnalysisAssistant) GetHistory() []models.Message {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHistory")
+	ret0, _ := ret[0].([]models.Message)
+	return ret0
+}
+
+// GetHistory indicates an expected call of GetHistory.
+func (mr *MockAnalysisAssistantMockRecorder) GetHistory() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistory", reflect.TypeOf((*MockAnalysisAssistant)(nil).GetHistory))
+}
+
+// SetHistory mocks base method.
+func (m *MockAnalysisAssistant) SetHistory(arg0 []models.Message) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetHistory", arg0)
+}
+
+// SetHistory indicates an expected call of SetHistory.
+func (mr *MockAnalysisAssistantMockRecorder) SetHistory(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHistory", reflect.TypeOf((*MockAnalysisAssistant)(nil).SetHistory), arg0)
+}
+
 // MockInstructionAssistant is a mock of InstructionAssistant interface.
 type MockInstructionAssistant struct {
 	ctrl     *gomock.Controller
@@ -112,6 +146,32 @@ func (mr *MockInstructionAssistantMockRecorder) ClearHistory() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearHistory", reflect.TypeOf((*MockInstructionAssistant)(nil).ClearHistory))
 }
 
+// GetHistory mocks base method.
+func (m *MockInstructionAssistant) GetHistory() []models.Message {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHistory")
+	ret0, _ := ret[0].([]models.Message)
+	return ret0
+}
+
+// GetHistory indicates an expected call of GetHistory.
+func (mr *MockInstructionAssistantMockRecorder) GetHistory() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistory", reflect.TypeOf((*MockInstructionAssistant)(nil).GetHistory))
+}
+
+// SetHistory mocks base method.
+func (m *MockInstructionAssistant) SetHistory(arg0 []models.Message) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetHistory", arg0)
+}
+
+// SetHistory indicates an expected call of SetHistory.
+func (mr *MockInstructionAssistantMockRecorder) SetHistory(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHistory", reflect.TypeOf((*MockInstructionAssistant)(nil).SetHistory), arg0)
+}
+
 // MockGenerateCodeAssistant is a mock of GenerateCodeAssistant interface.
 type MockGenerateCodeAssistant struct {
 	ctrl     *gomock.Controller
@@ -220,7 +280,7 @@ func TestLLMProgrammingService_ImplementWithContext_Success(t *testing.T) {
 	mockContext.EXPECT().GetRepoStructure().Return([]string{"/"}).Times(1)
 	mockContext.EXPECT().GetChangeRequest().Return("Implement feature X").Times(1)
 	mockContext.EXPECT().GetFileContent(gomock.Any()).Return("", false).AnyTimes() // Assuming no context files for now
-	mockContext.EXPECT().UpdateFileContent(gomock.Any(), gomock.Any()).Return().AnyTimes()
+	mockContext.EXPECT().UpdateFileContent(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
 
 	service := NewLLMProgrammingService(
 		mockAnalysisAssistant,
@@ -241,3 +301,154 @@ func TestLLMProgrammingService_ImplementWithContext_Success(t *testing.T) {
 		t.Errorf("ImplementWithContext returned unexpected response: %v, want: %v", response, "File updated successfully")
 	}
 }
+
+func TestLLMProgrammingService_GenerateFilesContent_Parallel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockContext := context2.NewMockProgrammingAgentContext(ctrl)
+	mockContext.EXPECT().GetFileContent(gomock.Any()).Return("", false).AnyTimes()
+	mockContext.EXPECT().GetChangeRequest().Return("Implement feature X").AnyTimes()
+	mockContext.EXPECT().UpdateFileContent("a.go", "generated").Return(nil).Times(1)
+	mockContext.EXPECT().UpdateFileContent("b.go", "generated").Return(nil).Times(1)
+
+	// Each generation waits until the other one has started, so they only complete if run in parallel.
+	started := make(chan struct{}, 2)
+	generate := func(_ context.Context, _ string) (string, error) {
+		started <- struct{}{}
+		deadline := time.After(5 * time.Second)
+		for len(started) < 2 {
+			select {
+			case <-deadline:
+				return "", errors.New("generations did not run in parallel")
+			case <-time.After(time.Millisecond):
+			}
+		}
+		return "generated", nil
+	}
+
+	var workers []GenerationWorker
+	for i := 0; i < 2; i++ {
+		generateCodeAssistant := NewMockGenerateCodeAssistant(ctrl)
+		generateCodeAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).DoAndReturn(generate).MaxTimes(1)
+		workers = append(workers, GenerationWorker{GenerateCode: generateCodeAssistant, ApplyPatch: NewMockGenerateCodeAssistant(ctrl)})
+	}
+
+	service := NewLLMProgrammingService(nil, nil, nil, nil, nil, nil, 10, WithGenerationWorkers(workers...))
+
+	err := service.generateFilesContent([]commands.UpdateFileCommand{
+		{FilePath: "a.go", ImplementationPlan: "Plan A"},
+		{FilePath: "b.go", ImplementationPlan: "Plan B"},
+	}, mockContext)
+	if err != nil {
+		t.Errorf("generateFilesContent returned an error: %v", err)
+	}
+}
+
+func TestLLMProgrammingService_GenerateFileContent_ReadOnlyFile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), []byte{0x00, 0x01}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	agentContext, err := context2.NewLocalProgrammingAgentContext(dir, "Change data.bin", &utils.NoOpGitUtil{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No content is generated for a file that cannot be written
+	generateCodeAssistant := NewMockGenerateCodeAssistant(ctrl)
+	worker := GenerationWorker{GenerateCode: generateCodeAssistant, ApplyPatch: NewMockGenerateCodeAssistant(ctrl)}
+	service := NewLLMProgrammingService(nil, nil, nil, nil, nil, nil, 10, WithGenerationWorkers(worker))
+
+	err = service.generateFileContent(worker, "Plan", "data.bin", nil, agentContext)
+	assert.ErrorContains(t, err, "it is a binary file")
+}
+
+func TestIndependentUpdates(t *testing.T) {
+	tests := []struct {
+		name      string
+		updates   []commands.UpdateFileCommand
+		mentioned []string
+		expected  bool
+	}{
+		{
+			name:     "distinct files",
+			updates:  []commands.UpdateFileCommand{{FilePath: "a.go", ContextFiles: []string{"c.go"}}, {FilePath: "b.go", ContextFiles: []string{"c.go"}}},
+			expected: true,
+		},
+		{
+			name:     "file used as context of another",
+			updates:  []commands.UpdateFileCommand{{FilePath: "a.go"}, {FilePath: "b.go", ContextFiles: []string{"a.go"}}},
+			expected: false,
+		},
+		{
+			name:     "same file updated twice",
+			updates:  []commands.UpdateFileCommand{{FilePath: "a.go"}, {FilePath: "a.go"}},
+			expected: false,
+		},
+		{
+			name:     "same file under different paths",
+			updates:  []commands.UpdateFileCommand{{FilePath: "./a.go"}, {FilePath: "b.go", ContextFiles: []string{"dir/../a.go"}}},
+			expected: false,
+		},
+		{
+			name:      "mentioned file updated",
+			updates:   []commands.UpdateFileCommand{{FilePath: "a.go"}, {FilePath: "b.go"}},
+			mentioned: []string{"./a.go"},
+			expected:  false,
+		},
+		{
+			name:      "single mentioned file updated",
+			updates:   []commands.UpdateFileCommand{{FilePath: "a.go"}},
+			mentioned: []string{"a.go"},
+			expected:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := independentUpdates(test.updates, test.mentioned); got != test.expected {
+				t.Errorf("independentUpdates() = %v, want %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestLLMProgrammingService_AskWithContext_AskUserFailPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	instructionAssistant := NewMockInstructionAssistant(ctrl)
+	instructionAssistant.EXPECT().ClearHistory().AnyTimes()
+	instructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.AskUserCommand{Question: "Which main?"}, nil)
+	service := NewLLMProgrammingService(nil, &historyAnalysisAssistant{}, nil, instructionAssistant, nil, nil, 25, WithAskUserPolicy(config.FailPolicy))
+
+	_, err := service.AskWithContext(askContext(ctrl, "What does main do?"))
+	if !errors.Is(err, commands.ErrNoUserToAsk) {
+		t.Errorf("AskWithContext: got error %v, want %v", err, commands.ErrNoUserToAsk)
+	}
+}
+
+func TestLLMProgrammingService_WithHistories(t *testing.T) {
+	codeHistory := []models.Message{{Role: "user", Content: "Add a README"}, {Role: "assistant", Content: "Done"}}
+	askHistory := []models.Message{{Role: "user", Content: "What does main.go do?"}, {Role: "assistant", Content: "noted"}}
+	previous := NewLLMProgrammingService(&historyAnalysisAssistant{history: codeHistory}, &historyAnalysisAssistant{}, nil, nil, nil, nil, 10)
+	previous.askThread = &AskThread{
+		Turns:     []AskTurn{{Question: "What does main.go do?", Answer: "It starts the agent."}},
+		FilesRead: []string{"main.go"},
+		History:   askHistory,
+	}
+
+	codeAnalysisAssistant := &historyAnalysisAssistant{}
+	askAnalysisAssistant := &historyAnalysisAssistant{}
+	NewLLMProgrammingService(codeAnalysisAssistant, askAnalysisAssistant, nil, nil, nil, nil, 10, WithHistories(previous.Histories()))
+	assert.Equal(t, codeHistory, codeAnalysisAssistant.history)
+	assert.Equal(t, askHistory, askAnalysisAssistant.history)
+
+	// Histories that are not continued start empty
+	codeAnalysisAssistant = &historyAnalysisAssistant{}
+	askAnalysisAssistant = &historyAnalysisAssistant{}
+	NewLLMProgrammingService(codeAnalysisAssistant, askAnalysisAssistant, nil, nil, nil, nil, 10, WithHistories(Histories{}))
+	assert.Empty(t, codeAnalysisAssistant.history)
+	assert.Empty(t, askAnalysisAssistant.history)
+}
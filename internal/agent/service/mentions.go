@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+// mentionedFiles returns the files mentioned in changeRequest, e.g. @main.go. The repository structure is only
+// fetched if the change request can mention a file.
+func mentionedFiles(agentContext context.ProgrammingAgentContext, changeRequest string) []context.Mention {
+	if !strings.Contains(changeRequest, "@") {
+		return nil
+	}
+	return context.ParseMentions(changeRequest, agentContext.GetRepoStructure())
+}
+
+// mentionExcerpt is the content attached to a prompt for a mentioned file.
+type mentionExcerpt struct {
+	mention context.Mention
+	text    string
+}
+
+// excerptMentions returns the contents of the mentioned files that exist, in the order of mentions, as long as their
+// total size stays within context.MaxMentionedBytes, and the paths of the files left out because of that limit. The
+// whole file is attached when its mentioned lines cannot be shown, e.g. for the preview of a large file.
+func excerptMentions(agentContext context.ProgrammingAgentContext, mentions []context.Mention) ([]mentionExcerpt, []string) {
+	var excerpts []mentionExcerpt
+	var leftOut []string
+	size := 0
+	for _, mention := range mentions {
+		content, exists := agentContext.GetFileContent(mention.Path)
+		if !exists {
+			continue
+		}
+		text, err := mention.Excerpt(content)
+		if err != nil {
+			logging.Logger.Warnf("Attaching the whole file instead: %v", err)
+			mention, text = context.Mention{Path: mention.Path}, content
+		}
+		if size+len(text) > context.MaxMentionedBytes {
+			leftOut = append(leftOut, mention.String())
+			continue
+		}
+		size += len(text)
+		excerpts = append(excerpts, mentionExcerpt{mention: mention, text: text})
+	}
+	if len(leftOut) > 0 {
+		logging.Logger.Warnf("The mentioned files exceed %d bytes, left out %s", context.MaxMentionedBytes, strings.Join(leftOut, ", "))
+	}
+	return excerpts, leftOut
+}
+
+// formatMentions returns the contents of the mentioned files in the format of the read command, to pre-load them
+// into the first prompt of a request. The files left out by the size limit are listed, so that they can be read.
+func formatMentions(agentContext context.ProgrammingAgentContext, mentions []context.Mention) string {
+	excerpts, leftOut := excerptMentions(agentContext, mentions)
+	var sb strings.Builder
+	for _, excerpt := range excerpts {
+		fmt.Fprintf(&sb, "Content of %s:\n%s\n\n", excerpt.mention, excerpt.text)
+	}
+	if len(leftOut) > 0 {
+		fmt.Fprintf(&sb, "These mentioned files were left out because the mentions exceed %d bytes, read them if you need them: %s\n\n",
+			context.MaxMentionedBytes, strings.Join(leftOut, ", "))
+	}
+	return sb.String()
+}
+
+// buildMentionPromptComponent constructs the context file prompt component of the mentioned files that are not
+// already among contextFiles, or the generated file itself. The files left out by the size limit are listed.
+func buildMentionPromptComponent(agentContext context.ProgrammingAgentContext, mentions []context.Mention, contextFiles []string, file string) string {
+	mentions = slices.DeleteFunc(slices.Clone(mentions), func(mention context.Mention) bool {
+		return mention.Path == file || slices.Contains(contextFiles, mention.Path)
+	})
+	excerpts, leftOut := excerptMentions(agentContext, mentions)
+	var sb strings.Builder
+	for _, excerpt := range excerpts {
+		fmt.Fprintf(&sb, "File: %s\n%s\n", excerpt.mention, excerpt.text)
+	}
+	if len(leftOut) > 0 {
+		fmt.Fprintf(&sb, "Mentioned files left out because the mentions exceed %d bytes: %s\n", context.MaxMentionedBytes, strings.Join(leftOut, ", "))
+	}
+	return sb.String()
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/commands"
+	context2 "github.com/EduardDranca/GoAgent/internal/agent/context"
+)
+
+func TestLLMProgrammingService_AskWithContext_PreloadsMentions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	askAnalysisAssistant := &historyAnalysisAssistant{}
+	askInstructionAssistant := NewMockInstructionAssistant(ctrl)
+	askInstructionAssistant.EXPECT().ClearHistory().AnyTimes()
+	askInstructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.RespondCommand{Message: "It starts the agent."}, nil)
+	service := NewLLMProgrammingService(nil, askAnalysisAssistant, nil, askInstructionAssistant, nil, nil, 10)
+
+	_, err := service.AskWithContext(askContext(ctrl, "What does @main.go do?"))
+	require.NoError(t, err)
+	assert.Contains(t, askAnalysisAssistant.history[0].Content, "already loaded")
+	assert.Contains(t, askAnalysisAssistant.history[0].Content, "Content of main.go:\npackage main\n")
+	assert.Equal(t, []string{"main.go"}, service.askThread.FilesRead)
+}
+
+func TestBuildMentionPromptComponent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	agentContext := context2.NewMockProgrammingAgentContext(ctrl)
+	agentContext.EXPECT().GetFileContent("c.go").Return("line 1\nline 2\nline 3", true).AnyTimes()
+	mentions := context2.ParseMentions("Move @c.go:2-3 from @b.go to @a.go", []string{"a.go", "b.go", "c.go"})
+
+	// The generated file and the context files chosen by the LLM are left out
+	component := buildMentionPromptComponent(agentContext, mentions, []string{"b.go"}, "a.go")
+	assert.Equal(t, "File: c.go:2-3\nline 2\nline 3\n", component)
+
+	assert.Empty(t, buildMentionPromptComponent(agentContext, nil, nil, "a.go"))
+}
+
+func TestFormatMentions_SizeLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	agentContext := context2.NewMockProgrammingAgentContext(ctrl)
+	large := strings.Repeat("x", context2.MaxMentionedBytes/2+1)
+	for _, file := range []string{"a.go", "b.go", "c.go"} {
+		agentContext.EXPECT().GetFileContent(file).Return(large, true)
+	}
+	agentContext.EXPECT().GetFileContent("d.go").Return("small", true)
+	mentions := context2.ParseMentions("Compare @a.go @b.go @c.go @d.go", []string{"a.go", "b.go", "c.go", "d.go"})
+
+	formatted := formatMentions(agentContext, mentions)
+	assert.Contains(t, formatted, "Content of a.go:\n")
+	assert.Contains(t, formatted, "Content of d.go:\nsmall\n")
+	assert.NotContains(t, formatted, "Content of b.go")
+	assert.Contains(t, formatted, "These mentioned files were left out because the mentions exceed 262144 bytes, read them if you need them: b.go, c.go\n")
+}
@@ -8,5 +8,11 @@ import (
 type ProgrammingService interface {
 	// ImplementWithContext implements the change request using the given context.
 	ImplementWithContext(agentContext context.ProgrammingAgentContext) (string, error)
+	// ResumeWithContext resumes an interrupted change request from its last checkpoint, restoring its pending
+	// changes into the context. An empty runID resumes the most recent run.
+	ResumeWithContext(runID string, agentContext context.ProgrammingAgentContext) (string, error)
+	// AskWithContext answers the question of the context, continuing the thread of the previous questions.
 	AskWithContext(ctx context.ProgrammingAgentContext) (string, error)
+	// ResetAskThread starts a new thread for the following questions.
+	ResetAskThread() error
 }
@@ -68,3 +68,32 @@ func (mr *MockServiceMockRecorder) ImplementWithContext(agentContext any) *gomoc
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImplementWithContext", reflect.TypeOf((*MockService)(nil).ImplementWithContext), agentContext)
 }
+
+// ResetAskThread mocks base method.
+func (m *MockService) ResetAskThread() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetAskThread")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetAskThread indicates an expected call of ResetAskThread.
+func (mr *MockServiceMockRecorder) ResetAskThread() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetAskThread", reflect.TypeOf((*MockService)(nil).ResetAskThread))
+}
+
+// ResumeWithContext mocks base method.
+func (m *MockService) ResumeWithContext(runID string, agentContext context.ProgrammingAgentContext) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResumeWithContext", runID, agentContext)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResumeWithContext indicates an expected call of ResumeWithContext.
+func (mr *MockServiceMockRecorder) ResumeWithContext(runID, agentContext any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResumeWithContext", reflect.TypeOf((*MockService)(nil).ResumeWithContext), runID, agentContext)
+}
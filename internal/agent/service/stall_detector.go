@@ -0,0 +1,211 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/commands"
+)
+
+const (
+	// maxCommandRepeats is the number of times the same command can be issued before the agent is considered stalled.
+	maxCommandRepeats = 3
+	// maxCommandsWithoutProgress is the number of consecutive commands that neither change files nor obtain new
+	// information, e.g. reading files that were already read, before the agent is considered stalled.
+	maxCommandsWithoutProgress = 6
+	// maxStallCorrections is the number of corrective messages sent to the agent before escalating to the user.
+	maxStallCorrections = 1
+	// structureFact is the information obtained by checking the structure of the repository.
+	structureFact = "check_structure"
+)
+
+// stallDetector tracks the commands of a request to detect an agent repeating itself without making progress.
+type stallDetector struct {
+	counts          map[string]int      // Times every normalized command was issued
+	repeats         map[string]int      // Times every normalized command was issued since it last stalled the agent
+	facts           map[string][]string // Information obtained by every normalized command
+	labels          map[string]string   // Description of every normalized command
+	order           []string            // Normalized commands in the order they were first issued
+	known           map[string]bool     // Information already obtained, e.g. the files read and the searches run
+	withoutProgress int                 // Consecutive commands that made no progress
+	corrections     int                 // Corrective messages sent since the user was last asked
+}
+
+func newStallDetector() *stallDetector {
+	return &stallDetector{
+		counts:  make(map[string]int),
+		repeats: make(map[string]int),
+		facts:   make(map[string][]string),
+		labels:  make(map[string]string),
+		known:   make(map[string]bool),
+	}
+}
+
+// observedCommand is a processed command as seen by the stall detector.
+type observedCommand struct {
+	key      string   // Normalized form of the command, under which near-identical commands are equal
+	label    string   // Description of the command
+	facts    []string // Information obtained by the command
+	modified []string // Files modified by the command
+}
+
+// observe records a processed command, contentOf returning the content of a file after the command. It returns why
+// the agent is stalled, or an empty string if it is not.
+func (d *stallDetector) observe(command commands.Command, contentOf func(path string) (string, bool)) string {
+	observed := describeCommand(command, contentOf)
+	key := observed.key
+	if d.counts[key] == 0 {
+		d.order = append(d.order, key)
+		d.labels[key] = observed.label
+		d.facts[key] = observed.facts
+	}
+	d.counts[key]++
+	d.repeats[key]++
+
+	// Writing a file content that was not written before is progress, and what was learnt about the file is outdated
+	progress := len(observed.modified) > 0 && d.counts[key] == 1
+	for _, path := range observed.modified {
+		d.forget("read " + path)
+	}
+	if len(observed.modified) > 0 {
+		d.forget(structureFact)
+	}
+	for _, fact := range observed.facts {
+		if !d.known[fact] {
+			d.known[fact] = true
+			progress = true
+		}
+	}
+	if progress {
+		d.withoutProgress = 0
+	} else {
+		d.withoutProgress++
+	}
+
+	switch {
+	case d.repeats[key] >= maxCommandRepeats:
+		reason := fmt.Sprintf("%s was issued %d times", observed.label, d.counts[key])
+		if len(observed.modified) > 0 {
+			reason += " with the same content"
+		}
+		// The next stall is only detected once the command is repeated again
+		d.repeats[key] = 1
+		d.withoutProgress = 0
+		return reason
+	case d.withoutProgress >= maxCommandsWithoutProgress:
+		reason := fmt.Sprintf("the last %d commands did not change any file or obtain new information", d.withoutProgress)
+		d.withoutProgress = 0
+		return reason
+	default:
+		return ""
+	}
+}
+
+// forget drops a fact that is outdated, e.g. the content of a modified file, so that obtaining it again is progress
+// and the commands obtaining it can be repeated.
+func (d *stallDetector) forget(fact string) {
+	delete(d.known, fact)
+	for key, facts := range d.facts {
+		if slices.Contains(facts, fact) {
+			d.repeats[key] = 0
+		}
+	}
+}
+
+// summary lists the commands issued so far, with the number of times they were issued.
+func (d *stallDetector) summary() string {
+	var sb strings.Builder
+	for _, key := range d.order {
+		fmt.Fprintf(&sb, "  - %s", d.labels[key])
+		if d.counts[key] > 1 {
+			fmt.Fprintf(&sb, " (%d times)", d.counts[key])
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// describeCommand returns how the stall detector sees a command. Commands modifying files are keyed on the content
+// the files have after the command, given by contentOf, so that rewriting a file with near-identical content is a
+// repeated command.
+func describeCommand(command commands.Command, contentOf func(path string) (string, bool)) observedCommand {
+	switch c := command.(type) {
+	case *commands.ReadCommand:
+		files := slices.Compact(slices.Sorted(slices.Values(normalizePaths(c.Files))))
+		var facts []string
+		for _, file := range files {
+			facts = append(facts, "read "+file)
+		}
+		key := "read " + strings.Join(files, ", ")
+		return observedCommand{key: key, label: key, facts: facts}
+	case *commands.SearchCommand:
+		key := "search " + normalizeText(c.Query)
+		return observedCommand{key: key, label: key, facts: []string{key}}
+	case *commands.CheckStructureCommand:
+		return observedCommand{key: structureFact, label: structureFact, facts: []string{structureFact}}
+	case *commands.AskUserCommand:
+		key := "ask_user " + normalizeText(c.Question)
+		return observedCommand{key: key, label: key, facts: []string{key}}
+	case *commands.UpdateFileCommand:
+		path := normalizePaths([]string{c.FilePath})[0]
+		label := "update_file " + path
+		return observedCommand{key: label + " " + contentHash(contentOf, path), label: label, modified: []string{path}}
+	case *commands.UpdateFilesCommand:
+		paths := make([]string, 0, len(c.Updates))
+		for _, update := range c.Updates {
+			paths = append(paths, update.FilePath)
+		}
+		paths = normalizePaths(paths)
+		label := "update_files " + strings.Join(paths, ", ")
+		key := label
+		for _, path := range paths {
+			key += " " + contentHash(contentOf, path)
+		}
+		return observedCommand{key: key, label: label, modified: paths}
+	case *commands.MoveFileCommand:
+		paths := normalizePaths([]string{c.OldPath, c.NewPath})
+		key := fmt.Sprintf("move_file %s to %s", paths[0], paths[1])
+		return observedCommand{key: key, label: key, modified: paths}
+	case *commands.DeleteFileCommand:
+		path := normalizePaths([]string{c.FilePath})[0]
+		key := "delete_file " + path
+		return observedCommand{key: key, label: key, modified: []string{path}}
+	default:
+		key := fmt.Sprintf("%T", command)
+		return observedCommand{key: key, label: key}
+	}
+}
+
+// contentHash returns a short hash of the content of the file at path, ignoring differences in whitespace, or an
+// empty string if the file has no content.
+func contentHash(contentOf func(path string) (string, bool), path string) string {
+	content, exists := contentOf(path)
+	if !exists {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(content), " ")))
+	return hex.EncodeToString(sum[:6])
+}
+
+// normalizeText lowers the case of text and collapses its whitespace, so that near-identical texts compare equal.
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// normalizePaths cleans paths and strips their surrounding spaces, so that equal paths, e.g. ./a.go and a.go,
+// compare equal.
+func normalizePaths(paths []string) []string {
+	normalized := make([]string, 0, len(paths))
+	for _, filePath := range paths {
+		if filePath = strings.TrimSpace(filePath); filePath != "" {
+			filePath = path.Clean(filepath.ToSlash(filePath))
+		}
+		normalized = append(normalized, filePath)
+	}
+	return normalized
+}
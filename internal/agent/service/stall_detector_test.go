@@ -0,0 +1,102 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/commands"
+	"github.com/EduardDranca/GoAgent/internal/input"
+)
+
+// noContent is the content of files before the agent writes them.
+func noContent(string) (string, bool) {
+	return "", false
+}
+
+func TestStallDetector_RepeatedCommands(t *testing.T) {
+	stalls := newStallDetector()
+
+	assert.Empty(t, stalls.observe(&commands.ReadCommand{Files: []string{"a.go", "b.go"}}, noContent))
+	assert.Empty(t, stalls.observe(&commands.SearchCommand{Query: "func main"}, noContent))
+	// Near-identical commands are counted as the same command
+	assert.Empty(t, stalls.observe(&commands.ReadCommand{Files: []string{"./b.go", "a.go"}}, noContent))
+	assert.Equal(t, "read a.go, b.go was issued 3 times", stalls.observe(&commands.ReadCommand{Files: []string{"b.go", "a.go", "a.go"}}, noContent))
+
+	// The command must be repeated again before the next stall
+	assert.Empty(t, stalls.observe(&commands.ReadCommand{Files: []string{"a.go", "b.go"}}, noContent))
+	assert.Empty(t, stalls.observe(&commands.SearchCommand{Query: "Func  Main"}, noContent))
+	assert.Equal(t, "search func main was issued 3 times", stalls.observe(&commands.SearchCommand{Query: "func main "}, noContent))
+	assert.Equal(t, "read a.go, b.go was issued 5 times", stalls.observe(&commands.ReadCommand{Files: []string{"a.go", "b.go"}}, noContent))
+
+	assert.Equal(t, "  - read a.go, b.go (5 times)\n  - search func main (3 times)\n", stalls.summary())
+}
+
+func TestStallDetector_NoProgress(t *testing.T) {
+	stalls := newStallDetector()
+
+	assert.Empty(t, stalls.observe(&commands.ReadCommand{Files: []string{"a.go", "b.go", "c.go"}}, noContent))
+	// Reading files again in different combinations obtains no new information
+	for _, files := range [][]string{{"a.go"}, {"b.go"}, {"c.go"}, {"a.go", "c.go"}, {"b.go", "c.go"}} {
+		assert.Empty(t, stalls.observe(&commands.ReadCommand{Files: files}, noContent))
+	}
+	// Changing a file is progress, and reading it again obtains new information
+	assert.Empty(t, stalls.observe(&commands.UpdateFileCommand{FilePath: "a.go"}, noContent))
+	assert.Empty(t, stalls.observe(&commands.ReadCommand{Files: []string{"a.go"}}, noContent))
+	for _, files := range [][]string{{"b.go"}, {"c.go"}, {"a.go", "c.go"}, {"b.go", "c.go"}, {"a.go", "b.go"}} {
+		assert.Empty(t, stalls.observe(&commands.ReadCommand{Files: files}, noContent))
+	}
+	assert.Equal(t, "the last 6 commands did not change any file or obtain new information",
+		stalls.observe(&commands.ReadCommand{Files: []string{"a.go", "b.go", "c.go"}}, noContent))
+}
+
+func TestStallDetector_ModifiedFiles(t *testing.T) {
+	stalls := newStallDetector()
+	content := "package main\n"
+	contentOf := func(string) (string, bool) { return content, true }
+
+	// Re-reading a file after changing it obtains new information, and can be repeated after every change
+	for range 3 {
+		assert.Empty(t, stalls.observe(&commands.ReadCommand{Files: []string{"main.go"}}, contentOf))
+		content += "// changed\n"
+		assert.Empty(t, stalls.observe(&commands.UpdateFileCommand{FilePath: "main.go"}, contentOf))
+	}
+	assert.Empty(t, stalls.observe(&commands.ReadCommand{Files: []string{"main.go"}}, contentOf))
+
+	// Rewriting a file with near-identical content is a repeated command, even after reading it
+	assert.Empty(t, stalls.observe(&commands.UpdateFileCommand{FilePath: "main.go"}, contentOf))
+	content = strings.ReplaceAll(content, "\n", "\n\n")
+	assert.Equal(t, "update_file main.go was issued 3 times with the same content",
+		stalls.observe(&commands.UpdateFileCommand{FilePath: "./main.go"}, contentOf))
+}
+
+func TestLLMProgrammingService_StalledAgentIsCorrectedThenEscalated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	analysisAssistant := &historyAnalysisAssistant{}
+	instructionAssistant := NewMockInstructionAssistant(ctrl)
+	instructionAssistant.EXPECT().ClearHistory().AnyTimes()
+	instructionAssistant.EXPECT().Execute(gomock.Any(), gomock.Any()).Return(&commands.ReadCommand{Files: []string{"main.go"}}, nil).AnyTimes()
+	service := NewLLMProgrammingService(nil, analysisAssistant, nil, instructionAssistant, nil, nil, 25)
+
+	var prompts []string
+	originalGetter := input.UserInputGetter
+	input.UserInputGetter = func(prompt string) (string, error) {
+		prompts = append(prompts, prompt)
+		return "n", nil
+	}
+	defer func() { input.UserInputGetter = originalGetter }()
+
+	response, err := service.AskWithContext(askContext(ctrl, "What does main.go do?"))
+	require.NoError(t, err)
+	assert.Equal(t, "Process stopped by user after the agent stalled.", response)
+
+	// The third read is answered with a corrective message, the fifth is escalated to the user
+	require.Len(t, analysisAssistant.history, 10)
+	assert.NotContains(t, analysisAssistant.history[4].Content, "you are not making progress")
+	assert.Contains(t, analysisAssistant.history[6].Content, "you are not making progress: read main.go was issued 3 times")
+	require.Len(t, prompts, 1)
+	assert.Contains(t, prompts[0], "read main.go (5 times)")
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"gopkg.in/yaml.v3"
+
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+// apiKeyCache holds the API keys read from commands and files, so that a command, which may prompt for a
+// passphrase, runs once per process, e.g. across profile switches.
+var apiKeyCache = struct {
+	sync.Mutex
+	keys map[string]string
+}{keys: make(map[string]string)}
+
+// resolveAPIKey returns the API key of the service: api_key if it is set, otherwise the output of api_key_command
+// or the content of api_key_file. The key is redacted from the logs.
+func (s *ServiceConfig) resolveAPIKey(service LLMServiceType) (string, error) {
+	var apiKey string
+	var err error
+	switch {
+	case s.APIKey != "":
+		apiKey = s.APIKey
+	case s.APIKeyCommand != "":
+		apiKey, err = cachedAPIKey("command "+s.APIKeyCommand, func() (string, error) {
+			return runAPIKeyCommand(s.APIKeyCommand)
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to get the API key of %s from %s.api_key_command: %w", service, service, err)
+		}
+	case s.APIKeyFile != "":
+		apiKey, err = cachedAPIKey("file "+s.APIKeyFile, func() (string, error) {
+			return readAPIKeyFile(s.APIKeyFile)
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to get the API key of %s from %s.api_key_file: %w", service, service, err)
+		}
+	}
+	logging.RedactSecret(apiKey)
+	return apiKey, nil
+}
+
+// cachedAPIKey returns the API key cached for source, reading it with read the first time.
+func cachedAPIKey(source string, read func() (string, error)) (string, error) {
+	apiKeyCache.Lock()
+	defer apiKeyCache.Unlock()
+	if apiKey, ok := apiKeyCache.keys[source]; ok {
+		return apiKey, nil
+	}
+	apiKey, err := read()
+	if err != nil {
+		return "", err
+	}
+	apiKeyCache.keys[source] = apiKey
+	return apiKey, nil
+}
+
+// runAPIKeyCommand runs command with the shell and returns its output, e.g. pass show gemini. The command can
+// prompt on the terminal, its stdout is the key.
+func runAPIKeyCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	var stdout bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, &stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("command %q failed: %w", command, err)
+	}
+	apiKey := strings.TrimSpace(stdout.String())
+	if apiKey == "" {
+		return "", fmt.Errorf("command %q printed no key", command)
+	}
+	return apiKey, nil
+}
+
+// readAPIKeyFile returns the key held by the file at path, which can start with ~/ for the home directory.
+func readAPIKeyFile(path string) (string, error) {
+	if rest, found := strings.CutPrefix(path, "~/"); found {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to expand %s: %w", path, err)
+		}
+		path = filepath.Join(home, rest)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	apiKey := strings.TrimSpace(string(data))
+	if apiKey == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return apiKey, nil
+}
+
+// keySettings returns the dotted paths of the settings called one of names, e.g. api_key, written in the YAML
+// mapping node, including those of its profiles.
+func keySettings(node *yaml.Node, prefix string, names ...string) []string {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	var keys []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		if slices.Contains(names, key) && value.Kind == yaml.ScalarNode && value.Value != "" && value.Tag != "!!null" {
+			keys = append(keys, prefix+key)
+			continue
+		}
+		keys = append(keys, keySettings(value, prefix+key+".", names...)...)
+	}
+	return keys
+}
+
+// isTracked reports whether the file at path is tracked by the git repository holding it, i.e. committed or staged.
+func isTracked(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	repo, err := git.PlainOpenWithOptions(filepath.Dir(path), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return false
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false
+	}
+	relativePath, err := filepath.Rel(worktree.Filesystem.Root(), path)
+	if err != nil {
+		return false
+	}
+	index, err := repo.Storer.Index()
+	if err != nil {
+		return false
+	}
+	_, err = index.Entry(filepath.ToSlash(relativePath))
+	return err == nil
+}
@@ -5,15 +5,22 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	agentcontext "github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/agent/instructions"
 	"github.com/EduardDranca/GoAgent/internal/logging"
+	"github.com/EduardDranca/GoAgent/internal/utils"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds all the configuration parameters for the application.
 type Config struct {
-	Directory          string
+	Directory string
+	// Profile is the name of the selected profile, empty when none is selected.
+	Profile            string
 	ProgrammingService LLMServiceType
 	GeminiApiKey       string
 	GroqApiKey         string
@@ -21,319 +28,566 @@ type Config struct {
 	// RateLimitRPM specifies the rate limit in requests per minute.
 	// Defaults to 0, which means no rate limit.
 	RateLimitRPM int
+	// RateLimitTPM specifies the rate limit in tokens per minute, shared by all requests. 0 means no limit.
+	RateLimitTPM int
+	// MaxInFlightRequests is the number of LLM requests that can be sent concurrently. 0 means no limit.
+	MaxInFlightRequests int
 	// GlamourStylePath specifies the style to use for glamour output.
 	GlamourStylePath GlamourStyleType
 	LogLevel         string // Add LogLevel field
 	MaxHistoryLength int    // Add MaxHistoryLength field
 	MaxProcessLoops  int    `yaml:"max_process_loops"`
+	// MaxFileSize is the largest file, in bytes, whose full content is given to the LLM. 0 means no limit.
+	MaxFileSize int
+	// FilePreviewLines is the number of head and tail lines shown for files over MaxFileSize.
+	FilePreviewLines int
+	// MaxParallelGenerations is the number of files whose content can be generated concurrently.
+	MaxParallelGenerations int
+	// RecordSessions enables writing every LLM request and response to a transcript in SessionsDir.
+	RecordSessions bool
+	// ReplaySession is the id or path of a transcript whose responses are served instead of calling an LLM.
+	ReplaySession string
+	// ReplayStrict fails replayed requests that match no recorded request, instead of serving the next recorded response.
+	ReplayStrict bool
+	// FakeScript is the path of the YAML or JSON script of canned responses used by the fake service.
+	FakeScript string
+	// Fallbacks lists, per assistant role, the providers tried in order when the configured service fails.
+	Fallbacks map[string][]ProviderConfig
+	// CircuitBreaker configures when a failing provider is skipped.
+	CircuitBreaker CircuitBreakerConfig
+	// Retry configures how requests failing with a rate limit or a transient error are retried.
+	Retry RetryConfig
+	// Compaction configures how session histories over their budget are compacted.
+	Compaction CompactionConfig
+	// SessionsDir is the directory where session transcripts are recorded, in the repository directory.
+	SessionsDir string
+	// RunsDir is the directory where change requests are checkpointed so that they can be resumed. Empty disables checkpoints.
+	RunsDir string
+	// ResumeRun is the id of an interrupted run to resume on startup.
+	ResumeRun string
+	// PersistAskThread saves the /ask conversation to AskThreadPath, so that follow-up questions survive restarts.
+	PersistAskThread bool
+	// AskThreadPath is the file where the /ask conversation is persisted, in the repository directory.
+	AskThreadPath string
+	// AskUserPolicy answers the questions of the agent without asking the user. Empty asks the user.
+	AskUserPolicy AskUserPolicy
+	// Instructions bounds the project instruction files injected into the prompts.
+	Instructions InstructionsConfig
+	// Roles overrides, per assistant role, the provider, model and sampling of its sessions.
+	Roles map[string]RoleConfig
+	// ProviderRateLimits overrides, per service, the rate limits of its requests.
+	ProviderRateLimits map[LLMServiceType]RateLimitConfig
 
 	InstructionsModelName string `yaml:"instructions_model"`
 	GenerateCodeModelName string `yaml:"generate_code_model"`
 	AnalysisModelName     string `yaml:"analysis_model"`
 }
 
-// ConfigFile is a struct for YAML parsing, mirroring Config but suitable for file loading.
-type ConfigFile struct {
-	// Default model names - these are defaults if not specified per service
-	Gemini struct {
-		InstructionsModelName string `yaml:"instructions_model"`
-		GenerateCodeModelName string `yaml:"generate_code_model"`
-		AnalysisModelName     string `yaml:"analysis_model"`
-	} `yaml:"gemini"`
-	Groq struct {
-		InstructionsModelName string `yaml:"instructions_model"`
-		GenerateCodeModelName string `yaml:"generate_code_model"`
-		AnalysisModelName     string `yaml:"analysis_model"`
-	} `yaml:"groq"`
-	OpenAI struct {
-		InstructionsModelName string `yaml:"instructions_model"`
-		GenerateCodeModelName string `yaml:"generate_code_model"`
-		AnalysisModelName     string `yaml:"analysis_model"`
-	} `yaml:"openai"`
-	MaxHistoryLength int    `yaml:"max_history_length"`
-	MaxProcessLoops  int    `yaml:"max_process_loops"`
-	RateLimitRPM     int    `yaml:"rate_limit_rpm"` // Add RateLimitRPM field for config file
-	GlamourStylePath string `yaml:"glamour_style"`  // Add GlamourStylePath field for config file
-	LogLevel         string `yaml:"log_level"`      // Add LogLevel field for config file
+// ProviderConfig selects the service and model of a fallback provider.
+type ProviderConfig struct {
+	Service LLMServiceType `yaml:"service"`
+	Model   string         `yaml:"model"` // Defaults to the service's default model for the role
 }
 
-// LoadConfig parses command-line flags, loads environment variables, and reads config file.
-func LoadConfig() (*Config, error) {
-	defaultDir, err := os.Getwd()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current working directory: %w", err)
-	}
-
-	// Define command-line flags with default values
-	defaultProgrammingService := string(GeminiService)
-	defaultRateLimitRPM := 0
-	defaultGlamourStyle := string(DraculaStyle)
-	defaultLogLevel := "info"
-	defaultMaxHistoryLength := 100
-	defaultMaxProcessLoops := 25 // Corrected default value based on analysis history
-
-	directoryFlag := flag.String("directory", "", "Sets the root directory of your Git repository. Defaults to the current working directory if not provided. Must be a Git repository.")
-	programmingServiceFlag := flag.String("service", defaultProgrammingService, fmt.Sprintf("Sets the programming service to use (%s, %s, %s). Defaults to %s.", GeminiService, GroqService, OpenAIService, defaultProgrammingService))
-	geminiApiKeyFlag := flag.String("gemini-api-key", "", "Sets the Gemini API key. Required when using the Gemini service.")
-	groqApiKeyFlag := flag.String("groq-api-key", "", "Sets the Groq API key. Required when using the Groq service.")
-	openaiApiKeyFlag := flag.String("openai-api-key", "", "Sets the OpenAI API key. Required when using the OpenAI service.")
-	rateLimitRPMFlag := flag.Int("rate-limit", defaultRateLimitRPM, "Sets the rate limit for API requests per minute. Prevents exceeding API usage limits. Defaults to 0, which means no rate limit.")
-	glamourStyleFlag := flag.String("glamour-style", defaultGlamourStyle, fmt.Sprintf("Sets the Glamour style for Markdown rendering in the terminal. Defaults to %s.", defaultGlamourStyle))
-	logLevelFlag := flag.String("log-level", defaultLogLevel, fmt.Sprintf("Sets the logging level. Allowed values are: %s. Defaults to %s.", strings.Join([]string{"debug", "info", "warning", "error"}, ", "), defaultLogLevel))
-	maxHistoryLengthFlag := flag.Int("max-history-length", defaultMaxHistoryLength, "Sets the maximum history length for LLM sessions. Defaults to 100.")
-	maxProcessLoopsFlag := flag.Int("max-process-loops", defaultMaxProcessLoops, "Sets the maximum number of process loops. Defaults to 25.")
-
-	// Parse command-line flags
-	flag.Parse()
-
-	// Read flag values
-	directory := defaultDir
-	if *directoryFlag != "" {
-		directory = *directoryFlag
-	}
-	programmingServiceStr := *programmingServiceFlag
-	geminiApiKey := *geminiApiKeyFlag
-	groqApiKey := *groqApiKeyFlag
-	openaiApiKey := *openaiApiKeyFlag
-	rateLimitRPM := *rateLimitRPMFlag
-	glamourStylePathStr := *glamourStyleFlag
-	logLevel := *logLevelFlag
-	maxHistoryLength := *maxHistoryLengthFlag
-	maxProcessLoops := *maxProcessLoopsFlag
-
-	// Load API keys from environment variables if not provided via flags
-	if geminiApiKey == "" {
-		geminiApiKey = os.Getenv("GEMINI_API_KEY")
-	}
-	if groqApiKey == "" {
-		groqApiKey = os.Getenv("GROQ_API_KEY")
-	}
-	if openaiApiKey == "" {
-		openaiApiKey = os.Getenv("OPENAI_API_KEY")
-	}
+// RoleConfig selects the provider, model and sampling of an assistant role. Unset values keep their defaults.
+type RoleConfig struct {
+	Service          LLMServiceType `yaml:"service,omitempty"` // Defaults to the programming service
+	Model            string         `yaml:"model,omitempty"`   // Defaults to the configured model of the role's task, or the service's default model
+	Temperature      *float32       `yaml:"temperature,omitempty"`
+	TopP             *float32       `yaml:"top_p,omitempty"`
+	TopK             *int           `yaml:"top_k,omitempty"`
+	MaxHistoryLength int            `yaml:"max_history_length,omitempty"`
+	// MaxOutputTokens is the maximum number of tokens of a response. 0 uses the provider's default.
+	MaxOutputTokens int      `yaml:"max_output_tokens,omitempty"`
+	Stop            []string `yaml:"stop,omitempty"`
+	// Seed makes sampling repeatable where the provider supports it. Not supported by Gemini.
+	Seed *int `yaml:"seed,omitempty"`
+	// ReasoningEffort sets how much reasoning models think before responding. Only supported by OpenAI.
+	ReasoningEffort ReasoningEffort `yaml:"reasoning_effort,omitempty"`
+}
 
-	// Validate programming service
-	var programmingService LLMServiceType
-	switch programmingServiceStr {
-	case string(GeminiService):
-		programmingService = GeminiService
-	case string(GroqService):
-		programmingService = GroqService
-	case string(OpenAIService):
-		programmingService = OpenAIService
+// maxStopSequences is the number of stop sequences accepted by every provider.
+const maxStopSequences = 4
+
+// validate returns an error if a value of the role configuration is out of range.
+func (r RoleConfig) validate() error {
+	if r.Temperature != nil && (*r.Temperature < 0 || *r.Temperature > 2) {
+		return fmt.Errorf("temperature %v is out of range, expected a value between 0 and 2", *r.Temperature)
+	}
+	if r.TopP != nil && (*r.TopP <= 0 || *r.TopP > 1) {
+		return fmt.Errorf("top_p %v is out of range, expected a value greater than 0 and at most 1", *r.TopP)
+	}
+	if r.TopK != nil && *r.TopK < 1 {
+		return fmt.Errorf("top_k %d is out of range, expected a value of at least 1", *r.TopK)
+	}
+	if r.MaxOutputTokens < 0 {
+		return fmt.Errorf("max_output_tokens %d is out of range, expected a positive value", r.MaxOutputTokens)
+	}
+	if r.MaxHistoryLength < 0 {
+		return fmt.Errorf("max_history_length %d is out of range, expected a positive value", r.MaxHistoryLength)
+	}
+	if len(r.Stop) > maxStopSequences {
+		return fmt.Errorf("%d stop sequences are set, at most %d are supported", len(r.Stop), maxStopSequences)
+	}
+	if slices.Contains(r.Stop, "") {
+		return fmt.Errorf("stop sequences cannot be empty")
+	}
+	switch r.ReasoningEffort {
+	case "", LowReasoningEffort, MediumReasoningEffort, HighReasoningEffort:
 	default:
-		return nil, fmt.Errorf("invalid programming service: %s", programmingServiceStr)
+		return fmt.Errorf("invalid reasoning_effort: %s, allowed values are %s, %s, %s", r.ReasoningEffort, LowReasoningEffort, MediumReasoningEffort, HighReasoningEffort)
 	}
+	return nil
+}
 
-	logging.Logger.Infof("Service being used: %s", programmingService)
+// RateLimitConfig limits the requests sent to a provider. 0 keeps the global limit.
+type RateLimitConfig struct {
+	RequestsPerMinute int `yaml:"rpm"`
+	TokensPerMinute   int `yaml:"tpm"`
+	MaxInFlight       int `yaml:"max_in_flight"`
+}
 
-	// Check for API key if required service is selected
-	if programmingService == GeminiService && geminiApiKey == "" {
-		return nil, fmt.Errorf("gemini-api-key flag or GEMINI_API_KEY environment variable not set for gemini programming service")
-	}
-	if programmingService == GroqService && groqApiKey == "" {
-		return nil, fmt.Errorf("groq-api-key flag or GROQ_API_KEY environment variable not set for groq programming service")
+// CircuitBreakerConfig configures the circuit breaker of every provider.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive retryable failures after which a provider is skipped.
+	FailureThreshold int `yaml:"failure_threshold"`
+	// Cooldown is how long a provider is skipped before it is tried again.
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// RetryConfig configures the retries of failed LLM requests.
+type RetryConfig struct {
+	// MaxAttempts is the number of times a request is sent, including the first.
+	MaxAttempts int `yaml:"max_attempts"`
+	// InitialDelay is the delay before the first retry, doubled for every following retry and jittered.
+	InitialDelay time.Duration `yaml:"initial_delay"`
+	// MaxDelay caps the delay between retries. A provider asking to wait longer is not retried.
+	MaxDelay time.Duration `yaml:"max_delay"`
+}
+
+// InstructionsConfig bounds the size of the project instruction files given to the LLM.
+type InstructionsConfig struct {
+	// MaxFileSize is the number of bytes of an instruction file that are used, the rest is truncated.
+	MaxFileSize int `yaml:"max_file_size"`
+	// MaxTotalSize is the number of bytes of directory instructions added to a single prompt.
+	MaxTotalSize int `yaml:"max_total_size"`
+}
+
+// CompactionConfig configures the compaction of LLM session histories. The first turn of a history,
+// holding the task of the session, is always kept.
+type CompactionConfig struct {
+	// Strategy defaults to TruncateCompaction, as SummarizeCompaction sends an LLM request for every compaction.
+	Strategy CompactionStrategy `yaml:"strategy"`
+	// MaxTokens is the number of estimated tokens above which a history is compacted. 0 means no limit.
+	MaxTokens int `yaml:"max_tokens"`
+	// SummaryModel is the model writing summaries. Defaults to the instructions model.
+	SummaryModel string `yaml:"summary_model"`
+}
+
+// APIKey returns the API key of a service, and false if the service is unknown.
+func (c *Config) APIKey(service LLMServiceType) (string, bool) {
+	switch service {
+	case GeminiService:
+		return c.GeminiApiKey, true
+	case GroqService:
+		return c.GroqApiKey, true
+	case OpenAIService:
+		return c.OpenaiApiKey, true
+	case FakeService:
+		return "", true
+	default:
+		return "", false
 	}
-	if programmingService == OpenAIService && openaiApiKey == "" {
-		return nil, fmt.Errorf("openai-api-key flag or OPENAI_API_KEY environment variable not set for openai programming service")
+}
+
+// setAPIKey sets the API key of a known service.
+func (c *Config) setAPIKey(service LLMServiceType, apiKey string) {
+	switch service {
+	case GeminiService:
+		c.GeminiApiKey = apiKey
+	case GroqService:
+		c.GroqApiKey = apiKey
+	case OpenAIService:
+		c.OpenaiApiKey = apiKey
 	}
+}
 
-	// Initialize config with values from flags (or their defaults)
-	cfg := &Config{
-		Directory:          directory,
-		ProgrammingService: programmingService,
-		GeminiApiKey:       geminiApiKey,
-		GroqApiKey:         groqApiKey,
-		OpenaiApiKey:       openaiApiKey,
-		RateLimitRPM:       rateLimitRPM,
-		GlamourStylePath:   GlamourStyleType(glamourStylePathStr), // Will be validated later
-		LogLevel:           logLevel,                              // Will be validated later
-		MaxHistoryLength:   maxHistoryLength,
-		MaxProcessLoops:    maxProcessLoops,
-
-		// Default model names - these are defaults if not specified per service
-		InstructionsModelName: "",
-		GenerateCodeModelName: "",
-		AnalysisModelName:     "",
-	}
-
-	configFilePath := filepath.Join(".go-agent", "config.yaml")
-
-	_, err = os.Stat(configFilePath)
-
-	defaultConfigFileMap := map[string]map[string]string{
-		string(GeminiService): {
-			"instructions_model":  "gemini-2.5-flash-preview-04-17",
-			"generate_code_model": "gemini-2.5-flash-preview-04-17",
-			"analysis_model":      "gemini-2.5-flash-preview-04-17",
-		},
-		string(GroqService): {
-			"instructions_model":  "meta-llama/llama-4-maverick-17b-128e-instruct",
-			"generate_code_model": "meta-llama/llama-4-maverick-17b-128e-instruct",
-			"analysis_model":      "meta-llama/llama-4-maverick-17b-128e-instruct",
-		},
-		string(OpenAIService): {
-			"instructions_model":  "gpt-4.5-preview",
-			"generate_code_model": "gpt-4.5-preview",
-			"analysis_model":      "gpt-4.5-preview",
-		},
-	}
-
-	if os.IsNotExist(err) {
-		// Config file does not exist, create directory and file with default values
-		err = os.MkdirAll(filepath.Dir(configFilePath), 0755)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create config directory: %w", err)
-		}
-		file, err := os.Create(configFilePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create config file: %w", err)
+// services returns the services used by the programming service, its roles and their fallbacks.
+func (c *Config) services() []LLMServiceType {
+	services := []LLMServiceType{c.ProgrammingService}
+	add := func(service LLMServiceType) {
+		if service != "" && !slices.Contains(services, service) {
+			services = append(services, service)
 		}
-		defer file.Close()
-
-		// Create a temporary ConfigFile struct with default values for writing
-		tempDefaultConfigFile := &ConfigFile{
-			Gemini: struct {
-				InstructionsModelName string `yaml:"instructions_model"`
-				GenerateCodeModelName string `yaml:"generate_code_model"`
-				AnalysisModelName     string `yaml:"analysis_model"`
-			}{
-				InstructionsModelName: defaultConfigFileMap[string(GeminiService)]["instructions_model"],
-				GenerateCodeModelName: defaultConfigFileMap[string(GeminiService)]["generate_code_model"],
-				AnalysisModelName:     defaultConfigFileMap[string(GeminiService)]["analysis_model"],
-			},
-			Groq: struct {
-				InstructionsModelName string `yaml:"instructions_model"`
-				GenerateCodeModelName string `yaml:"generate_code_model"`
-				AnalysisModelName     string `yaml:"analysis_model"`
-			}{
-				InstructionsModelName: defaultConfigFileMap[string(GroqService)]["instructions_model"],
-				GenerateCodeModelName: defaultConfigFileMap[string(GroqService)]["generate_code_model"],
-				AnalysisModelName:     defaultConfigFileMap[string(GroqService)]["analysis_model"],
-			},
-			OpenAI: struct {
-				InstructionsModelName string `yaml:"instructions_model"`
-				GenerateCodeModelName string `yaml:"generate_code_model"`
-				AnalysisModelName     string `yaml:"analysis_model"`
-			}{
-				InstructionsModelName: defaultConfigFileMap[string(OpenAIService)]["instructions_model"],
-				GenerateCodeModelName: defaultConfigFileMap[string(OpenAIService)]["generate_code_model"],
-				AnalysisModelName:     defaultConfigFileMap[string(OpenAIService)]["analysis_model"],
-			},
-			MaxHistoryLength: defaultMaxHistoryLength,
-			MaxProcessLoops:  defaultMaxProcessLoops,
-			RateLimitRPM:     defaultRateLimitRPM,
-			GlamourStylePath: defaultGlamourStyle,
-			LogLevel:         defaultLogLevel,
+	}
+	for _, roleConfig := range c.Roles {
+		add(roleConfig.Service)
+	}
+	for _, providers := range c.Fallbacks {
+		for _, provider := range providers {
+			add(provider.Service)
 		}
+	}
+	return services
+}
 
-		yamlData, err := yaml.Marshal(tempDefaultConfigFile) // Use the temporary struct
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal default config to YAML: %w", err)
-		}
-		_, err = file.Write(yamlData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to write default config to file: %w", err)
+// UsesService reports whether the programming service, one of its roles or one of their fallbacks uses service.
+func (c *Config) UsesService(service LLMServiceType) bool {
+	return slices.Contains(c.services(), service)
+}
+
+// RateLimits returns the rate limits of a service: its own limits where they are set, the global limits otherwise.
+func (c *Config) RateLimits(service LLMServiceType) RateLimitConfig {
+	limits := c.ProviderRateLimits[service]
+	if limits.RequestsPerMinute <= 0 {
+		limits.RequestsPerMinute = c.RateLimitRPM
+	}
+	if limits.TokensPerMinute <= 0 {
+		limits.TokensPerMinute = c.RateLimitTPM
+	}
+	if limits.MaxInFlight <= 0 {
+		limits.MaxInFlight = c.MaxInFlightRequests
+	}
+	return limits
+}
+
+// ConfigFile is a struct for YAML parsing, mirroring Config but suitable for file loading. Every field is a setting
+// of the layered configuration, see LoadLayers.
+type ConfigFile struct {
+	// Profile selects one of Profiles, whose settings override the files and environment variables
+	Profile string `yaml:"profile"`
+	// Profiles maps names to partial configurations, e.g. cheap: {service: groq, max_process_loops: 10}
+	Profiles map[string]yaml.Node `yaml:"profiles,omitempty"`
+	Service  string               `yaml:"service"`
+	// Default model names and API key of every service
+	Gemini                 ServiceConfig `yaml:"gemini"`
+	Groq                   ServiceConfig `yaml:"groq"`
+	OpenAI                 ServiceConfig `yaml:"openai"`
+	MaxHistoryLength       int           `yaml:"max_history_length"`
+	MaxProcessLoops        int           `yaml:"max_process_loops"`
+	RateLimitRPM           int           `yaml:"rate_limit_rpm"`
+	MaxFileSize            int           `yaml:"max_file_size"`
+	FilePreviewLines       int           `yaml:"file_preview_lines"`
+	MaxParallelGenerations int           `yaml:"max_parallel_generations"`
+	RecordSessions         bool          `yaml:"record_sessions"`
+	FakeScript             string        `yaml:"fake_script"`
+	GlamourStylePath       string        `yaml:"glamour_style"`
+	LogLevel               string        `yaml:"log_level"`
+	// Fallbacks maps assistant roles, e.g. code_analysis, to the providers tried when the configured service fails
+	Fallbacks           map[string][]ProviderConfig `yaml:"fallbacks,omitempty"`
+	CircuitBreaker      CircuitBreakerConfig        `yaml:"circuit_breaker"`
+	Retry               RetryConfig                 `yaml:"retry"`
+	Compaction          CompactionConfig            `yaml:"compaction"`
+	RateLimitTPM        int                         `yaml:"rate_limit_tpm"`
+	MaxInFlightRequests int                         `yaml:"max_in_flight_requests"`
+	PersistAskThread    bool                        `yaml:"persist_ask_thread"`
+	AskUserPolicy       AskUserPolicy               `yaml:"ask_user_policy"`
+	Instructions        InstructionsConfig          `yaml:"instructions"`
+	// Roles maps assistant roles, e.g. generate_code, to the provider, model and sampling they use
+	Roles map[string]RoleConfig `yaml:"roles,omitempty"`
+	// ProviderRateLimits maps services to their own rate limits, e.g. groq: {rpm: 30}
+	ProviderRateLimits map[LLMServiceType]RateLimitConfig `yaml:"provider_rate_limits,omitempty"`
+}
+
+// validate returns an error if a numeric setting is out of range.
+func (f *ConfigFile) validate() error {
+	type intSetting struct {
+		key   string
+		value int
+	}
+	for _, s := range []intSetting{
+		{"max_history_length", f.MaxHistoryLength},
+		{"max_process_loops", f.MaxProcessLoops},
+		{"max_parallel_generations", f.MaxParallelGenerations},
+		{"circuit_breaker.failure_threshold", f.CircuitBreaker.FailureThreshold},
+		{"retry.max_attempts", f.Retry.MaxAttempts},
+		{"instructions.max_file_size", f.Instructions.MaxFileSize},
+		{"instructions.max_total_size", f.Instructions.MaxTotalSize},
+	} {
+		if s.value < 1 {
+			return fmt.Errorf("%s %d is out of range, expected a value of at least 1", s.key, s.value)
 		}
+	}
 
-	} else if err == nil {
-		// Config file exists, load values from it
-		yamlFile, err := os.ReadFile(configFilePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read config file: %w", err)
+	// 0 disables these limits
+	limits := []intSetting{
+		{"rate_limit_rpm", f.RateLimitRPM},
+		{"rate_limit_tpm", f.RateLimitTPM},
+		{"max_in_flight_requests", f.MaxInFlightRequests},
+		{"max_file_size", f.MaxFileSize},
+		{"file_preview_lines", f.FilePreviewLines},
+		{"compaction.max_tokens", f.Compaction.MaxTokens},
+	}
+	for service, rateLimits := range f.ProviderRateLimits {
+		limits = append(limits,
+			intSetting{fmt.Sprintf("provider_rate_limits.%s.rpm", service), rateLimits.RequestsPerMinute},
+			intSetting{fmt.Sprintf("provider_rate_limits.%s.tpm", service), rateLimits.TokensPerMinute},
+			intSetting{fmt.Sprintf("provider_rate_limits.%s.max_in_flight", service), rateLimits.MaxInFlight},
+		)
+	}
+	for _, s := range limits {
+		if s.value < 0 {
+			return fmt.Errorf("%s %d is out of range, expected a positive value or 0", s.key, s.value)
 		}
+	}
 
-		var configFile ConfigFile
-		err = yaml.Unmarshal(yamlFile, &configFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal config file: %w", err)
+	for key, value := range map[string]time.Duration{
+		"circuit_breaker.cooldown": f.CircuitBreaker.Cooldown,
+		"retry.initial_delay":      f.Retry.InitialDelay,
+		"retry.max_delay":          f.Retry.MaxDelay,
+	} {
+		if value < 0 {
+			return fmt.Errorf("%s %s is out of range, expected a positive duration or 0", key, value)
 		}
+	}
+	return nil
+}
 
-		// Override values from config file if they are set AND the corresponding flag was not explicitly set (i.e., still has its default value)
+// ServiceConfig holds the default models and the API key of a service.
+type ServiceConfig struct {
+	InstructionsModelName string `yaml:"instructions_model"`
+	GenerateCodeModelName string `yaml:"generate_code_model"`
+	AnalysisModelName     string `yaml:"analysis_model"`
+	APIKey                string `yaml:"api_key,omitempty"`
+	// APIKeyCommand is run by the shell to print the API key when api_key is not set, e.g. pass show gemini
+	APIKeyCommand string `yaml:"api_key_command,omitempty"`
+	// APIKeyFile holds the API key when api_key and api_key_command are not set
+	APIKeyFile string `yaml:"api_key_file,omitempty"`
+}
 
-		// Model names
-		if programmingService == GroqService {
-			if configFile.Groq.InstructionsModelName != "" {
-				cfg.InstructionsModelName = configFile.Groq.InstructionsModelName
-			}
-			if configFile.Groq.GenerateCodeModelName != "" {
-				cfg.GenerateCodeModelName = configFile.Groq.GenerateCodeModelName
-			}
-			if configFile.Groq.AnalysisModelName != "" {
-				cfg.AnalysisModelName = configFile.Groq.AnalysisModelName
-			}
-		}
-		if programmingService == GeminiService {
-			if configFile.Gemini.InstructionsModelName != "" {
-				cfg.InstructionsModelName = configFile.Gemini.InstructionsModelName
-			}
-			if configFile.Gemini.GenerateCodeModelName != "" {
-				cfg.GenerateCodeModelName = configFile.Gemini.GenerateCodeModelName
+// service returns the configuration of a service, or nil for the fake service, which has none.
+func (f *ConfigFile) service(service LLMServiceType) *ServiceConfig {
+	switch service {
+	case GeminiService:
+		return &f.Gemini
+	case GroqService:
+		return &f.Groq
+	case OpenAIService:
+		return &f.OpenAI
+	default:
+		return nil
+	}
+}
+
+// SessionsDir is the directory, relative to the repository, where session transcripts are recorded.
+// Like the other state paths, it is defined by utils, which keeps them out of the commits.
+var SessionsDir = utils.SessionsDir
+
+// RunsDir is the directory, relative to the repository, where change requests are checkpointed.
+var RunsDir = utils.RunsDir
+
+// AskThreadPath is the file, relative to the repository, where the /ask conversation is persisted.
+var AskThreadPath = utils.AskThreadPath
+
+// defaultConfigFileMap holds the default model names of every service, by task.
+var defaultConfigFileMap = map[string]map[string]string{
+	string(GeminiService): {
+		"instructions_model":  "gemini-2.5-flash-preview-04-17",
+		"generate_code_model": "gemini-2.5-flash-preview-04-17",
+		"analysis_model":      "gemini-2.5-flash-preview-04-17",
+	},
+	string(GroqService): {
+		"instructions_model":  "meta-llama/llama-4-maverick-17b-128e-instruct",
+		"generate_code_model": "meta-llama/llama-4-maverick-17b-128e-instruct",
+		"analysis_model":      "meta-llama/llama-4-maverick-17b-128e-instruct",
+	},
+	string(OpenAIService): {
+		"instructions_model":  "gpt-4.5-preview",
+		"generate_code_model": "gpt-4.5-preview",
+		"analysis_model":      "gpt-4.5-preview",
+	},
+	string(FakeService): {
+		"instructions_model":  "fake",
+		"generate_code_model": "fake",
+		"analysis_model":      "fake",
+	},
+}
+
+// DefaultModelNames returns the default instructions, code generation and analysis models of a service.
+func DefaultModelNames(service LLMServiceType) (instructionsModel string, generateCodeModel string, analysisModel string) {
+	models := defaultConfigFileMap[string(service)]
+	return models["instructions_model"], models["generate_code_model"], models["analysis_model"]
+}
+
+// defaultConfigFile returns the built-in defaults of every setting.
+func defaultConfigFile() ConfigFile {
+	file := ConfigFile{
+		Service:                string(GeminiService),
+		MaxHistoryLength:       100,
+		MaxProcessLoops:        25,
+		MaxFileSize:            agentcontext.DefaultMaxFileSize,
+		FilePreviewLines:       agentcontext.DefaultPreviewLines,
+		MaxParallelGenerations: 3,
+		GlamourStylePath:       string(DraculaStyle),
+		LogLevel:               "info",
+		CircuitBreaker:         CircuitBreakerConfig{FailureThreshold: 3, Cooldown: time.Minute},
+		Retry:                  RetryConfig{MaxAttempts: 4, InitialDelay: time.Second, MaxDelay: time.Minute},
+		Compaction:             CompactionConfig{Strategy: TruncateCompaction, MaxTokens: 100000},
+		Instructions:           InstructionsConfig{MaxFileSize: instructions.DefaultMaxFileSize, MaxTotalSize: instructions.DefaultMaxTotalSize},
+	}
+	for _, service := range []LLMServiceType{GeminiService, GroqService, OpenAIService} {
+		serviceConfig := file.service(service)
+		serviceConfig.InstructionsModelName, serviceConfig.GenerateCodeModelName, serviceConfig.AnalysisModelName = DefaultModelNames(service)
+	}
+	return file
+}
+
+// LoadConfig parses the command-line flags and loads the configuration from its layers, see LoadLayers.
+func LoadConfig() (*Config, error) {
+	layers, err := LoadLayers(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := layers.Config()
+	if err != nil {
+		return nil, err
+	}
+	logging.Logger.Infof("Service being used: %s", cfg.ProgrammingService)
+	return cfg, nil
+}
+
+// Config validates the merged settings and returns the configuration of the application.
+func (l *Layers) Config() (*Config, error) {
+	file := l.file
+
+	if err := file.validate(); err != nil {
+		return nil, err
+	}
+
+	// Validate programming service
+	programmingService := LLMServiceType(file.Service)
+	switch programmingService {
+	case GeminiService, GroqService, OpenAIService, FakeService:
+	default:
+		return nil, fmt.Errorf("invalid programming service: %s", file.Service)
+	}
+
+	cfg := &Config{
+		Directory:              l.directory,
+		Profile:                file.Profile,
+		ProgrammingService:     programmingService,
+		GeminiApiKey:           file.Gemini.APIKey,
+		GroqApiKey:             file.Groq.APIKey,
+		OpenaiApiKey:           file.OpenAI.APIKey,
+		RateLimitRPM:           file.RateLimitRPM,
+		RateLimitTPM:           file.RateLimitTPM,
+		MaxInFlightRequests:    file.MaxInFlightRequests,
+		GlamourStylePath:       GlamourStyleType(file.GlamourStylePath),
+		LogLevel:               file.LogLevel,
+		MaxHistoryLength:       file.MaxHistoryLength,
+		MaxProcessLoops:        file.MaxProcessLoops,
+		MaxFileSize:            file.MaxFileSize,
+		FilePreviewLines:       file.FilePreviewLines,
+		MaxParallelGenerations: file.MaxParallelGenerations,
+		RecordSessions:         file.RecordSessions,
+		SessionsDir:            filepath.Join(l.directory, SessionsDir),
+		RunsDir:                filepath.Join(l.directory, RunsDir),
+		ResumeRun:              l.resumeRun,
+		ReplaySession:          l.replaySession,
+		ReplayStrict:           l.replayStrict,
+		FakeScript:             file.FakeScript,
+		Fallbacks:              file.Fallbacks,
+		CircuitBreaker:         file.CircuitBreaker,
+		Retry:                  file.Retry,
+		Compaction:             file.Compaction,
+		PersistAskThread:       file.PersistAskThread,
+		AskThreadPath:          filepath.Join(l.directory, AskThreadPath),
+		AskUserPolicy:          file.AskUserPolicy,
+		Instructions:           file.Instructions,
+		Roles:                  file.Roles,
+		ProviderRateLimits:     file.ProviderRateLimits,
+	}
+	if serviceConfig := file.service(programmingService); serviceConfig != nil {
+		cfg.InstructionsModelName = serviceConfig.InstructionsModelName
+		cfg.GenerateCodeModelName = serviceConfig.GenerateCodeModelName
+		cfg.AnalysisModelName = serviceConfig.AnalysisModelName
+	} else {
+		cfg.InstructionsModelName, cfg.GenerateCodeModelName, cfg.AnalysisModelName = DefaultModelNames(programmingService)
+	}
+
+	// Get the API keys of the services used from their commands or files; replayed sessions never call the services
+	replaySession := cfg.ReplaySession
+	if replaySession == "" {
+		for _, service := range cfg.services() {
+			serviceConfig := file.service(service)
+			if serviceConfig == nil {
+				continue
 			}
-			if configFile.Gemini.AnalysisModelName != "" {
-				cfg.AnalysisModelName = configFile.Gemini.AnalysisModelName
+			apiKey, err := serviceConfig.resolveAPIKey(service)
+			if err != nil {
+				return nil, err
 			}
+			cfg.setAPIKey(service, apiKey)
 		}
-		if programmingService == OpenAIService {
-			if configFile.OpenAI.InstructionsModelName != "" {
-				cfg.InstructionsModelName = configFile.OpenAI.InstructionsModelName
-			}
-			if configFile.OpenAI.GenerateCodeModelName != "" { // Corrected field name
-				cfg.GenerateCodeModelName = configFile.OpenAI.GenerateCodeModelName
+	}
+
+	// Check for API key if required service is selected
+	if apiKey, _ := cfg.APIKey(programmingService); apiKey == "" && programmingService != FakeService && replaySession == "" {
+		return nil, fmt.Errorf("no API key set for the %s programming service, set %s", programmingService, apiKeySources(programmingService))
+	}
+
+	// The fake service cannot answer without a script
+	if programmingService == FakeService && cfg.FakeScript == "" && cfg.ReplaySession == "" {
+		return nil, fmt.Errorf("fake-script flag or fake_script config value not set for fake programming service")
+	}
+
+	// Validate the fallback providers, which need their API keys like the configured service
+	for role, providers := range cfg.Fallbacks {
+		for _, provider := range providers {
+			apiKey, ok := cfg.APIKey(provider.Service)
+			if !ok {
+				return nil, fmt.Errorf("invalid service %q in the fallbacks of %s", provider.Service, role)
 			}
-			if configFile.OpenAI.AnalysisModelName != "" {
-				cfg.AnalysisModelName = configFile.OpenAI.AnalysisModelName
+			if apiKey == "" && provider.Service != FakeService && replaySession == "" {
+				return nil, fmt.Errorf("no API key set for %s, which is a fallback provider of %s", provider.Service, role)
 			}
 		}
+	}
 
-		// MaxHistoryLength: Override if set in file AND flag is default
-		if configFile.MaxHistoryLength != 0 && *maxHistoryLengthFlag == defaultMaxHistoryLength {
-			cfg.MaxHistoryLength = configFile.MaxHistoryLength
+	// Validate the sampling of the roles, and their providers, which need their API keys like the configured service
+	for role, roleConfig := range cfg.Roles {
+		if err := roleConfig.validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration of the role %s: %w", role, err)
 		}
-
-		// MaxProcessLoops: Override if set in file AND flag is default
-		if configFile.MaxProcessLoops != 0 && *maxProcessLoopsFlag == defaultMaxProcessLoops {
-			cfg.MaxProcessLoops = configFile.MaxProcessLoops
+		if roleConfig.Service == "" {
+			continue
 		}
-
-		// RateLimitRPM: Override if set in file (> 0) AND flag is default (0)
-		if configFile.RateLimitRPM > 0 && *rateLimitRPMFlag == defaultRateLimitRPM {
-			cfg.RateLimitRPM = configFile.RateLimitRPM
+		apiKey, ok := cfg.APIKey(roleConfig.Service)
+		if !ok {
+			return nil, fmt.Errorf("invalid service %q for the role %s", roleConfig.Service, role)
 		}
-
-		// GlamourStylePath: Override if set in file AND flag is default
-		if configFile.GlamourStylePath != "" && *glamourStyleFlag == defaultGlamourStyle {
-			cfg.GlamourStylePath = GlamourStyleType(configFile.GlamourStylePath)
+		if apiKey == "" && roleConfig.Service != FakeService && replaySession == "" {
+			return nil, fmt.Errorf("no API key set for %s, which is the service of %s", roleConfig.Service, role)
 		}
-
-		// LogLevel: Override if set in file AND flag is default
-		if configFile.LogLevel != "" && *logLevelFlag == defaultLogLevel {
-			cfg.LogLevel = configFile.LogLevel
+	}
+	for service := range cfg.ProviderRateLimits {
+		if _, ok := cfg.APIKey(service); !ok {
+			return nil, fmt.Errorf("invalid service %q in provider_rate_limits", service)
 		}
 	}
 
-	// Set default model names if still empty after checking file
-	if cfg.InstructionsModelName == "" {
-		cfg.InstructionsModelName = defaultConfigFileMap[string(programmingService)]["instructions_model"]
-	}
-	if cfg.GenerateCodeModelName == "" {
-		cfg.GenerateCodeModelName = defaultConfigFileMap[string(programmingService)]["generate_code_model"]
+	// Validate the compaction strategy
+	switch cfg.Compaction.Strategy {
+	case SummarizeCompaction, TruncateCompaction:
+	default:
+		return nil, fmt.Errorf("invalid compaction strategy: %s, allowed strategies are %s, %s", cfg.Compaction.Strategy, SummarizeCompaction, TruncateCompaction)
 	}
-	if cfg.AnalysisModelName == "" {
-		cfg.AnalysisModelName = defaultConfigFileMap[string(programmingService)]["analysis_model"]
+
+	// Validate the ask_user policy
+	switch cfg.AskUserPolicy {
+	case "", BestJudgementPolicy, FirstOptionPolicy, FailPolicy:
+	default:
+		return nil, fmt.Errorf("invalid ask_user policy: %s, allowed policies are %s, %s, %s", cfg.AskUserPolicy, BestJudgementPolicy, FirstOptionPolicy, FailPolicy)
 	}
 
-	// Validate Glamour style after potential override
-	var finalGlamourStyle GlamourStyleType
+	// Validate Glamour style
 	switch cfg.GlamourStylePath {
 	case AsciiStyle, AutoStyle, DarkStyle, DraculaStyle, TokyoNightStyle, LightStyle, NottyStyle, PinkStyle:
-		finalGlamourStyle = cfg.GlamourStylePath
 	default:
 		return nil, fmt.Errorf("invalid glamour style: %s, allowed styles are %s, %s, %s, %s, %s, %s, %s, %s", cfg.GlamourStylePath, AsciiStyle, AutoStyle, DarkStyle, DraculaStyle, TokyoNightStyle, LightStyle, NottyStyle, PinkStyle)
 	}
-	cfg.GlamourStylePath = finalGlamourStyle // Assign validated style
 
-	// Validate Log Level after potential override
+	// Validate Log Level
 	switch cfg.LogLevel {
 	case "debug", "info", "warning", "error":
 		// Valid log level
@@ -343,3 +597,9 @@ func LoadConfig() (*Config, error) {
 
 	return cfg, nil
 }
+
+// apiKeySources lists the ways of setting the API key of a service.
+func apiKeySources(service LLMServiceType) string {
+	return fmt.Sprintf("the -%s-api-key flag, the %s_API_KEY or %s environment variable, or %s.api_key, %s.api_key_command or %s.api_key_file in the user config",
+		service, strings.ToUpper(string(service)), envVariable(string(service)+".api_key"), service, service, service)
+}
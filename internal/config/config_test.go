@@ -3,6 +3,7 @@ package config_test
 import (
 	"flag"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -136,3 +137,93 @@ func TestLoadConfig_RateLimitRPMFromFlag(t *testing.T) {
 	cfg, _ := config.LoadConfig()
 	require.Equal(t, 100, cfg.RateLimitRPM, "RateLimitRPM should be loaded from flag")
 }
+
+// loadConfigFile loads the configuration with the given arguments from a temporary working directory
+// holding a config file with content.
+func loadConfigFile(t *testing.T, content string, args ...string) (*config.Config, error) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = append([]string{"agent"}, args...)
+	workingDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer func() {
+		os.Args = os.Args[:1]
+		require.NoError(t, os.Chdir(workingDir))
+	}()
+	require.NoError(t, os.MkdirAll(".go-agent", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(".go-agent", "config.yaml"), []byte(content), 0644))
+	return config.LoadConfig()
+}
+
+func TestLoadConfig_Roles(t *testing.T) {
+	configFile := `
+roles:
+  code_instruction:
+    service: groq
+    temperature: 0.1
+  generate_code:
+    model: gemini-2.5-pro
+    top_k: 40
+    max_history_length: 20
+    max_output_tokens: 32768
+    stop: ["<END>"]
+    seed: 7
+  code_analysis:
+    reasoning_effort: high
+provider_rate_limits:
+  groq:
+    rpm: 30
+`
+	_, err := loadConfigFile(t, configFile, "-gemini-api-key", "test-gemini-key")
+	require.ErrorContains(t, err, "no API key set for groq, which is the service of code_instruction")
+
+	cfg, err := loadConfigFile(t, configFile, "-gemini-api-key", "test-gemini-key", "-groq-api-key", "test-groq-key")
+	require.NoError(t, err)
+	require.Equal(t, config.GroqService, cfg.Roles["code_instruction"].Service)
+	require.InDelta(t, 0.1, *cfg.Roles["code_instruction"].Temperature, 1e-6)
+	generateCode := cfg.Roles["generate_code"]
+	require.Equal(t, "gemini-2.5-pro", generateCode.Model)
+	require.Equal(t, 40, *generateCode.TopK)
+	require.Equal(t, 20, generateCode.MaxHistoryLength)
+	require.Equal(t, 32768, generateCode.MaxOutputTokens)
+	require.Equal(t, []string{"<END>"}, generateCode.Stop)
+	require.Equal(t, 7, *generateCode.Seed)
+	require.Equal(t, config.HighReasoningEffort, cfg.Roles["code_analysis"].ReasoningEffort)
+	require.Equal(t, 30, cfg.RateLimits(config.GroqService).RequestsPerMinute)
+}
+
+func TestLoadConfig_InvalidRoleSampling(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		wantErr string
+	}{
+		{"temperature", "temperature: 2.5", "temperature 2.5 is out of range"},
+		{"top_p", "top_p: 0", "top_p 0 is out of range"},
+		{"top_k", "top_k: 0", "top_k 0 is out of range"},
+		{"max_output_tokens", "max_output_tokens: -1", "max_output_tokens -1 is out of range"},
+		{"too many stop sequences", "stop: [a, b, c, d, e]", "5 stop sequences are set, at most 4 are supported"},
+		{"empty stop sequence", `stop: [""]`, "stop sequences cannot be empty"},
+		{"reasoning_effort", "reasoning_effort: extreme", "invalid reasoning_effort: extreme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfigFile(t, "roles:\n  generate_code:\n    "+tt.role+"\n", "-gemini-api-key", "test-gemini-key")
+			require.ErrorContains(t, err, "invalid configuration of the role generate_code: "+tt.wantErr)
+		})
+	}
+}
+
+func TestConfig_RateLimits(t *testing.T) {
+	cfg := &config.Config{
+		RateLimitRPM: 10,
+		RateLimitTPM: 1000,
+		ProviderRateLimits: map[config.LLMServiceType]config.RateLimitConfig{
+			config.GroqService: {RequestsPerMinute: 30, MaxInFlight: 2},
+		},
+	}
+	require.Equal(t, config.RateLimitConfig{RequestsPerMinute: 30, TokensPerMinute: 1000, MaxInFlight: 2}, cfg.RateLimits(config.GroqService))
+	require.Equal(t, config.RateLimitConfig{RequestsPerMinute: 10, TokensPerMinute: 1000}, cfg.RateLimits(config.GeminiService))
+}
@@ -4,9 +4,11 @@ package config
 type LLMServiceType string
 
 const (
-	GeminiService  LLMServiceType = "gemini"
+	GeminiService LLMServiceType = "gemini"
 	GroqService   LLMServiceType = "groq"
 	OpenAIService LLMServiceType = "openai"
+	// FakeService serves canned responses from a script instead of calling an LLM, for offline end-to-end tests.
+	FakeService LLMServiceType = "fake"
 )
 
 // GlamourStyleType represents the type of Glamour style to use.
@@ -22,3 +24,35 @@ const (
 	NottyStyle      GlamourStyleType = "notty"
 	PinkStyle       GlamourStyleType = "pink"
 )
+
+// CompactionStrategy represents how LLM session histories over their budget are compacted.
+type CompactionStrategy string
+
+const (
+	// SummarizeCompaction replaces the oldest turns with a summary written by the summary model.
+	SummarizeCompaction CompactionStrategy = "summarize"
+	// TruncateCompaction drops the oldest turns.
+	TruncateCompaction CompactionStrategy = "truncate"
+)
+
+// AskUserPolicy represents how the questions the agent asks the user are answered when no user is available. The empty
+// policy asks the user, as in interactive sessions.
+type AskUserPolicy string
+
+const (
+	// BestJudgementPolicy tells the agent to proceed with its best judgement and state the assumptions it made.
+	BestJudgementPolicy AskUserPolicy = "best_judgement"
+	// FirstOptionPolicy answers with the first of the suggested answers, or as BestJudgementPolicy if there are none.
+	FirstOptionPolicy AskUserPolicy = "first_option"
+	// FailPolicy stops the request.
+	FailPolicy AskUserPolicy = "fail"
+)
+
+// ReasoningEffort represents how much reasoning models think before responding.
+type ReasoningEffort string
+
+const (
+	LowReasoningEffort    ReasoningEffort = "low"
+	MediumReasoningEffort ReasoningEffort = "medium"
+	HighReasoningEffort   ReasoningEffort = "high"
+)
@@ -0,0 +1,468 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UserConfigFile is the path of the user configuration, relative to $XDG_CONFIG_HOME, or ~/.config if it is not set.
+var UserConfigFile = filepath.Join("go-agent", "config.yaml")
+
+// ProjectConfigFile is the path of the project configuration, relative to the repository.
+var ProjectConfigFile = filepath.Join(".go-agent", "config.yaml")
+
+const (
+	// envPrefix prefixes the environment variables of the settings, e.g. GOAGENT_RETRY_MAX_ATTEMPTS sets retry.max_attempts.
+	envPrefix = "GOAGENT_"
+	// directoryKey is the key of the repository directory, which selects the project configuration and is not read from files.
+	directoryKey = "directory"
+	// profileKey is the key of the selected profile, and profilesKey the key of the profiles that can be selected.
+	profileKey  = "profile"
+	profilesKey = "profiles"
+	// OriginDefault is the origin of the settings that keep their built-in default.
+	OriginDefault = "default"
+	// OriginSelected is the origin of the settings selected with Layers.WithProfile and Layers.WithSetting.
+	OriginSelected = "selected"
+)
+
+// setting is a value of the configuration file that can be set by every layer.
+type setting struct {
+	key   string // Dotted path of the value in the configuration file, e.g. retry.max_attempts
+	index []int  // Index of the field in ConfigFile
+}
+
+// settings lists every setting, in the order of the fields of ConfigFile.
+var settings = fileSettings(reflect.TypeOf(ConfigFile{}), "", nil)
+
+// fileSettings returns the settings of the struct type t, whose key starts with prefix and whose index starts with index.
+// Nested structs hold settings of their own, any other field is a single setting.
+func fileSettings(t reflect.Type, prefix string, index []int) []setting {
+	var result []setting
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		if field.Type.Kind() == reflect.Struct {
+			result = append(result, fileSettings(field.Type, prefix+name+".", fieldIndex)...)
+			continue
+		}
+		result = append(result, setting{key: prefix + name, index: fieldIndex})
+	}
+	return result
+}
+
+// legacyEnvVariables maps the environment variables read before GOAGENT_* variables existed to their settings.
+// The GOAGENT_* variables take precedence over them.
+var legacyEnvVariables = []struct{ name, key string }{
+	{"GEMINI_API_KEY", "gemini.api_key"},
+	{"GROQ_API_KEY", "groq.api_key"},
+	{"OPENAI_API_KEY", "openai.api_key"},
+}
+
+// Setting is the effective value of a setting and where it came from.
+type Setting struct {
+	Key    string // Dotted path of the setting in the configuration file, e.g. retry.max_attempts
+	Value  string // Formatted value, with API keys redacted
+	Origin string // OriginDefault, "user config <path>", "project config <path>", "env <variable>", "profile <name>", "flag -<name>" or OriginSelected
+}
+
+// Layers holds the settings merged from the layers of the configuration.
+type Layers struct {
+	file          ConfigFile
+	origins       map[string]string // Origin of every setting that does not keep its default
+	directory     string
+	resumeRun     string
+	replaySession string
+	replayStrict  bool
+	flagValues    []layerValue // Values of the setting flags that were set and of WithSetting, applied over the other layers
+	profile       string       // Profile selected with WithProfile, over the other layers
+}
+
+// layerValue is a value of a setting given by a layer.
+type layerValue struct {
+	key    string
+	value  string
+	origin string
+}
+
+// settingFlag is a command-line flag setting a configuration value. It is applied after the other layers.
+type settingFlag struct {
+	key    string
+	value  string
+	isBool bool
+}
+
+func (f *settingFlag) String() string     { return f.value }
+func (f *settingFlag) Set(v string) error { f.value = v; return nil }
+func (f *settingFlag) IsBoolFlag() bool   { return f.isBool }
+
+// LoadLayers parses the command-line flags in args with flags and merges the configuration from its layers,
+// from the lowest precedence to the highest:
+//  1. the built-in defaults
+//  2. the user config, $XDG_CONFIG_HOME/go-agent/config.yaml
+//  3. the project config, .go-agent/config.yaml in the repository directory
+//  4. GOAGENT_* environment variables, e.g. GOAGENT_LOG_LEVEL for log_level, holding YAML values for lists and maps
+//  5. the selected profile, one of the partial configurations in profiles
+//  6. the command-line flags
+func LoadLayers(flags *flag.FlagSet, args []string) (*Layers, error) {
+	settingFlags := map[string]*settingFlag{}
+	addFlag := func(name string, key string, isBool bool, usage string) {
+		settingFlags[name] = &settingFlag{key: key, isBool: isBool}
+		flags.Var(settingFlags[name], name, usage)
+	}
+	directoryFlag := flags.String("directory", "", "Sets the root directory of your Git repository. Defaults to the current working directory if not provided. Must be a Git repository.")
+	addFlag("profile", profileKey, false, "Selects a profile of the configuration, which overrides the settings of the files and environment variables.")
+	addFlag("service", "service", false, fmt.Sprintf("Sets the programming service to use (%s, %s, %s, %s). Defaults to %s.", GeminiService, GroqService, OpenAIService, FakeService, GeminiService))
+	addFlag("gemini-api-key", "gemini.api_key", false, "Sets the Gemini API key. Required when using the Gemini service.")
+	addFlag("groq-api-key", "groq.api_key", false, "Sets the Groq API key. Required when using the Groq service.")
+	addFlag("openai-api-key", "openai.api_key", false, "Sets the OpenAI API key. Required when using the OpenAI service.")
+	addFlag("rate-limit", "rate_limit_rpm", false, "Sets the rate limit for API requests per minute. Prevents exceeding API usage limits. Defaults to 0, which means no rate limit.")
+	addFlag("glamour-style", "glamour_style", false, fmt.Sprintf("Sets the Glamour style for Markdown rendering in the terminal. Defaults to %s.", DraculaStyle))
+	addFlag("log-level", "log_level", false, "Sets the logging level. Allowed values are: debug, info, warning, error. Defaults to info.")
+	addFlag("max-history-length", "max_history_length", false, "Sets the maximum history length, in turns, for LLM sessions. Longer histories are compacted. Defaults to 100.")
+	addFlag("max-process-loops", "max_process_loops", false, "Sets the maximum number of process loops. Defaults to 25.")
+	addFlag("record-sessions", "record_sessions", true, fmt.Sprintf("Records every LLM request and response to a transcript in %s.", SessionsDir))
+	addFlag("fake-script", "fake_script", false, "Sets the script of canned responses served by the fake service. Required when using the fake service.")
+	resumeFlag := flags.String("resume", "", fmt.Sprintf("Resumes the interrupted change request of the given run id (a directory in %s) from its last checkpoint.", RunsDir))
+	replayFlag := flags.String("replay", "", fmt.Sprintf("Replays the LLM responses of a recorded transcript, given by id (a file in %s) or path, instead of calling the LLM service.", SessionsDir))
+	replayStrictFlag := flags.Bool("replay-strict", false, "Fails replayed requests that differ from every recorded request, instead of serving the next recorded response.")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	layers := &Layers{
+		origins:       make(map[string]string),
+		resumeRun:     *resumeFlag,
+		replaySession: *replayFlag,
+		replayStrict:  *replayStrictFlag,
+	}
+
+	// The directory selects the project config, so it is resolved first
+	switch {
+	case *directoryFlag != "":
+		layers.directory = *directoryFlag
+		layers.origins[directoryKey] = "flag -directory"
+	case os.Getenv(envVariable(directoryKey)) != "":
+		layers.directory = os.Getenv(envVariable(directoryKey))
+		layers.origins[directoryKey] = "env " + envVariable(directoryKey)
+	default:
+		directory, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current working directory: %w", err)
+		}
+		layers.directory = directory
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		if settingFlag, ok := settingFlags[f.Name]; ok {
+			layers.flagValues = append(layers.flagValues, layerValue{key: settingFlag.key, value: settingFlag.value, origin: "flag -" + f.Name})
+		}
+	})
+	if err := layers.merge(); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+// WithProfile returns the configuration merged again from its layers with the profile name selected, over the profile
+// selected by the files, the environment variables and the -profile flag. The other flags keep their precedence.
+func (l *Layers) WithProfile(name string) (*Layers, error) {
+	layers := l.clone()
+	layers.profile = name
+	if err := layers.merge(); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+// WithSetting returns the configuration merged again from its layers with the setting key set to value, over every
+// other layer, e.g. to switch models in the REPL.
+func (l *Layers) WithSetting(key string, value string) (*Layers, error) {
+	layers := l.clone()
+	layers.flagValues = append(layers.flagValues, layerValue{key: key, value: value, origin: OriginSelected})
+	if err := layers.merge(); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+// clone returns layers to be merged again with the sources of l.
+func (l *Layers) clone() *Layers {
+	layers := &Layers{
+		origins:       make(map[string]string),
+		directory:     l.directory,
+		resumeRun:     l.resumeRun,
+		replaySession: l.replaySession,
+		replayStrict:  l.replayStrict,
+		flagValues:    slices.Clone(l.flagValues),
+		profile:       l.profile,
+	}
+	if origin, ok := l.origins[directoryKey]; ok {
+		layers.origins[directoryKey] = origin
+	}
+	return layers
+}
+
+// merge merges the settings of every layer over the built-in defaults.
+func (l *Layers) merge() error {
+	l.file = defaultConfigFile()
+	if userConfigPath := userConfigPath(); userConfigPath != "" {
+		if err := l.loadFile(userConfigPath, "user config "+userConfigPath, false); err != nil {
+			return err
+		}
+	}
+	projectConfigPath := filepath.Join(l.directory, ProjectConfigFile)
+	if err := l.loadFile(projectConfigPath, "project config "+projectConfigPath, true); err != nil {
+		return err
+	}
+
+	for _, variable := range legacyEnvVariables {
+		if value := os.Getenv(variable.name); value != "" {
+			if err := l.set(variable.key, value, "env "+variable.name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range settings {
+		if value := os.Getenv(envVariable(s.key)); value != "" {
+			if err := l.set(s.key, value, "env "+envVariable(s.key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The profile is selected before its values are applied, which the other flags override
+	for _, flagValue := range l.flagValues {
+		if flagValue.key == profileKey {
+			if err := l.set(flagValue.key, flagValue.value, flagValue.origin); err != nil {
+				return err
+			}
+		}
+	}
+	if l.profile != "" {
+		if err := l.set(profileKey, l.profile, OriginSelected); err != nil {
+			return err
+		}
+	}
+	if err := l.applyProfile(); err != nil {
+		return err
+	}
+	for _, flagValue := range l.flagValues {
+		if flagValue.key != profileKey {
+			if err := l.set(flagValue.key, flagValue.value, flagValue.origin); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyProfile merges the settings of the selected profile, if any, into the layers.
+func (l *Layers) applyProfile() error {
+	name := l.file.Profile
+	if name == "" {
+		return nil
+	}
+	profile, ok := l.file.Profiles[name]
+	if !ok {
+		if len(l.file.Profiles) == 0 {
+			return fmt.Errorf("unknown profile %q, no profiles are configured", name)
+		}
+		return fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(l.ProfileNames(), ", "))
+	}
+	if profile.Kind != yaml.MappingNode {
+		return fmt.Errorf("profile %s must be a mapping of settings", name)
+	}
+	for _, key := range []string{profileKey, profilesKey} {
+		if hasKey(&profile, []string{key}) {
+			return fmt.Errorf("profile %s cannot set %s", name, key)
+		}
+	}
+	if err := l.decode(&profile, "profile "+name); err != nil {
+		return fmt.Errorf("failed to apply profile %s: %w", name, err)
+	}
+	return nil
+}
+
+// ProfileNames returns the names of the configured profiles, sorted.
+func (l *Layers) ProfileNames() []string {
+	names := make([]string, 0, len(l.file.Profiles))
+	for name := range l.file.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// userConfigPath returns the path of the user config, or an empty path if the home directory is unknown.
+func userConfigPath() string {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, UserConfigFile)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", UserConfigFile)
+}
+
+// envVariable returns the environment variable of the setting key.
+func envVariable(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// loadFile merges the settings of the config file at path, if it exists, into the layers. isProject is set for the
+// project config, which lives in the repository.
+func (l *Layers) loadFile(path string, origin string, isProject bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return fmt.Errorf("failed to unmarshal config file %s: %w", path, err)
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+	// A committed key is readable by everyone with access to the repository. The project config is committed along
+	// with the changes of the agent, so it cannot hold keys even before it is tracked.
+	if keys := keySettings(document.Content[0], "", "api_key"); len(keys) > 0 && (isProject || isTracked(path)) {
+		reason := "is tracked by git"
+		if isProject {
+			reason = "is in the repository"
+		}
+		return fmt.Errorf("config file %s %s and holds the API key %s: move it to the user config or an environment variable", path, reason, strings.Join(keys, ", "))
+	}
+	// Everyone who can commit to the repository controls the project config, so it cannot choose the command run
+	// at startup nor the file read for a key.
+	if sources := keySettings(document.Content[0], "", "api_key_command", "api_key_file"); len(sources) > 0 && isProject {
+		return fmt.Errorf("config file %s is in the repository and sets %s: move it to the user config", path, strings.Join(sources, ", "))
+	}
+	if err := l.decode(document.Content[0], origin); err != nil {
+		return fmt.Errorf("failed to unmarshal config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// decode merges the settings of the YAML mapping node, which came from origin, into the layers.
+func (l *Layers) decode(node *yaml.Node, origin string) error {
+	if err := node.Decode(&l.file); err != nil {
+		return err
+	}
+	for _, s := range settings {
+		if hasKey(node, strings.Split(s.key, ".")) {
+			l.origins[s.key] = origin
+		}
+	}
+	return nil
+}
+
+// hasKey reports whether the YAML mapping node holds the value at path.
+func hasKey(node *yaml.Node, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == path[0] {
+			return hasKey(node.Content[i+1], path[1:])
+		}
+	}
+	return false
+}
+
+// set sets the setting key to value, which is parsed as YAML unless the setting is a string.
+func (l *Layers) set(key string, value string, origin string) error {
+	for _, s := range settings {
+		if s.key != key {
+			continue
+		}
+		field := reflect.ValueOf(&l.file).Elem().FieldByIndex(s.index)
+		if field.Kind() == reflect.String {
+			field.SetString(value)
+		} else if err := yaml.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+			return fmt.Errorf("invalid value %q of %s set by %s: %w", value, key, origin, err)
+		}
+		l.origins[key] = origin
+		return nil
+	}
+	return fmt.Errorf("unknown setting %s", key)
+}
+
+// Settings returns the effective value of every setting and where it came from.
+func (l *Layers) Settings() []Setting {
+	result := []Setting{{Key: directoryKey, Value: l.directory, Origin: l.origin(directoryKey)}}
+	for _, s := range settings {
+		field := reflect.ValueOf(l.file).FieldByIndex(s.index)
+		result = append(result, Setting{Key: s.key, Value: formatValue(s.key, field), Origin: l.origin(s.key)})
+	}
+	return result
+}
+
+// origin returns where the value of the setting key came from.
+func (l *Layers) origin(key string) string {
+	if origin, ok := l.origins[key]; ok {
+		return origin
+	}
+	return OriginDefault
+}
+
+// formatValue formats the value of a setting for display, redacting API keys. Profiles, which may hold API keys,
+// are listed by name.
+func formatValue(key string, value reflect.Value) string {
+	if profiles, ok := value.Interface().(map[string]yaml.Node); ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return "[" + strings.Join(names, ", ") + "]"
+	}
+	if strings.HasSuffix(key, "api_key") {
+		if value.String() == "" {
+			return `""`
+		}
+		return "<redacted>"
+	}
+	switch v := value.Interface().(type) {
+	case time.Duration:
+		return v.String()
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	}
+	if kind := value.Kind(); kind != reflect.Map && kind != reflect.Slice {
+		return fmt.Sprint(value.Interface())
+	}
+	var node yaml.Node
+	if err := node.Encode(value.Interface()); err != nil {
+		return fmt.Sprint(value.Interface())
+	}
+	node.Style = yaml.FlowStyle
+	data, err := yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Sprint(value.Interface())
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,255 @@
+package config_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/config"
+)
+
+// writeConfig writes a config file at path, creating its directory.
+func writeConfig(t *testing.T, path string, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+// origins maps the key of every setting to its origin.
+func origins(layers *config.Layers) map[string]string {
+	result := make(map[string]string)
+	for _, setting := range layers.Settings() {
+		result[setting.Key] = setting.Origin
+	}
+	return result
+}
+
+func TestLoadLayers_Precedence(t *testing.T) {
+	configHome, repo := t.TempDir(), t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("GEMINI_API_KEY", "")
+	userConfig := filepath.Join(configHome, config.UserConfigFile)
+	writeConfig(t, userConfig, `
+gemini:
+  api_key: user-gemini-key
+log_level: debug
+max_process_loops: 10
+retry:
+  max_attempts: 6
+`)
+	projectConfig := filepath.Join(repo, config.ProjectConfigFile)
+	writeConfig(t, projectConfig, `
+log_level: warning
+retry:
+  max_delay: 2m
+`)
+	t.Setenv("GOAGENT_MAX_PROCESS_LOOPS", "12")
+	t.Setenv("GOAGENT_RETRY_INITIAL_DELAY", "5s")
+	t.Setenv("GOAGENT_ROLES", "{generate_code: {service: gemini, max_output_tokens: 32768}}")
+
+	layers, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", repo, "-log-level", "error"})
+	require.NoError(t, err)
+	cfg, err := layers.Config()
+	require.NoError(t, err)
+
+	assert.Equal(t, repo, cfg.Directory)
+	assert.Equal(t, filepath.Join(repo, config.RunsDir), cfg.RunsDir)
+	assert.Equal(t, filepath.Join(repo, config.SessionsDir), cfg.SessionsDir)
+	assert.Equal(t, filepath.Join(repo, config.AskThreadPath), cfg.AskThreadPath)
+	assert.Equal(t, "user-gemini-key", cfg.GeminiApiKey)
+	assert.Equal(t, "error", cfg.LogLevel)
+	assert.Equal(t, 12, cfg.MaxProcessLoops)
+	assert.Equal(t, config.RetryConfig{MaxAttempts: 6, InitialDelay: 5 * time.Second, MaxDelay: 2 * time.Minute}, cfg.Retry)
+	assert.Equal(t, 32768, cfg.Roles["generate_code"].MaxOutputTokens)
+	assert.Equal(t, 100, cfg.MaxHistoryLength)
+	assert.Equal(t, config.TruncateCompaction, cfg.Compaction.Strategy, "histories are not summarized unless configured")
+
+	origin := origins(layers)
+	assert.Equal(t, "flag -directory", origin["directory"])
+	assert.Equal(t, "user config "+userConfig, origin["gemini.api_key"])
+	assert.Equal(t, "user config "+userConfig, origin["retry.max_attempts"])
+	assert.Equal(t, "project config "+projectConfig, origin["retry.max_delay"])
+	assert.Equal(t, "env GOAGENT_RETRY_INITIAL_DELAY", origin["retry.initial_delay"])
+	assert.Equal(t, "env GOAGENT_MAX_PROCESS_LOOPS", origin["max_process_loops"])
+	assert.Equal(t, "env GOAGENT_ROLES", origin["roles"])
+	assert.Equal(t, "flag -log-level", origin["log_level"])
+	assert.Equal(t, config.OriginDefault, origin["max_history_length"])
+}
+
+func TestLayers_Settings(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GOAGENT_FALLBACKS", "{generate_code: [{service: groq}]}")
+	layers, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", t.TempDir(), "-groq-api-key", "secret-key"})
+	require.NoError(t, err)
+
+	values := make(map[string]string)
+	for _, setting := range layers.Settings() {
+		values[setting.Key] = setting.Value
+	}
+	assert.Equal(t, "<redacted>", values["groq.api_key"])
+	assert.Equal(t, `""`, values["openai.api_key"])
+	assert.Equal(t, "1m0s", values["retry.max_delay"])
+	assert.Equal(t, "{generate_code: [{service: groq, model: \"\"}]}", values["fallbacks"])
+	assert.Equal(t, "gemini", values["service"])
+}
+
+func TestLoadLayers_InvalidValues(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GOAGENT_MAX_PROCESS_LOOPS", "many")
+	_, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", t.TempDir()})
+	assert.ErrorContains(t, err, `invalid value "many" of max_process_loops set by env GOAGENT_MAX_PROCESS_LOOPS`)
+
+	os.Unsetenv("GOAGENT_MAX_PROCESS_LOOPS")
+	repo := t.TempDir()
+	writeConfig(t, filepath.Join(repo, config.ProjectConfigFile), "retry: [1, 2]")
+	_, err = config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", repo})
+	assert.ErrorContains(t, err, "failed to unmarshal config file")
+}
+
+func TestLayers_ConfigOutOfRange(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GEMINI_API_KEY", "test-key")
+	for _, tc := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-max-process-loops", "0"}, "max_process_loops 0 is out of range, expected a value of at least 1"},
+		{[]string{"-max-history-length", "-5"}, "max_history_length -5 is out of range"},
+		{[]string{"-rate-limit", "-1"}, "rate_limit_rpm -1 is out of range, expected a positive value or 0"},
+	} {
+		args := append([]string{"-directory", t.TempDir()}, tc.args...)
+		layers, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), args)
+		require.NoError(t, err)
+		_, err = layers.Config()
+		assert.ErrorContains(t, err, tc.err)
+	}
+
+	t.Setenv("GOAGENT_MAX_PARALLEL_GENERATIONS", "-2")
+	layers, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", t.TempDir()})
+	require.NoError(t, err)
+	_, err = layers.Config()
+	assert.ErrorContains(t, err, "max_parallel_generations -2 is out of range")
+}
+
+func TestLayers_ConfigNoFileSizeLimit(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GEMINI_API_KEY", "test-key")
+	t.Setenv("GOAGENT_MAX_FILE_SIZE", "0")
+	layers, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", t.TempDir()})
+	require.NoError(t, err)
+	cfg, err := layers.Config()
+	require.NoError(t, err)
+	assert.Equal(t, 0, cfg.MaxFileSize, "0 disables the limit")
+}
+
+func TestLayers_ConfigAskUserPolicy(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GEMINI_API_KEY", "test-key")
+	load := func() (*config.Config, error) {
+		layers, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", t.TempDir()})
+		require.NoError(t, err)
+		return layers.Config()
+	}
+
+	cfg, err := load()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.AskUserPolicy, "the user is asked by default")
+
+	t.Setenv("GOAGENT_ASK_USER_POLICY", "fail")
+	cfg, err = load()
+	require.NoError(t, err)
+	assert.Equal(t, config.FailPolicy, cfg.AskUserPolicy)
+
+	t.Setenv("GOAGENT_ASK_USER_POLICY", "guess")
+	_, err = load()
+	assert.ErrorContains(t, err, "invalid ask_user policy: guess")
+}
+
+func TestLoadLayers_Profiles(t *testing.T) {
+	configHome, repo := t.TempDir(), t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("GEMINI_API_KEY", "gemini-key")
+	t.Setenv("GROQ_API_KEY", "groq-key")
+	writeConfig(t, filepath.Join(configHome, config.UserConfigFile), `
+profiles:
+  cheap:
+    service: groq
+    max_process_loops: 10
+    provider_rate_limits:
+      groq: {rpm: 30}
+`)
+	writeConfig(t, filepath.Join(repo, config.ProjectConfigFile), `
+profile: cheap
+max_process_loops: 40
+profiles:
+  best:
+    roles:
+      generate_code: {model: gemini-2.5-pro}
+`)
+
+	layers, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", repo, "-log-level", "debug"})
+	require.NoError(t, err)
+	cfg, err := layers.Config()
+	require.NoError(t, err)
+	assert.Equal(t, "cheap", cfg.Profile)
+	assert.Equal(t, config.GroqService, cfg.ProgrammingService)
+	assert.Equal(t, 10, cfg.MaxProcessLoops, "the profile overrides the project config")
+	assert.Equal(t, 30, cfg.RateLimits(config.GroqService).RequestsPerMinute)
+	assert.Equal(t, []string{"best", "cheap"}, layers.ProfileNames())
+	assert.Equal(t, "profile cheap", origins(layers)["max_process_loops"])
+	for _, setting := range layers.Settings() {
+		if setting.Key == "profiles" {
+			assert.Equal(t, "[best, cheap]", setting.Value, "profiles, which may hold API keys, are listed by name")
+		}
+	}
+
+	best, err := layers.WithProfile("best")
+	require.NoError(t, err)
+	cfg, err = best.Config()
+	require.NoError(t, err)
+	assert.Equal(t, "best", cfg.Profile)
+	assert.Equal(t, config.GeminiService, cfg.ProgrammingService)
+	assert.Equal(t, 40, cfg.MaxProcessLoops)
+	assert.Equal(t, "gemini-2.5-pro", cfg.Roles["generate_code"].Model)
+	assert.Equal(t, "debug", cfg.LogLevel, "the flags keep their precedence")
+	assert.Equal(t, config.OriginSelected, origins(best)["profile"])
+
+	// Selected settings override every layer, and are kept with the selected profile
+	flash, err := best.WithSetting("gemini.analysis_model", "gemini-2.0-flash")
+	require.NoError(t, err)
+	cfg, err = flash.Config()
+	require.NoError(t, err)
+	assert.Equal(t, "best", cfg.Profile)
+	assert.Equal(t, "gemini-2.0-flash", cfg.AnalysisModelName)
+	assert.Equal(t, config.OriginSelected, origins(flash)["gemini.analysis_model"])
+
+	_, err = layers.WithProfile("fast")
+	assert.ErrorContains(t, err, `unknown profile "fast", expected one of best, cheap`)
+	_, err = config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", repo, "-profile", "fast"})
+	assert.ErrorContains(t, err, `unknown profile "fast"`)
+
+	writeConfig(t, filepath.Join(repo, config.ProjectConfigFile), "profiles: {nested: {profile: cheap}}")
+	_, err = layers.WithProfile("nested")
+	assert.ErrorContains(t, err, "profile nested cannot set profile")
+}
+
+func TestLayers_ConfigReplayStrict(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	layers, err := config.LoadLayers(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-directory", t.TempDir(), "-replay", "run", "-replay-strict"})
+	require.NoError(t, err)
+	cfg, err := layers.Config()
+	require.NoError(t, err)
+	assert.True(t, cfg.ReplayStrict)
+
+	// The replay flags are kept when a setting is changed during the session.
+	layers, err = layers.WithSetting("log_level", "debug")
+	require.NoError(t, err)
+	cfg, err = layers.Config()
+	require.NoError(t, err)
+	assert.True(t, cfg.ReplayStrict)
+}
@@ -0,0 +1,105 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/config"
+)
+
+func TestLoadSuite(t *testing.T) {
+	suite, err := LoadSuite(filepath.Join("testdata", "suite.yaml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Minute, suite.CheckTimeout)
+	assert.Equal(t, 10, suite.MaxProcessLoops)
+	assert.Equal(t, config.BestJudgementPolicy, suite.AskUserPolicy)
+	require.Len(t, suite.Targets, 2)
+	assert.Equal(t, "fake", suite.Targets[0].GenerateCodeModel)
+	instructionsModel, _, _ := config.DefaultModelNames(config.GeminiService)
+	assert.Equal(t, instructionsModel, suite.Targets[1].InstructionsModel)
+	require.Len(t, suite.Tasks, 2)
+	assert.Equal(t, filepath.Join("testdata", "fixtures", "readme"), suite.Tasks[0].Fixture)
+	assert.Equal(t, filepath.Join("testdata", "scripts", "add_hello.yaml"), suite.Tasks[0].FakeScript)
+}
+
+func TestLoadSuite_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		suite   string
+		wantErr string
+	}{
+		{"no targets", "tasks: [{name: a, fixture: f, request: r, check: c}]", "no targets defined"},
+		{"invalid service", "targets: [{service: nope}]\ntasks: [{name: a, fixture: f, request: r, check: c}]", `invalid service "nope" of target 1`},
+		{"duplicate target", "targets: [{service: fake}, {service: fake}]\ntasks: [{name: a, fixture: f, request: r, check: c}]", "duplicate target name fake"},
+		{"invalid ask_user_policy", "ask_user_policy: guess\ntargets: [{service: fake}]\ntasks: [{name: a, fixture: f, request: r, check: c}]", `invalid ask_user_policy "guess"`},
+		{"incomplete task", "targets: [{service: fake}]\ntasks: [{name: a, fixture: f}]", "task a must set fixture, request and check"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "suite.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.suite), 0644))
+			_, err := LoadSuite(path)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRun_FakeService(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "")
+	suite, err := LoadSuite(filepath.Join("testdata", "suite.yaml"))
+	require.NoError(t, err)
+
+	results := Run(context.Background(), suite, nil)
+	require.Len(t, results, 4)
+
+	passed := results[0]
+	assert.Equal(t, "add-hello", passed.Task)
+	assert.True(t, passed.Passed, passed.Error)
+	assert.Equal(t, 2, passed.Loops)
+	assert.Equal(t, 7, passed.Requests)
+	assert.True(t, passed.TokensEstimated)
+	assert.Positive(t, passed.Tokens)
+	assert.Equal(t, []string{"hello.txt"}, passed.FilesTouched)
+
+	failed := results[1]
+	assert.False(t, failed.Passed)
+	assert.Contains(t, failed.Error, "check failed")
+	assert.Equal(t, []string{"hello.txt"}, failed.FilesTouched)
+
+	for _, skipped := range results[2:] {
+		assert.Equal(t, "gemini-flash", skipped.Target)
+		assert.True(t, skipped.Skipped)
+		assert.Equal(t, "GEMINI_API_KEY is not set", skipped.Error)
+	}
+
+	// Only the requested targets run
+	assert.Len(t, Run(context.Background(), suite, []string{"gemini-flash"}), 2)
+}
+
+func TestWriteTable(t *testing.T) {
+	results := []Result{
+		{Target: "a", Service: "fake", Model: "fake", Task: "t1", Passed: true, Loops: 2, Tokens: 100, TokensEstimated: true, Duration: time.Second, FilesTouched: []string{"x.go", "y.go"}},
+		{Target: "a", Service: "fake", Model: "fake", Task: "t2", Loops: 4, Tokens: 50, Duration: time.Second},
+		{Target: "b", Service: "gemini", Model: "flash", Task: "t1", Skipped: true},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, WriteTable(&out, results))
+	assert.Equal(t, `TASK  TARGET  RESULT  LOOPS  TOKENS  TIME  FILES TOUCHED
+t1    a       PASS    2      ~100    1s    x.go, y.go
+t2    a       FAIL    4      50      1s    -
+t1    b       SKIP    0      0       0s    -
+
+TARGET  SERVICE  MODEL  PASSED           AVG LOOPS  TOKENS  TIME
+a       fake     fake   1/2              3.0        ~150    2s
+b       gemini   flash  0/0 (1 skipped)  0.0        0       0s
+`, out.String())
+}
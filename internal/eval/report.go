@@ -0,0 +1,108 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// targetSummary aggregates the results of a target.
+type targetSummary struct {
+	target, service, model  string
+	passed, failed, skipped int
+	loops, tokens           int
+	estimated               bool
+	duration                time.Duration
+}
+
+// WriteTable writes the result of every task and a comparison of the targets as aligned text tables.
+func WriteTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "TASK\tTARGET\tRESULT\tLOOPS\tTOKENS\tTIME\tFILES TOUCHED")
+	for _, result := range results {
+		status := "FAIL"
+		switch {
+		case result.Skipped:
+			status = "SKIP"
+		case result.Passed:
+			status = "PASS"
+		}
+		filesTouched := "-"
+		if len(result.FilesTouched) > 0 {
+			filesTouched = strings.Join(result.FilesTouched, ", ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", result.Task, result.Target, status, result.Loops,
+			formatTokens(result.Tokens, result.TokensEstimated), formatDuration(result.Duration), filesTouched)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "TARGET\tSERVICE\tMODEL\tPASSED\tAVG LOOPS\tTOKENS\tTIME")
+	for _, summary := range summarize(results) {
+		run := summary.passed + summary.failed
+		avgLoops := 0.0
+		if run > 0 {
+			avgLoops = float64(summary.loops) / float64(run)
+		}
+		passed := fmt.Sprintf("%d/%d", summary.passed, run)
+		if summary.skipped > 0 {
+			passed += fmt.Sprintf(" (%d skipped)", summary.skipped)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.1f\t%s\t%s\n", summary.target, summary.service, summary.model, passed, avgLoops,
+			formatTokens(summary.tokens, summary.estimated), formatDuration(summary.duration))
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the results as an indented JSON array.
+func WriteJSON(w io.Writer, results []Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(results); err != nil {
+		return fmt.Errorf("failed to encode eval results: %w", err)
+	}
+	return nil
+}
+
+// summarize aggregates the results per target, in the order the targets first appear.
+func summarize(results []Result) []*targetSummary {
+	var summaries []*targetSummary
+	byTarget := make(map[string]*targetSummary)
+	for _, result := range results {
+		summary, ok := byTarget[result.Target]
+		if !ok {
+			summary = &targetSummary{target: result.Target, service: result.Service, model: result.Model}
+			byTarget[result.Target] = summary
+			summaries = append(summaries, summary)
+		}
+		switch {
+		case result.Skipped:
+			summary.skipped++
+			continue
+		case result.Passed:
+			summary.passed++
+		default:
+			summary.failed++
+		}
+		summary.loops += result.Loops
+		summary.tokens += result.Tokens
+		summary.estimated = summary.estimated || result.TokensEstimated
+		summary.duration += result.Duration
+	}
+	return summaries
+}
+
+// formatTokens formats a token count, marking estimated counts with a "~".
+func formatTokens(tokens int, estimated bool) string {
+	if estimated {
+		return fmt.Sprintf("~%d", tokens)
+	}
+	return fmt.Sprintf("%d", tokens)
+}
+
+func formatDuration(duration time.Duration) string {
+	return duration.Round(time.Millisecond).String()
+}
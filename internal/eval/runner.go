@@ -0,0 +1,336 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/EduardDranca/GoAgent/internal/agent"
+	agentcontext "github.com/EduardDranca/GoAgent/internal/agent/context"
+	"github.com/EduardDranca/GoAgent/internal/agent/initialize"
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/config"
+	"github.com/EduardDranca/GoAgent/internal/input"
+	"github.com/EduardDranca/GoAgent/internal/llm"
+	"github.com/EduardDranca/GoAgent/internal/logging"
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+// maxCheckOutput is the number of trailing bytes of the check output kept in a Result.
+const maxCheckOutput = 4096
+
+// Result is the outcome of one task run against one target.
+type Result struct {
+	Target          string        `json:"target"`
+	Service         string        `json:"service"`
+	Model           string        `json:"model"`
+	Task            string        `json:"task"`
+	Skipped         bool          `json:"skipped"`
+	Passed          bool          `json:"passed"`
+	Error           string        `json:"error,omitempty"` // Why the task was skipped or failed
+	Loops           int           `json:"loops"`
+	Requests        int           `json:"requests"`
+	Tokens          int           `json:"tokens"`
+	TokensEstimated bool          `json:"tokens_estimated"`
+	Duration        time.Duration `json:"duration_ns"`
+	FilesTouched    []string      `json:"files_touched"`
+	CheckOutput     string        `json:"check_output,omitempty"`
+}
+
+// loopCounter is implemented by programming services that count their process loops.
+type loopCounter interface {
+	LoopsUsed() int
+}
+
+// Run runs every task of the suite against every target whose name is in targets, or against all
+// targets if targets is empty. Targets whose API key is not set are skipped. Prompts of the agent
+// are answered with "no" and its questions by the ask_user policy of the suite, so runs never wait for input.
+func Run(ctx context.Context, suite *Suite, targets []string) []Result {
+	originalGetter := input.UserInputGetter
+	input.UserInputGetter = func(prompt string) (string, error) {
+		logging.Logger.Warnf("Answering \"no\" to agent prompt during eval: %s", prompt)
+		return "N", nil
+	}
+	defer func() { input.UserInputGetter = originalGetter }()
+
+	var results []Result
+	for _, target := range suite.Targets {
+		if len(targets) > 0 && !slices.Contains(targets, target.Name) {
+			continue
+		}
+		for _, task := range suite.Tasks {
+			logging.Logger.Infof("Running eval task %s against target %s", task.Name, target.Name)
+			results = append(results, runTask(ctx, suite, target, task))
+		}
+	}
+	return results
+}
+
+// runTask runs a task in a temporary copy of its fixture.
+func runTask(ctx context.Context, suite *Suite, target Target, task Task) Result {
+	result := Result{
+		Target:  target.Name,
+		Service: string(target.Service),
+		Model:   target.GenerateCodeModel,
+		Task:    task.Name,
+	}
+
+	cfg, err := newTaskConfig(suite, target, task)
+	if err != nil {
+		result.Skipped = true
+		result.Error = err.Error()
+		return result
+	}
+
+	dir, err := os.MkdirTemp("", "go-agent-eval-*")
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to create task directory: %v", err)
+		return result
+	}
+	defer os.RemoveAll(dir)
+	cfg.Directory = dir
+
+	if err := prepareRepository(task.Fixture, dir); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	before, err := snapshotFiles(dir)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	usage := llm.NewUsageCounter()
+	programmingService, err := initialize.InitProgrammingService(ctx, cfg, initialize.WithUsageCounter(usage))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	programmingAgent := agent.NewLocalProgrammingAgent(programmingService, &utils.RealGitUtil{}, agentcontext.WithFileLimits(agentcontext.DefaultFileLimits()))
+	if localAgent, ok := programmingAgent.(*agent.LocalProgrammingAgent); ok {
+		localAgent.SetAutoCommit(true)
+	}
+
+	startedAt := time.Now()
+	implementErr := programmingAgent.Implement(models.AgentRequest{Query: task.Request, Directory: dir})
+	result.Duration = time.Since(startedAt)
+
+	stats := usage.Stats()
+	result.Requests = stats.Requests
+	result.Tokens = stats.Tokens.Total()
+	result.TokensEstimated = stats.Estimated
+	if counter, ok := programmingService.(loopCounter); ok {
+		result.Loops = counter.LoopsUsed()
+	}
+
+	after, err := snapshotFiles(dir)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.FilesTouched = changedFiles(before, after)
+
+	if implementErr != nil {
+		result.Error = fmt.Sprintf("implementation failed: %v", implementErr)
+		return result
+	}
+	if result.Loops >= suite.MaxProcessLoops {
+		result.Error = fmt.Sprintf("reached the limit of %d process loops", suite.MaxProcessLoops)
+		return result
+	}
+
+	output, err := runCheck(ctx, dir, task.Check, suite.CheckTimeout)
+	result.CheckOutput = output
+	if err != nil {
+		result.Error = fmt.Sprintf("check failed: %v", err)
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// newTaskConfig builds the configuration of a task run. It returns an error if the target cannot be run.
+func newTaskConfig(suite *Suite, target Target, task Task) (*config.Config, error) {
+	cfg := &config.Config{
+		ProgrammingService:     target.Service,
+		RateLimitRPM:           target.RateLimitRPM,
+		LogLevel:               "info",
+		MaxHistoryLength:       100,
+		MaxProcessLoops:        suite.MaxProcessLoops,
+		MaxFileSize:            agentcontext.DefaultMaxFileSize,
+		FilePreviewLines:       agentcontext.DefaultPreviewLines,
+		MaxParallelGenerations: 1,
+		InstructionsModelName:  target.InstructionsModel,
+		GenerateCodeModelName:  target.GenerateCodeModel,
+		AnalysisModelName:      target.AnalysisModel,
+		AskUserPolicy:          suite.AskUserPolicy,
+	}
+
+	switch target.Service {
+	case config.GeminiService:
+		cfg.GeminiApiKey = os.Getenv("GEMINI_API_KEY")
+		if cfg.GeminiApiKey == "" {
+			return nil, fmt.Errorf("GEMINI_API_KEY is not set")
+		}
+	case config.GroqService:
+		cfg.GroqApiKey = os.Getenv("GROQ_API_KEY")
+		if cfg.GroqApiKey == "" {
+			return nil, fmt.Errorf("GROQ_API_KEY is not set")
+		}
+	case config.OpenAIService:
+		cfg.OpenaiApiKey = os.Getenv("OPENAI_API_KEY")
+		if cfg.OpenaiApiKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY is not set")
+		}
+	case config.FakeService:
+		cfg.FakeScript = task.FakeScript
+		if cfg.FakeScript == "" {
+			cfg.FakeScript = target.FakeScript
+		}
+		if cfg.FakeScript == "" {
+			return nil, fmt.Errorf("no fake script set for task %s", task.Name)
+		}
+	}
+	return cfg, nil
+}
+
+// prepareRepository copies the fixture into dir and commits it to a new git repository.
+func prepareRepository(fixture string, dir string) error {
+	err := filepath.WalkDir(fixture, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(fixture, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dir, relPath), 0755)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, relPath), content, info.Mode().Perm())
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy fixture %s: %w", fixture, err)
+	}
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		return fmt.Errorf("failed to initialize repository: %w", err)
+	}
+	repoConfig, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read repository config: %w", err)
+	}
+	repoConfig.User.Name = "GoAgent Eval"
+	repoConfig.User.Email = "eval@go-agent.local"
+	if err := repo.SetConfig(repoConfig); err != nil {
+		return fmt.Errorf("failed to write repository config: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := worktree.AddGlob("."); err != nil {
+		return fmt.Errorf("failed to add fixture files: %w", err)
+	}
+	_, err = worktree.Commit("Fixture", &git.CommitOptions{
+		Author:            &object.Signature{Name: repoConfig.User.Name, Email: repoConfig.User.Email, When: time.Now()},
+		AllowEmptyCommits: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit fixture: %w", err)
+	}
+	return nil
+}
+
+// snapshotFiles returns the content hash of every file in dir outside of .git.
+func snapshotFiles(dir string) (map[string][sha256.Size]byte, error) {
+	hashes := make(map[string][sha256.Size]byte)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hashes[filepath.ToSlash(relPath)] = sha256.Sum256(content)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot %s: %w", dir, err)
+	}
+	return hashes, nil
+}
+
+// changedFiles returns the sorted paths that were added, modified or deleted between two snapshots.
+func changedFiles(before map[string][sha256.Size]byte, after map[string][sha256.Size]byte) []string {
+	var changed []string
+	for path, hash := range after {
+		if previous, ok := before[path]; !ok || previous != hash {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// runCheck runs the check command of a task with sh in dir and returns the tail of its combined output.
+func runCheck(ctx context.Context, dir string, check string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", check)
+	cmd.Dir = dir
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+
+	tail := output.String()
+	if len(tail) > maxCheckOutput {
+		tail = "..." + tail[len(tail)-maxCheckOutput:]
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return tail, fmt.Errorf("timed out after %s", timeout)
+	}
+	return strings.TrimSpace(tail), err
+}
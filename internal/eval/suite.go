@@ -0,0 +1,150 @@
+package eval
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/EduardDranca/GoAgent/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultMaxProcessLoops = 25
+	defaultCheckTimeout    = 10 * time.Minute
+)
+
+// Suite is a set of tasks run against every target, loaded from a YAML file.
+type Suite struct {
+	Targets []Target `yaml:"targets"`
+	Tasks   []Task   `yaml:"tasks"`
+	// MaxProcessLoops bounds the process loops of a single task; the task fails when it is reached.
+	MaxProcessLoops int `yaml:"max_process_loops"`
+	// CheckTimeout bounds the run time of a check command.
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+	// AskUserPolicy is how the questions the agent asks for clarification are answered, as no user is available.
+	AskUserPolicy config.AskUserPolicy `yaml:"ask_user_policy"`
+}
+
+// Target is a provider and the models used for each assistant task.
+type Target struct {
+	Name              string                `yaml:"name"`
+	Service           config.LLMServiceType `yaml:"service"`
+	InstructionsModel string                `yaml:"instructions_model"`
+	GenerateCodeModel string                `yaml:"generate_code_model"`
+	AnalysisModel     string                `yaml:"analysis_model"`
+	RateLimitRPM      int                   `yaml:"rate_limit_rpm"`
+	// FakeScript is the script of the fake service used for tasks that don't set their own.
+	FakeScript string `yaml:"fake_script"`
+}
+
+// Task is a change request applied to a copy of a repository fixture, verified by a check command.
+type Task struct {
+	Name    string `yaml:"name"`
+	Fixture string `yaml:"fixture"` // Directory copied into the temporary repository the task runs in
+	Request string `yaml:"request"`
+	Check   string `yaml:"check"` // Shell command run in the repository; the task passes if it exits with 0
+	// FakeScript is the script of canned responses used when the task runs against the fake service.
+	FakeScript string `yaml:"fake_script"`
+}
+
+// LoadSuite reads a suite file. Relative fixture and script paths are resolved against the directory of the file.
+func LoadSuite(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read eval suite %s: %w", path, err)
+	}
+	suite := &Suite{}
+	if err := yaml.Unmarshal(data, suite); err != nil {
+		return nil, fmt.Errorf("failed to parse eval suite %s: %w", path, err)
+	}
+
+	baseDir := filepath.Dir(path)
+	for i := range suite.Targets {
+		suite.Targets[i].FakeScript = resolvePath(baseDir, suite.Targets[i].FakeScript)
+	}
+	for i := range suite.Tasks {
+		suite.Tasks[i].Fixture = resolvePath(baseDir, suite.Tasks[i].Fixture)
+		suite.Tasks[i].FakeScript = resolvePath(baseDir, suite.Tasks[i].FakeScript)
+	}
+
+	if err := suite.validate(); err != nil {
+		return nil, fmt.Errorf("invalid eval suite %s: %w", path, err)
+	}
+	return suite, nil
+}
+
+// validate checks the suite and fills in the defaults.
+func (s *Suite) validate() error {
+	if len(s.Targets) == 0 {
+		return fmt.Errorf("no targets defined")
+	}
+	if len(s.Tasks) == 0 {
+		return fmt.Errorf("no tasks defined")
+	}
+	if s.MaxProcessLoops <= 0 {
+		s.MaxProcessLoops = defaultMaxProcessLoops
+	}
+	if s.CheckTimeout <= 0 {
+		s.CheckTimeout = defaultCheckTimeout
+	}
+	switch s.AskUserPolicy {
+	case "":
+		s.AskUserPolicy = config.BestJudgementPolicy
+	case config.BestJudgementPolicy, config.FirstOptionPolicy, config.FailPolicy:
+	default:
+		return fmt.Errorf("invalid ask_user_policy %q", s.AskUserPolicy)
+	}
+
+	names := make(map[string]bool)
+	for i := range s.Targets {
+		target := &s.Targets[i]
+		switch target.Service {
+		case config.GeminiService, config.GroqService, config.OpenAIService, config.FakeService:
+		default:
+			return fmt.Errorf("invalid service %q of target %d", target.Service, i+1)
+		}
+		if target.Name == "" {
+			target.Name = string(target.Service)
+		}
+		if names[target.Name] {
+			return fmt.Errorf("duplicate target name %s", target.Name)
+		}
+		names[target.Name] = true
+
+		instructionsModel, generateCodeModel, analysisModel := config.DefaultModelNames(target.Service)
+		if target.InstructionsModel == "" {
+			target.InstructionsModel = instructionsModel
+		}
+		if target.GenerateCodeModel == "" {
+			target.GenerateCodeModel = generateCodeModel
+		}
+		if target.AnalysisModel == "" {
+			target.AnalysisModel = analysisModel
+		}
+	}
+
+	names = make(map[string]bool)
+	for i, task := range s.Tasks {
+		if task.Name == "" {
+			return fmt.Errorf("task %d has no name", i+1)
+		}
+		if names[task.Name] {
+			return fmt.Errorf("duplicate task name %s", task.Name)
+		}
+		names[task.Name] = true
+		if task.Fixture == "" || task.Request == "" || task.Check == "" {
+			return fmt.Errorf("task %s must set fixture, request and check", task.Name)
+		}
+	}
+	return nil
+}
+
+// resolvePath makes a relative path relative to baseDir.
+func resolvePath(baseDir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
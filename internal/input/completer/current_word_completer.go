@@ -2,128 +2,183 @@ package completer
 
 import (
 	"fmt"
-	"github.com/EduardDranca/GoAgent/internal/utils"
-	"github.com/reeflective/readline"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+const (
+	// maxCandidates bounds the candidates shown for a word.
+	maxCandidates = 100
+	// maxRecent is the number of recently used candidates ranked higher.
+	maxRecent = 50
+	// recencyBonus is the score added to the most recently used candidate, decreasing linearly for older ones.
+	recencyBonus = 20
+	// mentionMarker starts the mentions of files, e.g. @main.go.
+	mentionMarker = "@"
 )
 
-// CurrentWordCompleter implements the AutoCompleter interface. It provides completions
-// based on the current word of the input line.
+// lineRange matches the line range ending a mention, e.g. :10-80.
+var lineRange = regexp.MustCompile(`:\d+(-\d+)?$`)
+
+// CurrentWordCompleter completes the word at the cursor: with the commands and their arguments on lines starting with
+// a command, with the files and directories of the repository after an @, and with its files and Go symbols
+// otherwise. Words are matched fuzzily, and the candidates ranked by score and recency.
 type CurrentWordCompleter struct {
-	files []string
+	index    *FileIndex
+	commands CommandSource // Nil when commands are not completed
+
+	mu     sync.Mutex
+	recent []string // Candidates used recently, most recent first
 }
 
-// NewCurrentWordCompleter creates a new CurrentWordCompleter with a list of files.
-// It initializes the completer with the provided list of files to suggest completions from.
-func NewCurrentWordCompleter(files []string) (*CurrentWordCompleter, error) {
-	return &CurrentWordCompleter{
-		files: files,
-	}, nil
+// CommandSource completes the commands typed at the prompt, e.g. /help, and their arguments.
+type CommandSource interface {
+	// CompleteCommand returns every candidate for the word ending at the end of line, and false if the word is not
+	// a command or one of its arguments.
+	CompleteCommand(line string) ([]string, bool)
 }
 
-// Do implements the AutoCompleter interface. It takes a line of input and a position,
-// extracts the word at the cursor position, and returns suggestions for completing that word.
-// The suggestions are returned as a slice of []rune, and the length of the word at the cursor
-// position is also returned.
-func (c *CurrentWordCompleter) Do(line []rune, pos int) readline.Completions {
-	lineStr := string(line) // Convert []rune to string for easier handling
-
-	// Find the start and end of the word at the cursor position
-	startPos := 0
-	endPos := pos
-	for i := 0; i < pos; i++ {
-		if line[i] == ' ' {
-			startPos = i + 1
-		}
-	}
-	for endPos < len(line) && line[endPos] != ' ' {
-		endPos++
+// Option is a functional option type for configuring a CurrentWordCompleter.
+type Option func(c *CurrentWordCompleter)
+
+// WithCommands completes the commands of commands, and their arguments, instead of file names.
+func WithCommands(commands CommandSource) Option {
+	return func(c *CurrentWordCompleter) {
+		c.commands = commands
 	}
+}
 
-	wordAtCursor := lineStr[startPos:endPos]
+// Completion holds the candidates completing the word at the cursor, best first.
+type Completion struct {
+	Start int // Position of the first rune of the word in the line
+	// Replacement replaces the word before the candidates are shown: the common prefix of the candidates if it
+	// extends the word, or the word itself if they have none, e.g. when they match it fuzzily.
+	Replacement string
+	Candidates  []string
+}
 
-	// No completion if cursor is in the middle of a word
-	if pos != endPos {
-		return readline.CompleteValues()
+// NewCurrentWordCompleter creates a new CurrentWordCompleter with a list of files, which is never refreshed.
+func NewCurrentWordCompleter(files []string, options ...Option) (*CurrentWordCompleter, error) {
+	return newCurrentWordCompleter(newStaticFileIndex(files), options...), nil
+}
+
+// newCurrentWordCompleter creates a CurrentWordCompleter of the files and symbols of index.
+func newCurrentWordCompleter(index *FileIndex, options ...Option) *CurrentWordCompleter {
+	c := &CurrentWordCompleter{index: index}
+	for _, option := range options {
+		option(c)
 	}
+	return c
+}
 
-	var suggestions []string
-	for _, file := range c.files {
-		if strings.HasPrefix(strings.ToLower(file), strings.ToLower(wordAtCursor)) {
-			suggestions = append(suggestions, file)
-		}
+// Complete returns the candidates completing the word ending at pos, the position of the cursor in line. There are
+// none if the cursor is in the middle of a word.
+func (c *CurrentWordCompleter) Complete(line []rune, pos int) Completion {
+	start, end := pos, pos
+	for start > 0 && line[start-1] != ' ' {
+		start--
+	}
+	for end < len(line) && line[end] != ' ' {
+		end++
+	}
+	word := string(line[start:pos])
+	completion := Completion{Start: start, Replacement: word}
+	if pos != end {
+		return completion
 	}
 
-	if len(suggestions) > 0 {
-		commonPrefix := longestCommonPrefix(suggestions)
-		// If there's a common prefix, and it's not the same as the word already typed (case-insensitive)
-		if commonPrefix != "" && strings.ToLower(commonPrefix) != strings.ToLower(wordAtCursor) {
-			// Return only the common prefix as a suggestion
-			return readline.CompleteValues(commonPrefix).DisplayList()
+	var candidates []string
+	isCommand := false
+	if c.commands != nil {
+		candidates, isCommand = c.commands.CompleteCommand(string(line[:pos]))
+	}
+	marker := ""
+	switch {
+	case isCommand:
+	case strings.HasPrefix(word, mentionMarker):
+		marker = mentionMarker
+		for _, file := range append(c.index.Files(), c.index.Directories()...) {
+			candidates = append(candidates, mentionMarker+file)
 		}
+	default:
+		candidates = append(c.index.Files(), c.index.Symbols()...)
 	}
 
-	// Otherwise, return all matching suggestions
-	return readline.CompleteValues(suggestions...).DisplayList()
+	candidates = rankMatches(word, candidates, c.recency, maxCandidates)
+	if len(candidates) == 0 {
+		return completion
+	}
+	completion.Candidates = candidates
+	if prefix := longestCommonPrefix(candidates); strings.HasPrefix(prefix, word) || len(prefix) > len(marker) {
+		completion.Replacement = prefix
+	}
+	return completion
 }
 
-func getCurrentFiles(gitUtil utils.GitUtil, directory string) ([]string, error) {
-	files, err := gitUtil.LsTree(directory)
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch files in repository: %w", err)
+// recency returns the bonus of candidate if it was used recently.
+func (c *CurrentWordCompleter) recency(candidate string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := slices.Index(c.recent, strings.TrimPrefix(candidate, mentionMarker))
+	if i < 0 {
+		return 0
 	}
-
-	return files, nil
+	return recencyBonus * (maxRecent - i) / maxRecent
 }
 
-func InitCompleter(directory string, gitUtil utils.GitUtil) (*CurrentWordCompleter, error) {
-	files, err := getCurrentFiles(gitUtil, directory)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current files for completer: %w", err)
+// Remember ranks the candidates used in line, a line typed at the prompt, higher in the next completions: the
+// command starting it, and the files, directories and symbols of the repository. Other words, e.g. those of an /ask
+// query, are not candidates and are left out.
+func (c *CurrentWordCompleter) Remember(line string) {
+	words := strings.Fields(line)
+	var used []string
+	if len(words) > 0 && strings.HasPrefix(words[0], "/") {
+		used = append(used, words[0])
+		words = words[1:]
 	}
-
-	currentWordCompleter, err := NewCurrentWordCompleter(files)
-	if err != nil {
-		// This error path from NewCurrentWordCompleter is currently not reachable
-		// based on its implementation, but kept for robustness.
-		return nil, fmt.Errorf("failed to initialize current word completer: %w", err)
+	known := append(append(c.index.Files(), c.index.Directories()...), c.index.Symbols()...)
+	for _, word := range words {
+		word = strings.TrimPrefix(word, mentionMarker)
+		word = lineRange.ReplaceAllString(strings.TrimRight(word, ".,;!?)'\""), "")
+		if slices.Contains(known, word) {
+			used = append(used, word)
+		}
 	}
-	return currentWordCompleter, nil
+	c.touch(used...)
 }
 
-// longestCommonPrefix finds the longest common prefix among a slice of strings.
-func longestCommonPrefix(strs []string) string {
-	if len(strs) == 0 {
-		return ""
-	}
-	if len(strs) == 1 {
-		return strs[0]
+// Refresh updates the files and symbols of the completer, ranking the files changed since the last refresh higher,
+// e.g. the files the agent edited.
+func (c *CurrentWordCompleter) Refresh() error {
+	if err := c.index.Refresh(); err != nil {
+		return err
 	}
+	c.touch(c.index.Changed()...)
+	return nil
+}
 
-	// Find the shortest string to limit the comparison length
-	shortest := strs[0]
-	for _, s := range strs {
-		if len(s) < len(shortest) {
-			shortest = s
-		}
+// touch moves the candidates to the front of the recently used candidates.
+func (c *CurrentWordCompleter) touch(candidates ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, candidate := range candidates {
+		c.recent = slices.DeleteFunc(c.recent, func(recent string) bool { return recent == candidate })
+		c.recent = slices.Insert(c.recent, 0, candidate)
 	}
+	if len(c.recent) > maxRecent {
+		c.recent = c.recent[:maxRecent]
+	}
+}
 
-	prefix := ""
-	for i := 0; i < len(shortest); i++ {
-		char := shortest[i]
-		match := true
-		for j := 1; j < len(strs); j++ {
-			if strs[j][i] != char {
-				match = false
-				break
-			}
-		}
-		if match {
-			prefix += string(char)
-		} else {
-			break
-		}
+// InitCompleter creates a completer of the files and Go symbols of the repository in directory, updated by Refresh.
+func InitCompleter(directory string, gitUtil utils.GitUtil, options ...Option) (*CurrentWordCompleter, error) {
+	index, err := NewFileIndex(directory, gitUtil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current files for completer: %w", err)
 	}
-	return prefix
+	return newCurrentWordCompleter(index, options...), nil
 }
@@ -0,0 +1,52 @@
+package completer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// commandSource completes /profile with two profiles.
+type commandSource struct{}
+
+func (commandSource) CompleteCommand(line string) ([]string, bool) {
+	switch line {
+	case "/pr":
+		return []string{"/help", "/profile"}, true
+	case "/profile ", "/profile ch":
+		return []string{"best", "cheap"}, true
+	}
+	return nil, false
+}
+
+func TestCurrentWordCompleter_Complete(t *testing.T) {
+	c, err := NewCurrentWordCompleter([]string{"main.go", "internal/config/config.go", "internal/config/layers.go", "README.md"}, WithCommands(commandSource{}))
+	assert.NoError(t, err)
+	complete := func(line string) Completion {
+		return c.Complete([]rune(line), len([]rune(line)))
+	}
+
+	assert.Equal(t, Completion{Start: 0, Replacement: "main.go", Candidates: []string{"main.go"}}, complete("ma"))
+	assert.Equal(t, Completion{Start: 4, Replacement: "internal/config/", Candidates: []string{"internal/config/config.go", "internal/config/layers.go"}}, complete("fix int"))
+	assert.Equal(t, Completion{Start: 4, Replacement: "internal/config/layers.go", Candidates: []string{"internal/config/layers.go"}}, complete("fix cfglay"), "fuzzy matches")
+	assert.Equal(t, Completion{Start: 4, Replacement: "@internal/config/", Candidates: []string{"@internal/config/", "@internal/config/config.go", "@internal/config/layers.go"}}, complete("fix @icfg"), "mentions complete files and directories")
+	assert.Equal(t, Completion{Start: 0, Replacement: "/profile", Candidates: []string{"/profile"}}, complete("/pr"))
+	assert.Equal(t, Completion{Start: 9, Replacement: "cheap", Candidates: []string{"cheap"}}, complete("/profile ch"))
+	assert.Equal(t, Completion{Start: 0, Replacement: "xyz"}, complete("xyz"))
+	assert.Equal(t, Completion{Start: 4, Replacement: "go", Candidates: []string{"main.go", "internal/config/config.go", "internal/config/layers.go"}}, complete("fix go"), "candidates without a common prefix leave the word")
+
+	middle := c.Complete([]rune("fix main.go"), 6)
+	assert.Empty(t, middle.Candidates, "no completion in the middle of a word")
+}
+
+func TestCurrentWordCompleter_Recency(t *testing.T) {
+	c, err := NewCurrentWordCompleter([]string{"internal/config/config.go", "internal/config/layers.go"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "internal/config/config.go", c.Complete([]rune("c"), 1).Candidates[0])
+	c.Remember("Document @internal/config/layers.go:10-20.")
+	assert.Equal(t, "internal/config/layers.go", c.Complete([]rune("c"), 1).Candidates[0], "files used recently rank first")
+
+	c.Remember("/ask what does internal/config/config.go do?")
+	assert.Equal(t, []string{"internal/config/config.go", "/ask", "internal/config/layers.go"}, c.recent, "only commands and known files are remembered")
+}
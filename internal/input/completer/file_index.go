@@ -0,0 +1,264 @@
+package completer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/EduardDranca/GoAgent/internal/logging"
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+// FileIndex holds the files of a repository and the Go symbols they declare, e.g. NewFileIndex or FileIndex.Refresh.
+// It is built once and updated incrementally from the changes reported by git. It is safe for concurrent use.
+type FileIndex struct {
+	directory string
+	gitUtil   utils.GitUtil
+	isGitRepo bool
+
+	mu         sync.Mutex
+	files      map[string]bool
+	symbols    map[string][]string // Symbols declared by every Go file
+	updated    map[string]bool     // Files indexed by Refresh since the files were set, skipped by the background indexing
+	generation int                 // Incremented when the files are set, stopping the background indexing of the previous ones
+	head       string              // HEAD when the index was last updated
+	dirty      []string            // Files that had changed when the index was last updated, checked again by the next update
+	changed    []string            // Files changed by the last update
+	built      sync.WaitGroup      // Done once the symbols of the files set last are indexed
+}
+
+// NewFileIndex indexes the files of the repository in directory. The Go symbols are indexed in the background.
+func NewFileIndex(directory string, gitUtil utils.GitUtil) (*FileIndex, error) {
+	isGitRepo, _ := utils.IsGitRepository(directory)
+	index := &FileIndex{directory: directory, gitUtil: gitUtil, isGitRepo: isGitRepo}
+	if isGitRepo {
+		// Only the changes following the current HEAD are applied by Refresh
+		head, dirty, err := utils.GitChangedFiles(directory, "")
+		if err != nil {
+			logging.Logger.Warnf("Failed to read the changes of the repository, the file index will be rebuilt after every request: %v", err)
+		}
+		index.head, index.dirty = head, dirty
+	}
+	files, err := gitUtil.LsTree(directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch files in repository: %w", err)
+	}
+	index.setFiles(files)
+	return index, nil
+}
+
+// newStaticFileIndex returns an index of files that is never refreshed and has no symbols.
+func newStaticFileIndex(files []string) *FileIndex {
+	index := &FileIndex{files: make(map[string]bool), symbols: make(map[string][]string)}
+	for _, file := range files {
+		index.files[file] = true
+	}
+	return index
+}
+
+// setFiles replaces the files of the index and indexes their symbols in the background. The symbols of the files
+// updated or removed by Refresh in the meantime are left as Refresh set them.
+func (x *FileIndex) setFiles(files []string) {
+	x.mu.Lock()
+	x.generation++
+	generation := x.generation
+	x.files = make(map[string]bool, len(files))
+	x.symbols = make(map[string][]string)
+	x.updated = make(map[string]bool)
+	for _, file := range files {
+		x.files[file] = true
+	}
+	x.mu.Unlock()
+
+	x.built.Add(1)
+	go func() {
+		defer x.built.Done()
+		for _, file := range files {
+			symbols := x.parseSymbols(file)
+			x.mu.Lock()
+			if x.generation != generation {
+				// The files were set again
+				x.mu.Unlock()
+				return
+			}
+			if len(symbols) > 0 && x.files[file] && !x.updated[file] {
+				x.symbols[file] = symbols
+			}
+			x.mu.Unlock()
+		}
+	}()
+}
+
+// Refresh updates the index with the files changed since it was last updated: the files changed by new commits, the
+// files with pending changes and those that had pending changes before. Outside of git repositories, or if the
+// changes cannot be read, the index is rebuilt. It does not wait for the symbols being indexed in the background.
+func (x *FileIndex) Refresh() error {
+	if x.directory == "" {
+		return nil
+	}
+
+	x.mu.Lock()
+	since, dirty := x.head, x.dirty
+	x.mu.Unlock()
+	if !x.isGitRepo || since == "" {
+		return x.rebuild()
+	}
+	head, changes, err := utils.GitChangedFiles(x.directory, since)
+	if err != nil {
+		logging.Logger.Warnf("Failed to read the changes of the repository, rebuilding the file index: %v", err)
+		return x.rebuild()
+	}
+
+	changed := append(slices.Clone(changes), dirty...)
+	slices.Sort(changed)
+	changed = slices.Compact(changed)
+	updates := make(map[string][]string, len(changed))
+	var existing []string
+	for _, file := range changed {
+		if info, err := os.Stat(filepath.Join(x.directory, file)); err == nil && info.Mode().IsRegular() {
+			existing = append(existing, file)
+			updates[file] = x.parseSymbols(file)
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, file := range changed {
+		x.updated[file] = true
+		if symbols, ok := updates[file]; ok {
+			x.files[file] = true
+			x.symbols[file] = symbols
+			continue
+		}
+		delete(x.files, file)
+		delete(x.symbols, file)
+	}
+	x.head, x.dirty, x.changed = head, changes, existing
+	return nil
+}
+
+// rebuild lists the files of the repository again, e.g. outside of git repositories.
+func (x *FileIndex) rebuild() error {
+	files, err := x.gitUtil.LsTree(x.directory)
+	if err != nil {
+		return fmt.Errorf("failed to fetch files in repository: %w", err)
+	}
+	if x.isGitRepo {
+		head, dirty, err := utils.GitChangedFiles(x.directory, "")
+		if err == nil {
+			x.mu.Lock()
+			x.head, x.dirty = head, dirty
+			x.mu.Unlock()
+		}
+	}
+	x.setFiles(files)
+	return nil
+}
+
+// Files returns the files of the index, sorted.
+func (x *FileIndex) Files() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	files := make([]string, 0, len(x.files))
+	for file := range x.files {
+		files = append(files, file)
+	}
+	slices.Sort(files)
+	return files
+}
+
+// Directories returns the directories holding the files of the index, with a trailing slash, sorted.
+func (x *FileIndex) Directories() []string {
+	seen := make(map[string]bool)
+	var directories []string
+	for _, file := range x.Files() {
+		for dir := path.Dir(file); dir != "." && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			directories = append(directories, dir+"/")
+		}
+	}
+	slices.Sort(directories)
+	return directories
+}
+
+// Symbols returns the Go symbols declared by the files of the index, sorted and without duplicates.
+func (x *FileIndex) Symbols() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var symbols []string
+	for _, fileSymbols := range x.symbols {
+		symbols = append(symbols, fileSymbols...)
+	}
+	slices.Sort(symbols)
+	return slices.Compact(symbols)
+}
+
+// Changed returns the files changed by the last refresh, e.g. those edited by the agent.
+func (x *FileIndex) Changed() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return slices.Clone(x.changed)
+}
+
+// parseSymbols returns the top-level declarations of the Go file, methods being prefixed by their receiver type,
+// e.g. FileIndex.Refresh. Test files and files that do not parse have no symbols.
+func (x *FileIndex) parseSymbols(file string) []string {
+	if x.directory == "" || !strings.HasSuffix(file, ".go") || strings.HasSuffix(file, "_test.go") {
+		return nil
+	}
+	parsed, err := parser.ParseFile(token.NewFileSet(), filepath.Join(x.directory, file), nil, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+
+	var symbols []string
+	add := func(name string) {
+		if name != "_" && name != "init" && name != "main" {
+			symbols = append(symbols, name)
+		}
+	}
+	for _, decl := range parsed.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				add(receiverType(decl.Recv.List[0].Type) + "." + decl.Name.Name)
+				continue
+			}
+			add(decl.Name.Name)
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					add(spec.Name.Name)
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						add(name.Name)
+					}
+				}
+			}
+		}
+	}
+	return symbols
+}
+
+// receiverType returns the name of the type of a method receiver, e.g. FileIndex for *FileIndex or Set[T].
+func receiverType(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverType(expr.X)
+	case *ast.IndexExpr:
+		return receiverType(expr.X)
+	case *ast.IndexListExpr:
+		return receiverType(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package completer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+func TestFileIndex_Refresh(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	writeFile := func(name string, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	commit := func() {
+		_, err := worktree.Add(".")
+		require.NoError(t, err)
+		_, err = worktree.Commit("commit", &git.CommitOptions{
+			All:    true,
+			Author: &object.Signature{Name: "GoAgent", Email: "goagent@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+	}
+
+	writeFile("main.go", "package main\n\nfunc main() {}\n\nfunc run() {}\n")
+	writeFile("pkg/index.go", "package pkg\n\ntype Index struct{}\n\nfunc (x *Index) Refresh() {}\n\nconst maxFiles = 10\n")
+	writeFile("pkg/index_test.go", "package pkg\n\nfunc TestIndex() {}\n")
+	writeFile("notes.txt", "todo\n")
+	commit()
+
+	index, err := NewFileIndex(dir, &utils.RealGitUtil{})
+	require.NoError(t, err)
+	index.built.Wait()
+	assert.Equal(t, []string{"main.go", "notes.txt", "pkg/index.go", "pkg/index_test.go"}, index.Files())
+	assert.Equal(t, []string{"pkg/"}, index.Directories())
+	assert.Equal(t, []string{"Index", "Index.Refresh", "maxFiles", "run"}, index.Symbols(), "test files, main and init are left out")
+
+	// An untracked file, an edited file and a deleted one are picked up without a commit
+	writeFile("pkg/search.go", "package pkg\n\nfunc Search() {}\n")
+	writeFile("main.go", "package main\n\nfunc main() {}\n")
+	require.NoError(t, os.Remove(filepath.Join(dir, "notes.txt")))
+	require.NoError(t, index.Refresh())
+	assert.Equal(t, []string{"main.go", "pkg/index.go", "pkg/index_test.go", "pkg/search.go"}, index.Files())
+	assert.Equal(t, []string{"Index", "Index.Refresh", "Search", "maxFiles"}, index.Symbols())
+	assert.Equal(t, []string{"main.go", "pkg/search.go"}, index.Changed())
+
+	// Committing the changes, then deleting a committed file, keeps the index in sync
+	commit()
+	require.NoError(t, os.Remove(filepath.Join(dir, "pkg/search.go")))
+	commit()
+	require.NoError(t, index.Refresh())
+	assert.Equal(t, []string{"main.go", "pkg/index.go", "pkg/index_test.go"}, index.Files())
+	assert.Equal(t, []string{"Index", "Index.Refresh", "maxFiles"}, index.Symbols())
+}
@@ -0,0 +1,142 @@
+package completer
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Scores of the runes of a fuzzy match.
+const (
+	matchScore       = 1
+	consecutiveBonus = 5  // The rune follows the previous matched rune
+	boundaryBonus    = 8  // The rune starts the candidate, a path element, a word or a camel case hump
+	prefixBonus      = 15 // The candidate starts with the pattern
+	gapPenalty       = 1  // Per rune skipped between two matched runes, at most maxGapPenalty per gap
+	maxGapPenalty    = 3
+)
+
+// fuzzyScore reports whether the runes of pattern appear in candidate in order, ignoring case, and scores the match:
+// runes following each other or starting words score higher, skipped runes lower. The best of the matches starting
+// at every occurrence of the first rune of pattern is scored. An empty pattern matches every candidate with a score
+// of 0.
+func fuzzyScore(pattern string, candidate string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	patternRunes := []rune(strings.ToLower(pattern))
+	candidateRunes := []rune(candidate)
+
+	best, found := 0, false
+	for start, r := range candidateRunes {
+		if unicode.ToLower(r) != patternRunes[0] {
+			continue
+		}
+		score, ok := scoreFrom(patternRunes, candidateRunes, start)
+		if !ok {
+			// Later starts cannot match either
+			break
+		}
+		if !found || score > best {
+			best, found = score, true
+		}
+	}
+	if !found {
+		return 0, false
+	}
+	if strings.HasPrefix(strings.ToLower(candidate), string(patternRunes)) {
+		best += prefixBonus
+	}
+	return best, true
+}
+
+// scoreFrom matches the lower case runes of pattern in candidate greedily, from the rune at start, and scores the match.
+func scoreFrom(pattern []rune, candidate []rune, start int) (int, bool) {
+	score, matched, last := 0, 0, -1
+	for i := start; i < len(candidate) && matched < len(pattern); i++ {
+		if unicode.ToLower(candidate[i]) != pattern[matched] {
+			continue
+		}
+		score += matchScore
+		switch {
+		case last >= 0 && last == i-1:
+			score += consecutiveBonus
+		case last >= 0:
+			score -= min((i-last-1)*gapPenalty, maxGapPenalty)
+		}
+		if isBoundary(candidate, i) {
+			score += boundaryBonus
+		}
+		matched++
+		last = i
+	}
+	return score, matched == len(pattern)
+}
+
+// isBoundary reports whether the rune at i starts runes: the first rune, the rune after a separator or an upper case
+// rune after a lower case one.
+func isBoundary(runes []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	previous := runes[i-1]
+	switch previous {
+	case '/', '.', '_', '-', ' ', ':', '@':
+		return true
+	}
+	return unicode.IsLower(previous) && unicode.IsUpper(runes[i])
+}
+
+// match is a candidate matching a pattern, with its rank.
+type match struct {
+	value string
+	score int
+}
+
+// rankMatches returns the candidates matching pattern, best first: by score, including the bonus of recent
+// candidates, then by length and alphabetically. At most limit candidates are returned.
+func rankMatches(pattern string, candidates []string, recency func(string) int, limit int) []string {
+	var matches []match
+	for _, candidate := range candidates {
+		score, ok := fuzzyScore(pattern, candidate)
+		if !ok {
+			continue
+		}
+		matches = append(matches, match{value: candidate, score: score + recency(candidate)})
+	}
+	slices.SortFunc(matches, func(a, b match) int {
+		return cmp.Or(
+			cmp.Compare(b.score, a.score),
+			cmp.Compare(len(a.value), len(b.value)),
+			strings.Compare(a.value, b.value),
+		)
+	})
+
+	values := make([]string, 0, min(len(matches), limit))
+	for _, m := range matches[:min(len(matches), limit)] {
+		values = append(values, m.value)
+	}
+	return values
+}
+
+// longestCommonPrefix finds the longest common prefix among a slice of strings.
+func longestCommonPrefix(strs []string) string {
+	if len(strs) == 0 {
+		return ""
+	}
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		i := 0
+		for i < len(prefix) && i < len(s) && prefix[i] == s[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	// Do not split a multi-byte rune
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix
+}
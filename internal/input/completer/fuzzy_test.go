@@ -0,0 +1,54 @@
+package completer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	_, ok := fuzzyScore("cfglay", "internal/config/layers.go")
+	assert.True(t, ok, "runes in order")
+	_, ok = fuzzyScore("CFGLAY", "internal/config/layers.go")
+	assert.True(t, ok, "case is ignored")
+	_, ok = fuzzyScore("layc", "internal/config/layers.go")
+	assert.False(t, ok, "runes out of order")
+	score, ok := fuzzyScore("", "main.go")
+	assert.True(t, ok)
+	assert.Zero(t, score)
+
+	boundary, _ := fuzzyScore("lay", "internal/config/layers.go")
+	inside, _ := fuzzyScore("lay", "internal/display.go")
+	assert.Greater(t, boundary, inside, "matches starting a path element rank higher")
+
+	prefix, _ := fuzzyScore("main", "main.go")
+	scattered, _ := fuzzyScore("main", "internal/metrics/analysis_input.go")
+	assert.Greater(t, prefix, scattered)
+
+	camel, _ := fuzzyScore("wp", "Layers.WithProfile")
+	lower, _ := fuzzyScore("wp", "Layers.switchprofile")
+	assert.Greater(t, camel, lower, "camel case humps are boundaries")
+}
+
+func TestRankMatches(t *testing.T) {
+	candidates := []string{"internal/display.go", "internal/config/layers.go", "internal/config/layers_test.go", "README.md"}
+	noRecency := func(string) int { return 0 }
+
+	assert.Equal(t, []string{"internal/config/layers.go", "internal/config/layers_test.go", "internal/display.go"}, rankMatches("lay", candidates, noRecency, 10))
+	assert.Equal(t, []string{"internal/config/layers.go"}, rankMatches("lay", candidates, noRecency, 1))
+
+	recent := func(candidate string) int {
+		if candidate == "internal/display.go" {
+			return recencyBonus
+		}
+		return 0
+	}
+	assert.Equal(t, "internal/display.go", rankMatches("lay", candidates, recent, 10)[0], "recent candidates rank higher")
+}
+
+func TestLongestCommonPrefix(t *testing.T) {
+	assert.Equal(t, "", longestCommonPrefix(nil))
+	assert.Equal(t, "main.go", longestCommonPrefix([]string{"main.go"}))
+	assert.Equal(t, "internal/config/", longestCommonPrefix([]string{"internal/config/config.go", "internal/config/layers.go"}))
+	assert.Equal(t, "caf", longestCommonPrefix([]string{"café", "cafè"}), "runes are not split")
+}
@@ -8,6 +8,7 @@ import (
 	"io" // Import io for io.EOF
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
@@ -93,7 +94,12 @@ func GetLocalChangeRequest(currentWordCompleter *completer.CurrentWordCompleter)
 
 	shell.Prompt.Primary(func() string { return "\033[31m>>\033[0m " })
 
-	shell.Completer = currentWordCompleter.Do
+	shell.Completer = nil
+	if currentWordCompleter != nil {
+		shell.Completer = func(line []rune, pos int) readline.Completions {
+			return complete(currentWordCompleter, line, pos)
+		}
+	}
 
 	// Read the line using the shared shell instance
 	line, err := shell.Readline()
@@ -115,6 +121,25 @@ func GetLocalChangeRequest(currentWordCompleter *completer.CurrentWordCompleter)
 	return line, nil
 }
 
+// complete completes the word at the cursor of the shell. Readline only shows the candidates starting with the word,
+// so the word is replaced first, e.g. by the common prefix of its fuzzy matches. Fuzzy matches without a common
+// prefix are listed in a message instead, leaving the word as typed.
+func complete(currentWordCompleter *completer.CurrentWordCompleter, line []rune, pos int) readline.Completions {
+	completion := currentWordCompleter.Complete(line, pos)
+	if completion.Replacement != string(line[completion.Start:pos]) {
+		replacement := []rune(completion.Replacement)
+		shell.Line().Cut(completion.Start, pos)
+		shell.Line().Insert(completion.Start, replacement...)
+		shell.Cursor().Set(completion.Start + len(replacement))
+	}
+	for _, candidate := range completion.Candidates {
+		if !strings.HasPrefix(candidate, completion.Replacement) {
+			return readline.CompleteMessage("Matches: %s", strings.Join(completion.Candidates, "  "))
+		}
+	}
+	return readline.CompleteValues(completion.Candidates...).NoSort().DisplayList()
+}
+
 // GetUserInput gets generic user input using the shared readline shell.
 func GetUserInput(prompt string) (string, error) {
 	if shell == nil {
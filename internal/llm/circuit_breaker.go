@@ -0,0 +1,78 @@
+package llm
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of sending a request to a provider whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops requests to a provider after consecutive retryable failures. Once the cooldown
+// has passed, a single request is let through; the breaker closes if it succeeds and opens again if not.
+// It is safe for concurrent use, so the sessions of all roles using a provider can share it.
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	cooldown         time.Duration
+	failures         int
+	openedAt         time.Time
+	probing          bool // A request is being let through after the cooldown
+	now              func() time.Time
+}
+
+// NewCircuitBreaker creates a breaker that opens after failureThreshold consecutive failures, for cooldown.
+func NewCircuitBreaker(failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: max(failureThreshold, 1),
+		cooldown:         cooldown,
+		now:              time.Now,
+	}
+}
+
+// Allow reports whether a request may be sent.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.failureThreshold {
+		return true
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Success records a successful request and closes the breaker.
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+}
+
+// Release ends the request let through by Allow without recording its outcome, e.g. when it was canceled or
+// failed because of the request itself. A probe is released so that another request can probe the provider, and the
+// failures counted so far are kept.
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// Failure records a failed request, opening the breaker once the failure threshold is reached.
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.failures >= b.failureThreshold {
+		b.openedAt = b.now()
+	}
+}
@@ -0,0 +1,220 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+// summaryPrefix starts the message holding the summary of the turns evicted from a history.
+const summaryPrefix = "Summary of the earlier conversation:\n"
+
+// summaryAcknowledgement is the reply to the summary message, which keeps the roles of the history alternating.
+const summaryAcknowledgement = "Understood, I will continue from this summary."
+
+// HistoryBudget bounds the size of a session history. Zero values disable the corresponding bound.
+type HistoryBudget struct {
+	MaxTurns  int // Maximum number of user/assistant turns
+	MaxTokens int // Maximum number of estimated tokens
+}
+
+// exceeded reports whether history, with message about to be sent unless it is empty, is over the budget.
+func (b HistoryBudget) exceeded(history []models.Message, message string) bool {
+	messages := len(history)
+	if message != "" {
+		messages += 2 // The message and its response
+	}
+	return (b.MaxTurns > 0 && messages > b.MaxTurns*2) ||
+		(b.MaxTokens > 0 && estimatePromptTokens("", history, message) > b.MaxTokens)
+}
+
+// target returns the budget that history is compacted to before message is sent, and false if it needs no
+// compaction. A history over the budget is compacted to half the budget, so that it is not compacted again on the
+// next turn. A forced compaction, e.g. after the provider rejected the history as too long for its context window,
+// halves the history even if it is within the budget.
+func (b HistoryBudget) target(history []models.Message, message string, force bool) (HistoryBudget, bool) {
+	if force {
+		turns := (len(history) + 1) / 2
+		if message != "" {
+			turns++
+		}
+		return HistoryBudget{MaxTurns: max((turns+1)/2, 1), MaxTokens: max((estimatePromptTokens("", history, message)+1)/2, 1)}, true
+	}
+	if !b.exceeded(history, message) {
+		return b, false
+	}
+	return HistoryBudget{MaxTurns: (b.MaxTurns + 1) / 2, MaxTokens: (b.MaxTokens + 1) / 2}, true
+}
+
+// evict splits a history into its pinned first turn, the oldest turns to evict and the turns kept. Turns are
+// evicted until the pinned and kept turns, with message about to be sent, fit in target. The last turn is always
+// kept, which is message if it is set.
+func evict(history []models.Message, message string, target HistoryBudget) (pinned []models.Message, evicted []models.Message, kept []models.Message) {
+	minKept := 2
+	if message != "" {
+		minKept = 0
+	}
+	pinned = history[:min(2, len(history))]
+	evicted = []models.Message{}
+	kept = history[len(pinned):]
+	for len(kept) > minKept && target.exceeded(append(slices.Clip(pinned), kept...), message) {
+		evicted = append(evicted, kept[:min(2, len(kept))]...)
+		kept = kept[min(2, len(kept)):]
+	}
+	return pinned, evicted, kept
+}
+
+// split returns the turns of history to evict before message is sent, as evict does, and an empty evicted if history
+// needs no compaction.
+func (b HistoryBudget) split(history []models.Message, message string, force bool) (pinned []models.Message, evicted []models.Message, kept []models.Message) {
+	target, ok := b.target(history, message, force)
+	if !ok {
+		return history, nil, nil
+	}
+	return evict(history, message, target)
+}
+
+// HistoryCompactor shrinks a session history that grew over its budget.
+type HistoryCompactor interface {
+	// Compact returns the compacted history, and false if it was left unchanged. The history is compacted if it is over
+	// its budget once message, unless it is empty, is sent, or if force is set.
+	Compact(ctx context.Context, history []models.Message, message string, force bool) ([]models.Message, bool)
+}
+
+// TruncatingCompactor drops the oldest turns of a history, except the first one holding the task of the session.
+type TruncatingCompactor struct {
+	budget HistoryBudget
+}
+
+// NewTruncatingCompactor creates a TruncatingCompactor keeping histories within budget.
+func NewTruncatingCompactor(budget HistoryBudget) *TruncatingCompactor {
+	return &TruncatingCompactor{budget: budget}
+}
+
+func (c *TruncatingCompactor) Compact(_ context.Context, history []models.Message, message string, force bool) ([]models.Message, bool) {
+	pinned, evicted, kept := c.budget.split(history, message, force)
+	if len(evicted) == 0 {
+		return history, false
+	}
+	logging.Logger.Debugf("Dropping %d messages from an LLM session history over its budget.", len(evicted))
+	return append(slices.Clone(pinned), kept...), true
+}
+
+// Summarizer condenses conversation turns into a summary.
+type Summarizer interface {
+	Summarize(ctx context.Context, turns []models.Message) (string, error)
+}
+
+// SummarizingCompactor replaces the oldest turns of a history, except the first one holding the task of
+// the session, with a summary. If summarizing fails, the turns are dropped as by a TruncatingCompactor.
+type SummarizingCompactor struct {
+	budget     HistoryBudget
+	summarizer Summarizer
+}
+
+// NewSummarizingCompactor creates a SummarizingCompactor keeping histories within budget with summarizer.
+func NewSummarizingCompactor(budget HistoryBudget, summarizer Summarizer) *SummarizingCompactor {
+	return &SummarizingCompactor{budget: budget, summarizer: summarizer}
+}
+
+func (c *SummarizingCompactor) Compact(ctx context.Context, history []models.Message, message string, force bool) ([]models.Message, bool) {
+	// A previous summary is the oldest turn after the pinned one, so it is evicted first and folded into the new summary
+	pinned, evicted, kept := c.budget.split(history, message, force)
+	if len(evicted) == 0 {
+		return history, false
+	}
+
+	compacted := slices.Clone(pinned)
+	summary, err := c.summarizer.Summarize(ctx, evicted)
+	if err != nil {
+		logging.Logger.Warnf("Failed to summarize %d messages of an LLM session history, dropping them instead: %v", len(evicted), err)
+	} else {
+		logging.Logger.Debugf("Summarized %d messages of an LLM session history over its budget.", len(evicted))
+		compacted = append(compacted,
+			models.Message{Role: "user", Content: summaryPrefix + summary},
+			models.Message{Role: "assistant", Content: summaryAcknowledgement},
+		)
+	}
+	return append(compacted, kept...), true
+}
+
+// SessionSummarizer summarizes turns by sending them to an LLM session, starting from an empty history
+// every time. It is safe for concurrent use.
+type SessionSummarizer struct {
+	mu      sync.Mutex
+	session LLMSession
+}
+
+// NewSessionSummarizer creates a SessionSummarizer sending its requests to session.
+func NewSessionSummarizer(session LLMSession) *SessionSummarizer {
+	return &SessionSummarizer{session: session}
+}
+
+func (s *SessionSummarizer) Summarize(ctx context.Context, turns []models.Message) (string, error) {
+	var conversation strings.Builder
+	for _, turn := range turns {
+		fmt.Fprintf(&conversation, "%s:\n%s\n\n", turn.Role, turn.Content)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session.SetHistory([]models.Message{})
+	summary, err := s.session.SendMessage(ctx, fmt.Sprintf("Summarize the following conversation:\n\n%s", conversation.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+	return strings.TrimSpace(summary), nil
+}
+
+// CompactingSession decorates an LLMSession and compacts its history before every message, counting the message. If
+// the provider rejects a message as too long for the context window of its model, the history is compacted further
+// and the message is sent once more.
+type CompactingSession struct {
+	llmSession LLMSession
+	compactor  HistoryCompactor
+}
+
+// NewCompactingSession wraps llmSession so that its history is compacted by compactor.
+func NewCompactingSession(llmSession LLMSession, compactor HistoryCompactor) *CompactingSession {
+	return &CompactingSession{llmSession: llmSession, compactor: compactor}
+}
+
+func (s *CompactingSession) SendMessage(ctx context.Context, message string, options ...Option) (string, error) {
+	s.compact(ctx, message, false)
+	response, err := s.llmSession.SendMessage(ctx, message, options...)
+	if err != nil && ClassifyError(err) == ErrorClassContextLength && s.compact(ctx, message, true) {
+		logging.Logger.Warnf("The request exceeds the context window of the model, retrying with a compacted history: %v", err)
+		response, err = s.llmSession.SendMessage(ctx, message, options...)
+	}
+	return response, err
+}
+
+// compact compacts the history of the session before message is sent, and reports whether it changed.
+func (s *CompactingSession) compact(ctx context.Context, message string, force bool) bool {
+	history, compacted := s.compactor.Compact(ctx, s.llmSession.GetHistory(), message, force)
+	if compacted {
+		s.llmSession.SetHistory(history)
+	}
+	return compacted
+}
+
+func (s *CompactingSession) GetHistory() []models.Message {
+	return s.llmSession.GetHistory()
+}
+
+func (s *CompactingSession) SetHistory(history []models.Message) {
+	s.llmSession.SetHistory(history)
+}
+
+// LastUsage returns the usage reported for the last message by the decorated session, if it reports usage.
+func (s *CompactingSession) LastUsage() (TokenUsage, bool) {
+	if reporter, ok := s.llmSession.(UsageReporter); ok {
+		return reporter.LastUsage()
+	}
+	return TokenUsage{}, false
+}
@@ -0,0 +1,175 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+)
+
+// turns creates a history of n user/assistant turns.
+func turns(n int) []models.Message {
+	var history []models.Message
+	for i := 1; i <= n; i++ {
+		history = append(history,
+			models.Message{Role: "user", Content: fmt.Sprintf("request %d", i)},
+			models.Message{Role: "assistant", Content: fmt.Sprintf("response %d", i)},
+		)
+	}
+	return history
+}
+
+// stubSummarizer records the turns it summarizes.
+type stubSummarizer struct {
+	summarized [][]models.Message
+	err        error
+}
+
+func (s *stubSummarizer) Summarize(_ context.Context, turns []models.Message) (string, error) {
+	s.summarized = append(s.summarized, turns)
+	return fmt.Sprintf("summary of %d messages", len(turns)), s.err
+}
+
+func TestTruncatingCompactor(t *testing.T) {
+	compactor := NewTruncatingCompactor(HistoryBudget{MaxTurns: 4})
+
+	history, compacted := compactor.Compact(context.Background(), turns(4), "", false)
+	assert.False(t, compacted)
+	assert.Equal(t, turns(4), history)
+
+	// The first turn is pinned, and turns are dropped until half the budget is left
+	history, compacted = compactor.Compact(context.Background(), turns(5), "", false)
+	assert.True(t, compacted)
+	assert.Equal(t, append(turns(1), turns(5)[8:]...), history)
+}
+
+func TestTruncatingCompactor_TokenBudget(t *testing.T) {
+	history := turns(3)
+	history[3].Content = strings.Repeat("x", 400) // 100 tokens
+	compactor := NewTruncatingCompactor(HistoryBudget{MaxTokens: 100})
+
+	compactedHistory, compacted := compactor.Compact(context.Background(), history, "", false)
+	assert.True(t, compacted)
+	assert.Equal(t, []models.Message{history[0], history[1], history[4], history[5]}, compactedHistory)
+
+	// The last turn is kept even if it is over the budget on its own
+	compactedHistory, compacted = compactor.Compact(context.Background(), history[:4], "", false)
+	assert.False(t, compacted)
+	assert.Equal(t, history[:4], compactedHistory)
+}
+
+func TestSummarizingCompactor(t *testing.T) {
+	summarizer := &stubSummarizer{}
+	compactor := NewSummarizingCompactor(HistoryBudget{MaxTurns: 4}, summarizer)
+
+	history, compacted := compactor.Compact(context.Background(), turns(5), "", false)
+	require.True(t, compacted)
+	require.Len(t, history, 6)
+	assert.Equal(t, turns(1), history[:2])
+	assert.Equal(t, summaryPrefix+"summary of 6 messages", history[2].Content)
+	assert.Equal(t, "assistant", history[3].Role)
+	assert.Equal(t, turns(5)[8:], history[4:])
+
+	// The previous summary is folded into the next one
+	history = append(history, turns(8)[10:]...)
+	history, compacted = compactor.Compact(context.Background(), history, "", false)
+	require.True(t, compacted)
+	require.Len(t, summarizer.summarized, 2)
+	assert.Equal(t, summaryPrefix+"summary of 6 messages", summarizer.summarized[1][0].Content)
+	assert.Equal(t, summaryPrefix+"summary of 8 messages", history[2].Content)
+	assert.Equal(t, turns(8)[14:], history[4:])
+}
+
+func TestSummarizingCompactor_FailedSummaryDropsTurns(t *testing.T) {
+	compactor := NewSummarizingCompactor(HistoryBudget{MaxTurns: 4}, &stubSummarizer{err: errors.New("unavailable")})
+
+	history, compacted := compactor.Compact(context.Background(), turns(5), "", false)
+	assert.True(t, compacted)
+	assert.Equal(t, append(turns(1), turns(5)[8:]...), history)
+}
+
+// conversationSession is an LLMSession whose history records both the requests and the responses.
+type conversationSession struct {
+	*MockLLMSession
+}
+
+func (s *conversationSession) SendMessage(_ context.Context, message string, _ ...Option) (string, error) {
+	s.History = append(s.History, models.Message{Role: "user", Content: message}, models.Message{Role: "assistant", Content: "response"})
+	return "response", nil
+}
+
+func TestCompactingSession(t *testing.T) {
+	mockSession := &conversationSession{NewMockLLMSession("", nil)}
+	summarySession := NewMockLLMSession("the summary", nil)
+	summarySession.SetHistory(turns(1))
+	session := NewCompactingSession(mockSession, NewSummarizingCompactor(HistoryBudget{MaxTurns: 1}, NewSessionSummarizer(summarySession)))
+
+	_, err := session.SendMessage(context.Background(), "first")
+	require.NoError(t, err)
+	_, err = session.SendMessage(context.Background(), "second")
+	require.NoError(t, err)
+	_, err = session.SendMessage(context.Background(), "third")
+	require.NoError(t, err)
+
+	history := session.GetHistory()
+	require.Len(t, history, 6)
+	assert.Equal(t, "first", history[0].Content)
+	assert.Equal(t, summaryPrefix+"the summary", history[2].Content)
+	assert.Equal(t, "third", history[4].Content)
+	require.Len(t, summarySession.GetHistory(), 1, "every summary starts from an empty history")
+	assert.Contains(t, summarySession.GetHistory()[0].Content, "second")
+}
+
+func TestCompactingSession_CountsTheMessage(t *testing.T) {
+	mockSession := &conversationSession{NewMockLLMSession("", nil)}
+	mockSession.SetHistory(turns(3))
+	session := NewCompactingSession(mockSession, NewTruncatingCompactor(HistoryBudget{MaxTokens: 100}))
+
+	// The history is within the budget, but not with the whole file sent next
+	_, err := session.SendMessage(context.Background(), strings.Repeat("x", 400))
+	require.NoError(t, err)
+	history := session.GetHistory()
+	require.Len(t, history, 4)
+	assert.Equal(t, turns(1), history[:2])
+}
+
+// contextWindowSession is a conversationSession whose provider rejects histories longer than maxMessages.
+type contextWindowSession struct {
+	*conversationSession
+	maxMessages int
+	sent        int
+}
+
+func (s *contextWindowSession) SendMessage(ctx context.Context, message string, options ...Option) (string, error) {
+	s.sent++
+	if len(s.History) > s.maxMessages {
+		return "", &ProviderError{Provider: "groq/llama", Class: ErrorClassContextLength, Err: errors.New("context_length_exceeded")}
+	}
+	return s.conversationSession.SendMessage(ctx, message, options...)
+}
+
+func TestCompactingSession_CompactsOnContextLengthError(t *testing.T) {
+	mockSession := &contextWindowSession{conversationSession: &conversationSession{NewMockLLMSession("", nil)}, maxMessages: 6}
+	mockSession.SetHistory(turns(5))
+	session := NewCompactingSession(mockSession, NewTruncatingCompactor(HistoryBudget{MaxTurns: 100}))
+
+	response, err := session.SendMessage(context.Background(), "next")
+	require.NoError(t, err)
+	assert.Equal(t, "response", response)
+	assert.Equal(t, 2, mockSession.sent, "the message is sent once more after compacting")
+	history := session.GetHistory()
+	assert.Equal(t, turns(1), history[:2])
+	assert.Equal(t, "next", history[len(history)-2].Content)
+
+	// A history that cannot be compacted further is not retried
+	mockSession.maxMessages, mockSession.sent = 0, 0
+	_, err = session.SendMessage(context.Background(), "again")
+	assert.Equal(t, ErrorClassContextLength, ClassifyError(err))
+	assert.LessOrEqual(t, mockSession.sent, 2)
+}
@@ -0,0 +1,208 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/jpoz/groq"
+	"github.com/openai/openai-go"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrorClass groups provider errors by how they should be handled.
+type ErrorClass string
+
+const (
+	ErrorClassRateLimit     ErrorClass = "rate-limit"     // Too many requests or tokens; retryable after a delay
+	ErrorClassTransient     ErrorClass = "transient"      // Server, timeout and network errors; retryable
+	ErrorClassAuth          ErrorClass = "auth"           // Missing, invalid or unauthorized API key
+	ErrorClassContextLength ErrorClass = "context-length" // The request does not fit in the context window of the model
+	ErrorClassContentFilter ErrorClass = "content-filter" // The prompt or the response was blocked by the provider
+	ErrorClassUnknown       ErrorClass = "unknown"
+)
+
+var (
+	// statusCodePattern extracts the status code from errors that only report it in their message, e.g. Groq's.
+	statusCodePattern = regexp.MustCompile(`status code: (\d{3})`)
+	// retryDelayPattern extracts the delay suggested in the message of rate limit errors,
+	// e.g. "Please try again in 7.66s" (Groq, OpenAI) or "retryDelay": "37s" (Gemini).
+	retryDelayPattern = regexp.MustCompile(`(?i)(?:try again in|retry in|"retryDelay":\s*")\s*((?:\d+(?:\.\d+)?(?:ms|h|m|s))+)`)
+)
+
+// Message fragments identifying the class of errors whose status code is ambiguous, e.g. a 400 for a bad API key.
+var (
+	authMessages          = []string{"api key not valid", "invalid api key", "invalid_api_key", "incorrect api key", "api_key_invalid", "permission denied", "unauthenticated"}
+	contextLengthMessages = []string{"context length", "context_length_exceeded", "context window", "maximum context", "too many tokens", "input token count", "prompt is too long", "reduce the length"}
+	contentFilterMessages = []string{"content_filter", "content filter", "content_policy", "content management policy"}
+	rateLimitMessages     = []string{"rate limit", "rate_limit", "quota", "resource_exhausted", "resource exhausted"}
+)
+
+// ProviderError is an error returned by a provider, annotated with its class and an actionable hint.
+type ProviderError struct {
+	Provider string // e.g. gemini/gemini-2.0-flash
+	Class    ErrorClass
+	Attempts int // Number of times the request was sent
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	message := fmt.Sprintf("%s: %v", e.Provider, e.Err)
+	if e.Attempts > 1 {
+		message = fmt.Sprintf("%s failed %d times: %v", e.Provider, e.Attempts, e.Err)
+	}
+	if hint := e.hint(); hint != "" {
+		message += " (" + hint + ")"
+	}
+	return message
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// hint tells the user how to resolve errors that retrying does not fix.
+func (e *ProviderError) hint() string {
+	switch e.Class {
+	case ErrorClassAuth:
+		return "the API key was rejected: check the API key flag or environment variable of the service, and that the key has access to the model"
+	case ErrorClassContextLength:
+		return "the request exceeds the context window of the model: lower max_history_length, narrow the change request or use a model with a larger context window"
+	case ErrorClassContentFilter:
+		return "the provider blocked the request or the response with its content filter: rephrase the change request"
+	case ErrorClassRateLimit:
+		return "the provider is still rate limiting requests: lower the rate limit with -rate-limit, raise retry.max_attempts or configure fallbacks"
+	default:
+		return ""
+	}
+}
+
+// ClassifyError returns the class of an error returned by a provider.
+func ClassifyError(err error) ErrorClass {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return ErrorClassUnknown
+	}
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Class
+	}
+	var blockedErr *genai.BlockedError
+	if errors.As(err, &blockedErr) {
+		return ErrorClassContentFilter
+	}
+
+	message := strings.ToLower(err.Error())
+	var groqErr groq.Error
+	var openaiErr *openai.Error
+	switch {
+	case errors.As(err, &groqErr):
+		message = strings.ToLower(groqErr.Type + ": " + groqErr.Message)
+	case errors.As(err, &openaiErr):
+		message = strings.ToLower(openaiErr.Code + ": " + openaiErr.Type + ": " + openaiErr.Message)
+	}
+	switch {
+	case containsAny(message, authMessages):
+		return ErrorClassAuth
+	case containsAny(message, contextLengthMessages):
+		return ErrorClassContextLength
+	case containsAny(message, contentFilterMessages):
+		return ErrorClassContentFilter
+	}
+
+	if code, ok := statusCode(err); ok {
+		switch {
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			return ErrorClassAuth
+		case code == http.StatusTooManyRequests:
+			return ErrorClassRateLimit
+		case code == http.StatusRequestEntityTooLarge:
+			return ErrorClassContextLength
+		case code == http.StatusRequestTimeout || code >= http.StatusInternalServerError:
+			return ErrorClassTransient
+		default:
+			return ErrorClassUnknown
+		}
+	}
+	switch {
+	case containsAny(message, rateLimitMessages):
+		return ErrorClassRateLimit
+	case errors.As(err, &groqErr) && strings.Contains(groqErr.Type, "server_error"):
+		return ErrorClassTransient
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorClassTransient
+	}
+	return ErrorClassUnknown
+}
+
+// IsRetryable reports whether a request that failed with err may succeed if it is sent again, possibly
+// to another provider: rate limits, server errors, timeouts and network errors are retryable.
+func IsRetryable(err error) bool {
+	class := ClassifyError(err)
+	return class == ErrorClassRateLimit || class == ErrorClassTransient
+}
+
+// RetryAfter returns the delay a provider asked to wait before retrying, from the Retry-After header
+// of the response or from the error message.
+func RetryAfter(err error) (time.Duration, bool) {
+	var header http.Header
+	var googleErr *googleapi.Error
+	var openaiErr *openai.Error
+	switch {
+	case errors.As(err, &googleErr):
+		header = googleErr.Header
+	case errors.As(err, &openaiErr) && openaiErr.Response != nil:
+		header = openaiErr.Response.Header
+	}
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, parseErr := strconv.Atoi(value); parseErr == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, parseErr := http.ParseTime(value); parseErr == nil {
+			return max(time.Until(date), 0), true
+		}
+	}
+
+	if match := retryDelayPattern.FindStringSubmatch(err.Error()); match != nil {
+		if delay, parseErr := time.ParseDuration(match[1]); parseErr == nil {
+			return delay, true
+		}
+	}
+	return 0, false
+}
+
+// statusCode returns the HTTP status code of a provider error, if it is known.
+func statusCode(err error) (int, bool) {
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code, true
+	}
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode, true
+	}
+	if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		return code, true
+	}
+	return 0, false
+}
+
+// containsAny reports whether s contains any of the fragments.
+func containsAny(s string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if strings.Contains(s, fragment) {
+			return true
+		}
+	}
+	return false
+}
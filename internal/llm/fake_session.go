@@ -0,0 +1,142 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNoScriptedResponse is returned when a fake session receives a message that no scripted response matches.
+var ErrNoScriptedResponse = errors.New("no scripted response matches the message")
+
+// FakeResponse is a canned response of a fake session.
+type FakeResponse struct {
+	// Match is a regular expression the incoming message must match; an empty Match matches every message.
+	Match    string `yaml:"match" json:"match"`
+	Response string `yaml:"response" json:"response"`
+	// Error, if set, is returned as the error of the call instead of a response.
+	Error string `yaml:"error" json:"error"`
+	// Repeat allows the response to be served any number of times; otherwise it is served once.
+	Repeat bool `yaml:"repeat" json:"repeat"`
+
+	pattern *regexp.Regexp
+	used    bool
+}
+
+// FakeScript holds the canned responses of every assistant role, e.g. code_analysis or generate_code.
+// It is safe for concurrent use by the fake sessions sharing it.
+type FakeScript struct {
+	Roles map[string][]*FakeResponse `yaml:"roles" json:"roles"`
+
+	mu sync.Mutex
+}
+
+// LoadFakeScript reads a YAML or JSON script of canned responses.
+func LoadFakeScript(path string) (*FakeScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fake script %s: %w", path, err)
+	}
+	script, err := ParseFakeScript(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fake script %s: %w", path, err)
+	}
+	return script, nil
+}
+
+// ParseFakeScript parses a YAML or JSON script of canned responses and compiles its patterns.
+func ParseFakeScript(data []byte) (*FakeScript, error) {
+	script := &FakeScript{}
+	// JSON is a subset of YAML, so both formats are parsed by the YAML decoder
+	if err := yaml.Unmarshal(data, script); err != nil {
+		return nil, fmt.Errorf("failed to parse fake script: %w", err)
+	}
+	for role, responses := range script.Roles {
+		for i, response := range responses {
+			if response == nil {
+				return nil, fmt.Errorf("response %d of role %s is empty", i+1, role)
+			}
+			pattern, err := regexp.Compile(response.Match)
+			if err != nil {
+				return nil, fmt.Errorf("invalid match pattern of response %d of role %s: %w", i+1, role, err)
+			}
+			response.pattern = pattern
+		}
+	}
+	return script, nil
+}
+
+// next returns the first response of role that matches message and has not been served yet.
+func (s *FakeScript) next(role string, message string) (*FakeResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, response := range s.Roles[role] {
+		if response.used && !response.Repeat {
+			continue
+		}
+		if response.pattern.MatchString(message) {
+			response.used = true
+			return response, nil
+		}
+	}
+	return nil, fmt.Errorf("%w for role %s: %.200q", ErrNoScriptedResponse, role, message)
+}
+
+// Unused returns, per role, the number of non-repeatable responses that were never served.
+func (s *FakeScript) Unused() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unused := make(map[string]int)
+	for role, responses := range s.Roles {
+		for _, response := range responses {
+			if !response.used && !response.Repeat {
+				unused[role]++
+			}
+		}
+	}
+	return unused
+}
+
+// FakeSession implements LLMSession by serving the responses scripted for a role, without calling any LLM.
+type FakeSession struct {
+	script  *FakeScript
+	role    string
+	history []models.Message
+}
+
+// NewFakeSession creates a session that serves the responses scripted for role.
+func NewFakeSession(script *FakeScript, role string) *FakeSession {
+	return &FakeSession{
+		script:  script,
+		role:    role,
+		history: []models.Message{},
+	}
+}
+
+func (s *FakeSession) SendMessage(_ context.Context, message string, _ ...Option) (string, error) {
+	response, err := s.script.next(s.role, message)
+	if err != nil {
+		return "", err
+	}
+	if response.Error != "" {
+		return "", errors.New(response.Error)
+	}
+	s.history = append(s.history, models.Message{Role: "user", Content: message}, models.Message{Role: "model", Content: response.Response})
+	return response.Response, nil
+}
+
+func (s *FakeSession) GetHistory() []models.Message {
+	return s.history
+}
+
+func (s *FakeSession) SetHistory(history []models.Message) {
+	s.history = history
+}
@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeSession_ServesScriptedResponses(t *testing.T) {
+	script, err := ParseFakeScript([]byte(`
+roles:
+  code_analysis:
+    - match: "context files"
+      response: "No context files are needed."
+    - response: "Create the file."
+      repeat: true
+  generate_code:
+    - error: "quota exceeded"
+`))
+	require.NoError(t, err)
+
+	analysis := NewFakeSession(script, "code_analysis")
+	response, err := analysis.SendMessage(context.Background(), "Which context files should be provided?")
+	require.NoError(t, err)
+	assert.Equal(t, "No context files are needed.", response)
+
+	// A served response is not served again, so the catch-all response answers from now on.
+	for i := 0; i < 2; i++ {
+		response, err = analysis.SendMessage(context.Background(), "Which context files should be provided?")
+		require.NoError(t, err)
+		assert.Equal(t, "Create the file.", response)
+	}
+	assert.Len(t, analysis.GetHistory(), 6)
+
+	_, err = NewFakeSession(script, "generate_code").SendMessage(context.Background(), "generate")
+	assert.EqualError(t, err, "quota exceeded")
+
+	_, err = NewFakeSession(script, "generate_code").SendMessage(context.Background(), "generate again")
+	assert.True(t, errors.Is(err, ErrNoScriptedResponse))
+	assert.Empty(t, script.Unused())
+}
+
+func TestParseFakeScript_JSON(t *testing.T) {
+	script, err := ParseFakeScript([]byte(`{"roles": {"ask_instruction": [{"match": "^Answer", "response": "{\"command\": \"respond\"}"}]}}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"ask_instruction": 1}, script.Unused())
+
+	response, err := NewFakeSession(script, "ask_instruction").SendMessage(context.Background(), "Answer the question")
+	require.NoError(t, err)
+	assert.Equal(t, `{"command": "respond"}`, response)
+}
+
+func TestParseFakeScript_InvalidPattern(t *testing.T) {
+	_, err := ParseFakeScript([]byte(`
+roles:
+  code_analysis:
+    - match: "("
+      response: "never"
+`))
+	assert.ErrorContains(t, err, "invalid match pattern of response 1 of role code_analysis")
+}
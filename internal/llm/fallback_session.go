@@ -0,0 +1,104 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+// FallbackProvider is one provider of a fallback chain.
+type FallbackProvider struct {
+	Name    string // Identifies the provider in logs, e.g. gemini/gemini-2.5-flash
+	Session LLMSession
+	Breaker *CircuitBreaker // Shared by every session using the same provider; nil disables the breaker
+}
+
+// FallbackSession implements LLMSession over an ordered chain of providers. Every message is sent to the
+// first provider whose circuit breaker is closed; if it fails with a retryable error, the message is sent
+// to the next one, after giving it the conversation history of the provider that served the previous turn.
+type FallbackSession struct {
+	role      string
+	providers []FallbackProvider
+	current   int // Index of the provider that served the last turn, which holds the conversation history
+}
+
+// NewFallbackSession creates a session for role that fails over along providers, in order.
+func NewFallbackSession(role string, providers ...FallbackProvider) *FallbackSession {
+	return &FallbackSession{role: role, providers: providers}
+}
+
+func (s *FallbackSession) SendMessage(ctx context.Context, message string, options ...Option) (string, error) {
+	// Failed attempts may leave the message in a provider's history, so every attempt starts from this copy
+	history := slices.Clone(s.providers[s.current].Session.GetHistory())
+
+	var errs []error
+	for i, provider := range s.providers {
+		if provider.Breaker != nil && !provider.Breaker.Allow() {
+			errs = append(errs, fmt.Errorf("%s: %w", provider.Name, ErrCircuitOpen))
+			continue
+		}
+		if i != s.current {
+			provider.Session.SetHistory(slices.Clone(history))
+		}
+
+		response, err := provider.Session.SendMessage(ctx, message, options...)
+		if err == nil {
+			if provider.Breaker != nil {
+				provider.Breaker.Success()
+			}
+			if i != s.current {
+				logging.Logger.Infof("The %s session switched to provider %s.", s.role, provider.Name)
+			}
+			logging.Logger.Debugf("The %s turn was served by %s.", s.role, provider.Name)
+			s.current = i
+			return response, nil
+		}
+
+		provider.Session.SetHistory(slices.Clone(history))
+		if !IsRetryable(err) {
+			// The request was canceled or is at fault, which tells nothing about the provider: its probe, if any, is
+			// released and its failures are kept
+			if provider.Breaker != nil {
+				provider.Breaker.Release()
+			}
+			return "", providerError(provider.Name, err)
+		}
+		if provider.Breaker != nil {
+			provider.Breaker.Failure()
+		}
+		errs = append(errs, providerError(provider.Name, err))
+		if i < len(s.providers)-1 {
+			logging.Logger.Warnf("Provider %s failed for the %s session, failing over: %v", provider.Name, s.role, err)
+		}
+	}
+	return "", fmt.Errorf("every provider of the %s session failed: %w", s.role, errors.Join(errs...))
+}
+
+func (s *FallbackSession) GetHistory() []models.Message {
+	return s.providers[s.current].Session.GetHistory()
+}
+
+func (s *FallbackSession) SetHistory(history []models.Message) {
+	s.providers[s.current].Session.SetHistory(history)
+}
+
+// LastUsage returns the usage reported by the provider that served the last turn, if it reports usage.
+func (s *FallbackSession) LastUsage() (TokenUsage, bool) {
+	if reporter, ok := s.providers[s.current].Session.(UsageReporter); ok {
+		return reporter.LastUsage()
+	}
+	return TokenUsage{}, false
+}
+
+// providerError prefixes err with the name of the provider, unless it already is a *ProviderError.
+func providerError(provider string, err error) error {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return err
+	}
+	return fmt.Errorf("%s: %w", provider, err)
+}
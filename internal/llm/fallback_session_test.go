@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	assert.True(t, breaker.Allow())
+	breaker.Failure()
+	assert.False(t, breaker.Allow(), "the breaker opens at the failure threshold")
+
+	now = now.Add(time.Minute)
+	assert.True(t, breaker.Allow(), "a single request is let through after the cooldown")
+	assert.False(t, breaker.Allow())
+	breaker.Failure()
+	assert.False(t, breaker.Allow(), "a failed probe opens the breaker again")
+
+	now = now.Add(time.Minute)
+	require.True(t, breaker.Allow())
+	breaker.Success()
+	assert.True(t, breaker.Allow())
+	assert.True(t, breaker.Allow())
+}
+
+func TestFallbackSession_FailsOverWithHistory(t *testing.T) {
+	primary := NewMockLLMSession("primary response", nil)
+	fallback := NewMockLLMSession("fallback response", nil)
+	breaker := NewCircuitBreaker(2, time.Hour)
+	session := NewFallbackSession("code_analysis",
+		FallbackProvider{Name: "gemini/flash", Session: primary, Breaker: breaker},
+		FallbackProvider{Name: "groq/llama", Session: fallback, Breaker: NewCircuitBreaker(2, time.Hour)},
+	)
+
+	response, err := session.SendMessage(context.Background(), "first")
+	require.NoError(t, err)
+	assert.Equal(t, "primary response", response)
+
+	primary.SendMessageError = &googleapi.Error{Code: http.StatusTooManyRequests}
+	response, err = session.SendMessage(context.Background(), "second")
+	require.NoError(t, err)
+	assert.Equal(t, "fallback response", response)
+	// The fallback received the history of the primary, which is restored to its state before the failed turn
+	assert.Equal(t, []models.Message{{Content: "first"}, {Content: "second"}}, session.GetHistory())
+	assert.Equal(t, []models.Message{{Content: "first"}}, primary.GetHistory())
+
+	// The next turn tries the primary again, and skips it once its breaker is open
+	_, err = session.SendMessage(context.Background(), "third")
+	require.NoError(t, err)
+	primary.SendMessageError = nil
+	_, err = session.SendMessage(context.Background(), "fourth")
+	require.NoError(t, err)
+	assert.False(t, breaker.Allow())
+	assert.Equal(t, []models.Message{{Content: "first"}, {Content: "second"}}, primary.GetHistory())
+	assert.Len(t, session.GetHistory(), 4)
+}
+
+func TestFallbackSession_NonRetryableErrorSurfaces(t *testing.T) {
+	fallback := NewMockLLMSession("fallback response", nil)
+	session := NewFallbackSession("generate_code",
+		FallbackProvider{Name: "openai/gpt", Session: NewMockLLMSession("", newOpenAIError(http.StatusUnauthorized, "", ""))},
+		FallbackProvider{Name: "groq/llama", Session: fallback},
+	)
+
+	_, err := session.SendMessage(context.Background(), "message")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "openai/gpt")
+	assert.Empty(t, fallback.GetHistory())
+}
+
+func TestFallbackSession_NonRetryableErrorReleasesProbe(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+	primary := NewMockLLMSession("primary response", &googleapi.Error{Code: http.StatusServiceUnavailable})
+	session := NewFallbackSession("generate_code",
+		FallbackProvider{Name: "gemini/flash", Session: primary, Breaker: breaker},
+		FallbackProvider{Name: "groq/llama", Session: NewMockLLMSession("fallback response", nil)},
+	)
+
+	_, err := session.SendMessage(context.Background(), "first")
+	require.NoError(t, err)
+	require.False(t, breaker.Allow())
+
+	// The half-open probe fails because of the request, which does not keep the provider skipped
+	now = now.Add(time.Minute)
+	primary.SendMessageError = &googleapi.Error{Code: http.StatusBadRequest}
+	_, err = session.SendMessage(context.Background(), "second")
+	require.ErrorContains(t, err, "gemini/flash")
+
+	primary.SendMessageError = nil
+	response, err := session.SendMessage(context.Background(), "third")
+	require.NoError(t, err)
+	assert.Equal(t, "primary response", response)
+}
+
+func TestFallbackSession_CanceledProbeKeepsBreakerOpen(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+	primary := NewMockLLMSession("primary response", &googleapi.Error{Code: http.StatusServiceUnavailable})
+	session := NewFallbackSession("generate_code",
+		FallbackProvider{Name: "gemini/flash", Session: primary, Breaker: breaker},
+		FallbackProvider{Name: "groq/llama", Session: NewMockLLMSession("fallback response", nil)},
+	)
+
+	_, err := session.SendMessage(context.Background(), "first")
+	require.NoError(t, err)
+
+	// The user cancels the half-open probe before the provider answers
+	now = now.Add(time.Minute)
+	primary.SendMessageError = context.Canceled
+	_, err = session.SendMessage(context.Background(), "second")
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.True(t, breaker.Allow(), "another request can probe the provider")
+	assert.False(t, breaker.Allow(), "the breaker is still half-open, not closed")
+}
+
+func TestFallbackSession_NonRetryableErrorKeepsFailures(t *testing.T) {
+	breaker := NewCircuitBreaker(3, time.Hour)
+	primary := NewMockLLMSession("primary response", &googleapi.Error{Code: http.StatusServiceUnavailable})
+	session := NewFallbackSession("generate_code",
+		FallbackProvider{Name: "gemini/flash", Session: primary, Breaker: breaker},
+		FallbackProvider{Name: "groq/llama", Session: NewMockLLMSession("fallback response", nil)},
+	)
+
+	for _, failure := range []error{
+		&googleapi.Error{Code: http.StatusServiceUnavailable},
+		&googleapi.Error{Code: http.StatusServiceUnavailable},
+		errors.New("unexpected response"),
+		&googleapi.Error{Code: http.StatusServiceUnavailable},
+	} {
+		primary.SendMessageError = failure
+		_, _ = session.SendMessage(context.Background(), "message")
+	}
+	assert.False(t, breaker.Allow(), "the error in the middle of the streak does not reset the failures")
+}
+
+func TestFallbackSession_AllProvidersFail(t *testing.T) {
+	session := NewFallbackSession("generate_code",
+		FallbackProvider{Name: "gemini/flash", Session: NewMockLLMSession("", &googleapi.Error{Code: http.StatusServiceUnavailable})},
+		FallbackProvider{Name: "groq/llama", Session: NewMockLLMSession("", errors.New("unexpected status code: 500, body: "))},
+	)
+
+	_, err := session.SendMessage(context.Background(), "message")
+	assert.ErrorContains(t, err, "every provider of the generate_code session failed")
+	assert.ErrorContains(t, err, "gemini/flash")
+	assert.ErrorContains(t, err, "groq/llama")
+}
@@ -4,16 +4,17 @@ import (
 	"context"
 	"fmt"
 	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/logging"
 	"github.com/google/generative-ai-go/genai"
 )
 
 // GeminiSession implements the LLMSession interface for Google's Gemini models.
 type GeminiSession struct {
-	client           *genai.Client
-	model            *genai.GenerativeModel
-	chat             *genai.ChatSession // Store the chat session
-	defaultOptions   *Options
-	maxHistoryLength int
+	client         *genai.Client
+	model          *genai.GenerativeModel
+	chat           *genai.ChatSession // Store the chat session
+	defaultOptions *Options
+	lastUsage      *TokenUsage // Usage reported for the last message, if any
 }
 
 // NewGeminiSession creates a new GeminiSession. It now accepts the genai.Client as a parameter.
@@ -29,6 +30,9 @@ func NewGeminiSession(client *genai.Client, modelName string, systemPrompt strin
 	}
 
 	defaultOptions := createOptions(options...)
+	if defaultOptions.Seed != nil || defaultOptions.ReasoningEffort != nil {
+		logging.Logger.Warnf("Gemini does not support the seed and reasoning effort options, ignoring them for %s", modelName)
+	}
 
 	s := &GeminiSession{
 		client:         client,
@@ -37,11 +41,6 @@ func NewGeminiSession(client *genai.Client, modelName string, systemPrompt strin
 		defaultOptions: defaultOptions,
 	}
 
-	s.maxHistoryLength = *defaultOptions.MaxHistoryLength
-	if s.maxHistoryLength == -1 {
-		s.maxHistoryLength = 100 // Default value if not set
-	}
-
 	return s, nil
 }
 
@@ -61,6 +60,9 @@ func (s *GeminiSession) SendMessage(ctx context.Context, message string, options
 		if s.defaultOptions.MaxOutputTokens != nil {
 			s.model.SetMaxOutputTokens(int32(*s.defaultOptions.MaxOutputTokens))
 		}
+		if s.defaultOptions.StopSequences != nil {
+			s.model.StopSequences = s.defaultOptions.StopSequences
+		}
 		if s.defaultOptions.ResponseFormat != nil {
 			s.model.ResponseMIMEType = "application/" + *s.defaultOptions.ResponseFormat
 		}
@@ -86,6 +88,9 @@ func (s *GeminiSession) SendMessage(ctx context.Context, message string, options
 	if opts.MaxOutputTokens != nil {
 		s.model.SetMaxOutputTokens(int32(*opts.MaxOutputTokens))
 	}
+	if opts.StopSequences != nil {
+		s.model.StopSequences = opts.StopSequences
+	}
 	if opts.ResponseFormat != nil {
 		s.model.ResponseMIMEType = "application/" + *opts.ResponseFormat
 	}
@@ -95,16 +100,15 @@ func (s *GeminiSession) SendMessage(ctx context.Context, message string, options
 		s.model.ResponseSchema = convertToGeminiSchema(*opts.JSONSchema)
 	}
 
+	s.lastUsage = nil
 	resp, err := s.chat.SendMessage(ctx, genai.Text(message))
 	if err != nil {
 		return "", fmt.Errorf("failed to send message to Gemini: %w", err)
 	}
-	responseText := extractResponseText(resp)
-
-	currentHistory := s.GetHistory()
-	if s.maxHistoryLength > 0 && len(currentHistory) > s.maxHistoryLength*2 {
-		s.SetHistory(currentHistory[2:])
+	if resp.UsageMetadata != nil {
+		s.lastUsage = &TokenUsage{PromptTokens: int(resp.UsageMetadata.PromptTokenCount), CompletionTokens: int(resp.UsageMetadata.CandidatesTokenCount)}
 	}
+	responseText := extractResponseText(resp)
 
 	return responseText, nil
 }
@@ -114,6 +118,14 @@ func (s *GeminiSession) GetHistory() []models.Message {
 	return historyToMessages(s.chat.History)
 }
 
+// LastUsage returns the token usage reported by Gemini for the last message.
+func (s *GeminiSession) LastUsage() (TokenUsage, bool) {
+	if s.lastUsage == nil {
+		return TokenUsage{}, false
+	}
+	return *s.lastUsage, true
+}
+
 // extractResponseText extracts the text from a GenerateContentResponse.
 func extractResponseText(resp *genai.GenerateContentResponse) string {
 	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
@@ -224,8 +236,13 @@ func historyToMessages(history []*genai.Content) []models.Message {
 func (s *GeminiSession) SetHistory(history []models.Message) {
 	var genaiHistory []*genai.Content
 	for _, msg := range history {
+		role := msg.Role
+		if role == "assistant" {
+			// History carried over from another provider
+			role = "model"
+		}
 		genaiHistory = append(genaiHistory, &genai.Content{
-			Role:  msg.Role,
+			Role:  role,
 			Parts: []genai.Part{genai.Text(msg.Content)},
 		})
 	}
@@ -6,25 +6,26 @@ import (
 	"fmt"
 	"github.com/EduardDranca/GoAgent/internal/agent/models"
 	"github.com/EduardDranca/GoAgent/internal/logging"
-	"github.com/avast/retry-go"
 	"github.com/jpoz/groq"
-	"time"
 )
 
 // GroqSession implements the LLMSession interface for Groq models.
 type GroqSession struct {
-	client           *groq.Client
-	model            string
-	history          []models.Message // Store the history
-	systemPrompt     string           // Store the system prompt
-	defaultOptions   *Options
-	maxHistoryLength int
+	client         *groq.Client
+	model          string
+	history        []models.Message // Store the history
+	systemPrompt   string           // Store the system prompt
+	defaultOptions *Options
+	lastUsage      *TokenUsage // Usage reported for the last message, if any
 }
 
 // NewGroqSession creates a new GroqSession. It now accepts the groq.Client as a parameter.
 func NewGroqSession(client *groq.Client, modelName string, systemPrompt string, options ...Option) *GroqSession {
 
 	defaultOptions := createOptions(options...)
+	if defaultOptions.ReasoningEffort != nil {
+		logging.Logger.Warnf("Groq does not support the reasoning effort option, ignoring it for %s", modelName)
+	}
 
 	s := &GroqSession{
 		client:         client,
@@ -34,11 +35,6 @@ func NewGroqSession(client *groq.Client, modelName string, systemPrompt string,
 		defaultOptions: defaultOptions,
 	}
 
-	s.maxHistoryLength = *defaultOptions.MaxHistoryLength
-	if s.maxHistoryLength == -1 {
-		s.maxHistoryLength = 100 // Default value if not set
-	}
-
 	if defaultOptions != nil && defaultOptions.JSONSchema != nil {
 		schemaJSON, err := json.MarshalIndent(*defaultOptions.JSONSchema, "", "  ")
 		if err != nil {
@@ -87,6 +83,12 @@ func (s *GroqSession) SendMessage(_ context.Context, message string, options ...
 	if s.defaultOptions.MaxOutputTokens != nil {
 		req.MaxTokens = *s.defaultOptions.MaxOutputTokens
 	}
+	if s.defaultOptions.StopSequences != nil {
+		req.Stop = s.defaultOptions.StopSequences
+	}
+	if s.defaultOptions.Seed != nil {
+		req.Seed = *s.defaultOptions.Seed
+	}
 	if s.defaultOptions.ResponseFormat != nil && *s.defaultOptions.ResponseFormat == "json" {
 		req.ResponseFormat = groq.ResponseFormat{
 			Type: "json_object",
@@ -103,6 +105,12 @@ func (s *GroqSession) SendMessage(_ context.Context, message string, options ...
 	if opts.MaxOutputTokens != nil {
 		req.MaxTokens = *opts.MaxOutputTokens
 	}
+	if opts.StopSequences != nil {
+		req.Stop = opts.StopSequences
+	}
+	if opts.Seed != nil {
+		req.Seed = *opts.Seed
+	}
 	if opts.ResponseFormat != nil && *opts.ResponseFormat == "json" {
 		req.ResponseFormat = groq.ResponseFormat{
 			Type: "json_object",
@@ -119,31 +127,20 @@ func (s *GroqSession) SendMessage(_ context.Context, message string, options ...
 		messages[0].Content = fmt.Sprintf("%s\n\nPlease respond using the following JSON schema:\n%s", messages[0].Content, string(schemaJSON))
 	}
 
-	var resp *groq.ChatCompletion
-	// TODO: Added retry since the groq session sometimes fails, should investigate at a later date.
-	err := retry.Do(
-		func() error {
-			var rErr error
-			resp, rErr = s.client.CreateChatCompletion(req)
-			return rErr
-		},
-		retry.Attempts(3), // Maximum 3 attempts
-		retry.DelayType(retry.BackOffDelay),
-		retry.Delay(100*time.Millisecond),
-		retry.MaxDelay(5*time.Second), // Maximum delay between retries is 5 seconds
-	)
+	s.lastUsage = nil
+	resp, err := s.client.CreateChatCompletion(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to create chat completion after multiple retries: %w", err)
+		return "", fmt.Errorf("failed to create chat completion: %w", err)
+	}
+
+	if resp.Usage.PromptTokens != nil && resp.Usage.CompletionTokens != nil {
+		s.lastUsage = &TokenUsage{PromptTokens: *resp.Usage.PromptTokens, CompletionTokens: *resp.Usage.CompletionTokens}
 	}
 
 	assistantMessage := models.Message{Role: "assistant", Content: resp.Choices[0].Message.Content}
 	//Append to history after sending the request
 	s.history = append(s.history, assistantMessage)
 
-	if len(s.history) > s.maxHistoryLength*2 {
-		s.history = s.history[2:]
-	}
-
 	return resp.Choices[0].Message.Content, nil
 }
 
@@ -152,6 +149,14 @@ func (s *GroqSession) GetHistory() []models.Message {
 	return s.history
 }
 
+// LastUsage returns the token usage reported by Groq for the last message.
+func (s *GroqSession) LastUsage() (TokenUsage, bool) {
+	if s.lastUsage == nil {
+		return TokenUsage{}, false
+	}
+	return *s.lastUsage, true
+}
+
 func (s *GroqSession) SetHistory(history []models.Message) {
-	s.history = history
+	s.history = assistantRoles(history)
 }
@@ -17,13 +17,15 @@ type Option func(o *Options)
 
 // Options holds the configurable parameters for LLM interactions.
 type Options struct {
-	Temperature      *float32
-	TopK             *int
-	TopP             *float32
-	MaxOutputTokens  *int
-	ResponseFormat   *string                 // "json" or "text"
-	JSONSchema       *map[string]interface{} // For Gemini, this will be used to construct genai.Schema. For Groq, this will be added to the system prompt.
-	MaxHistoryLength *int
+	Temperature     *float32                `json:"temperature,omitempty"`
+	TopK            *int                    `json:"top_k,omitempty"`
+	TopP            *float32                `json:"top_p,omitempty"`
+	MaxOutputTokens *int                    `json:"max_output_tokens,omitempty"`
+	ResponseFormat  *string                 `json:"response_format,omitempty"` // "json" or "text"
+	JSONSchema      *map[string]interface{} `json:"json_schema,omitempty"`     // For Gemini, this will be used to construct genai.Schema. For Groq, this will be added to the system prompt.
+	StopSequences   []string                `json:"stop_sequences,omitempty"`
+	Seed            *int                    `json:"seed,omitempty"`             // Not supported by Gemini
+	ReasoningEffort *string                 `json:"reasoning_effort,omitempty"` // "low", "medium" or "high", only supported by OpenAI
 }
 
 // WithTemperature sets the temperature for the LLM.
@@ -47,6 +49,34 @@ func WithTopP(topP float32) Option {
 	}
 }
 
+// WithMaxOutputTokens sets the maximum number of tokens the LLM generates in a response.
+func WithMaxOutputTokens(maxOutputTokens int) Option {
+	return func(o *Options) {
+		o.MaxOutputTokens = &maxOutputTokens
+	}
+}
+
+// WithStopSequences sets the sequences at which the LLM stops generating a response.
+func WithStopSequences(stopSequences ...string) Option {
+	return func(o *Options) {
+		o.StopSequences = stopSequences
+	}
+}
+
+// WithSeed sets the seed used to sample responses, so that repeated requests return the same response where supported.
+func WithSeed(seed int) Option {
+	return func(o *Options) {
+		o.Seed = &seed
+	}
+}
+
+// WithReasoningEffort sets how much reasoning models think before responding: "low", "medium" or "high".
+func WithReasoningEffort(effort string) Option {
+	return func(o *Options) {
+		o.ReasoningEffort = &effort
+	}
+}
+
 // WithResponseFormat sets the desired response format ("json" or "text").
 func WithResponseFormat(format string) Option {
 	return func(o *Options) {
@@ -61,13 +91,6 @@ func WithJSONSchema(schema map[string]interface{}) Option {
 	}
 }
 
-// WithMaxHistoryLength sets the maximum history length for the LLM session.
-func WithMaxHistoryLength(length int) Option {
-	return func(o *Options) {
-		o.MaxHistoryLength = &length
-	}
-}
-
 func WithJSON() Option {
 	return WithResponseFormat("json")
 }
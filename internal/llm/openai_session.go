@@ -7,16 +7,17 @@ import (
 	"github.com/EduardDranca/GoAgent/internal/agent/models"
 	"github.com/EduardDranca/GoAgent/internal/logging"
 	"github.com/openai/openai-go"
+	"slices"
 )
 
 // OpenAISession implements the LLMSession interface for OpenAI models.
 type OpenAISession struct {
-	client           *openai.Client
-	model            string
-	history          []models.Message // Store the history
-	systemPrompt     string           // Store the system prompt
-	defaultOptions   *Options
-	maxHistoryLength int
+	client         *openai.Client
+	model          string
+	history        []models.Message // Store the history
+	systemPrompt   string           // Store the system prompt
+	defaultOptions *Options
+	lastUsage      *TokenUsage // Usage reported for the last message, if any
 }
 
 // NewOpenAISession creates a new OpenAISession.
@@ -31,11 +32,6 @@ func NewOpenAISession(client *openai.Client, modelName, systemPrompt string, opt
 		defaultOptions: defaultOptions,
 	}
 
-	s.maxHistoryLength = *defaultOptions.MaxHistoryLength
-	if s.maxHistoryLength == -1 {
-		s.maxHistoryLength = 100 // Default value if not set
-	}
-
 	if defaultOptions != nil && defaultOptions.JSONSchema != nil {
 		schemaJSON, err := json.MarshalIndent(*defaultOptions.JSONSchema, "", "  ")
 		if err != nil {
@@ -84,6 +80,15 @@ func (s *OpenAISession) SendMessage(ctx context.Context, message string, options
 	if s.defaultOptions.MaxOutputTokens != nil {
 		req.MaxTokens = openai.Int(int64(*s.defaultOptions.MaxOutputTokens))
 	}
+	if s.defaultOptions.StopSequences != nil {
+		req.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](openai.ChatCompletionNewParamsStopArray(s.defaultOptions.StopSequences))
+	}
+	if s.defaultOptions.Seed != nil {
+		req.Seed = openai.Int(int64(*s.defaultOptions.Seed))
+	}
+	if s.defaultOptions.ReasoningEffort != nil {
+		req.ReasoningEffort = openai.F(openai.ChatCompletionReasoningEffort(*s.defaultOptions.ReasoningEffort))
+	}
 	if s.defaultOptions.ResponseFormat != nil && *s.defaultOptions.ResponseFormat == "json" {
 		if s.defaultOptions.JSONSchema == nil {
 			req.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
@@ -113,6 +118,20 @@ func (s *OpenAISession) SendMessage(ctx context.Context, message string, options
 	if opts.MaxOutputTokens != nil {
 		req.MaxTokens = openai.Int(int64(*opts.MaxOutputTokens))
 	}
+	if opts.StopSequences != nil {
+		req.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](openai.ChatCompletionNewParamsStopArray(opts.StopSequences))
+	}
+	if opts.Seed != nil {
+		req.Seed = openai.Int(int64(*opts.Seed))
+	}
+	if opts.ReasoningEffort != nil {
+		req.ReasoningEffort = openai.F(openai.ChatCompletionReasoningEffort(*opts.ReasoningEffort))
+	}
+	// Reasoning models reject max_tokens and limit their output, reasoning included, with max_completion_tokens,
+	// which is never set otherwise, so swapping the two fields leaves max_tokens unset
+	if req.ReasoningEffort.Present && req.MaxTokens.Present {
+		req.MaxCompletionTokens, req.MaxTokens = req.MaxTokens, req.MaxCompletionTokens
+	}
 	if opts.ResponseFormat != nil && *opts.ResponseFormat == "json" {
 		req.Functions = openai.F([]openai.ChatCompletionNewParamsFunction{
 			{
@@ -121,18 +140,19 @@ func (s *OpenAISession) SendMessage(ctx context.Context, message string, options
 		})
 	}
 
+	s.lastUsage = nil
 	resp, err := s.client.Chat.Completions.New(ctx, req)
 	if err != nil {
 		return "", fmt.Errorf("failed to create chat completion: %w", err)
 	}
+	if resp.Usage.TotalTokens > 0 {
+		s.lastUsage = &TokenUsage{PromptTokens: int(resp.Usage.PromptTokens), CompletionTokens: int(resp.Usage.CompletionTokens)}
+	}
 
 	if len(resp.Choices) > 0 {
 		// Append to history after sending the request
 		assistantMessage := models.Message{Role: "assistant", Content: resp.Choices[0].Message.Content}
 		s.history = append(s.history, assistantMessage)
-		if len(s.history) > s.maxHistoryLength*2 {
-			s.history = s.history[2:]
-		}
 		return resp.Choices[0].Message.Content, nil
 	}
 	return "", fmt.Errorf("no response from API")
@@ -143,7 +163,30 @@ func (s *OpenAISession) GetHistory() []models.Message {
 	return s.history
 }
 
+// LastUsage returns the token usage reported by OpenAI for the last message.
+func (s *OpenAISession) LastUsage() (TokenUsage, bool) {
+	if s.lastUsage == nil {
+		return TokenUsage{}, false
+	}
+	return *s.lastUsage, true
+}
+
 // SetHistory sets the conversation history.
 func (s *OpenAISession) SetHistory(history []models.Message) {
-	s.history = history
+	s.history = assistantRoles(history)
+}
+
+// assistantRoles returns history with the "model" role used by Gemini renamed to "assistant", so that
+// history carried over from a Gemini session can be sent to OpenAI-compatible APIs.
+func assistantRoles(history []models.Message) []models.Message {
+	if !slices.ContainsFunc(history, func(msg models.Message) bool { return msg.Role == "model" }) {
+		return history
+	}
+	converted := slices.Clone(history)
+	for i := range converted {
+		if converted[i].Role == "model" {
+			converted[i].Role = "assistant"
+		}
+	}
+	return converted
 }
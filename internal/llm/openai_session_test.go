@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newOpenAITestSession returns an OpenAI session served by a test server, and the body of the last request it received.
+func newOpenAITestSession(t *testing.T, options ...Option) (*OpenAISession, *map[string]any) {
+	t.Helper()
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"done"}}]}`))
+	}))
+	t.Cleanup(server.Close)
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	return NewOpenAISession(client, "gpt-4.1", "system", options...), &body
+}
+
+func TestOpenAISession_SamplingOptions(t *testing.T) {
+	session, body := newOpenAITestSession(t,
+		WithTemperature(0.5),
+		WithMaxOutputTokens(4096),
+		WithStopSequences("<END>"),
+		WithSeed(42),
+	)
+	response, err := session.SendMessage(context.Background(), "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "done", response)
+	assert.InDelta(t, 0.5, (*body)["temperature"], 1e-6)
+	assert.Equal(t, 4096.0, (*body)["max_tokens"])
+	assert.Equal(t, []any{"<END>"}, (*body)["stop"])
+	assert.Equal(t, 42.0, (*body)["seed"])
+	assert.NotContains(t, *body, "reasoning_effort")
+}
+
+func TestOpenAISession_ReasoningEffort(t *testing.T) {
+	session, body := newOpenAITestSession(t, WithMaxOutputTokens(4096), WithReasoningEffort("high"))
+	_, err := session.SendMessage(context.Background(), "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "high", (*body)["reasoning_effort"])
+	// Reasoning models only accept max_completion_tokens
+	assert.Equal(t, 4096.0, (*body)["max_completion_tokens"])
+	assert.NotContains(t, *body, "max_tokens")
+}
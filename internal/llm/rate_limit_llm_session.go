@@ -2,23 +2,28 @@ package llm
 
 import (
 	"context"
-	"fmt"
 	"github.com/EduardDranca/GoAgent/internal/agent/models"
-
-	"golang.org/x/time/rate"
 )
 
+// RateLimitSession decorates an LLMSession and waits for a RateLimiter, possibly shared with other
+// sessions, before sending each message. The tokens of a request are estimated from the system prompt, the
+// history, the message and the maximum output tokens beforehand, and corrected with the usage reported by
+// the provider afterwards.
 type RateLimitSession struct {
-	llmSession        LLMSession
-	requestsPerMinute float64
-	rateLimiter       *rate.Limiter
+	llmSession     LLMSession
+	rateLimiter    *RateLimiter
+	systemPrompt   string
+	defaultOptions *Options
 }
 
-func NewRateLimitSession(llmSession LLMSession, rateLimiter *rate.Limiter) *RateLimitSession {
+// NewRateLimitSession creates a RateLimitSession. systemPrompt and options are the ones the wrapped session
+// was created with, to estimate the tokens of its requests.
+func NewRateLimitSession(llmSession LLMSession, rateLimiter *RateLimiter, systemPrompt string, options ...Option) *RateLimitSession {
 	return &RateLimitSession{
-		llmSession:        llmSession,
-		rateLimiter:       rateLimiter,
-		requestsPerMinute: float64(rateLimiter.Limit()),
+		llmSession:     llmSession,
+		rateLimiter:    rateLimiter,
+		systemPrompt:   systemPrompt,
+		defaultOptions: createOptions(options...),
 	}
 }
 
@@ -31,13 +36,46 @@ func (rl *RateLimitSession) SetHistory(history []models.Message) {
 }
 
 func (rl *RateLimitSession) SendMessage(ctx context.Context, message string, options ...Option) (string, error) {
-	if rl.requestsPerMinute > 0 {
-		err := rl.rateLimiter.Wait(ctx)
-		if err != nil {
-			return "", fmt.Errorf("rate limiter wait error: %w", err)
-		}
+	promptTokens := estimatePromptTokens(rl.systemPrompt, rl.llmSession.GetHistory(), message)
+	done, err := rl.rateLimiter.acquire(ctx, promptTokens+rl.maxOutputTokens(options...))
+	if err != nil {
+		return "", err
 	}
 
 	response, err := rl.llmSession.SendMessage(ctx, message, options...)
+	actualTokens := promptTokens + EstimateUsage("", response).CompletionTokens
+	if usage, ok := rl.LastUsage(); ok && err == nil {
+		actualTokens = usage.Total()
+	}
+	done(actualTokens)
 	return response, err
 }
+
+// LastUsage returns the usage reported by the wrapped session for its last message, if it reports usage.
+func (rl *RateLimitSession) LastUsage() (TokenUsage, bool) {
+	if reporter, ok := rl.llmSession.(UsageReporter); ok {
+		return reporter.LastUsage()
+	}
+	return TokenUsage{}, false
+}
+
+// maxOutputTokens returns the maximum output tokens of a request sent with options, or 0 if they are not limited.
+func (rl *RateLimitSession) maxOutputTokens(options ...Option) int {
+	opts := *rl.defaultOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if opts.MaxOutputTokens == nil {
+		return 0
+	}
+	return *opts.MaxOutputTokens
+}
+
+// estimatePromptTokens approximates the prompt tokens of a message sent after history with systemPrompt.
+func estimatePromptTokens(systemPrompt string, history []models.Message, message string) int {
+	length := len(systemPrompt) + len(message)
+	for _, historyMessage := range history {
+		length += len(historyMessage.Content)
+	}
+	return (length + charsPerToken - 1) / charsPerToken
+}
@@ -4,17 +4,17 @@ import (
 	"context"
 	"errors"
 	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
-	"golang.org/x/time/rate"
 )
 
 func TestRateLimitSession_SendMessage(t *testing.T) {
 	mockSession := NewMockLLMSession("mock response", nil)
-	limiter := rate.NewLimiter(rate.Limit(10), 10)
-	rlSession := NewRateLimitSession(mockSession, limiter)
+	limiter := NewRateLimiter(RateLimits{RequestsPerMinute: 600})
+	rlSession := NewRateLimitSession(mockSession, limiter, "")
 
 	msg := "test message"
 	resp, err := rlSession.SendMessage(context.Background(), msg)
@@ -26,8 +26,8 @@ func TestRateLimitSession_SendMessage(t *testing.T) {
 
 func TestRateLimitSession_SendMessage_RateLimited(t *testing.T) {
 	mockSession := NewMockLLMSession("mock response", nil)
-	limiter := rate.NewLimiter(rate.Limit(1), 1) // Limit to 1 request per second
-	rlSession := NewRateLimitSession(mockSession, limiter)
+	limiter := NewRateLimiter(RateLimits{RequestsPerMinute: 60}) // Limit to 1 request per second
+	rlSession := NewRateLimitSession(mockSession, limiter, "")
 
 	// First request should be allowed
 	_, err := rlSession.SendMessage(context.Background(), "message 1")
@@ -44,7 +44,7 @@ func TestRateLimitSession_SendMessage_RateLimited(t *testing.T) {
 
 func TestRateLimitSession_GetHistory_SetHistory(t *testing.T) {
 	mockSession := NewMockLLMSession("mock response", nil)
-	rlSession := NewRateLimitSession(mockSession, rate.NewLimiter(rate.Limit(10), 10))
+	rlSession := NewRateLimitSession(mockSession, NewRateLimiter(RateLimits{RequestsPerMinute: 600}), "")
 
 	history := []models.Message{{Content: "message 1"}, {Content: "message 2"}}
 	rlSession.SetHistory(history)
@@ -56,8 +56,8 @@ func TestRateLimitSession_GetHistory_SetHistory(t *testing.T) {
 
 func TestRateLimitSession_RateLimit(t *testing.T) {
 	mockSession := NewMockLLMSession("mock response", nil)
-	rateLimiter := rate.NewLimiter(rate.Limit(1), 1) // 1 request per second
-	rlSession := NewRateLimitSession(mockSession, rateLimiter)
+	rateLimiter := NewRateLimiter(RateLimits{RequestsPerMinute: 60}) // 1 request per second
+	rlSession := NewRateLimitSession(mockSession, rateLimiter, "")
 
 	numMessages := 3
 	start := time.Now()
@@ -74,8 +74,8 @@ func TestRateLimitSession_RateLimit(t *testing.T) {
 
 func TestRateLimitSession_ContextCancel(t *testing.T) {
 	mockSession := NewMockLLMSession("mock response", nil)
-	rateLimiter := rate.NewLimiter(rate.Limit(10), 10)
-	rlSession := NewRateLimitSession(mockSession, rateLimiter)
+	rateLimiter := NewRateLimiter(RateLimits{RequestsPerMinute: 600})
+	rlSession := NewRateLimitSession(mockSession, rateLimiter, "")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // Immediately cancel the context
@@ -89,11 +89,125 @@ func TestRateLimitSession_ContextCancel(t *testing.T) {
 func TestRateLimitSession_PropagatesLLMSessionError(t *testing.T) {
 	mockError := errors.New("mock LLMSession error")
 	mockSession := NewMockLLMSession("", mockError) // Mock session returns an error
-	rateLimiter := rate.NewLimiter(rate.Limit(10), 10)
-	rlSession := NewRateLimitSession(mockSession, rateLimiter)
+	rateLimiter := NewRateLimiter(RateLimits{RequestsPerMinute: 600})
+	rlSession := NewRateLimitSession(mockSession, rateLimiter, "")
 
 	_, err := rlSession.SendMessage(context.Background(), "test message")
 
 	assert.Error(t, err)
 	assert.EqualError(t, err, mockError.Error(), "Expected error to be propagated from mock LLMSession")
 }
+
+func TestRateLimitSession_TokensPerMinute(t *testing.T) {
+	// 20 tokens per second, and the first response reports a full minute of tokens
+	rateLimiter := NewRateLimiter(RateLimits{TokensPerMinute: 1200})
+	reporting := &reportingSession{NewMockLLMSession("ok", nil), TokenUsage{PromptTokens: 1000, CompletionTokens: 200}}
+	rlSession := NewRateLimitSession(reporting, rateLimiter, "")
+
+	_, err := rlSession.SendMessage(context.Background(), "hi")
+	assert.NoError(t, err)
+
+	// The underestimated tokens were charged, so the next request waits for its own estimate to refill
+	rlSession.SetHistory(nil)
+	start := time.Now()
+	_, err = rlSession.SendMessage(context.Background(), "a message estimated at ten tokens long..")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestRateLimitSession_RefundsOverestimatedTokens(t *testing.T) {
+	// 20 tokens per second, and the responses report far fewer tokens than the estimate of a long history
+	rateLimiter := NewRateLimiter(RateLimits{TokensPerMinute: 1200})
+	reporting := &reportingSession{NewMockLLMSession("ok", nil), TokenUsage{PromptTokens: 10, CompletionTokens: 10}}
+	rlSession := NewRateLimitSession(reporting, rateLimiter, "")
+
+	message := strings.Repeat("a", 1100*charsPerToken)
+	_, err := rlSession.SendMessage(context.Background(), message)
+	assert.NoError(t, err)
+
+	// Without the refund, the second request would wait for the bucket to refill for about 50 seconds
+	rlSession.SetHistory(nil)
+	start := time.Now()
+	_, err = rlSession.SendMessage(context.Background(), message)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// blockingSession is an LLMSession whose SendMessage blocks until release is closed.
+type blockingSession struct {
+	*MockLLMSession
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSession) SendMessage(ctx context.Context, message string, options ...Option) (string, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return "ok", nil
+}
+
+func TestRateLimitSession_MaxInFlight(t *testing.T) {
+	rateLimiter := NewRateLimiter(RateLimits{MaxInFlight: 1})
+	blocking := &blockingSession{NewMockLLMSession("", nil), make(chan struct{}, 1), make(chan struct{})}
+	first := NewRateLimitSession(blocking, rateLimiter, "")
+	second := NewRateLimitSession(NewMockLLMSession("ok", nil), rateLimiter, "")
+
+	done := make(chan error)
+	go func() {
+		_, err := first.SendMessage(context.Background(), "first")
+		done <- err
+	}()
+	<-blocking.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := second.SendMessage(ctx, "second")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the second request waits for the first one to complete")
+
+	close(blocking.release)
+	assert.NoError(t, <-done)
+	_, err = second.SendMessage(context.Background(), "second")
+	assert.NoError(t, err)
+}
+
+func TestRateLimitSession_EstimatesSystemPromptAndMaxOutputTokens(t *testing.T) {
+	// 20 tokens per second, and the system prompt and the output of the first request take the whole minute
+	rateLimiter := NewRateLimiter(RateLimits{TokensPerMinute: 1200})
+	systemPrompt := strings.Repeat("a", 600*charsPerToken)
+	rlSession := NewRateLimitSession(NewMockLLMSession("", errors.New("mock error")), rateLimiter, systemPrompt, WithMaxOutputTokens(8))
+
+	_, err := rlSession.SendMessage(context.Background(), "hi", WithMaxOutputTokens(600))
+	assert.EqualError(t, err, "mock error")
+
+	// The failed request is charged its 601 prompt tokens, so the next one waits for 10 of its 609 tokens
+	rlSession.SetHistory(nil)
+	start := time.Now()
+	_, err = rlSession.SendMessage(context.Background(), "hi")
+	assert.EqualError(t, err, "mock error")
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestRateLimitSession_MaxInFlightIsNotHeldWhileWaiting(t *testing.T) {
+	rateLimiter := NewRateLimiter(RateLimits{RequestsPerMinute: 60, MaxInFlight: 1})
+	rlSession := NewRateLimitSession(NewMockLLMSession("ok", nil), rateLimiter, "")
+	_, err := rlSession.SendMessage(context.Background(), "first")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := rlSession.SendMessage(ctx, "second")
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The second request waits for the requests per minute without taking the only slot
+	select {
+	case rateLimiter.inFlight <- struct{}{}:
+		<-rateLimiter.inFlight
+	default:
+		t.Error("the in-flight slot is held by a request waiting for the rate limit")
+	}
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
@@ -6,8 +6,6 @@ import (
 
 	"github.com/EduardDranca/GoAgent/internal/config"
 
-	"golang.org/x/time/rate"
-
 	"github.com/google/generative-ai-go/genai"
 	"github.com/jpoz/groq"
 	"github.com/openai/openai-go"
@@ -16,7 +14,7 @@ import (
 )
 
 // NewRateLimitSessionBuilder builds a rate-limited LLM session based on the given LLM service type.
-func NewRateLimitSessionBuilder(ctx context.Context, llmType config.LLMServiceType, apiKey string, modelName string, rateLimiter *rate.Limiter, systemMessage string, options ...Option) (LLMSession, error) {
+func NewRateLimitSessionBuilder(ctx context.Context, llmType config.LLMServiceType, apiKey string, modelName string, rateLimiter *RateLimiter, systemMessage string, options ...Option) (LLMSession, error) {
 	var baseSession LLMSession
 
 	switch llmType {
@@ -33,10 +31,12 @@ func NewRateLimitSessionBuilder(ctx context.Context, llmType config.LLMServiceTy
 		groqClient := groq.NewClient(groq.WithAPIKey(apiKey))
 		baseSession = NewGroqSession(groqClient, modelName, systemMessage, options...)
 	case config.OpenAIService:
-		openaiClient := openai.NewClient(option2.WithAPIKey(apiKey))
+		openaiClient := openai.NewClient(option2.WithAPIKey(apiKey), option2.WithMaxRetries(0)) // Retried by RetrySession
 		baseSession = NewOpenAISession(openaiClient, modelName, systemMessage, options...)
+	default:
+		return nil, fmt.Errorf("unsupported LLM service type for a rate-limited session: %s", llmType)
 	}
 
-	rateLimitedSession := NewRateLimitSession(baseSession, rateLimiter)
+	rateLimitedSession := NewRateLimitSession(baseSession, rateLimiter, systemMessage, options...)
 	return rateLimitedSession, nil
 }
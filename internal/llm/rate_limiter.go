@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimits configures a RateLimiter. Zero values disable the corresponding limit.
+type RateLimits struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+	MaxInFlight       int // Maximum number of requests sent concurrently
+}
+
+// RateLimiter limits the requests per minute, the tokens per minute and the concurrent requests
+// of all the sessions sharing it. It is safe for concurrent use.
+type RateLimiter struct {
+	requests *rate.Limiter // nil when requests per minute are not limited
+	tokens   *tokenBucket  // nil when tokens per minute are not limited
+	inFlight chan struct{} // nil when concurrent requests are not limited
+}
+
+// NewRateLimiter creates a RateLimiter enforcing limits.
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	limiter := &RateLimiter{}
+	if limits.RequestsPerMinute > 0 {
+		limiter.requests = rate.NewLimiter(rate.Limit(float64(limits.RequestsPerMinute)/60), 1)
+	}
+	if limits.TokensPerMinute > 0 {
+		// A full minute of tokens can be spent at once, as the providers' quotas allow
+		limiter.tokens = newTokenBucket(limits.TokensPerMinute)
+	}
+	if limits.MaxInFlight > 0 {
+		limiter.inFlight = make(chan struct{}, limits.MaxInFlight)
+	}
+	return limiter
+}
+
+// acquire waits until a request estimated to use estimatedTokens can be sent. The returned function
+// must be called once the request completed, with the tokens it actually used, to free its slot,
+// charge the tokens that were underestimated and refund the ones that were overestimated.
+// The in-flight slot is taken last, so that a request waiting for the rate limits does not hold it.
+func (l *RateLimiter) acquire(ctx context.Context, estimatedTokens int) (func(actualTokens int), error) {
+	if l.requests != nil {
+		if err := l.requests.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("rate limiter wait error: %w", err)
+		}
+	}
+	reservedTokens := 0
+	if l.tokens != nil {
+		// Requests larger than the whole budget wait for a full bucket instead of failing
+		reservedTokens = min(estimatedTokens, l.tokens.burst)
+		if err := l.tokens.wait(ctx, reservedTokens); err != nil {
+			return nil, fmt.Errorf("rate limiter wait error: %w", err)
+		}
+	}
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			if l.tokens != nil {
+				l.tokens.adjust(reservedTokens)
+			}
+			return nil, fmt.Errorf("rate limiter wait error: %w", ctx.Err())
+		}
+	}
+
+	return func(actualTokens int) {
+		if l.inFlight != nil {
+			<-l.inFlight
+		}
+		if l.tokens != nil {
+			l.tokens.adjust(reservedTokens - min(actualTokens, l.tokens.burst))
+		}
+	}, nil
+}
+
+// tokenBucket limits the tokens spent per minute. Unlike rate.Limiter, tokens that were taken can be
+// given back, to correct the estimate of a request once its usage is known. It is safe for concurrent use.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second
+	burst  int
+	tokens float64 // Negative while the bucket is in debt
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket of tokensPerMinute tokens. A full minute of tokens can be spent
+// at once, as the providers' quotas allow.
+func newTokenBucket(tokensPerMinute int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(tokensPerMinute) / 60,
+		burst:  tokensPerMinute,
+		tokens: float64(tokensPerMinute),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accumulated since the last update. The caller holds mu.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(float64(b.burst), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait takes n tokens and waits until the bucket is out of debt. Requests are served in the order
+// they take their tokens. If ctx is done first, the tokens are given back.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.adjust(n)
+		return ctx.Err()
+	}
+}
+
+// adjust gives n tokens back to the bucket, up to a full bucket, or takes -n tokens without waiting.
+// Taking tokens puts the bucket in debt, delaying the next requests instead.
+func (b *tokenBucket) adjust(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens = min(float64(b.burst), b.tokens+float64(n))
+}
@@ -0,0 +1,158 @@
+package llm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+const (
+	RecordTypeMessage    = "message"     // A request sent to the LLM and its response
+	RecordTypeSetHistory = "set_history" // The session history was replaced, e.g. cleared between tasks
+)
+
+// TranscriptRecord is a single line of a session transcript.
+type TranscriptRecord struct {
+	Seq        int              `json:"seq"`
+	Role       string           `json:"role"` // The assistant role the session is used for, e.g. code_analysis
+	Model      string           `json:"model,omitempty"`
+	Type       string           `json:"type"`
+	Request    string           `json:"request,omitempty"`
+	Response   string           `json:"response,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Options    *Options         `json:"options,omitempty"` // Effective options, session defaults overridden by call options
+	History    []models.Message `json:"history,omitempty"`
+	StartedAt  time.Time        `json:"started_at"`
+	DurationMs int64            `json:"duration_ms"`
+}
+
+// SessionRecorder appends the records of all sessions of a run to a single JSONL transcript.
+// It is safe for concurrent use. Every record is written with a single unbuffered write.
+type SessionRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+	path string
+	seq  int
+}
+
+// NewSessionID returns a new, sortable transcript identifier.
+func NewSessionID() string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix))
+}
+
+// NewSessionRecorder creates the transcript <dir>/<id>.jsonl.
+func NewSessionRecorder(dir string, id string) (*SessionRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sessions directory %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, id+".jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session transcript %s: %w", path, err)
+	}
+	return &SessionRecorder{file: file, path: path}, nil
+}
+
+// Path returns the location of the transcript.
+func (r *SessionRecorder) Path() string {
+	return r.path
+}
+
+// Record assigns the next sequence number to record and appends it to the transcript.
+func (r *SessionRecorder) Record(record TranscriptRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	record.Seq = r.seq
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode transcript record: %w", err)
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write transcript record: %w", err)
+	}
+	return nil
+}
+
+// Close closes the transcript file.
+func (r *SessionRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// RecordingSession decorates an LLMSession and records every request, response and history change.
+type RecordingSession struct {
+	llmSession     LLMSession
+	recorder       *SessionRecorder
+	role           string
+	model          string
+	defaultOptions []Option
+}
+
+// NewRecordingSession wraps llmSession so that its traffic is written to recorder under the given role.
+// defaultOptions should be the options the session was created with, so that the recorded options are complete.
+func NewRecordingSession(llmSession LLMSession, recorder *SessionRecorder, role string, model string, defaultOptions ...Option) *RecordingSession {
+	return &RecordingSession{
+		llmSession:     llmSession,
+		recorder:       recorder,
+		role:           role,
+		model:          model,
+		defaultOptions: defaultOptions,
+	}
+}
+
+func (s *RecordingSession) SendMessage(ctx context.Context, message string, options ...Option) (string, error) {
+	startedAt := time.Now()
+	response, err := s.llmSession.SendMessage(ctx, message, options...)
+
+	record := TranscriptRecord{
+		Role:       s.role,
+		Model:      s.model,
+		Type:       RecordTypeMessage,
+		Request:    message,
+		Response:   response,
+		Options:    createOptions(append(append([]Option{}, s.defaultOptions...), options...)...),
+		StartedAt:  startedAt,
+		DurationMs: time.Since(startedAt).Milliseconds(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	s.record(record)
+	return response, err
+}
+
+func (s *RecordingSession) GetHistory() []models.Message {
+	return s.llmSession.GetHistory()
+}
+
+func (s *RecordingSession) SetHistory(history []models.Message) {
+	s.llmSession.SetHistory(history)
+	s.record(TranscriptRecord{
+		Role:      s.role,
+		Model:     s.model,
+		Type:      RecordTypeSetHistory,
+		History:   history,
+		StartedAt: time.Now(),
+	})
+}
+
+// record writes a record, logging instead of failing the LLM call if the transcript cannot be written.
+func (s *RecordingSession) record(record TranscriptRecord) {
+	if err := s.recorder.Record(record); err != nil {
+		logging.Logger.Warnf("Failed to record %s session: %v", s.role, err)
+	}
+}
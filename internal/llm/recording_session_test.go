@@ -0,0 +1,88 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingSession_RecordsAndReplays(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewSessionRecorder(dir, "run")
+	require.NoError(t, err)
+
+	analysis := NewRecordingSession(NewMockLLMSession("analysis response", nil), recorder, "code_analysis", "model-a", WithTemperature(0.3))
+	failing := NewRecordingSession(NewMockLLMSession("", errors.New("quota exceeded")), recorder, "generate_code", "model-b")
+
+	response, err := analysis.SendMessage(context.Background(), "analyse this", WithTopK(10))
+	require.NoError(t, err)
+	assert.Equal(t, "analysis response", response)
+	analysis.SetHistory([]models.Message{})
+	_, err = failing.SendMessage(context.Background(), "generate that")
+	require.Error(t, err)
+	require.NoError(t, recorder.Close())
+
+	// The transcript holds one JSON record per line, in order.
+	raw, err := os.ReadFile(filepath.Join(dir, "run.jsonl"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `"seq":1`)
+	assert.Contains(t, lines[0], `"options":{"temperature":0.3,"top_k":10}`)
+	assert.Contains(t, lines[1], `"type":"set_history"`)
+	assert.Contains(t, lines[2], `"error":"quota exceeded"`)
+
+	// Replaying serves the recorded responses and errors without calling an LLM.
+	transcript, err := LoadTranscript(filepath.Join(dir, "run.jsonl"))
+	require.NoError(t, err)
+	require.Equal(t, 2, transcript.Remaining())
+
+	replayedAnalysis := NewReplaySession(transcript, "code_analysis", true)
+	replayedGenerate := NewReplaySession(transcript, "generate_code", true)
+
+	response, err = replayedAnalysis.SendMessage(context.Background(), "analyse this")
+	require.NoError(t, err)
+	assert.Equal(t, "analysis response", response)
+	_, err = replayedGenerate.SendMessage(context.Background(), "generate that")
+	assert.EqualError(t, err, "quota exceeded")
+	assert.Equal(t, 0, transcript.Remaining())
+
+	_, err = replayedAnalysis.SendMessage(context.Background(), "analyse this")
+	assert.ErrorIs(t, err, ErrReplayExhausted)
+}
+
+func TestReplaySession_MatchesRequestsOutOfOrder(t *testing.T) {
+	transcript := NewTranscript([]TranscriptRecord{
+		{Seq: 1, Role: "generate_code", Type: RecordTypeMessage, Request: "generate a.go", Response: "package a"},
+		{Seq: 2, Role: "generate_code", Type: RecordTypeMessage, Request: "generate b.go", Response: "package b"},
+	})
+
+	// Parallel workers may send their requests in a different order than when recording.
+	session := NewReplaySession(transcript, "generate_code", true)
+	response, err := session.SendMessage(context.Background(), "generate b.go")
+	require.NoError(t, err)
+	assert.Equal(t, "package b", response)
+	response, err = session.SendMessage(context.Background(), "generate a.go")
+	require.NoError(t, err)
+	assert.Equal(t, "package a", response)
+}
+
+func TestReplaySession_MismatchedRequest(t *testing.T) {
+	records := []TranscriptRecord{{Seq: 1, Role: "code_analysis", Type: RecordTypeMessage, Request: "recorded", Response: "response"}}
+
+	transcript := NewTranscript(records)
+	_, err := NewReplaySession(transcript, "code_analysis", true).SendMessage(context.Background(), "different")
+	assert.Error(t, err)
+	assert.Equal(t, 1, transcript.Remaining(), "a strict mismatch does not use up the recorded response")
+
+	response, err := NewReplaySession(NewTranscript(records), "code_analysis", false).SendMessage(context.Background(), "different")
+	require.NoError(t, err)
+	assert.Equal(t, "response", response)
+}
@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+// ErrReplayExhausted is returned when a replayed session receives more messages than were recorded.
+var ErrReplayExhausted = errors.New("no recorded response left to replay")
+
+// maxTranscriptLineSize bounds a single transcript line, which holds a full request and response.
+const maxTranscriptLineSize = 64 * 1024 * 1024
+
+// Transcript holds the recorded messages of a run, grouped by role, and tracks which ones were replayed.
+// It is safe for concurrent use by the replay sessions sharing it.
+type Transcript struct {
+	mu       sync.Mutex
+	messages map[string][]TranscriptRecord
+	replayed map[string][]bool
+}
+
+// LoadTranscript reads a JSONL transcript written by a SessionRecorder.
+func LoadTranscript(path string) (*Transcript, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session transcript %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var records []TranscriptRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxTranscriptLineSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record TranscriptRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to parse line %d of session transcript %s: %w", lineNumber, path, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read session transcript %s: %w", path, err)
+	}
+	return NewTranscript(records), nil
+}
+
+// NewTranscript creates a Transcript from records, e.g. ones built by hand in tests.
+func NewTranscript(records []TranscriptRecord) *Transcript {
+	t := &Transcript{
+		messages: make(map[string][]TranscriptRecord),
+		replayed: make(map[string][]bool),
+	}
+	for _, record := range records {
+		if record.Type != RecordTypeMessage {
+			continue
+		}
+		t.messages[record.Role] = append(t.messages[record.Role], record)
+		t.replayed[record.Role] = append(t.replayed[record.Role], false)
+	}
+	return t
+}
+
+// next returns the first unreplayed record of role whose request matches message. Parallel sessions
+// sharing a role may send their requests in a different order than when recording, so requests are
+// matched by content first and by order only if no request matches. The returned record is marked as
+// replayed, unless strict is set and no request matches, which is an error.
+func (t *Transcript) next(role string, message string, strict bool) (TranscriptRecord, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	first := -1
+	for i, record := range t.messages[role] {
+		if t.replayed[role][i] {
+			continue
+		}
+		if record.Request == message {
+			t.replayed[role][i] = true
+			return record, true, nil
+		}
+		if first == -1 {
+			first = i
+		}
+	}
+	if first == -1 {
+		return TranscriptRecord{}, false, fmt.Errorf("%w for role %s", ErrReplayExhausted, role)
+	}
+	if strict {
+		return TranscriptRecord{}, false, fmt.Errorf("replayed %s request matches no recorded request, the next one is #%d", role, t.messages[role][first].Seq)
+	}
+	t.replayed[role][first] = true
+	return t.messages[role][first], false, nil
+}
+
+// Remaining returns the number of recorded messages that have not been replayed yet.
+func (t *Transcript) Remaining() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	remaining := 0
+	for _, replayed := range t.replayed {
+		for _, done := range replayed {
+			if !done {
+				remaining++
+			}
+		}
+	}
+	return remaining
+}
+
+// ReplaySession implements LLMSession by serving the responses recorded for a role, without calling any LLM.
+type ReplaySession struct {
+	transcript *Transcript
+	role       string
+	strict     bool
+	history    []models.Message
+}
+
+// NewReplaySession creates a session that replays the responses recorded for role.
+// In strict mode a request that matches no recorded request is an error and leaves the transcript
+// unchanged; otherwise the next recorded response is served and the mismatch is logged.
+func NewReplaySession(transcript *Transcript, role string, strict bool) *ReplaySession {
+	return &ReplaySession{
+		transcript: transcript,
+		role:       role,
+		strict:     strict,
+		history:    []models.Message{},
+	}
+}
+
+func (s *ReplaySession) SendMessage(_ context.Context, message string, _ ...Option) (string, error) {
+	record, matched, err := s.transcript.next(s.role, message, s.strict)
+	if err != nil {
+		return "", err
+	}
+	if !matched {
+		logging.Logger.Warnf("Replayed %s request differs from recorded request #%d, serving the recorded response anyway.", s.role, record.Seq)
+	}
+
+	s.history = append(s.history, models.Message{Role: "user", Content: message}, models.Message{Role: "model", Content: record.Response})
+	if record.Error != "" {
+		return record.Response, errors.New(record.Error)
+	}
+	return record.Response, nil
+}
+
+func (s *ReplaySession) GetHistory() []models.Message {
+	return s.history
+}
+
+func (s *ReplaySession) SetHistory(history []models.Message) {
+	s.history = history
+}
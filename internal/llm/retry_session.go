@@ -0,0 +1,112 @@
+package llm
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+	"github.com/EduardDranca/GoAgent/internal/logging"
+)
+
+// RetryPolicy configures how many times and how long a RetrySession retries a failed request.
+type RetryPolicy struct {
+	MaxAttempts  int           // Number of times a request is sent, including the first; values below 1 disable retries
+	InitialDelay time.Duration // Delay before the first retry, doubled for every following retry
+	MaxDelay     time.Duration // Upper bound of the delay, including delays asked for by the provider
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 4, InitialDelay: time.Second, MaxDelay: time.Minute}
+}
+
+// backoff returns the jittered exponential delay before retry n, counting from 1: a random
+// duration between half and all of InitialDelay * 2^(n-1), capped to MaxDelay.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < n && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// RetrySession decorates an LLMSession and retries requests that failed with a rate limit or a transient
+// error. The delay asked for by the provider is honored; otherwise it backs off exponentially with jitter.
+// Errors are returned as a *ProviderError telling the user how to resolve them.
+type RetrySession struct {
+	llmSession LLMSession
+	provider   string
+	policy     RetryPolicy
+	sleep      func(ctx context.Context, delay time.Duration) error
+}
+
+// NewRetrySession wraps the session of provider, named e.g. gemini/gemini-2.0-flash, so that it retries following policy.
+func NewRetrySession(llmSession LLMSession, provider string, policy RetryPolicy) *RetrySession {
+	return &RetrySession{llmSession: llmSession, provider: provider, policy: policy, sleep: sleepContext}
+}
+
+func (s *RetrySession) SendMessage(ctx context.Context, message string, options ...Option) (string, error) {
+	// Failed attempts may leave the message in the history, so every attempt starts from this copy
+	history := slices.Clone(s.llmSession.GetHistory())
+
+	for attempt := 1; ; attempt++ {
+		response, err := s.llmSession.SendMessage(ctx, message, options...)
+		if err == nil {
+			return response, nil
+		}
+		s.llmSession.SetHistory(slices.Clone(history))
+
+		class := ClassifyError(err)
+		if ctx.Err() != nil || (class != ErrorClassRateLimit && class != ErrorClassTransient) || attempt >= s.policy.MaxAttempts {
+			return "", &ProviderError{Provider: s.provider, Class: class, Attempts: attempt, Err: err}
+		}
+
+		delay := s.policy.backoff(attempt)
+		if retryAfter, ok := RetryAfter(err); ok {
+			if retryAfter > s.policy.MaxDelay {
+				// Waiting that long would stall the agent, fail so that a fallback provider can take over
+				return "", &ProviderError{Provider: s.provider, Class: class, Attempts: attempt, Err: err}
+			}
+			delay = retryAfter
+		}
+		logging.Logger.Warnf("Request to %s failed with a %s error, retrying in %s (attempt %d of %d): %v",
+			s.provider, class, delay.Round(time.Millisecond), attempt+1, s.policy.MaxAttempts, err)
+		if err := s.sleep(ctx, delay); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (s *RetrySession) GetHistory() []models.Message {
+	return s.llmSession.GetHistory()
+}
+
+func (s *RetrySession) SetHistory(history []models.Message) {
+	s.llmSession.SetHistory(history)
+}
+
+// LastUsage returns the usage reported for the last message by the decorated session, if it reports usage.
+func (s *RetrySession) LastUsage() (TokenUsage, bool) {
+	if reporter, ok := s.llmSession.(UsageReporter); ok {
+		return reporter.LastUsage()
+	}
+	return TokenUsage{}, false
+}
+
+// sleepContext waits for delay or until ctx is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,174 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/jpoz/groq"
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"gemini invalid key", &googleapi.Error{Code: http.StatusBadRequest, Message: "API key not valid. Please pass a valid API key."}, ErrorClassAuth},
+		{"openai unauthorized", newOpenAIError(http.StatusUnauthorized, "", ""), ErrorClassAuth},
+		{"groq invalid key", groq.Error{Type: "invalid_request_error", Message: "Invalid API Key"}, ErrorClassAuth},
+		{"openai context length", newOpenAIError(http.StatusBadRequest, "context_length_exceeded", "This model's maximum context length is 8192 tokens"), ErrorClassContextLength},
+		{"gemini blocked", &genai.BlockedError{}, ErrorClassContentFilter},
+		{"openai content filter", newOpenAIError(http.StatusBadRequest, "content_filter", ""), ErrorClassContentFilter},
+		{"gemini quota", &googleapi.Error{Code: http.StatusTooManyRequests}, ErrorClassRateLimit},
+		{"groq rate limit", groq.Error{Type: "tokens", Message: "Rate limit reached for model llama. Please try again in 7.66s."}, ErrorClassRateLimit},
+		{"groq server error", errors.New("unexpected status code: 503, body: unavailable"), ErrorClassTransient},
+		{"deadline exceeded", context.DeadlineExceeded, ErrorClassTransient},
+		{"bad request", newOpenAIError(http.StatusBadRequest, "", ""), ErrorClassUnknown},
+		{"classified", &ProviderError{Class: ErrorClassAuth, Err: errors.New("denied")}, ErrorClassAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ClassifyError(tt.err))
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"gemini rate limit", fmt.Errorf("failed to send message to Gemini: %w", &googleapi.Error{Code: http.StatusTooManyRequests}), true},
+		{"gemini bad request", &googleapi.Error{Code: http.StatusBadRequest}, false},
+		{"openai server error", newOpenAIError(http.StatusServiceUnavailable, "", ""), true},
+		{"openai unauthorized", newOpenAIError(http.StatusUnauthorized, "", ""), false},
+		{"status code in message", errors.New("unexpected status code: 502, body: bad gateway"), true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"canceled", context.Canceled, false},
+		{"unknown", errors.New("invalid JSON"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryable(tt.err))
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	delay, ok := RetryAfter(&googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"12"}}})
+	require.True(t, ok)
+	assert.Equal(t, 12*time.Second, delay)
+
+	delay, ok = RetryAfter(groq.Error{Message: "Rate limit reached. Please try again in 1m2.5s."})
+	require.True(t, ok)
+	assert.Equal(t, time.Minute+2500*time.Millisecond, delay)
+
+	delay, ok = RetryAfter(errors.New(`"retryDelay": "37s"`))
+	require.True(t, ok)
+	assert.Equal(t, 37*time.Second, delay)
+
+	_, ok = RetryAfter(&googleapi.Error{Code: http.StatusServiceUnavailable})
+	assert.False(t, ok)
+}
+
+// newOpenAIError creates an error as returned by the OpenAI client.
+func newOpenAIError(statusCode int, code string, message string) *openai.Error {
+	request, _ := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", nil)
+	return &openai.Error{StatusCode: statusCode, Code: code, Message: message, Request: request, Response: &http.Response{StatusCode: statusCode}}
+}
+
+// scriptedSession fails with the given errors, in order, then succeeds.
+type scriptedSession struct {
+	MockLLMSession
+	errs []error
+}
+
+func (s *scriptedSession) SendMessage(ctx context.Context, message string, options ...Option) (string, error) {
+	s.History = append(s.History, models.Message{Content: message})
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return "", err
+	}
+	return "response", nil
+}
+
+func newTestRetrySession(session LLMSession, policy RetryPolicy) (*RetrySession, *[]time.Duration) {
+	var delays []time.Duration
+	retrySession := NewRetrySession(session, "gemini/flash", policy)
+	retrySession.sleep = func(_ context.Context, delay time.Duration) error {
+		delays = append(delays, delay)
+		return nil
+	}
+	return retrySession, &delays
+}
+
+func TestRetrySession_RetriesRetryableErrors(t *testing.T) {
+	session := &scriptedSession{errs: []error{
+		&googleapi.Error{Code: http.StatusServiceUnavailable},
+		&googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"3"}}},
+	}}
+	retrySession, delays := newTestRetrySession(session, RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: time.Minute})
+
+	response, err := retrySession.SendMessage(context.Background(), "message")
+	require.NoError(t, err)
+	assert.Equal(t, "response", response)
+	require.Len(t, *delays, 2)
+	assert.GreaterOrEqual(t, (*delays)[0], 500*time.Millisecond)
+	assert.LessOrEqual(t, (*delays)[0], time.Second)
+	assert.Equal(t, 3*time.Second, (*delays)[1], "the Retry-After header is honored")
+	assert.Equal(t, []models.Message{{Content: "message"}}, retrySession.GetHistory(), "failed attempts are removed from the history")
+}
+
+func TestRetrySession_GivesUp(t *testing.T) {
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantClass    ErrorClass
+		wantHint     string
+	}{
+		{"auth error", []error{newOpenAIError(http.StatusUnauthorized, "", "")}, 1, ErrorClassAuth, "check the API key"},
+		{"context length", []error{newOpenAIError(http.StatusBadRequest, "context_length_exceeded", "")}, 1, ErrorClassContextLength, "max_history_length"},
+		{"retries exhausted", []error{&googleapi.Error{Code: 429}, &googleapi.Error{Code: 429}, &googleapi.Error{Code: 429}}, 3, ErrorClassRateLimit, "still rate limiting"},
+		{"retry after too long", []error{&googleapi.Error{Code: 429, Header: http.Header{"Retry-After": []string{"3600"}}}}, 1, ErrorClassRateLimit, "still rate limiting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &scriptedSession{errs: tt.errs}
+			retrySession, _ := newTestRetrySession(session, RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: time.Minute})
+
+			_, err := retrySession.SendMessage(context.Background(), "message")
+			var providerErr *ProviderError
+			require.ErrorAs(t, err, &providerErr)
+			assert.Equal(t, tt.wantAttempts, providerErr.Attempts)
+			assert.Equal(t, tt.wantClass, providerErr.Class)
+			assert.Contains(t, err.Error(), "gemini/flash")
+			assert.Contains(t, err.Error(), tt.wantHint)
+			assert.Empty(t, retrySession.GetHistory())
+		})
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	for i := 0; i < 20; i++ {
+		delay := policy.backoff(2)
+		assert.GreaterOrEqual(t, delay, time.Second)
+		assert.LessOrEqual(t, delay, 2*time.Second)
+	}
+	assert.LessOrEqual(t, policy.backoff(10), 5*time.Second)
+	assert.GreaterOrEqual(t, policy.backoff(10), 2500*time.Millisecond)
+}
@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/EduardDranca/GoAgent/internal/agent/models"
+)
+
+// charsPerToken is the approximate number of characters per token, used when a provider does not report usage.
+const charsPerToken = 4
+
+// TokenUsage is the number of tokens consumed by LLM requests.
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// Total returns the number of prompt and completion tokens.
+func (u TokenUsage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// UsageReporter is implemented by sessions that know the token usage reported by the provider for their last message.
+type UsageReporter interface {
+	LastUsage() (TokenUsage, bool)
+}
+
+// UsageStats is the usage accumulated by a UsageCounter.
+type UsageStats struct {
+	Requests int        `json:"requests"`
+	Tokens   TokenUsage `json:"tokens"`
+	// Estimated is true if the usage of at least one request was not reported and had to be estimated.
+	Estimated bool `json:"estimated"`
+}
+
+// UsageCounter accumulates the requests and token usage of the sessions sharing it.
+// It is safe for concurrent use.
+type UsageCounter struct {
+	mu    sync.Mutex
+	stats UsageStats
+}
+
+// NewUsageCounter creates an empty UsageCounter.
+func NewUsageCounter() *UsageCounter {
+	return &UsageCounter{}
+}
+
+// Add records a request that consumed usage.
+func (c *UsageCounter) Add(usage TokenUsage, estimated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Requests++
+	c.stats.Tokens.PromptTokens += usage.PromptTokens
+	c.stats.Tokens.CompletionTokens += usage.CompletionTokens
+	c.stats.Estimated = c.stats.Estimated || estimated
+}
+
+// Stats returns the usage accumulated so far.
+func (c *UsageCounter) Stats() UsageStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// UsageSession decorates an LLMSession and adds the usage of every request to a UsageCounter.
+// The usage reported by the provider is used if available, otherwise it is estimated from the message lengths.
+type UsageSession struct {
+	llmSession LLMSession
+	counter    *UsageCounter
+}
+
+// NewUsageSession wraps llmSession so that its usage is added to counter.
+func NewUsageSession(llmSession LLMSession, counter *UsageCounter) *UsageSession {
+	return &UsageSession{llmSession: llmSession, counter: counter}
+}
+
+func (s *UsageSession) SendMessage(ctx context.Context, message string, options ...Option) (string, error) {
+	response, err := s.llmSession.SendMessage(ctx, message, options...)
+	if reporter, ok := s.llmSession.(UsageReporter); ok && err == nil {
+		if usage, reported := reporter.LastUsage(); reported {
+			s.counter.Add(usage, false)
+			return response, err
+		}
+	}
+	s.counter.Add(EstimateUsage(message, response), true)
+	return response, err
+}
+
+func (s *UsageSession) GetHistory() []models.Message {
+	return s.llmSession.GetHistory()
+}
+
+func (s *UsageSession) SetHistory(history []models.Message) {
+	s.llmSession.SetHistory(history)
+}
+
+// EstimateUsage approximates the token usage of a request from the length of the message and the response.
+func EstimateUsage(message string, response string) TokenUsage {
+	return TokenUsage{
+		PromptTokens:     (len(message) + charsPerToken - 1) / charsPerToken,
+		CompletionTokens: (len(response) + charsPerToken - 1) / charsPerToken,
+	}
+}
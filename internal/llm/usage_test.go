@@ -0,0 +1,33 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reportingSession is an LLMSession that reports a fixed usage for every message.
+type reportingSession struct {
+	*MockLLMSession
+	usage TokenUsage
+}
+
+func (s *reportingSession) LastUsage() (TokenUsage, bool) {
+	return s.usage, true
+}
+
+func TestUsageSession_CountsReportedAndEstimatedUsage(t *testing.T) {
+	counter := NewUsageCounter()
+	reported := NewUsageSession(NewRateLimitSession(&reportingSession{NewMockLLMSession("ok", nil), TokenUsage{PromptTokens: 10, CompletionTokens: 5}}, NewRateLimiter(RateLimits{}), ""), counter)
+	_, err := reported.SendMessage(context.Background(), "message")
+	require.NoError(t, err)
+	assert.Equal(t, UsageStats{Requests: 1, Tokens: TokenUsage{PromptTokens: 10, CompletionTokens: 5}}, counter.Stats())
+
+	estimated := NewUsageSession(NewMockLLMSession("12345678", nil), counter)
+	_, err = estimated.SendMessage(context.Background(), "1234")
+	require.NoError(t, err)
+	assert.Equal(t, UsageStats{Requests: 2, Tokens: TokenUsage{PromptTokens: 11, CompletionTokens: 7}, Estimated: true}, counter.Stats())
+	assert.Equal(t, 18, counter.Stats().Tokens.Total())
+}
@@ -2,6 +2,9 @@ package logging
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -15,7 +18,84 @@ const (
 
 var AllowedLogLevels = []string{DebugLevel, InfoLevel, WarningLevel, ErrorLevel}
 
-var Logger = zap.Must(zap.NewDevelopmentConfig().Build()).Sugar()
+var Logger = zap.Must(zap.NewDevelopmentConfig().Build(redactSecrets)).Sugar()
+
+// redacted replaces the secrets in log entries.
+const redacted = "<redacted>"
+
+// secrets holds the values, e.g. API keys, that are redacted from every log entry.
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// RedactSecret redacts secret from the log entries written from now on.
+func RedactSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, value := range secrets.values {
+		if value == secret {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, secret)
+}
+
+// redact returns s with every secret replaced.
+func redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, secret := range secrets.values {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// redactSecrets wraps the core of a logger so that the secrets are redacted from its entries.
+var redactSecrets = zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+	return redactingCore{core}
+})
+
+// redactingCore is a zapcore.Core redacting the secrets from the messages and the string fields of its entries.
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{c.Core.With(redactFields(fields))}
+}
+
+func (c redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = redact(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// redactFields returns the fields with the secrets redacted from their strings.
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	result := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = redact(field.String)
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				field = zap.String(field.Key, redact(err.Error()))
+			}
+		}
+		result[i] = field
+	}
+	return result
+}
 
 // InitializeLogging initializes the zap logging library with the specified log level.
 func InitializeLogging(logLevel string) error {
@@ -32,7 +112,7 @@ func InitializeLogging(logLevel string) error {
 	config.Level.SetLevel(level)
 
 	// Build the logger
-	l, err := config.Build()
+	l, err := config.Build(redactSecrets)
 	if err != nil {
 		return fmt.Errorf("failed to initialize zap logger: %w", err)
 	}
@@ -0,0 +1,24 @@
+package logging
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedactSecret(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(redactingCore{core}).Sugar()
+
+	RedactSecret("sk-secret-key")
+	RedactSecret("")
+	logger.Infof("Calling the API with sk-secret-key")
+	logger.With("key", "sk-secret-key").Infow("Request failed", "error", errors.New("invalid key sk-secret-key"))
+
+	entries := logs.All()
+	assert.Equal(t, "Calling the API with <redacted>", entries[0].Message)
+	assert.Equal(t, map[string]interface{}{"key": "<redacted>", "error": "invalid key <redacted>"}, entries[1].ContextMap())
+}
@@ -0,0 +1,210 @@
+// Package repl holds the slash commands of the interactive session, e.g. /help, and dispatches the lines typed at
+// the prompt to them.
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Prefix starts the lines that run a command, e.g. /help.
+const Prefix = "/"
+
+// ErrQuit is returned by the command ending the session.
+var ErrQuit = errors.New("quit")
+
+// ErrUsage is wrapped by the errors of commands given invalid arguments.
+var ErrUsage = errors.New("invalid arguments")
+
+// Arg describes an argument of a command.
+type Arg struct {
+	Name     string // Shown in the usage, e.g. <name>
+	Optional bool
+	// Text takes the rest of the line, e.g. the query of /ask. Only the last argument can be text.
+	Text bool
+	// Values returns the values completed for the argument, nil if they are not known.
+	Values func() []string
+}
+
+// Command is a command of the session, run by typing its name or one of its aliases after the prefix.
+type Command struct {
+	Name    string // Name without the prefix, e.g. help
+	Aliases []string
+	Args    []Arg
+	Help    string // One-line description shown by /help
+	// Run runs the command with the arguments following its name, writing its output to out.
+	Run func(out io.Writer, argument string) error
+}
+
+// Usage returns how the command is typed, e.g. /profile [<name>].
+func (c *Command) Usage() string {
+	usage := Prefix + c.Name
+	for _, arg := range c.Args {
+		if arg.Optional {
+			usage += " [<" + arg.Name + ">]"
+		} else {
+			usage += " <" + arg.Name + ">"
+		}
+	}
+	return usage
+}
+
+// checkArgs returns an error wrapping ErrUsage if the argument does not match the arguments of the command.
+func (c *Command) checkArgs(argument string) error {
+	words := strings.Fields(argument)
+	required := 0
+	for _, arg := range c.Args {
+		if !arg.Optional {
+			required++
+		}
+	}
+	textual := len(c.Args) > 0 && c.Args[len(c.Args)-1].Text
+	if len(words) < required || (!textual && len(words) > len(c.Args)) {
+		return fmt.Errorf("%w, usage: %s", ErrUsage, c.Usage())
+	}
+	return nil
+}
+
+// Registry holds the commands of the session.
+type Registry struct {
+	commands []*Command
+	names    map[string]*Command // Command of every name and alias
+	fallback string              // Name of the command run by lines that do not start with the prefix
+}
+
+// NewRegistry creates a registry whose lines without the prefix run the command named fallback, e.g. implement.
+func NewRegistry(fallback string) *Registry {
+	return &Registry{names: make(map[string]*Command), fallback: fallback}
+}
+
+// Register adds the command to the registry. Its name and aliases must not be used by another command.
+func (r *Registry) Register(command Command) error {
+	if command.Name == "" || command.Run == nil {
+		return fmt.Errorf("command %q has no name or no Run function", command.Name)
+	}
+	for i, arg := range command.Args {
+		if arg.Text && i != len(command.Args)-1 {
+			return fmt.Errorf("argument %s of /%s takes the rest of the line but is not the last one", arg.Name, command.Name)
+		}
+	}
+	for _, name := range append([]string{command.Name}, command.Aliases...) {
+		if _, ok := r.names[name]; ok {
+			return fmt.Errorf("command name /%s is already registered", name)
+		}
+	}
+	r.commands = append(r.commands, &command)
+	for _, name := range append([]string{command.Name}, command.Aliases...) {
+		r.names[name] = &command
+	}
+	return nil
+}
+
+// MustRegister adds the commands to the registry, panicking if one cannot be registered.
+func (r *Registry) MustRegister(commands ...Command) {
+	for _, command := range commands {
+		if err := r.Register(command); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Lookup returns the command with the name or alias name, with or without the prefix.
+func (r *Registry) Lookup(name string) (*Command, bool) {
+	command, ok := r.names[strings.TrimPrefix(name, Prefix)]
+	return command, ok
+}
+
+// Commands returns the registered commands, sorted by name.
+func (r *Registry) Commands() []*Command {
+	commands := slices.Clone(r.commands)
+	slices.SortFunc(commands, func(a, b *Command) int { return strings.Compare(a.Name, b.Name) })
+	return commands
+}
+
+// Parse splits the line into the name of its command, without the prefix, and the argument following it. A line
+// that does not start with the prefix is the argument of the fallback command.
+func (r *Registry) Parse(line string) (name string, argument string) {
+	line = strings.TrimSpace(line)
+	rest, found := strings.CutPrefix(line, Prefix)
+	if !found {
+		return r.fallback, line
+	}
+	if i := strings.IndexAny(rest, " \t"); i >= 0 {
+		return rest[:i], strings.TrimSpace(rest[i+1:])
+	}
+	return rest, ""
+}
+
+// Execute runs the command of the line, writing its output to out. It returns ErrQuit if the command ends the session.
+func (r *Registry) Execute(line string, out io.Writer) error {
+	name, argument := r.Parse(line)
+	command, ok := r.Lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown command %s%s, run %shelp to list the commands", Prefix, name, Prefix)
+	}
+	if err := command.checkArgs(argument); err != nil {
+		return err
+	}
+	return command.Run(out, argument)
+}
+
+// WriteHelp writes the usage and description of every command to out, or of the command name if it is not empty.
+func (r *Registry) WriteHelp(out io.Writer, name string) error {
+	if name != "" {
+		command, ok := r.Lookup(name)
+		if !ok {
+			return fmt.Errorf("unknown command %s%s", Prefix, strings.TrimPrefix(name, Prefix))
+		}
+		fmt.Fprintf(out, "%s\n  %s\n", command.Usage(), command.Help)
+		if len(command.Aliases) > 0 {
+			fmt.Fprintf(out, "  Aliases: %s%s\n", Prefix, strings.Join(command.Aliases, ", "+Prefix))
+		}
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, command := range r.Commands() {
+		fmt.Fprintf(writer, "%s\t%s\n", command.Usage(), command.Help)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if r.fallback != "" {
+		fmt.Fprintf(out, "Lines that do not start with %s run %s%s.\n", Prefix, Prefix, r.fallback)
+	}
+	return nil
+}
+
+// CompleteCommand returns the candidates for the word ending at the end of line, whether or not they start with it, to
+// be matched by the completer: command names if it is the first word and starts with the prefix, or the values of the
+// argument of the command it belongs to. It returns false if the word is neither, e.g. to complete file names instead.
+func (r *Registry) CompleteCommand(line string) ([]string, bool) {
+	if !strings.HasPrefix(line, Prefix) {
+		return nil, false
+	}
+	words := strings.Fields(line)
+	if len(words) == 1 && !strings.HasSuffix(line, " ") {
+		var names []string
+		for _, command := range r.Commands() {
+			names = append(names, Prefix+command.Name)
+		}
+		return names, true
+	}
+
+	command, ok := r.Lookup(words[0])
+	if !ok {
+		return nil, false
+	}
+	index := len(words) - 1
+	if strings.HasSuffix(line, " ") {
+		index++
+	}
+	if index-1 >= len(command.Args) || command.Args[index-1].Values == nil {
+		return nil, false
+	}
+	return command.Args[index-1].Values(), true
+}
@@ -0,0 +1,107 @@
+package repl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRegistry returns a registry whose commands record the arguments they are run with.
+func newTestRegistry(t *testing.T, runs *[]string) *Registry {
+	t.Helper()
+	record := func(name string) func(io.Writer, string) error {
+		return func(out io.Writer, argument string) error {
+			*runs = append(*runs, name+":"+argument)
+			fmt.Fprintf(out, "ran %s", name)
+			return nil
+		}
+	}
+	registry := NewRegistry("implement")
+	registry.MustRegister(
+		Command{Name: "implement", Args: []Arg{{Name: "change request", Text: true}}, Help: "Implements a change.", Run: record("implement")},
+		Command{Name: "profile", Args: []Arg{{Name: "name", Optional: true, Values: func() []string { return []string{"best", "cheap"} }}}, Help: "Switches profiles.", Run: record("profile")},
+		Command{Name: "quit", Aliases: []string{"exit", "q"}, Help: "Ends the session.", Run: func(io.Writer, string) error { return ErrQuit }},
+	)
+	return registry
+}
+
+func TestRegistry_Execute(t *testing.T) {
+	var runs []string
+	registry := newTestRegistry(t, &runs)
+	var out bytes.Buffer
+
+	require.NoError(t, registry.Execute("add a README", &out))
+	require.NoError(t, registry.Execute("/profile\tbest", &out))
+	require.NoError(t, registry.Execute("/profile", &out))
+	assert.Equal(t, []string{"implement:add a README", "profile:best", "profile:"}, runs)
+	assert.Equal(t, "ran implementran profileran profile", out.String())
+
+	assert.ErrorIs(t, registry.Execute("/q", &out), ErrQuit)
+	assert.ErrorContains(t, registry.Execute("/profile best cheap", &out), "invalid arguments, usage: /profile [<name>]")
+	assert.ErrorIs(t, registry.Execute("/implement", &out), ErrUsage)
+	assert.EqualError(t, registry.Execute("/deploy now", &out), "unknown command /deploy, run /help to list the commands")
+}
+
+func TestRegistry_Register(t *testing.T) {
+	registry := newTestRegistry(t, new([]string))
+	run := func(io.Writer, string) error { return nil }
+
+	assert.EqualError(t, registry.Register(Command{Name: "exit", Run: run}), "command name /exit is already registered")
+	assert.Error(t, registry.Register(Command{Name: "status"}), "a command needs a Run function")
+	assert.Error(t, registry.Register(Command{Name: "commit", Args: []Arg{{Name: "message", Text: true}, {Name: "files"}}, Run: run}))
+	require.NoError(t, registry.Register(Command{Name: "status", Run: run}))
+
+	command, ok := registry.Lookup("/exit")
+	require.True(t, ok)
+	assert.Equal(t, "quit", command.Name)
+	var names []string
+	for _, command := range registry.Commands() {
+		names = append(names, command.Name)
+	}
+	assert.Equal(t, []string{"implement", "profile", "quit", "status"}, names)
+}
+
+func TestRegistry_WriteHelp(t *testing.T) {
+	registry := newTestRegistry(t, new([]string))
+
+	var out bytes.Buffer
+	require.NoError(t, registry.WriteHelp(&out, ""))
+	assert.Equal(t, `/implement <change request>  Implements a change.
+/profile [<name>]            Switches profiles.
+/quit                        Ends the session.
+Lines that do not start with / run /implement.
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, registry.WriteHelp(&out, "/exit"))
+	assert.Equal(t, "/quit\n  Ends the session.\n  Aliases: /exit, /q\n", out.String())
+	assert.EqualError(t, registry.WriteHelp(&out, "deploy"), "unknown command /deploy")
+}
+
+func TestRegistry_CompleteCommand(t *testing.T) {
+	registry := newTestRegistry(t, new([]string))
+
+	tests := []struct {
+		line       string
+		want       []string
+		wantTarget bool
+	}{
+		{"/", []string{"/implement", "/profile", "/quit"}, true},
+		{"/pr", []string{"/implement", "/profile", "/quit"}, true},
+		{"/profile ", []string{"best", "cheap"}, true},
+		{"/profile ch", []string{"best", "cheap"}, true},
+		{"/profile best ", nil, false},
+		{"/implement add ", nil, false},
+		{"add main", nil, false},
+		{"/deploy ", nil, false},
+	}
+	for _, tt := range tests {
+		values, ok := registry.CompleteCommand(tt.line)
+		assert.Equal(t, tt.wantTarget, ok, "line %q", tt.line)
+		assert.Equal(t, tt.want, values, "line %q", tt.line)
+	}
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// GitStatus is the state of the working tree of a repository.
+type GitStatus struct {
+	Branch  string   // Empty when HEAD is detached or before the first commit
+	Head    string   // Abbreviated hash of HEAD, empty before the first commit
+	Changes []string // Pending changes in the short format of git status, e.g. "M  main.go" or "?? notes.txt", by path
+}
+
+// GetGitStatus returns the branch and the pending changes of the repository in dir.
+func GetGitStatus(dir string) (*GitStatus, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository: %w", err)
+	}
+
+	status := &GitStatus{}
+	head, err := repo.Head()
+	switch {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		// No commit yet
+	case err != nil:
+		return nil, fmt.Errorf("error getting HEAD: %w", err)
+	default:
+		status.Head = head.Hash().String()[:7]
+		if head.Name().IsBranch() {
+			status.Branch = head.Name().Short()
+		}
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("error getting worktree: %w", err)
+	}
+	worktreeStatus, err := worktree.Status()
+	if err != nil {
+		return nil, fmt.Errorf("error getting worktree status: %w", err)
+	}
+	for path, fileStatus := range worktreeStatus {
+		if fileStatus.Staging == git.Unmodified && fileStatus.Worktree == git.Unmodified {
+			continue
+		}
+		status.Changes = append(status.Changes, fmt.Sprintf("%c%c %s", fileStatus.Staging, fileStatus.Worktree, path))
+	}
+	slices.SortFunc(status.Changes, func(a, b string) int { return strings.Compare(a[3:], b[3:]) })
+	return status, nil
+}
+
+// emptyTree is the hash of the tree without files, which git knows of in every repository.
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// GitDiff returns the diff of the pending changes to the tracked files of the repository in dir, against HEAD. Before
+// the first commit, the staged files are new and diffed against the empty tree.
+func GitDiff(dir string) (string, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", fmt.Errorf("error opening repository: %w", err)
+	}
+	base := "HEAD"
+	if _, err := repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		base = emptyTree
+	} else if err != nil {
+		return "", fmt.Errorf("error getting HEAD: %w", err)
+	}
+	return runGit(dir, "diff", "--no-color", base)
+}
+
+// GitChangedFiles returns the hash of HEAD, empty before the first commit, and the files that may have changed since
+// the commit since: the files changed by the commits following it and the files with pending changes, including the
+// untracked ones. Both paths of a renamed file are returned.
+func GitChangedFiles(dir string, since string) (string, []string, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("error opening repository: %w", err)
+	}
+
+	var head string
+	headRef, err := repo.Head()
+	switch {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		// No commit yet
+	case err != nil:
+		return "", nil, fmt.Errorf("error getting HEAD: %w", err)
+	default:
+		head = headRef.Hash().String()
+	}
+
+	var files []string
+	if since != "" && head != "" && since != head {
+		committed, err := changedBetween(repo, plumbing.NewHash(since), headRef.Hash())
+		if err != nil {
+			return "", nil, err
+		}
+		files = append(files, committed...)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting worktree: %w", err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting worktree status: %w", err)
+	}
+	for path, fileStatus := range status {
+		if fileStatus.Staging == git.Unmodified && fileStatus.Worktree == git.Unmodified {
+			continue
+		}
+		files = append(files, path)
+		if fileStatus.Extra != "" {
+			// The original path of a rename or a copy
+			files = append(files, fileStatus.Extra)
+		}
+	}
+	slices.Sort(files)
+	return head, slices.Compact(files), nil
+}
+
+// changedBetween returns the files that differ between the trees of the commits from and to.
+func changedBetween(repo *git.Repository, from, to plumbing.Hash) ([]string, error) {
+	var trees [2]*object.Tree
+	for i, hash := range []plumbing.Hash{from, to} {
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("error getting commit %s: %w", hash, err)
+		}
+		if trees[i], err = commit.Tree(); err != nil {
+			return nil, fmt.Errorf("error getting the tree of commit %s: %w", hash, err)
+		}
+	}
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return nil, fmt.Errorf("error comparing commits %s and %s: %w", from, to, err)
+	}
+	var files []string
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if name != "" {
+				files = append(files, name)
+			}
+		}
+	}
+	return files, nil
+}
+
+// runGit runs git with args in dir and returns its output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,112 @@
+package utils_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+func TestGetGitStatusAndDiff(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	status, err := utils.GetGitStatus(dir)
+	require.NoError(t, err)
+	assert.Equal(t, &utils.GitStatus{}, status, "a repository without commits")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644))
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = worktree.Add("main.go")
+	require.NoError(t, err)
+	_, err = worktree.Commit("Initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "GoAgent", Email: "goagent@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("todo\n"), 0644))
+	status, err = utils.GetGitStatus(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "master", status.Branch)
+	assert.Len(t, status.Head, 7)
+	assert.Equal(t, []string{" M main.go", "?? notes.txt"}, status.Changes)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	diff, err := utils.GitDiff(dir)
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+func main() {}")
+	assert.NotContains(t, diff, "notes.txt")
+}
+
+func TestGitDiff_NoCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("todo\n"), 0644))
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = worktree.Add("main.go")
+	require.NoError(t, err)
+
+	diff, err := utils.GitDiff(dir)
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+package main")
+	assert.NotContains(t, diff, "notes.txt", "untracked files are left out")
+}
+
+func TestGitChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func() string {
+		_, err := worktree.Add(".")
+		require.NoError(t, err)
+		hash, err := worktree.Commit("commit", &git.CommitOptions{
+			All:    true,
+			Author: &object.Signature{Name: "GoAgent", Email: "goagent@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		return hash.String()
+	}
+
+	head, files, err := utils.GitChangedFiles(dir, "")
+	require.NoError(t, err)
+	assert.Empty(t, head, "no commit yet")
+	assert.Empty(t, files)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644))
+	first := commit()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "util.go"), []byte("package main\n"), 0644))
+	second := commit()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "notes.txt"), []byte("todo\n"), 0644))
+
+	head, files, err = utils.GitChangedFiles(dir, first)
+	require.NoError(t, err)
+	assert.Equal(t, second, head)
+	assert.Equal(t, []string{"docs/notes.txt", "main.go", "util.go"}, files, "committed, modified and untracked files")
+
+	_, files, err = utils.GitChangedFiles(dir, second)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs/notes.txt", "main.go"}, files)
+}
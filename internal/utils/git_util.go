@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/EduardDranca/GoAgent/internal/logging"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"io/fs"
 	"os"
@@ -12,6 +13,16 @@ import (
 	"strings"
 )
 
+// The files and directories, relative to the repository, where GoAgent keeps its own state.
+var (
+	SessionsDir   = filepath.Join(".go-agent", "sessions")        // Recorded session transcripts
+	RunsDir       = filepath.Join(".go-agent", "runs")            // Checkpoints of change requests
+	AskThreadPath = filepath.Join(".go-agent", "ask_thread.json") // The persisted /ask conversation
+)
+
+// AgentStatePaths are the paths where GoAgent keeps its own state. Add never stages them.
+var AgentStatePaths = []string{SessionsDir, RunsDir, AskThreadPath}
+
 // GitUtil interface for Git operations.
 type GitUtil interface {
 	Add(dir string) error
@@ -77,7 +88,11 @@ func (g *RealGitUtil) Add(dir string) error {
 		return fmt.Errorf("error getting worktree: %w", err)
 	}
 
-	err = w.AddGlob(".")
+	// The state of the agent holds session histories, so it is never committed with the changes
+	for _, path := range AgentStatePaths {
+		w.Excludes = append(w.Excludes, gitignore.ParsePattern("/"+filepath.ToSlash(path), nil))
+	}
+	err = w.AddWithOptions(&git.AddOptions{All: true})
 	if err != nil {
 		logging.Logger.Errorf("Error adding files to commit: %v", err)
 		return fmt.Errorf("error adding files to commit: %w", err)
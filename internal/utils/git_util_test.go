@@ -0,0 +1,33 @@
+package utils_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+func TestRealGitUtil_AddSkipsAgentState(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	for _, file := range []string{"main.go", ".go-agent/config.yaml", ".go-agent/runs/1/checkpoint.json", ".go-agent/sessions/1.jsonl", ".go-agent/ask_thread.json"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, file), []byte("content\n"), 0644))
+	}
+
+	require.NoError(t, (&utils.RealGitUtil{}).Add(dir))
+
+	index, err := repo.Storer.Index()
+	require.NoError(t, err)
+	var staged []string
+	for _, entry := range index.Entries {
+		staged = append(staged, entry.Name)
+	}
+	assert.ElementsMatch(t, []string{"main.go", ".go-agent/config.yaml"}, staged)
+}
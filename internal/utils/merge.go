@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"strings"
+)
+
+const (
+	conflictMarkerOurs   = "<<<<<<< agent"
+	conflictMarkerBase   = "||||||| base"
+	conflictMarkerSep    = "======="
+	conflictMarkerTheirs = ">>>>>>> disk"
+
+	// maxLCSCells bounds the size of the LCS table; larger regions are treated as a single change.
+	maxLCSCells = 4_000_000
+)
+
+// ThreeWayMerge merges the changes made in ours and theirs relative to their common ancestor base,
+// line by line. Regions changed differently on both sides are emitted with diff3-style conflict
+// markers. It returns the merged content and the number of conflicting regions.
+func ThreeWayMerge(base string, ours string, theirs string) (string, int) {
+	baseLines := splitLines(base)
+	ourLines := splitLines(ours)
+	theirLines := splitLines(theirs)
+
+	ourMatches := matchLines(baseLines, ourLines)
+	theirMatches := matchLines(baseLines, theirLines)
+
+	var merged []string
+	conflicts := 0
+	baseIdx, ourIdx, theirIdx := 0, 0, 0
+
+	for {
+		// Find the next base line that is kept by both sides.
+		stable := -1
+		for i := baseIdx; i < len(baseLines); i++ {
+			if ourMatches[i] >= ourIdx && theirMatches[i] >= theirIdx {
+				stable = i
+				break
+			}
+		}
+
+		baseEnd, ourEnd, theirEnd := len(baseLines), len(ourLines), len(theirLines)
+		if stable != -1 {
+			baseEnd, ourEnd, theirEnd = stable, ourMatches[stable], theirMatches[stable]
+		}
+
+		chunk, conflict := mergeChunk(baseLines[baseIdx:baseEnd], ourLines[ourIdx:ourEnd], theirLines[theirIdx:theirEnd])
+		merged = append(merged, chunk...)
+		if conflict {
+			conflicts++
+		}
+
+		if stable == -1 {
+			break
+		}
+		merged = append(merged, baseLines[stable])
+		baseIdx, ourIdx, theirIdx = stable+1, ourEnd+1, theirEnd+1
+	}
+
+	return strings.Join(merged, ""), conflicts
+}
+
+// mergeChunk resolves a region between two stable lines.
+func mergeChunk(base []string, ours []string, theirs []string) ([]string, bool) {
+	switch {
+	case equalLines(ours, theirs):
+		return ours, false
+	case equalLines(base, ours):
+		return theirs, false
+	case equalLines(base, theirs):
+		return ours, false
+	}
+
+	chunk := []string{conflictMarkerOurs + "\n"}
+	chunk = append(chunk, withTrailingNewline(ours)...)
+	chunk = append(chunk, conflictMarkerBase+"\n")
+	chunk = append(chunk, withTrailingNewline(base)...)
+	chunk = append(chunk, conflictMarkerSep+"\n")
+	chunk = append(chunk, withTrailingNewline(theirs)...)
+	chunk = append(chunk, conflictMarkerTheirs+"\n")
+	return chunk, true
+}
+
+// matchLines returns, for every line of a, the index of the matching line in b according to their
+// longest common subsequence, or -1 if the line was removed.
+func matchLines(a []string, b []string) []int {
+	matches := make([]int, len(a))
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	// Common prefix and suffix are matched directly, which keeps the LCS table small for typical edits.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		matches[prefix] = prefix
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		matches[len(a)-1-suffix] = len(b) - 1 - suffix
+		suffix++
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+	if len(midA) == 0 || len(midB) == 0 || len(midA)*len(midB) > maxLCSCells {
+		return matches
+	}
+
+	// lengths[i][j] is the LCS length of midA[i:] and midB[j:].
+	width := len(midB) + 1
+	lengths := make([]int32, (len(midA)+1)*width)
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lengths[i*width+j] = lengths[(i+1)*width+j+1] + 1
+			} else {
+				lengths[i*width+j] = max(lengths[(i+1)*width+j], lengths[i*width+j+1])
+			}
+		}
+	}
+
+	for i, j := 0, 0; i < len(midA) && j < len(midB); {
+		switch {
+		case midA[i] == midB[j]:
+			matches[prefix+i] = prefix + j
+			i++
+			j++
+		case lengths[(i+1)*width+j] >= lengths[i*width+j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matches
+}
+
+// splitLines splits content into lines, keeping the line terminators.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// withTrailingNewline makes sure the last line of a conflict side ends with a newline, so that markers start on their own line.
+func withTrailingNewline(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	result := append([]string{}, lines...)
+	result[len(result)-1] += "\n"
+	return result
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
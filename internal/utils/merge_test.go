@@ -0,0 +1,81 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/EduardDranca/GoAgent/internal/utils"
+)
+
+func TestThreeWayMerge(t *testing.T) {
+	base := "one\ntwo\nthree\nfour\nfive\n"
+
+	tests := []struct {
+		name              string
+		ours              string
+		theirs            string
+		expectedMerged    string
+		expectedConflicts int
+	}{
+		{
+			name:           "only ours changed",
+			ours:           "one\nTWO\nthree\nfour\nfive\n",
+			theirs:         base,
+			expectedMerged: "one\nTWO\nthree\nfour\nfive\n",
+		},
+		{
+			name:           "only theirs changed",
+			ours:           base,
+			theirs:         "one\ntwo\nthree\nfour\nfive\nsix\n",
+			expectedMerged: "one\ntwo\nthree\nfour\nfive\nsix\n",
+		},
+		{
+			name:           "non-overlapping changes",
+			ours:           "zero\none\ntwo\nthree\nfour\nfive\n",
+			theirs:         "one\ntwo\nthree\nFOUR\nfive\n",
+			expectedMerged: "zero\none\ntwo\nthree\nFOUR\nfive\n",
+		},
+		{
+			name:           "deletion and insertion",
+			ours:           "one\nthree\nfour\nfive\n",
+			theirs:         "one\ntwo\nthree\nfour\nfour and a half\nfive\n",
+			expectedMerged: "one\nthree\nfour\nfour and a half\nfive\n",
+		},
+		{
+			name:           "identical changes",
+			ours:           "one\n2\nthree\nfour\nfive\n",
+			theirs:         "one\n2\nthree\nfour\nfive\n",
+			expectedMerged: "one\n2\nthree\nfour\nfive\n",
+		},
+		{
+			name:              "overlapping changes",
+			ours:              "one\nagent\nthree\nfour\nfive\n",
+			theirs:            "one\ndisk\nthree\nfour\nfive\n",
+			expectedMerged:    "one\n<<<<<<< agent\nagent\n||||||| base\ntwo\n=======\ndisk\n>>>>>>> disk\nthree\nfour\nfive\n",
+			expectedConflicts: 1,
+		},
+		{
+			name:              "overlapping changes without trailing newline",
+			ours:              "one\ntwo\nthree\nfour\nagent",
+			theirs:            "one\ntwo\nthree\nfour\ndisk",
+			expectedMerged:    "one\ntwo\nthree\nfour\n<<<<<<< agent\nagent\n||||||| base\nfive\n=======\ndisk\n>>>>>>> disk\n",
+			expectedConflicts: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, conflicts := utils.ThreeWayMerge(base, test.ours, test.theirs)
+			require.Equal(t, test.expectedMerged, merged)
+			require.Equal(t, test.expectedConflicts, conflicts)
+		})
+	}
+}
+
+func TestThreeWayMerge_EmptyBase(t *testing.T) {
+	merged, conflicts := utils.ThreeWayMerge("", "agent\n", "disk\n")
+	require.Equal(t, 1, conflicts)
+	require.Contains(t, merged, "agent\n")
+	require.Contains(t, merged, "disk\n")
+}